@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/status-im/status-go/mailserver"
+	"github.com/status-im/status-go/params"
+)
+
+var (
+	dbPath         = flag.String("db", "", "Path to wnode database folder or SQL database file")
+	storage        = flag.String("storage", params.MailServerStorageLevelDB, "MailServer storage backend (leveldb or sql)")
+	sqlDriver      = flag.String("driver", "sqlite3", "SQL driver used with -storage=sql")
+	exportFile     = flag.String("export", "", "Exports messages to this file")
+	importFile     = flag.String("import", "", "Imports messages from this file")
+	verifyFile     = flag.String("verify", "", "Verifies the checksum of this file without importing it")
+	lowerTimestamp = flag.Int("lower", 0, "Exports messages sent starting from this timestamp")
+	upperTimestamp = flag.Int("upper", 0, "Exports messages sent up to this timestamp (inclusive)")
+)
+
+func missingFlag(f string) {
+	log.Printf("flag -%s is required", f)
+	flag.Usage()
+	os.Exit(1)
+}
+
+func validateRange(lower, upper int) error {
+	if upper < lower {
+		return fmt.Errorf("upper value must not be lower than lower value")
+	}
+
+	if lower < 0 || upper < 0 {
+		return fmt.Errorf("upper and lower values must be greater than zero")
+	}
+
+	return nil
+}
+
+func init() {
+	flag.Parse()
+
+	if *verifyFile != "" {
+		return
+	}
+
+	if *dbPath == "" {
+		missingFlag("db")
+	}
+
+	if *exportFile == "" && *importFile == "" {
+		missingFlag("export or -import")
+	}
+
+	if *exportFile != "" && *upperTimestamp == 0 {
+		missingFlag("upper")
+	}
+}
+
+func openStorage() (mailserver.MailServerStorage, error) {
+	switch *storage {
+	case params.MailServerStorageLevelDB:
+		return mailserver.NewLevelDBStorage(*dbPath)
+	case params.MailServerStorageSQL:
+		return mailserver.NewSQLStorage(*sqlDriver, *dbPath)
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", *storage)
+	}
+}
+
+func main() {
+	if *verifyFile != "" {
+		verify(*verifyFile)
+		return
+	}
+
+	db, err := openStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if *exportFile != "" {
+		export(db, *exportFile)
+	}
+
+	if *importFile != "" {
+		verify(*importFile)
+		importFrom(db, *importFile)
+	}
+}
+
+func export(db mailserver.MailServerStorage, path string) {
+	if err := validateRange(*lowerTimestamp, *upperTimestamp); err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	n, err := mailserver.ExportEnvelopes(f, db, uint32(*lowerTimestamp), uint32(*upperTimestamp))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := f.Sync(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("exported %d messages.\n", n)
+}
+
+func verify(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	header, n, err := mailserver.VerifyEnvelopes(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("verified %d messages sent between %d and %d.\n", n, header.Lower, header.Upper)
+}
+
+func importFrom(db mailserver.MailServerStorage, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	stats, err := mailserver.ImportEnvelopes(f, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("imported %d messages, skipped %d duplicates.\n", stats.Imported, stats.Skipped)
+}
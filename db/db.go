@@ -18,6 +18,9 @@ const (
 	// DeduplicatorCache is used for the db entries used for messages
 	// deduplication cache
 	DeduplicatorCache
+	// MailServerSyncState is used for the db entries with the last time
+	// envelopes with a topic were synced from a mail server
+	MailServerSyncState
 )
 
 // Key creates a DB key for a specified service with specified data
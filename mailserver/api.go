@@ -0,0 +1,284 @@
+package mailserver
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+)
+
+const (
+	// defaultStatsDays is the number of days returned by DailyCounts if not specified.
+	defaultStatsDays = 7
+	// maxStatsDays is the max number of days returned by DailyCounts.
+	maxStatsDays = 365
+	// defaultTopTopicsLimit is the number of topics returned by TopTopics if not specified.
+	defaultTopTopicsLimit = 10
+	// day is the duration of a UTC day used by daily statistics and quotas.
+	day = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidStatsDays is returned when the number of days is out of range.
+	ErrInvalidStatsDays = errors.New("days must be between 0 and 365")
+	// ErrInvalidTimeRange is returned when the lower bound is above the upper bound.
+	ErrInvalidTimeRange = errors.New("from must not be greater than to")
+	// ErrLimiterNotConfigured is returned when rate limits are not configured.
+	ErrLimiterNotConfigured = errors.New("rate limits are not configured")
+)
+
+// AdminAPI represents a set of APIs from the `mailserver` namespace.
+// It allows operators to inspect and manage a running MailServer.
+type AdminAPI struct {
+	s   *WMailServer
+	now func() time.Time
+}
+
+// NewAdminAPI creates an instance of the MailServer admin API.
+func NewAdminAPI(s *WMailServer) *AdminAPI {
+	return &AdminAPI{s: s, now: time.Now}
+}
+
+// StatsResponse describes archived envelopes.
+type StatsResponse struct {
+	// StorageSize is an approximate size of archived envelopes in bytes.
+	StorageSize int64 `json:"storageSize"`
+	// Envelopes is an approximate number of archived envelopes.
+	// It is updated when envelopes are archived or pruned, so the storage is not read.
+	Envelopes int `json:"envelopes"`
+	// Oldest is the timestamp of the oldest envelope or 0 if there are no envelopes.
+	Oldest uint32 `json:"oldest"`
+}
+
+// Stats is an implementation of `mailserver_stats` API.
+func (api *AdminAPI) Stats(context.Context) (StatsResponse, error) {
+	var (
+		resp StatsResponse
+		err  error
+	)
+	if resp.StorageSize, err = api.s.db.Size(); err != nil {
+		return resp, err
+	}
+	resp.Envelopes = api.s.envelopes.get()
+	if resp.Oldest, err = api.s.db.Oldest(); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// DailyCount is the number of envelopes sent during a UTC day.
+type DailyCount struct {
+	// Day is the timestamp of the beginning of the day.
+	Day uint32 `json:"day"`
+	// Envelopes is the number of envelopes sent during the day.
+	Envelopes int `json:"envelopes"`
+}
+
+// DailyCounts is an implementation of `mailserver_dailyCounts` API.
+// It returns the number of envelopes sent during the given number of days
+// from today to the past.
+func (api *AdminAPI) DailyCounts(ctx context.Context, days int) ([]DailyCount, error) {
+	if days < 0 || days > maxStatsDays {
+		return nil, ErrInvalidStatsDays
+	}
+	if days == 0 {
+		days = defaultStatsDays
+	}
+
+	today := uint32(api.now().UTC().Truncate(day).Unix())
+	result := make([]DailyCount, 0, days)
+	for i := 0; i < days; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		lower := today - uint32(i)*uint32(day/time.Second)
+		if lower > today {
+			// days before the epoch
+			break
+		}
+		count, err := api.s.db.Count(lower, lower+uint32(day/time.Second))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, DailyCount{Day: lower, Envelopes: count})
+	}
+	return result, nil
+}
+
+// TopTopicsRequest is a request for the topics with the biggest volume.
+type TopTopicsRequest struct {
+	// From is a lower bound of time range (optional).
+	// Default is 24 hours back from To.
+	From uint32 `json:"from"`
+	// To is an upper bound of time range (optional).
+	// Default is now.
+	To uint32 `json:"to"`
+	// Limit is the max number of returned topics (optional).
+	// Default is 10.
+	Limit int `json:"limit"`
+}
+
+func (r *TopTopicsRequest) setDefaults(now time.Time) {
+	if r.To == 0 {
+		r.To = uint32(now.Unix())
+	}
+	if r.From == 0 && r.To > uint32(day/time.Second) {
+		r.From = r.To - uint32(day/time.Second)
+	}
+	if r.Limit <= 0 {
+		r.Limit = defaultTopTopicsLimit
+	}
+}
+
+// TopicStats describes envelopes with a single topic.
+type TopicStats struct {
+	Topic     whisper.TopicType `json:"topic"`
+	Envelopes int               `json:"envelopes"`
+	// Size is the total size of the envelopes in bytes.
+	Size int64 `json:"size"`
+}
+
+// TopTopics is an implementation of `mailserver_topTopics` API.
+// It returns topics with the biggest size of envelopes sent during
+// the time range, from the biggest to the smallest.
+// All envelopes in the time range are read, so the time range should be
+// kept reasonably small.
+func (api *AdminAPI) TopTopics(ctx context.Context, r TopTopicsRequest) ([]TopicStats, error) {
+	r.setDefaults(api.now())
+	if r.From > r.To {
+		return nil, ErrInvalidTimeRange
+	}
+
+	i, err := api.s.db.Query(StorageQuery{
+		Lower: r.From,
+		Upper: r.To,
+		Bloom: whisper.MakeFullNodeBloom(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer i.Release()
+
+	topics := make(map[whisper.TopicType]*TopicStats)
+	for n := 0; i.Next(); n++ {
+		if n%batchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		env := i.Envelope()
+		stats, ok := topics[env.Topic]
+		if !ok {
+			stats = &TopicStats{Topic: env.Topic}
+			topics[env.Topic] = stats
+		}
+		stats.Envelopes++
+		stats.Size += int64(whisper.EnvelopeHeaderLength + len(env.Data))
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	result := make([]TopicStats, 0, len(topics))
+	for _, stats := range topics {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Size != result[j].Size {
+			return result[i].Size > result[j].Size
+		}
+		return result[i].Envelopes > result[j].Envelopes
+	})
+	if len(result) > r.Limit {
+		result = result[:r.Limit]
+	}
+	return result, nil
+}
+
+// LimitedPeers is an implementation of `mailserver_limitedPeers` API.
+// It returns peers which used some of their rate limit budgets.
+func (api *AdminAPI) LimitedPeers(context.Context) ([]PeerLimits, error) {
+	api.s.muLimiter.RLock()
+	defer api.s.muLimiter.RUnlock()
+
+	if api.s.limiter == nil {
+		return nil, ErrLimiterNotConfigured
+	}
+	return api.s.limiter.peers(), nil
+}
+
+// ResetPeerLimits is an implementation of `mailserver_resetPeerLimits` API.
+// It refills rate limit budgets of the peer and returns false
+// if the peer did not use its budgets.
+func (api *AdminAPI) ResetPeerLimits(_ context.Context, peerID string) (bool, error) {
+	api.s.muLimiter.RLock()
+	defer api.s.muLimiter.RUnlock()
+
+	if api.s.limiter == nil {
+		return false, ErrLimiterNotConfigured
+	}
+	return api.s.limiter.reset(strings.ToLower(strings.TrimPrefix(peerID, "0x"))), nil
+}
+
+// PruneRequest is a request to remove archived envelopes.
+type PruneRequest struct {
+	// From is a lower bound of time range (inclusive).
+	From uint32 `json:"from"`
+	// To is an upper bound of time range (exclusive).
+	To uint32 `json:"to"`
+}
+
+// Prune is an implementation of `mailserver_prune` API.
+// It removes envelopes sent during the time range and returns how many have been removed.
+func (api *AdminAPI) Prune(_ context.Context, r PruneRequest) (int, error) {
+	if r.From > r.To {
+		return 0, ErrInvalidTimeRange
+	}
+
+	removed, err := api.s.db.Prune(r.From, r.To)
+	prunedEnvelopesCounter.Inc(int64(removed))
+	api.s.envelopes.add(-removed)
+	if api.s.cache != nil {
+		// cached results could contain removed envelopes
+		api.s.cache.Purge()
+	}
+	return removed, err
+}
+
+// ArchiveTopics describes topics of archived envelopes.
+type ArchiveTopics struct {
+	// Include is a list of archived topics. All topics are archived if it is empty.
+	Include []whisper.TopicType `json:"include"`
+	// Exclude is a list of topics which are never archived.
+	Exclude []whisper.TopicType `json:"exclude"`
+}
+
+// ArchiveTopicsResponse describes topics of archived envelopes
+// and the number of envelopes archived and skipped since MailServer started.
+type ArchiveTopicsResponse struct {
+	ArchiveTopics
+	Archived uint64 `json:"archived"`
+	Skipped  uint64 `json:"skipped"`
+}
+
+// ArchiveTopics is an implementation of `mailserver_archiveTopics` API.
+func (api *AdminAPI) ArchiveTopics(context.Context) (ArchiveTopicsResponse, error) {
+	var resp ArchiveTopicsResponse
+	resp.Include, resp.Exclude = api.s.archiveFilter.topics()
+	resp.Archived, resp.Skipped = api.s.archiveFilter.stats()
+	return resp, nil
+}
+
+// SetArchiveTopics is an implementation of `mailserver_setArchiveTopics` API.
+// It replaces topics of archived envelopes. Already archived envelopes are kept.
+func (api *AdminAPI) SetArchiveTopics(_ context.Context, topics ArchiveTopics) error {
+	api.s.archiveFilter.setTopics(topics.Include, topics.Exclude)
+	log.Info("changed archived topics", "include", len(topics.Include), "exclude", len(topics.Exclude))
+	return nil
+}
@@ -0,0 +1,168 @@
+package mailserver
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAdminAPI returns an API of a MailServer with an in-memory storage
+// and a clock set to the given time.
+func newTestAdminAPI(t *testing.T, now time.Time) *AdminAPI {
+	api := NewAdminAPI(&WMailServer{
+		db:            newTestLevelDBStorage(t),
+		archiveFilter: newArchiveFilter(nil, nil),
+	})
+	api.now = func() time.Time { return now }
+	return api
+}
+
+func TestAdminAPIStats(t *testing.T) {
+	api := newTestAdminAPI(t, time.Now())
+	defer api.s.db.Close()
+
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(100, testTopicA, 1)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(200, testTopicA, 2)))
+
+	// envelopes in the storage are counted when the MailServer starts
+	api.s.envelopes.start(api.s.db)
+	api.s.envelopes.wait()
+
+	stats, err := api.Stats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Envelopes)
+	require.Equal(t, uint32(100), stats.Oldest)
+
+	// the count is updated without reading the storage
+	api.s.Archive(newTestEnvelope(300, testTopicA, 3))
+	removed, err := api.Prune(context.Background(), PruneRequest{From: 0, To: 150})
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	stats, err = api.Stats(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Envelopes)
+	require.Equal(t, uint32(200), stats.Oldest)
+}
+
+func TestAdminAPIDailyCounts(t *testing.T) {
+	now := time.Date(2018, 10, 10, 12, 0, 0, 0, time.UTC)
+	today := uint32(time.Date(2018, 10, 10, 0, 0, 0, 0, time.UTC).Unix())
+	api := newTestAdminAPI(t, now)
+	defer api.s.db.Close()
+
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(today, testTopicA, 1)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(today+3600, testTopicA, 2)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(today-1, testTopicA, 3)))
+
+	counts, err := api.DailyCounts(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, []DailyCount{
+		{Day: today, Envelopes: 2},
+		{Day: today - 86400, Envelopes: 1},
+		{Day: today - 2*86400, Envelopes: 0},
+	}, counts)
+
+	counts, err = api.DailyCounts(context.Background(), 0)
+	require.NoError(t, err)
+	require.Len(t, counts, defaultStatsDays)
+
+	_, err = api.DailyCounts(context.Background(), maxStatsDays+1)
+	require.Equal(t, ErrInvalidStatsDays, err)
+}
+
+func TestAdminAPITopTopics(t *testing.T) {
+	api := newTestAdminAPI(t, time.Unix(1000, 0))
+	defer api.s.db.Close()
+
+	topicC := whisper.TopicType{0x09, 0x0A, 0x0B, 0x0C}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, api.s.db.Archive(newTestEnvelope(100, testTopicA, uint64(i))))
+	}
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(100, testTopicB, 10)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(101, testTopicB, 11)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(100, topicC, 20)))
+	// outside of the time range
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(500, topicC, 21)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(501, topicC, 22)))
+
+	envelopeSize := int64(whisper.EnvelopeHeaderLength + len("test data"))
+	topics, err := api.TopTopics(context.Background(), TopTopicsRequest{From: 0, To: 200, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []TopicStats{
+		{Topic: testTopicA, Envelopes: 3, Size: 3 * envelopeSize},
+		{Topic: testTopicB, Envelopes: 2, Size: 2 * envelopeSize},
+	}, topics)
+
+	_, err = api.TopTopics(context.Background(), TopTopicsRequest{From: 200, To: 100})
+	require.Equal(t, ErrInvalidTimeRange, err)
+}
+
+func TestAdminAPIPeerLimits(t *testing.T) {
+	api := newTestAdminAPI(t, time.Now())
+	defer api.s.db.Close()
+
+	_, err := api.LimitedPeers(context.Background())
+	require.Equal(t, ErrLimiterNotConfigured, err)
+
+	api.s.limiter, _ = newTestLimiter(limiterConfig{RequestsRate: 1, RequestsBurst: 1})
+	peerID := []byte{0x01, 0x02}
+	require.NoError(t, api.s.checkRateLimits(peerID))
+	require.Error(t, api.s.checkRateLimits(peerID))
+
+	peers, err := api.LimitedPeers(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []PeerLimits{{ID: hex.EncodeToString(peerID), Limited: true}}, peers)
+
+	reset, err := api.ResetPeerLimits(context.Background(), "0x"+hex.EncodeToString(peerID))
+	require.NoError(t, err)
+	require.True(t, reset)
+	require.NoError(t, api.s.checkRateLimits(peerID))
+
+	reset, err = api.ResetPeerLimits(context.Background(), "0x0304")
+	require.NoError(t, err)
+	require.False(t, reset)
+}
+
+func TestAdminAPIPrune(t *testing.T) {
+	api := newTestAdminAPI(t, time.Now())
+	defer api.s.db.Close()
+
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(100, testTopicA, 1)))
+	require.NoError(t, api.s.db.Archive(newTestEnvelope(200, testTopicA, 2)))
+
+	removed, err := api.Prune(context.Background(), PruneRequest{From: 0, To: 150})
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	count, err := api.s.db.Count(0, 300)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	_, err = api.Prune(context.Background(), PruneRequest{From: 200, To: 100})
+	require.Equal(t, ErrInvalidTimeRange, err)
+}
+
+func TestAdminAPIArchiveTopics(t *testing.T) {
+	api := newTestAdminAPI(t, time.Now())
+	defer api.s.db.Close()
+
+	api.s.Archive(newTestEnvelope(100, testTopicA, 1))
+
+	topics := ArchiveTopics{Include: []whisper.TopicType{testTopicB}, Exclude: []whisper.TopicType{}}
+	require.NoError(t, api.SetArchiveTopics(context.Background(), topics))
+	api.s.Archive(newTestEnvelope(100, testTopicA, 2))
+	api.s.Archive(newTestEnvelope(100, testTopicB, 3))
+
+	resp, err := api.ArchiveTopics(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, ArchiveTopicsResponse{ArchiveTopics: topics, Archived: 2, Skipped: 1}, resp)
+
+	count, err := api.s.db.Count(0, 200)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
@@ -0,0 +1,105 @@
+package mailserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+)
+
+var skippedMeter = metrics.NewRegisteredMeter("mailserver/skippedEnvelopes", nil)
+
+// archiveFilter decides which envelopes are archived based on their topics.
+// Topics can be changed at runtime.
+type archiveFilter struct {
+	// archived and skipped are accessed atomically and must stay
+	// at the beginning of the struct to be 64-bit aligned.
+	archived uint64
+	skipped  uint64
+
+	mu sync.RWMutex
+	// include is a set of archived topics. All topics are archived if it is empty.
+	include map[whisper.TopicType]struct{}
+	// exclude is a set of topics which are never archived.
+	exclude map[whisper.TopicType]struct{}
+}
+
+func newArchiveFilter(include, exclude []whisper.TopicType) *archiveFilter {
+	f := &archiveFilter{}
+	f.setTopics(include, exclude)
+	return f
+}
+
+// setTopics replaces the archived and excluded topics.
+func (f *archiveFilter) setTopics(include, exclude []whisper.TopicType) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.include = make(map[whisper.TopicType]struct{}, len(include))
+	for _, t := range include {
+		f.include[t] = struct{}{}
+	}
+	f.exclude = make(map[whisper.TopicType]struct{}, len(exclude))
+	for _, t := range exclude {
+		f.exclude[t] = struct{}{}
+	}
+}
+
+// topics returns the archived and excluded topics.
+func (f *archiveFilter) topics() (include, exclude []whisper.TopicType) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	include = make([]whisper.TopicType, 0, len(f.include))
+	for t := range f.include {
+		include = append(include, t)
+	}
+	exclude = make([]whisper.TopicType, 0, len(f.exclude))
+	for t := range f.exclude {
+		exclude = append(exclude, t)
+	}
+	return include, exclude
+}
+
+// allow returns true if envelopes with the topic should be archived.
+// Skipped envelopes are counted.
+func (f *archiveFilter) allow(topic whisper.TopicType) bool {
+	f.mu.RLock()
+	_, excluded := f.exclude[topic]
+	_, included := f.include[topic]
+	allowed := !excluded && (included || len(f.include) == 0)
+	f.mu.RUnlock()
+
+	if !allowed {
+		atomic.AddUint64(&f.skipped, 1)
+		skippedMeter.Mark(1)
+	}
+	return allowed
+}
+
+// markArchived counts an archived envelope.
+func (f *archiveFilter) markArchived() {
+	atomic.AddUint64(&f.archived, 1)
+}
+
+// stats returns the number of archived and skipped envelopes.
+func (f *archiveFilter) stats() (archived, skipped uint64) {
+	return atomic.LoadUint64(&f.archived), atomic.LoadUint64(&f.skipped)
+}
+
+// parseTopics decodes hex-encoded topics.
+func parseTopics(topics []string) ([]whisper.TopicType, error) {
+	result := make([]whisper.TopicType, len(topics))
+	for i, topic := range topics {
+		b, err := hex.DecodeString(strings.TrimPrefix(topic, "0x"))
+		if err != nil || len(b) != whisper.TopicLength {
+			return nil, fmt.Errorf("invalid topic: %s", topic)
+		}
+		result[i] = whisper.BytesToTopic(b)
+	}
+	return result, nil
+}
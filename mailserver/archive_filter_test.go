@@ -0,0 +1,74 @@
+package mailserver
+
+import (
+	"testing"
+
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFilter(t *testing.T) {
+	topicC := whisper.TopicType{0x09, 0x0A, 0x0B, 0x0C}
+
+	testCases := []struct {
+		name     string
+		include  []whisper.TopicType
+		exclude  []whisper.TopicType
+		expected map[whisper.TopicType]bool
+	}{
+		{
+			name:     "all topics",
+			expected: map[whisper.TopicType]bool{testTopicA: true, testTopicB: true, topicC: true},
+		},
+		{
+			name:     "included topics",
+			include:  []whisper.TopicType{testTopicA, testTopicB},
+			expected: map[whisper.TopicType]bool{testTopicA: true, testTopicB: true, topicC: false},
+		},
+		{
+			name:     "excluded topics",
+			exclude:  []whisper.TopicType{testTopicA},
+			expected: map[whisper.TopicType]bool{testTopicA: false, testTopicB: true, topicC: true},
+		},
+		{
+			name:     "exclude takes precedence",
+			include:  []whisper.TopicType{testTopicA, testTopicB},
+			exclude:  []whisper.TopicType{testTopicA},
+			expected: map[whisper.TopicType]bool{testTopicA: false, testTopicB: true, topicC: false},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newArchiveFilter(tc.include, tc.exclude)
+			for topic, allowed := range tc.expected {
+				require.Equal(t, allowed, f.allow(topic), "topic %x", topic)
+			}
+		})
+	}
+}
+
+func TestArchiveFilterSetTopics(t *testing.T) {
+	f := newArchiveFilter([]whisper.TopicType{testTopicA}, nil)
+	require.False(t, f.allow(testTopicB))
+
+	f.setTopics(nil, []whisper.TopicType{testTopicA})
+	require.True(t, f.allow(testTopicB))
+	require.False(t, f.allow(testTopicA))
+
+	include, exclude := f.topics()
+	require.Empty(t, include)
+	require.Equal(t, []whisper.TopicType{testTopicA}, exclude)
+
+	_, skipped := f.stats()
+	require.Equal(t, uint64(2), skipped)
+}
+
+func TestParseTopics(t *testing.T) {
+	topics, err := parseTopics([]string{"0x01020304", "05060708"})
+	require.NoError(t, err)
+	require.Equal(t, []whisper.TopicType{testTopicA, testTopicB}, topics)
+
+	_, err = parseTopics([]string{"0x010203"})
+	require.EqualError(t, err, "invalid topic: 0x010203")
+}
@@ -0,0 +1,259 @@
+package mailserver
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// queryCacheSize is the max number of cached query results.
+	queryCacheSize = 64
+	// maxCachedResultSize is the max size of envelopes in a single cached query result.
+	// Bigger results are read from the storage every time.
+	maxCachedResultSize = 512 * 1024
+)
+
+var (
+	queryCacheHitsMeter   = metrics.NewRegisteredMeter("mailserver/queryCacheHits", nil)
+	queryCacheMissesMeter = metrics.NewRegisteredMeter("mailserver/queryCacheMisses", nil)
+	coalescedQueriesMeter = metrics.NewRegisteredMeter("mailserver/coalescedQueries", nil)
+)
+
+// cachedEnvelope is an envelope returned by a query with its cursor.
+type cachedEnvelope struct {
+	envelope *whisper.Envelope
+	cursor   cursorType
+}
+
+// queryResult holds all envelopes returned by a query.
+type queryResult struct {
+	query     StorageQuery
+	envelopes []cachedEnvelope
+}
+
+// pendingQuery is a query which is read from the storage.
+// Identical queries made in the meantime wait for its result.
+type pendingQuery struct {
+	query StorageQuery
+	done  chan struct{}
+	// result is nil if the query could not be read completely.
+	result *queryResult
+	// stale is set if a matching envelope was archived while reading the query.
+	stale bool
+}
+
+// queryCache keeps results of recent queries and coalesces identical queries
+// made at the same time, so that popular queries are read from the storage once.
+// Results are invalidated when matching envelopes are archived
+// and purged when envelopes are pruned.
+type queryCache struct {
+	mu      sync.Mutex
+	db      MailServerStorage
+	results *lru.Cache
+	pending map[common.Hash]*pendingQuery
+}
+
+func newQueryCache(db MailServerStorage, size int) (*queryCache, error) {
+	results, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &queryCache{
+		db:      db,
+		results: results,
+		pending: make(map[common.Hash]*pendingQuery),
+	}, nil
+}
+
+// Query returns an iterator over a cached result of the query.
+// If the result is not cached, the query is read from the storage
+// before it is returned, so that identical queries made in the meantime
+// wait only for the storage and not for the delivery to the first requester.
+func (c *queryCache) Query(query StorageQuery) (StorageIterator, error) {
+	key, err := queryKey(query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if result, ok := c.results.Get(key); ok {
+		c.mu.Unlock()
+		queryCacheHitsMeter.Mark(1)
+		return newResultIterator(result.(*queryResult)), nil
+	}
+	if p, ok := c.pending[key]; ok {
+		c.mu.Unlock()
+		coalescedQueriesMeter.Mark(1)
+		<-p.done
+		if p.result != nil {
+			return newResultIterator(p.result), nil
+		}
+		return c.db.Query(query)
+	}
+	p := &pendingQuery{query: query, done: make(chan struct{})}
+	c.pending[key] = p
+	c.mu.Unlock()
+
+	queryCacheMissesMeter.Mark(1)
+	i, err := c.db.Query(query)
+	if err != nil {
+		c.finish(key, p, nil)
+		return nil, err
+	}
+	return c.read(key, p, i)
+}
+
+// read reads the result of the pending query from the storage iterator
+// until there are no more envelopes or the query limit is reached.
+// If the result is too big to be cached, identical queries are woken up
+// to read the storage on their own and the read envelopes are returned
+// followed by the rest of the storage iterator.
+func (c *queryCache) read(key common.Hash, p *pendingQuery, i StorageIterator) (StorageIterator, error) {
+	result := &queryResult{query: p.query}
+	size := 0
+	for i.Next() {
+		env := i.Envelope()
+		// the hash is cached by the envelope, compute it before the envelope is shared
+		env.Hash()
+		result.envelopes = append(result.envelopes, cachedEnvelope{envelope: env, cursor: i.Cursor()})
+
+		size += whisper.EnvelopeHeaderLength + len(env.Data)
+		if size > maxCachedResultSize {
+			c.finish(key, p, nil)
+			return &prefixIterator{prefix: newResultIterator(result), StorageIterator: i}, nil
+		}
+		if limit := p.query.Limit; limit != noLimits && uint32(len(result.envelopes)) == limit {
+			break
+		}
+	}
+
+	err := i.Error()
+	i.Release()
+	if err != nil {
+		c.finish(key, p, nil)
+		return nil, err
+	}
+	c.finish(key, p, result)
+	return newResultIterator(result), nil
+}
+
+// finish caches the result of the pending query unless it is nil or stale
+// and wakes up identical queries.
+func (c *queryCache) finish(key common.Hash, p *pendingQuery, result *queryResult) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	if result != nil && !p.stale {
+		c.results.Add(key, result)
+	}
+	c.mu.Unlock()
+
+	p.result = result
+	close(p.done)
+}
+
+// Invalidate removes cached results which could contain the envelope.
+func (c *queryCache) Invalidate(env *whisper.Envelope) {
+	timestamp := env.Expiry - env.TTL
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.results.Keys() {
+		result, ok := c.results.Peek(key)
+		if ok && result.(*queryResult).query.covers(timestamp, env.Topic) {
+			c.results.Remove(key)
+		}
+	}
+	for _, p := range c.pending {
+		if p.query.covers(timestamp, env.Topic) {
+			p.stale = true
+		}
+	}
+}
+
+// Purge removes all cached results.
+func (c *queryCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results.Purge()
+	for _, p := range c.pending {
+		p.stale = true
+	}
+}
+
+// queryKey returns a hash identifying the query.
+func queryKey(query StorageQuery) (common.Hash, error) {
+	data, err := rlp.EncodeToBytes(query)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// prefixIterator returns envelopes which were already read from the storage
+// and then continues with the storage iterator.
+type prefixIterator struct {
+	StorageIterator
+
+	prefix   *resultIterator
+	inPrefix bool
+}
+
+func (i *prefixIterator) Next() bool {
+	if i.prefix.Next() {
+		i.inPrefix = true
+		return true
+	}
+	i.inPrefix = false
+	return i.StorageIterator.Next()
+}
+
+func (i *prefixIterator) Envelope() *whisper.Envelope {
+	if i.inPrefix {
+		return i.prefix.Envelope()
+	}
+	return i.StorageIterator.Envelope()
+}
+
+func (i *prefixIterator) Cursor() cursorType {
+	if i.inPrefix {
+		return i.prefix.Cursor()
+	}
+	return i.StorageIterator.Cursor()
+}
+
+// resultIterator iterates over a cached query result.
+type resultIterator struct {
+	result *queryResult
+	pos    int
+}
+
+func newResultIterator(result *queryResult) *resultIterator {
+	return &resultIterator{result: result, pos: -1}
+}
+
+func (i *resultIterator) Next() bool {
+	i.pos++
+	return i.pos < len(i.result.envelopes)
+}
+
+func (i *resultIterator) Envelope() *whisper.Envelope {
+	return i.result.envelopes[i.pos].envelope
+}
+
+func (i *resultIterator) Cursor() cursorType {
+	return i.result.envelopes[i.pos].cursor
+}
+
+func (i *resultIterator) Error() error {
+	return nil
+}
+
+func (i *resultIterator) Release() {}
@@ -0,0 +1,209 @@
+package mailserver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts queries made to the storage.
+type countingStorage struct {
+	MailServerStorage
+
+	mu      sync.Mutex
+	queries int
+}
+
+func (s *countingStorage) Query(query StorageQuery) (StorageIterator, error) {
+	s.mu.Lock()
+	s.queries++
+	s.mu.Unlock()
+	return s.MailServerStorage.Query(query)
+}
+
+func (s *countingStorage) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func newTestQueryCache(t *testing.T) (*queryCache, *countingStorage) {
+	db := &countingStorage{MailServerStorage: newTestLevelDBStorage(t)}
+	cache, err := newQueryCache(db, queryCacheSize)
+	require.NoError(t, err)
+	return cache, db
+}
+
+// readAll reads all envelopes returned by the query.
+func readAll(t *testing.T, cache *queryCache, query StorageQuery) []*whisper.Envelope {
+	i, err := cache.Query(query)
+	require.NoError(t, err)
+	defer i.Release()
+
+	var result []*whisper.Envelope
+	for i.Next() {
+		result = append(result, i.Envelope())
+	}
+	require.NoError(t, i.Error())
+	return result
+}
+
+func TestQueryCacheHit(t *testing.T) {
+	cache, db := newTestQueryCache(t)
+	defer db.Close()
+
+	require.NoError(t, db.Archive(newTestEnvelope(100, testTopicA, 1)))
+	require.NoError(t, db.Archive(newTestEnvelope(101, testTopicA, 2)))
+
+	query := StorageQuery{Lower: 0, Upper: 200, Topics: []whisper.TopicType{testTopicA}}
+	first := readAll(t, cache, query)
+	require.Len(t, first, 2)
+	require.Equal(t, first, readAll(t, cache, query))
+	require.Equal(t, 1, db.count())
+
+	// a different query is not cached
+	readAll(t, cache, StorageQuery{Lower: 0, Upper: 150, Topics: []whisper.TopicType{testTopicA}})
+	require.Equal(t, 2, db.count())
+}
+
+func TestQueryCacheCursor(t *testing.T) {
+	cache, db := newTestQueryCache(t)
+	defer db.Close()
+
+	for n := 0; n < 3; n++ {
+		require.NoError(t, db.Archive(newTestEnvelope(100+uint32(n), testTopicA, uint64(n))))
+	}
+
+	query := StorageQuery{Lower: 0, Upper: 200, Bloom: whisper.MakeFullNodeBloom(), Limit: 2}
+	var cursors [2][]cursorType
+	for n := range cursors {
+		i, err := cache.Query(query)
+		require.NoError(t, err)
+		for i.Next() {
+			cursors[n] = append(cursors[n], i.Cursor())
+			if len(cursors[n]) == int(query.Limit) {
+				break
+			}
+		}
+		i.Release()
+	}
+	require.Len(t, cursors[0], 2)
+	require.Equal(t, cursors[0], cursors[1])
+	// the result is cached when the limit is reached
+	require.Equal(t, 1, db.count())
+}
+
+func TestQueryCacheTooBigResult(t *testing.T) {
+	cache, db := newTestQueryCache(t)
+	defer db.Close()
+
+	for n := 0; n < 3; n++ {
+		env := newTestEnvelope(100+uint32(n), testTopicA, uint64(n))
+		env.Data = make([]byte, maxCachedResultSize/2)
+		require.NoError(t, db.Archive(env))
+	}
+
+	query := StorageQuery{Lower: 0, Upper: 200, Bloom: whisper.MakeFullNodeBloom()}
+	first := readAll(t, cache, query)
+	require.Len(t, first, 3)
+	require.Equal(t, first, readAll(t, cache, query))
+	// too big results are not cached
+	require.Equal(t, 2, db.count())
+}
+
+func TestQueryCacheSlowReader(t *testing.T) {
+	cache, db := newTestQueryCache(t)
+	defer db.Close()
+
+	require.NoError(t, db.Archive(newTestEnvelope(100, testTopicA, 1)))
+
+	query := StorageQuery{Lower: 0, Upper: 200, Bloom: whisper.MakeFullNodeBloom()}
+	// the first requester does not read its result, e.g. it is delivered to a slow peer
+	leader, err := cache.Query(query)
+	require.NoError(t, err)
+	defer leader.Release()
+
+	results := make(chan []*whisper.Envelope, 1)
+	go func() {
+		results <- readAll(t, cache, query)
+	}()
+
+	select {
+	case result := <-results:
+		require.Len(t, result, 1)
+	case <-time.After(time.Second):
+		t.Fatal("identical query is blocked by the first requester")
+	}
+	require.Equal(t, 1, db.count())
+}
+
+func TestQueryCacheInvalidate(t *testing.T) {
+	cache, db := newTestQueryCache(t)
+	defer db.Close()
+
+	require.NoError(t, db.Archive(newTestEnvelope(100, testTopicA, 1)))
+
+	queryA := StorageQuery{Lower: 0, Upper: 200, Topics: []whisper.TopicType{testTopicA}}
+	queryB := StorageQuery{Lower: 0, Upper: 200, Topics: []whisper.TopicType{testTopicB}}
+	older := StorageQuery{Lower: 0, Upper: 100, Topics: []whisper.TopicType{testTopicA}}
+	readAll(t, cache, queryA)
+	readAll(t, cache, queryB)
+	readAll(t, cache, older)
+	require.Equal(t, 3, db.count())
+
+	env := newTestEnvelope(150, testTopicA, 2)
+	require.NoError(t, db.Archive(env))
+	cache.Invalidate(env)
+
+	require.Len(t, readAll(t, cache, queryA), 2)
+	// queries which can't contain the envelope stay cached
+	readAll(t, cache, queryB)
+	readAll(t, cache, older)
+	require.Equal(t, 4, db.count())
+}
+
+func TestQueryCacheCoalescing(t *testing.T) {
+	cache, db := newTestQueryCache(t)
+	defer db.Close()
+
+	require.NoError(t, db.Archive(newTestEnvelope(100, testTopicA, 1)))
+
+	query := StorageQuery{Lower: 0, Upper: 200, Bloom: whisper.MakeFullNodeBloom()}
+	leader, err := cache.Query(query)
+	require.NoError(t, err)
+
+	// identical queries wait until the first one is read
+	results := make(chan []*whisper.Envelope, 3)
+	for n := 0; n < cap(results); n++ {
+		go func() {
+			results <- readAll(t, cache, query)
+		}()
+	}
+
+	var expected []*whisper.Envelope
+	for leader.Next() {
+		expected = append(expected, leader.Envelope())
+	}
+	leader.Release()
+
+	for n := 0; n < cap(results); n++ {
+		require.Equal(t, expected, <-results)
+	}
+	require.Equal(t, 1, db.count())
+}
+
+func TestQueryCovers(t *testing.T) {
+	query := StorageQuery{
+		Lower:   10,
+		Upper:   50,
+		Windows: []TimeWindow{{Lower: 40, Upper: 50}, {Lower: 10, Upper: 20}},
+		Topics:  []whisper.TopicType{testTopicA},
+	}
+	require.True(t, query.covers(10, testTopicA))
+	require.True(t, query.covers(50, testTopicA))
+	require.False(t, query.covers(30, testTopicA))
+	require.False(t, query.covers(40, testTopicB))
+}
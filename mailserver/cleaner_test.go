@@ -17,7 +17,7 @@ import (
 func TestCleaner(t *testing.T) {
 	now := time.Now()
 	server := setupTestServer(t)
-	cleaner := NewCleanerWithDB(server.db)
+	cleaner := NewCleanerWithDB(levelDB(server))
 	defer server.Close()
 
 	archiveEnvelope(t, now.Add(-10*time.Second), server)
@@ -38,7 +38,7 @@ func benchmarkCleanerPrune(b *testing.B, messages int, batchSize int) {
 	server := setupTestServer(t)
 	defer server.Close()
 
-	cleaner := NewCleanerWithDB(server.db)
+	cleaner := NewCleanerWithDB(levelDB(server))
 	cleaner.batchSize = batchSize
 
 	for i := 0; i < messages; i++ {
@@ -68,11 +68,17 @@ func BenchmarkCleanerPruneM100_000_B100(b *testing.B) {
 
 func setupTestServer(t *testing.T) *WMailServer {
 	var s WMailServer
-	s.db, _ = leveldb.Open(storage.NewMemStorage(), nil)
+	db, _ := leveldb.Open(storage.NewMemStorage(), nil)
+	s.db = NewLevelDBStorageWithDB(db)
 	s.pow = powRequirement
 	return &s
 }
 
+// levelDB returns the leveldb database used by the server storage.
+func levelDB(s *WMailServer) dbImpl {
+	return s.db.(*LevelDBStorage).db
+}
+
 func archiveEnvelope(t *testing.T, sentTime time.Time, server *WMailServer) *whisper.Envelope {
 	env, err := generateEnvelope(sentTime)
 	require.NoError(t, err)
@@ -86,12 +92,12 @@ func testPrune(t *testing.T, u time.Time, expected int, c *Cleaner, s *WMailServ
 	_, err := c.Prune(0, upper)
 	require.NoError(t, err)
 
-	count := countMessages(t, s.db)
+	count := countMessages(t, levelDB(s))
 	require.Equal(t, expected, count)
 }
 
 func testMessagesCount(t *testing.T, expected int, s *WMailServer) {
-	count := countMessages(t, s.db)
+	count := countMessages(t, levelDB(s))
 	require.Equal(t, expected, count, fmt.Sprintf("expected %d message, got: %d", expected, count))
 }
 
@@ -0,0 +1,208 @@
+package mailserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/params"
+	"github.com/stretchr/testify/require"
+)
+
+// requestMessages sends a request from the first node to the MailServer
+// running on the second node and returns the response.
+func requestMessages(t *testing.T, shh *whisper.Whisper, mailServerID []byte, payload MessagesRequestPayload) *whisper.MailServerResponse {
+	keyID, err := shh.AddSymKeyFromPassword("testpassword")
+	require.NoError(t, err)
+	key, err := shh.GetSymKey(keyID)
+	require.NoError(t, err)
+	src, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	data, err := rlp.EncodeToBytes(payload)
+	require.NoError(t, err)
+	messageParams := &whisper.MessageParams{
+		KeySym:   key,
+		Payload:  data,
+		PoW:      powRequirement,
+		WorkTime: 2,
+		Src:      src,
+	}
+	msg, err := whisper.NewSentMessage(messageParams)
+	require.NoError(t, err)
+	request, err := msg.Wrap(messageParams, time.Now())
+	require.NoError(t, err)
+
+	events := make(chan whisper.EnvelopeEvent, 10)
+	sub := shh.SubscribeEnvelopeEvents(events)
+	defer sub.Unsubscribe()
+
+	// retry until whisper finishes the handshake with the peer
+	for i := 0; ; i++ {
+		if err = shh.RequestHistoricMessages(mailServerID, request); err == nil || i == 50 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Event == whisper.EventMailServerRequestCompleted && ev.Hash == request.Hash() {
+				resp := ev.Data.(*whisper.MailServerResponse)
+				require.NoError(t, resp.Error)
+				return resp
+			}
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the response")
+			return nil
+		}
+	}
+}
+
+// generateBigEnvelope returns an envelope with a 8KB payload.
+func generateBigEnvelope(t *testing.T, sentTime time.Time, key []byte) *whisper.Envelope {
+	messageParams := &whisper.MessageParams{
+		Topic:    whisper.TopicType{0x1F, 0x7E, 0xA1, 0x7F},
+		KeySym:   key,
+		Payload:  make([]byte, 8*1024),
+		PoW:      powRequirement,
+		WorkTime: 2,
+	}
+	msg, err := whisper.NewSentMessage(messageParams)
+	require.NoError(t, err)
+	env, err := msg.Wrap(messageParams, sentTime)
+	require.NoError(t, err)
+	return env
+}
+
+// deliveryTest is a client node connected to a MailServer node with archived envelopes.
+type deliveryTest struct {
+	client       *whisper.Whisper
+	mailServerID []byte
+	filterID     string
+	now          time.Time
+	// count is the number of archived envelopes.
+	count int
+}
+
+func startDeliveryTest(t *testing.T) (*deliveryTest, func()) {
+	dataDir, err := ioutil.TempDir("", "mailserver-delivery-test")
+	require.NoError(t, err)
+
+	nodes, shh := startTestNodes(t, 2)
+
+	server := &WMailServer{}
+	shh[1].RegisterServer(server)
+	require.NoError(t, server.Init(shh[1], &params.WhisperConfig{
+		DataDir:            dataDir,
+		MailServerPassword: "testpassword",
+	}))
+
+	stop := func() {
+		server.Close()
+		for _, n := range nodes {
+			require.NoError(t, n.Stop())
+		}
+		os.RemoveAll(dataDir)
+	}
+
+	// envelopes are bigger than a single batch in total
+	now := time.Now()
+	key := crypto.Keccak256Hash([]byte("test sample data"))
+	count := 0
+	for size := 0; size < 2*maxBatchSize; count++ {
+		env := generateBigEnvelope(t, now.Add(-time.Duration(count)*time.Second), key[:])
+		server.Archive(env)
+		size += len(env.Data)
+	}
+
+	filterID, err := shh[0].Subscribe(&whisper.Filter{
+		KeySym:   key[:],
+		Topics:   [][]byte{{0x1F, 0x7E, 0xA1, 0x7F}},
+		AllowP2P: true,
+	})
+	require.NoError(t, err)
+
+	nodes[0].Server().AddPeer(nodes[1].Server().Self())
+	mailServerID := nodes[1].Server().Self().ID
+	return &deliveryTest{
+		client:       shh[0],
+		mailServerID: mailServerID[:],
+		filterID:     filterID,
+		now:          now,
+		count:        count,
+	}, stop
+}
+
+func (d *deliveryTest) payload(batch, compress bool) MessagesRequestPayload {
+	return MessagesRequestPayload{
+		Lower:    uint32(d.now.Add(-time.Hour).Unix()),
+		Upper:    uint32(d.now.Unix()),
+		Bloom:    whisper.MakeFullNodeBloom(),
+		Batch:    batch,
+		Compress: compress,
+		Checksum: true,
+	}
+}
+
+func testDelivery(t *testing.T, batch, compress bool) {
+	d, stop := startDeliveryTest(t)
+	defer stop()
+
+	resp := requestMessages(t, d.client, d.mailServerID, d.payload(batch, compress))
+	require.Nil(t, resp.Cursor)
+	if batch {
+		require.NotNil(t, resp.Checksum)
+		require.Equal(t, uint32(d.count), resp.Checksum.Envelopes)
+		require.Equal(t, *resp.Checksum, resp.Received)
+	} else {
+		// envelopes sent one by one can't be matched with the request
+		require.Nil(t, resp.Checksum)
+	}
+
+	// envelopes are delivered to the filter asynchronously
+	received := 0
+	for i := 0; i < 50 && received < d.count; i++ {
+		received += len(d.client.GetFilter(d.filterID).Retrieve())
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Equal(t, d.count, received, fmt.Sprintf("batch=%t compress=%t", batch, compress))
+}
+
+func TestDeliveryOneByOne(t *testing.T) {
+	testDelivery(t, false, false)
+}
+
+func TestDeliveryInBatches(t *testing.T) {
+	testDelivery(t, true, false)
+}
+
+func TestDeliveryInCompressedBatches(t *testing.T) {
+	testDelivery(t, true, true)
+}
+
+func TestDeliveryConcurrentRequestsChecksums(t *testing.T) {
+	d, stop := startDeliveryTest(t)
+	defer stop()
+
+	// envelopes of concurrent requests to the same mailserver are counted separately
+	responses := make(chan *whisper.MailServerResponse, 2)
+	for i := 0; i < cap(responses); i++ {
+		go func() {
+			responses <- requestMessages(t, d.client, d.mailServerID, d.payload(true, true))
+		}()
+	}
+	for i := 0; i < cap(responses); i++ {
+		resp := <-responses
+		require.NotNil(t, resp.Checksum)
+		require.Equal(t, uint32(d.count), resp.Checksum.Envelopes)
+		require.Equal(t, *resp.Checksum, resp.Received)
+	}
+}
@@ -0,0 +1,53 @@
+package mailserver
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// envelopesCount is an approximate number of archived envelopes.
+// Envelopes are counted once in the background when the MailServer starts,
+// then the count is updated when envelopes are archived or pruned,
+// so that the storage is not iterated on every request for stats.
+// Envelopes archived twice or during the initial count are counted twice.
+type envelopesCount struct {
+	n  int64
+	wg sync.WaitGroup
+}
+
+// start counts envelopes in the storage in the background.
+func (c *envelopesCount) start(db MailServerStorage) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer recoverLevelDBPanics("envelopesCount")
+
+		n, err := db.Count(0, math.MaxUint32)
+		if err != nil {
+			log.Error(fmt.Sprintf("failed to count archived envelopes: %s", err))
+			return
+		}
+		c.add(n)
+	}()
+}
+
+// wait waits until the initial count is done.
+func (c *envelopesCount) wait() {
+	c.wg.Wait()
+}
+
+func (c *envelopesCount) add(n int) {
+	atomic.AddInt64(&c.n, int64(n))
+}
+
+func (c *envelopesCount) get() int {
+	// envelopes pruned during the initial count could be subtracted twice
+	if n := atomic.LoadInt64(&c.n); n > 0 {
+		return int(n)
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package mailserver
+
+// maxErrorMessageLength is the max length of an error message sent in a response.
+// An error code and an error message are sent in place of the cursor
+// and must be shorter than it.
+const maxErrorMessageLength = dbKeyLength - 2
+
+// ErrorCode is sent to a peer in the response to a request
+// which MailServer failed to process.
+type ErrorCode byte
+
+// Error codes sent in responses to failed requests.
+// Codes can only be appended in order to keep them stable.
+const (
+	// ErrorCodeUnknown is sent if the failure reason is not known.
+	ErrorCodeUnknown ErrorCode = iota
+	// ErrorCodeInvalidRequest is sent if the request can't be decrypted or decoded.
+	ErrorCodeInvalidRequest
+	// ErrorCodeLowPoW is sent if the request PoW is lower than required by MailServer.
+	ErrorCodeLowPoW
+	// ErrorCodeInvalidSignature is sent if the request is not signed correctly.
+	ErrorCodeInvalidSignature
+	// ErrorCodeInvalidRange is sent if the requested time range is invalid or too big.
+	ErrorCodeInvalidRange
+	// ErrorCodeRateLimited is sent if the peer or MailServer exceeded rate limits.
+	ErrorCodeRateLimited
+	// ErrorCodeInternal is sent if MailServer failed to read archived envelopes.
+	ErrorCodeInternal
+	// ErrorCodeUnauthorized is sent if the client is not allowed to request envelopes.
+	ErrorCodeUnauthorized
+	// ErrorCodeStaleRequest is sent if the request is not fresh or was already processed.
+	ErrorCodeStaleRequest
+)
+
+// requestError is an error which is reported to the peer
+// in the response to its request.
+type requestError struct {
+	code ErrorCode
+	err  error
+}
+
+func newRequestError(code ErrorCode, err error) error {
+	return &requestError{code: code, err: err}
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// errorCode returns a code of the error which is sent to the peer.
+func errorCode(err error) ErrorCode {
+	if reqErr, ok := err.(*requestError); ok {
+		return reqErr.code
+	}
+	return ErrorCodeUnknown
+}
+
+// encodeResponseError encodes the error code and the error message
+// truncated to fit in the response.
+func encodeResponseError(err error) []byte {
+	message := err.Error()
+	if len(message) > maxErrorMessageLength {
+		message = message[:maxErrorMessageLength]
+	}
+	return append([]byte{byte(errorCode(err))}, message...)
+}
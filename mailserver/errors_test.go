@@ -0,0 +1,22 @@
+package mailserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeResponseError(t *testing.T) {
+	data := encodeResponseError(newRequestError(ErrorCodeLowPoW, errors.New("PoW too low")))
+	require.Equal(t, byte(ErrorCodeLowPoW), data[0])
+	require.Equal(t, "PoW too low", string(data[1:]))
+
+	data = encodeResponseError(errors.New("unexpected error"))
+	require.Equal(t, byte(ErrorCodeUnknown), data[0])
+
+	// error must be shorter than a cursor
+	data = encodeResponseError(newRequestError(ErrorCodeInvalidRange, errors.New(strings.Repeat("x", 100))))
+	require.True(t, len(data) < dbKeyLength)
+}
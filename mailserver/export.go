@@ -0,0 +1,209 @@
+package mailserver
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+)
+
+// exportMagic identifies a stream of exported envelopes.
+const exportMagic = "status-mailserver-export"
+
+// exportVersion is the version of the export stream format.
+const exportVersion = 1
+
+// Kinds of frames in the export stream. A stream consists of a header frame,
+// any number of envelope frames and a trailer frame.
+const (
+	exportFrameHeader uint8 = iota
+	exportFrameEnvelope
+	exportFrameTrailer
+)
+
+var (
+	errExportInvalidMagic    = errors.New("not a mailserver export stream")
+	errExportInvalidChecksum = errors.New("export stream checksum mismatch")
+	errExportMissingTrailer  = errors.New("export stream is truncated")
+)
+
+// exportFrame is a single RLP-encoded element of the export stream.
+type exportFrame struct {
+	Kind uint8
+	Data rlp.RawValue
+}
+
+// ExportHeader describes exported envelopes.
+type ExportHeader struct {
+	Magic   string
+	Version uint
+	// Lower and Upper are timestamps limiting the exported time range (both inclusive).
+	Lower uint32
+	Upper uint32
+}
+
+// exportTrailer closes the stream. Checksum is a sha256 hash
+// of all RLP-encoded envelopes in the order they were written.
+type exportTrailer struct {
+	Count    uint64
+	Checksum common.Hash
+}
+
+// ExportStats summarizes an import of exported envelopes.
+type ExportStats struct {
+	Header ExportHeader
+	// Imported is a number of envelopes archived in the storage.
+	Imported int
+	// Skipped is a number of envelopes which were already archived.
+	Skipped int
+}
+
+func writeExportFrame(w io.Writer, kind uint8, val interface{}) (rlp.RawValue, error) {
+	data, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		return nil, err
+	}
+	return data, rlp.Encode(w, exportFrame{Kind: kind, Data: data})
+}
+
+// ExportEnvelopes writes envelopes sent between lower and upper timestamps
+// (both inclusive) to w and returns how many envelopes have been exported.
+func ExportEnvelopes(w io.Writer, db MailServerStorage, lower, upper uint32) (int, error) {
+	if _, err := writeExportFrame(w, exportFrameHeader, ExportHeader{
+		Magic:   exportMagic,
+		Version: exportVersion,
+		Lower:   lower,
+		Upper:   upper,
+	}); err != nil {
+		return 0, err
+	}
+
+	i, err := db.Query(StorageQuery{
+		Lower: lower,
+		Upper: upper,
+		Bloom: whisper.MakeFullNodeBloom(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer i.Release()
+
+	checksum := sha256.New()
+	count := 0
+	for i.Next() {
+		data, err := writeExportFrame(w, exportFrameEnvelope, i.Envelope())
+		if err != nil {
+			return count, err
+		}
+		checksum.Write(data) // nolint: errcheck
+		count++
+	}
+	if err := i.Error(); err != nil {
+		return count, err
+	}
+
+	_, err = writeExportFrame(w, exportFrameTrailer, exportTrailer{
+		Count:    uint64(count),
+		Checksum: common.BytesToHash(checksum.Sum(nil)),
+	})
+	return count, err
+}
+
+// ImportEnvelopes archives envelopes read from r in db. Envelopes which
+// are already archived are skipped. Envelopes are archived while the stream is read,
+// so a stream with an invalid checksum may be partially imported;
+// use VerifyEnvelopes to check the stream before importing it.
+func ImportEnvelopes(r io.Reader, db MailServerStorage) (ExportStats, error) {
+	var stats ExportStats
+	header, err := readExportStream(r, func(env *whisper.Envelope) error {
+		key := NewDbKey(env.Expiry-env.TTL, env.Hash())
+		exists, err := db.Has(key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			stats.Skipped++
+			return nil
+		}
+		if err := db.Archive(env); err != nil {
+			return err
+		}
+		stats.Imported++
+		return nil
+	})
+	stats.Header = header
+	return stats, err
+}
+
+// VerifyEnvelopes reads the stream and checks its checksum
+// without importing envelopes. It returns the number of envelopes in the stream.
+func VerifyEnvelopes(r io.Reader) (ExportHeader, int, error) {
+	count := 0
+	header, err := readExportStream(r, func(*whisper.Envelope) error {
+		count++
+		return nil
+	})
+	return header, count, err
+}
+
+// readExportStream decodes the stream and calls fn for every envelope.
+func readExportStream(r io.Reader, fn func(*whisper.Envelope) error) (header ExportHeader, err error) {
+	stream := rlp.NewStream(r, 0)
+
+	var frame exportFrame
+	if err = stream.Decode(&frame); err != nil {
+		return
+	}
+	if frame.Kind != exportFrameHeader {
+		return header, errExportInvalidMagic
+	}
+	if err = rlp.DecodeBytes(frame.Data, &header); err != nil {
+		return
+	}
+	if header.Magic != exportMagic {
+		return header, errExportInvalidMagic
+	}
+	if header.Version != exportVersion {
+		return header, fmt.Errorf("unsupported export stream version: %d", header.Version)
+	}
+
+	var (
+		checksum = sha256.New()
+		count    uint64
+	)
+	for {
+		if err = stream.Decode(&frame); err == io.EOF {
+			return header, errExportMissingTrailer
+		} else if err != nil {
+			return
+		}
+
+		switch frame.Kind {
+		case exportFrameEnvelope:
+			var env whisper.Envelope
+			if err = rlp.DecodeBytes(frame.Data, &env); err != nil {
+				return
+			}
+			checksum.Write(frame.Data) // nolint: errcheck
+			count++
+			if err = fn(&env); err != nil {
+				return
+			}
+		case exportFrameTrailer:
+			var trailer exportTrailer
+			if err = rlp.DecodeBytes(frame.Data, &trailer); err != nil {
+				return
+			}
+			if trailer.Count != count || trailer.Checksum != common.BytesToHash(checksum.Sum(nil)) {
+				return header, errExportInvalidChecksum
+			}
+			return header, nil
+		default:
+			return header, fmt.Errorf("unknown export frame kind: %d", frame.Kind)
+		}
+	}
+}
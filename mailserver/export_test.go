@@ -0,0 +1,88 @@
+package mailserver
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestLevelDBStorage(t *testing.T) *LevelDBStorage {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	return NewLevelDBStorageWithDB(db)
+}
+
+func TestExportImportEnvelopes(t *testing.T) {
+	source := newTestLevelDBStorage(t)
+	defer source.Close()
+	for i, timestamp := range []uint32{100, 101, 102, 200} {
+		require.NoError(t, source.Archive(newTestEnvelope(timestamp, testTopicA, uint64(i))))
+	}
+
+	var buf bytes.Buffer
+	exported, err := ExportEnvelopes(&buf, source, 100, 199)
+	require.NoError(t, err)
+	require.Equal(t, 3, exported)
+
+	header, count, err := VerifyEnvelopes(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	require.Equal(t, uint32(100), header.Lower)
+	require.Equal(t, uint32(199), header.Upper)
+
+	destination := newTestLevelDBStorage(t)
+	defer destination.Close()
+	// an envelope archived before the import is skipped
+	require.NoError(t, destination.Archive(newTestEnvelope(101, testTopicA, 1)))
+
+	stats, err := ImportEnvelopes(bytes.NewReader(buf.Bytes()), destination)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Imported)
+	require.Equal(t, 1, stats.Skipped)
+
+	count, err = destination.Count(0, 300)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	// importing the same stream again does not add anything
+	stats, err = ImportEnvelopes(bytes.NewReader(buf.Bytes()), destination)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Imported)
+	require.Equal(t, 3, stats.Skipped)
+}
+
+func TestImportEnvelopesInvalidStream(t *testing.T) {
+	source := newTestLevelDBStorage(t)
+	defer source.Close()
+	require.NoError(t, source.Archive(newTestEnvelope(100, testTopicA, 1)))
+	require.NoError(t, source.Archive(newTestEnvelope(101, testTopicA, 2)))
+
+	var buf bytes.Buffer
+	_, err := ExportEnvelopes(&buf, source, 0, 200)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	// drop the second envelope frame which is followed by the trailer
+	var frames [][]byte
+	for rest := data; len(rest) > 0; {
+		_, _, tail, err := rlp.Split(rest)
+		require.NoError(t, err)
+		frames = append(frames, rest[:len(rest)-len(tail)])
+		rest = tail
+	}
+	require.Len(t, frames, 4)
+	truncated := bytes.Join([][]byte{frames[0], frames[1], frames[3]}, nil)
+
+	_, _, err = VerifyEnvelopes(bytes.NewReader(truncated))
+	require.Equal(t, errExportInvalidChecksum, err)
+
+	_, _, err = VerifyEnvelopes(bytes.NewReader(data[:len(data)-len(frames[3])]))
+	require.Equal(t, errExportMissingTrailer, err)
+
+	_, _, err = VerifyEnvelopes(bytes.NewReader(frames[1]))
+	require.Equal(t, errExportInvalidMagic, err)
+}
@@ -1,50 +1,242 @@
 package mailserver
 
 import (
+	"errors"
 	"sync"
 	"time"
 )
 
+var (
+	errPeerRequestsLimit = errors.New("peer requests limit exceeded")
+	errPeerBytesLimit    = errors.New("peer bytes limit exceeded")
+	errGlobalLimit       = errors.New("global requests limit exceeded")
+)
+
+// tokenBucket is a bucket holding up to capacity tokens which is refilled
+// with rate tokens per second. Tokens can be taken after a request was processed,
+// so the bucket can go into debt which has to be paid off before the next request.
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate, capacity float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+		last:     now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+}
+
+// available returns true if there is at least one token in the bucket.
+func (b *tokenBucket) available(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+// take removes n tokens from the bucket.
+func (b *tokenBucket) take(n float64, now time.Time) {
+	b.refill(now)
+	b.tokens -= n
+}
+
+// full returns true if the bucket is refilled completely.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.capacity
+}
+
+// limiterConfig describes budgets of the limiter.
+// Zero rate disables the corresponding budget.
+type limiterConfig struct {
+	// RequestsRate is a number of requests per second a peer can make on average.
+	RequestsRate float64
+	// RequestsBurst is a number of requests a peer can make at once.
+	RequestsBurst int
+	// BytesRate is a number of bytes per second a peer can receive on average.
+	BytesRate float64
+	// BytesBurst is a number of bytes a peer can receive at once.
+	BytesBurst int
+	// GlobalRequestsRate is a number of requests per second all peers can make together.
+	GlobalRequestsRate float64
+	// GlobalRequestsBurst is a number of requests all peers can make at once.
+	GlobalRequestsBurst int
+	// Whitelist is a list of peer IDs which are not limited.
+	Whitelist []string
+}
+
+type peerBuckets struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+}
+
+// limiter keeps per-peer budgets of requests and sent bytes
+// and a global budget of requests.
 type limiter struct {
-	mu sync.RWMutex
+	mu sync.Mutex
+
+	config    limiterConfig
+	whitelist map[string]struct{}
+	global    *tokenBucket
+	db        map[string]*peerBuckets
+	now       func() time.Time
+}
 
-	timeout time.Duration
-	db      map[string]time.Time
+func newLimiter(config limiterConfig) *limiter {
+	l := &limiter{
+		config:    config,
+		whitelist: make(map[string]struct{}, len(config.Whitelist)),
+		db:        make(map[string]*peerBuckets),
+		now:       time.Now,
+	}
+	for _, id := range config.Whitelist {
+		l.whitelist[id] = struct{}{}
+	}
+	if config.GlobalRequestsRate > 0 {
+		l.global = newTokenBucket(config.GlobalRequestsRate, float64(config.GlobalRequestsBurst), l.now())
+	}
+	return l
 }
 
-func newLimiter(timeout time.Duration) *limiter {
-	return &limiter{
-		timeout: timeout,
-		db:      make(map[string]time.Time),
+func (l *limiter) peer(id string, now time.Time) *peerBuckets {
+	buckets, ok := l.db[id]
+	if !ok {
+		buckets = &peerBuckets{}
+		if l.config.RequestsRate > 0 {
+			buckets.requests = newTokenBucket(l.config.RequestsRate, float64(l.config.RequestsBurst), now)
+		}
+		if l.config.BytesRate > 0 {
+			buckets.bytes = newTokenBucket(l.config.BytesRate, float64(l.config.BytesBurst), now)
+		}
+		l.db[id] = buckets
 	}
+	return buckets
 }
 
-func (l *limiter) add(id string) {
+// allow checks budgets of the peer and the global budget
+// and takes a request token if the request is allowed.
+func (l *limiter) allow(id string) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.db[id] = time.Now()
+	if _, ok := l.whitelist[id]; ok {
+		return nil
+	}
+
+	now := l.now()
+	buckets := l.peer(id, now)
+
+	if buckets.requests != nil && !buckets.requests.available(now) {
+		return errPeerRequestsLimit
+	}
+	if buckets.bytes != nil && !buckets.bytes.available(now) {
+		return errPeerBytesLimit
+	}
+	if l.global != nil && !l.global.available(now) {
+		return errGlobalLimit
+	}
+
+	if buckets.requests != nil {
+		buckets.requests.take(1, now)
+	}
+	if l.global != nil {
+		l.global.take(1, now)
+	}
+	return nil
 }
 
-func (l *limiter) isAllowed(id string) bool {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+// addBytes charges the peer for bytes sent in response to a request.
+func (l *limiter) addBytes(id string, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	if lastRequestTime, ok := l.db[id]; ok {
-		return lastRequestTime.Add(l.timeout).Before(time.Now())
+	if _, ok := l.whitelist[id]; ok {
+		return
 	}
 
-	return true
+	now := l.now()
+	if buckets := l.peer(id, now); buckets.bytes != nil {
+		buckets.bytes.take(float64(size), now)
+	}
 }
 
+// deleteExpired removes peers with fully refilled budgets.
 func (l *limiter) deleteExpired() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	now := time.Now()
-	for id, lastRequestTime := range l.db {
-		if lastRequestTime.Add(l.timeout).Before(now) {
-			delete(l.db, id)
+	now := l.now()
+	for id, buckets := range l.db {
+		if buckets.requests != nil && !buckets.requests.full(now) {
+			continue
+		}
+		if buckets.bytes != nil && !buckets.bytes.full(now) {
+			continue
+		}
+		delete(l.db, id)
+	}
+}
+
+// PeerLimits describes the remaining budgets of a peer.
+// Budgets which are not configured are always zero.
+type PeerLimits struct {
+	// ID is the hex-encoded peer ID.
+	ID string `json:"id"`
+	// Requests is the number of requests the peer can make at once.
+	Requests float64 `json:"requests"`
+	// Bytes is the number of bytes the peer can receive at once.
+	// It is negative if the peer is in debt.
+	Bytes float64 `json:"bytes"`
+	// Limited is true if the next request of the peer will be rejected.
+	Limited bool `json:"limited"`
+}
+
+// peers returns the budgets of peers which are not refilled completely.
+func (l *limiter) peers() []PeerLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	result := make([]PeerLimits, 0, len(l.db))
+	for id, buckets := range l.db {
+		full := true
+		limits := PeerLimits{ID: id}
+		if buckets.requests != nil {
+			limits.Limited = !buckets.requests.available(now)
+			limits.Requests = buckets.requests.tokens
+			full = buckets.requests.full(now)
+		}
+		if buckets.bytes != nil {
+			limits.Limited = limits.Limited || !buckets.bytes.available(now)
+			limits.Bytes = buckets.bytes.tokens
+			full = full && buckets.bytes.full(now)
+		}
+		if !full {
+			result = append(result, limits)
 		}
 	}
+	return result
+}
+
+// reset refills budgets of the peer and returns false if the peer is not known.
+func (l *limiter) reset(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.db[id]
+	delete(l.db, id)
+	return ok
 }
@@ -8,82 +8,130 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestIsAllowed(t *testing.T) {
+// newTestLimiter returns a limiter with a clock controlled by the test.
+func newTestLimiter(config limiterConfig) (*limiter, *time.Time) {
+	l := newLimiter(config)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	if l.global != nil {
+		l.global.last = now
+	}
+	return l, &now
+}
+
+func TestAllowRequests(t *testing.T) {
 	peerID := "peerID"
-	testCases := []struct {
-		t               time.Duration
-		shouldBeAllowed bool
-		db              func() map[string]time.Time
-		info            string
-	}{
-		{
-			t:               5 * time.Millisecond,
-			shouldBeAllowed: true,
-			db: func() map[string]time.Time {
-				return make(map[string]time.Time)
-			},
-			info: "Expecting limiter.isAllowed to allow with an empty db",
-		},
-		{
-			t:               5 * time.Millisecond,
-			shouldBeAllowed: true,
-			db: func() map[string]time.Time {
-				db := make(map[string]time.Time)
-				db[peerID] = time.Now().Add(time.Duration(-10) * time.Millisecond)
-				return db
-			},
-			info: "Expecting limiter.isAllowed to allow with an expired peer on its db",
-		},
-		{
-			t:               5 * time.Millisecond,
-			shouldBeAllowed: false,
-			db: func() map[string]time.Time {
-				db := make(map[string]time.Time)
-				db[peerID] = time.Now().Add(time.Duration(-1) * time.Millisecond)
-				return db
-			},
-			info: "Expecting limiter.isAllowed to not allow with a non expired peer on its db",
-		},
+	l, now := newTestLimiter(limiterConfig{RequestsRate: 1, RequestsBurst: 3})
+
+	// burst is allowed
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.allow(peerID))
 	}
+	assert.Equal(t, errPeerRequestsLimit, l.allow(peerID))
+
+	// other peers have their own budgets
+	assert.NoError(t, l.allow("otherPeerID"))
+
+	// budget is refilled over time
+	*now = now.Add(time.Second)
+	assert.NoError(t, l.allow(peerID))
+	assert.Equal(t, errPeerRequestsLimit, l.allow(peerID))
+}
+
+func TestAllowBytes(t *testing.T) {
+	peerID := "peerID"
+	l, now := newTestLimiter(limiterConfig{BytesRate: 100, BytesBurst: 1000})
+
+	assert.NoError(t, l.allow(peerID))
+	l.addBytes(peerID, 1500)
+	assert.Equal(t, errPeerBytesLimit, l.allow(peerID))
+
+	// the debt must be paid off first
+	*now = now.Add(5 * time.Second)
+	assert.Equal(t, errPeerBytesLimit, l.allow(peerID))
+	*now = now.Add(time.Second)
+	assert.NoError(t, l.allow(peerID))
+}
+
+func TestAllowGlobal(t *testing.T) {
+	l, now := newTestLimiter(limiterConfig{GlobalRequestsRate: 2, GlobalRequestsBurst: 2})
+
+	assert.NoError(t, l.allow("peer1"))
+	assert.NoError(t, l.allow("peer2"))
+	assert.Equal(t, errGlobalLimit, l.allow("peer3"))
 
-	for _, tc := range testCases {
-		t.Run(tc.info, func(*testing.T) {
-			l := newLimiter(tc.t)
-			l.db = tc.db()
-			assert.Equal(t, tc.shouldBeAllowed, l.isAllowed(peerID))
-		})
+	*now = now.Add(time.Second)
+	assert.NoError(t, l.allow("peer3"))
+}
+
+func TestAllowWhitelisted(t *testing.T) {
+	peerID := "trustedPeerID"
+	l, _ := newTestLimiter(limiterConfig{
+		RequestsRate:       1,
+		RequestsBurst:      1,
+		GlobalRequestsRate: 1,
+		Whitelist:          []string{peerID},
+	})
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.allow(peerID))
 	}
+	assert.Empty(t, l.db)
 }
 
 func TestRemoveExpiredRateLimits(t *testing.T) {
 	peer := "peer"
-	l := newLimiter(time.Duration(5) * time.Second)
+	l, now := newTestLimiter(limiterConfig{RequestsRate: 1, RequestsBurst: 5})
+	start := *now
 	for i := 0; i < 10; i++ {
+		// every peer starts with an empty budget at a different time
+		*now = start.Add(time.Duration(i) * time.Second)
 		peerID := fmt.Sprintf("%s%d", peer, i)
-		l.db[peerID] = time.Now().Add(time.Duration(i*(-2)) * time.Second)
+		for j := 0; j < 5; j++ {
+			assert.NoError(t, l.allow(peerID))
+		}
 	}
 
+	// peers which spent their budget at least 5 seconds ago are refilled
+	*now = start.Add(11 * time.Second)
 	l.deleteExpired()
 	assert.Equal(t, 3, len(l.db))
 
-	for i := 0; i < 3; i++ {
+	for i := 0; i < 7; i++ {
 		peerID := fmt.Sprintf("%s%d", peer, i)
 		_, ok := l.db[peerID]
-		assert.True(t, ok, fmt.Sprintf("Non expired peer '%s' should exist, but it doesn't", peerID))
+		assert.False(t, ok, fmt.Sprintf("Expired peer '%s' should not exist, but it does", peerID))
 	}
-	for i := 3; i < 10; i++ {
+	for i := 7; i < 10; i++ {
 		peerID := fmt.Sprintf("%s%d", peer, i)
 		_, ok := l.db[peerID]
-		assert.False(t, ok, fmt.Sprintf("Expired peer '%s' should not exist, but it does", peerID))
+		assert.True(t, ok, fmt.Sprintf("Non expired peer '%s' should exist, but it doesn't", peerID))
 	}
 }
 
-func TestAddingLimts(t *testing.T) {
-	peerID := "peerAdding"
-	l := newLimiter(time.Duration(5) * time.Second)
-	pre := time.Now()
-	l.add(peerID)
-	post := time.Now()
-	assert.True(t, l.db[peerID].After(pre))
-	assert.True(t, l.db[peerID].Before(post))
+func TestLimitedPeersAndReset(t *testing.T) {
+	l, now := newTestLimiter(limiterConfig{RequestsRate: 1, RequestsBurst: 2, BytesRate: 100, BytesBurst: 1000})
+
+	assert.NoError(t, l.allow("peer1"))
+	l.addBytes("peer1", 1500)
+	assert.NoError(t, l.allow("peer2"))
+
+	peers := l.peers()
+	assert.Len(t, peers, 2)
+	for _, p := range peers {
+		switch p.ID {
+		case "peer1":
+			assert.Equal(t, PeerLimits{ID: "peer1", Requests: 1, Bytes: -500, Limited: true}, p)
+		case "peer2":
+			assert.Equal(t, PeerLimits{ID: "peer2", Requests: 1, Bytes: 1000, Limited: false}, p)
+		}
+	}
+
+	// peers with refilled budgets are not returned
+	*now = now.Add(time.Second)
+	assert.Len(t, l.peers(), 1)
+
+	assert.True(t, l.reset("peer1"))
+	assert.False(t, l.reset("peer1"))
+	assert.NoError(t, l.allow("peer1"))
 }
@@ -17,9 +17,12 @@
 package mailserver
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 
 	"time"
@@ -28,19 +31,29 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/rlp"
 	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
-	"github.com/status-im/status-go/db"
 	"github.com/status-im/status-go/params"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
-	"github.com/syndtr/goleveldb/leveldb/opt"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
-	maxQueryRange = 24 * time.Hour
-	noLimits      = 0
+	// defaultMaxQueryRange is used if WhisperConfig.MailServerMaxQueryRange is not set.
+	defaultMaxQueryRange = 24 * time.Hour
+	// queryChunkRange is the max time range delivered in response to a single request.
+	// Bigger time ranges are delivered in pages using the cursor.
+	queryChunkRange = 24 * time.Hour
+	// defaultRateLimitBurst is used if WhisperConfig.MailServerRateLimitBurst is not set.
+	defaultRateLimitBurst = 5
+	// defaultBytesLimitBurstPeriod is the period of time worth of bytes
+	// a peer can receive at once if WhisperConfig.MailServerBytesLimitBurst is not set.
+	defaultBytesLimitBurstPeriod = 60
+	// limiterCleanupPeriod is the period of removing peers with refilled budgets from the limiter.
+	limiterCleanupPeriod = time.Minute
+	noLimits             = 0
+	// maxBatchSize is the max size of envelopes sent in a single batch.
+	// It keeps batches below the default whisper max message size.
+	maxBatchSize = 512 * 1024
 )
 
 var (
@@ -51,8 +64,10 @@ var (
 	requestProcessTimer    = metrics.NewRegisteredTimer("mailserver/requestProcessTime", nil)
 	requestsMeter          = metrics.NewRegisteredMeter("mailserver/requests", nil)
 	requestErrorsCounter   = metrics.NewRegisteredCounter("mailserver/requestErrors", nil)
+	rateLimitedCounter     = metrics.NewRegisteredCounter("mailserver/rateLimitedRequests", nil)
 	sentEnvelopesMeter     = metrics.NewRegisteredMeter("mailserver/sentEnvelopes", nil)
 	sentEnvelopesSizeMeter = metrics.NewRegisteredMeter("mailserver/sentEnvelopesSize", nil)
+	sentBatchesMeter       = metrics.NewRegisteredMeter("mailserver/sentBatches", nil)
 	archivedMeter          = metrics.NewRegisteredMeter("mailserver/archivedEnvelopes", nil)
 	archivedSizeMeter      = metrics.NewRegisteredMeter("mailserver/archivedEnvelopesSize", nil)
 	archivedErrorsCounter  = metrics.NewRegisteredCounter("mailserver/archiveErrors", nil)
@@ -67,31 +82,46 @@ const (
 
 type cursorType []byte
 
-// dbImpl is an interface introduced to be able to test some unexpected
-// panics from leveldb that are difficult to reproduce.
-// normally the db implementation is leveldb.DB, but in TestMailServerDBPanicSuite
-// we use panicDB to test panics from the db.
-// more info about the panic errors:
-// https://github.com/syndtr/goleveldb/issues/224
-type dbImpl interface {
-	Close() error
-	Write(*leveldb.Batch, *opt.WriteOptions) error
-	Put([]byte, []byte, *opt.WriteOptions) error
-	Get([]byte, *opt.ReadOptions) ([]byte, error)
-	NewIterator(*util.Range, *opt.ReadOptions) iterator.Iterator
+// deliveryMode describes how envelopes are sent to the peer.
+type deliveryMode struct {
+	// requestID is the ID of the request batches are sent for.
+	requestID common.Hash
+	// batch packs many envelopes into a single p2p message.
+	batch bool
+	// compress compresses batches with snappy.
+	compress bool
+	// checksum sends the number and the hash of sent envelopes with the response.
+	checksum bool
 }
 
 // WMailServer whisper mailserver.
 type WMailServer struct {
-	db         dbImpl
+	db         MailServerStorage
+	cache      *queryCache
 	w          *whisper.Whisper
 	pow        float64
 	symFilter  *whisper.Filter
 	asymFilter *whisper.Filter
 
+	maxQueryRange time.Duration
+
+	verifyPeerSignature bool
+	allowedClients      map[string]struct{}
+	replayGuard         *replayGuard
+
 	muLimiter sync.RWMutex
 	limiter   *limiter
 	tick      *ticker
+
+	pruner    *pruner
+	envelopes envelopesCount
+
+	archiveFilter *archiveFilter
+	archivePoW    float64
+	topicQuota    *topicQuota
+
+	syncPeers map[string]struct{}
+	syncer    *syncer
 }
 
 // DBKey key to be stored on db.
@@ -126,29 +156,150 @@ func (s *WMailServer) Init(shh *whisper.Whisper, config *params.WhisperConfig) e
 
 	s.w = shh
 	s.pow = config.MinimumPoW
+	s.maxQueryRange = defaultMaxQueryRange
+	if config.MailServerMaxQueryRange > 0 {
+		s.maxQueryRange = time.Duration(config.MailServerMaxQueryRange) * time.Second
+	}
 
 	if err := s.setupRequestMessageDecryptor(config); err != nil {
 		return err
 	}
-	s.setupLimiter(time.Duration(config.MailServerRateLimit) * time.Second)
+	if err := s.setupRequestAuthentication(config); err != nil {
+		return err
+	}
+	s.setupLimiter(config)
+	if err := s.setupArchiveFilter(config); err != nil {
+		return err
+	}
 
 	// Open database in the last step in order not to init with error
 	// and leave the database open by accident.
-	database, err := db.Open(config.DataDir, nil)
+	database, err := NewStorage(config)
 	if err != nil {
 		return fmt.Errorf("open DB: %s", err)
 	}
 	s.db = database
+	s.envelopes.start(s.db)
+
+	if !config.MailServerDisableQueryCache {
+		if s.cache, err = newQueryCache(s.db, queryCacheSize); err != nil {
+			s.Close()
+			return fmt.Errorf("create query cache: %v", err)
+		}
+	}
+
+	s.setupPruner(config)
+
+	return s.setupSyncer(config)
+}
+
+// setupSyncer in case sync peers are configured it will allow them
+// to sync envelopes and start requesting missing envelopes from them periodically.
+func (s *WMailServer) setupSyncer(config *params.WhisperConfig) error {
+	if len(config.MailServerSyncPeers) == 0 {
+		return nil
+	}
+
+	s.syncPeers = make(map[string]struct{}, len(config.MailServerSyncPeers))
+	peers := make([]discover.NodeID, len(config.MailServerSyncPeers))
+	for i, enode := range config.MailServerSyncPeers {
+		node, err := discover.ParseNode(enode)
+		if err != nil {
+			return fmt.Errorf("parse sync peer: %v", err)
+		}
+		peers[i] = node.ID
+		s.syncPeers[hex.EncodeToString(node.ID[:])] = struct{}{}
+	}
+
+	period := defaultSyncPeriod
+	if config.MailServerSyncPeriod > 0 {
+		period = time.Duration(config.MailServerSyncPeriod) * time.Second
+	}
+	syncRange := defaultSyncRange
+	if config.MailServerSyncRange > 0 {
+		syncRange = time.Duration(config.MailServerSyncRange) * time.Second
+	}
+
+	s.syncer = newSyncer(s.w, peers, period, syncRange)
+	s.syncer.Start()
+	return nil
+}
+
+// setupArchiveFilter configures topics of archived envelopes,
+// the archival PoW and the per-topic daily quota.
+func (s *WMailServer) setupArchiveFilter(config *params.WhisperConfig) error {
+	include, err := parseTopics(config.MailServerArchiveTopics)
+	if err != nil {
+		return fmt.Errorf("parse archive topics: %v", err)
+	}
+	exclude, err := parseTopics(config.MailServerExcludeTopics)
+	if err != nil {
+		return fmt.Errorf("parse exclude topics: %v", err)
+	}
+
+	s.archiveFilter = newArchiveFilter(include, exclude)
 
+	s.archivePoW = config.MailServerArchivePoW
+	s.topicQuota = nil
+	if config.MailServerTopicDailyQuota > 0 {
+		s.topicQuota = newTopicQuota(config.MailServerTopicDailyQuota)
+	}
 	return nil
 }
 
-// setupLimiter in case limit is bigger than 0 it will setup an automated
-// limit db cleanup.
-func (s *WMailServer) setupLimiter(limit time.Duration) {
-	if limit > 0 {
-		s.limiter = newLimiter(limit)
-		s.setupMailServerCleanup(limit)
+// setupPruner in case a retention window or a max storage size is configured
+// it will start removing expired envelopes periodically.
+func (s *WMailServer) setupPruner(config *params.WhisperConfig) {
+	retention := time.Duration(config.MailServerDataRetention) * 24 * time.Hour
+	if retention <= 0 && config.MailServerMaxStorageSize <= 0 {
+		return
+	}
+
+	period := defaultPrunePeriod
+	if config.MailServerCleanupPeriod > 0 {
+		period = time.Duration(config.MailServerCleanupPeriod) * time.Second
+	}
+
+	s.pruner = newPruner(s.db, retention, config.MailServerMaxStorageSize, period)
+	s.pruner.cache = s.cache
+	s.pruner.envelopes = &s.envelopes
+	s.pruner.Start()
+}
+
+// setupLimiter in case any limit is configured it will setup a limiter
+// and an automated limit db cleanup.
+func (s *WMailServer) setupLimiter(config *params.WhisperConfig) {
+	lc := limiterConfig{
+		Whitelist: make([]string, len(config.MailServerRateLimitWhitelist)),
+	}
+	for i, id := range config.MailServerRateLimitWhitelist {
+		lc.Whitelist[i] = strings.ToLower(strings.TrimPrefix(id, "0x"))
+	}
+
+	if config.MailServerRateLimit > 0 {
+		lc.RequestsRate = 1 / float64(config.MailServerRateLimit)
+		lc.RequestsBurst = config.MailServerRateLimitBurst
+		if lc.RequestsBurst <= 0 {
+			lc.RequestsBurst = defaultRateLimitBurst
+		}
+	}
+
+	if config.MailServerBytesLimit > 0 {
+		lc.BytesRate = float64(config.MailServerBytesLimit)
+		lc.BytesBurst = config.MailServerBytesLimitBurst
+		if lc.BytesBurst <= 0 {
+			lc.BytesBurst = config.MailServerBytesLimit * defaultBytesLimitBurstPeriod
+		}
+	}
+
+	if config.MailServerGlobalRateLimit > 0 {
+		lc.GlobalRequestsRate = float64(config.MailServerGlobalRateLimit)
+		lc.GlobalRequestsBurst = config.MailServerGlobalRateLimit
+	}
+
+	if lc.RequestsRate > 0 || lc.BytesRate > 0 || lc.GlobalRequestsRate > 0 {
+		s.limiter = newLimiter(lc)
+		s.setupMailServerCleanup(limiterCleanupPeriod)
 	}
 }
 
@@ -183,6 +334,33 @@ func (s *WMailServer) setupRequestMessageDecryptor(config *params.WhisperConfig)
 	return nil
 }
 
+// setupRequestAuthentication configures checks of the request signer
+// and the replay protection.
+func (s *WMailServer) setupRequestAuthentication(config *params.WhisperConfig) error {
+	s.verifyPeerSignature = config.MailServerVerifyPeerSignature
+	s.allowedClients = nil
+	s.replayGuard = nil
+
+	if config.MailServerRequestMaxSkew > 0 {
+		s.replayGuard = newReplayGuard(time.Duration(config.MailServerRequestMaxSkew) * time.Second)
+	}
+
+	if len(config.MailServerAllowedClients) == 0 {
+		return nil
+	}
+
+	s.allowedClients = make(map[string]struct{}, len(config.MailServerAllowedClients))
+	for _, key := range config.MailServerAllowedClients {
+		pub, err := crypto.UnmarshalPubkey(common.FromHex(key))
+		if err != nil {
+			return fmt.Errorf("parse allowed client key: %v", err)
+		}
+		s.allowedClients[hex.EncodeToString(crypto.FromECDSAPub(pub))] = struct{}{}
+	}
+
+	return nil
+}
+
 // setupMailServerCleanup periodically runs an expired entries deleteion for
 // stored limits.
 func (s *WMailServer) setupMailServerCleanup(period time.Duration) {
@@ -194,6 +372,13 @@ func (s *WMailServer) setupMailServerCleanup(period time.Duration) {
 
 // Close the mailserver and its associated db connection.
 func (s *WMailServer) Close() {
+	if s.syncer != nil {
+		s.syncer.Stop()
+	}
+	if s.pruner != nil {
+		s.pruner.Stop()
+	}
+	s.envelopes.wait()
 	if s.db != nil {
 		if err := s.db.Close(); err != nil {
 			log.Error(fmt.Sprintf("s.db.Close failed: %s", err))
@@ -213,23 +398,49 @@ func recoverLevelDBPanics(calleMethodName string) {
 	}
 }
 
-// Archive a whisper envelope.
+// Archive a whisper envelope unless its topic is not archived,
+// its PoW is too low or the daily quota of its topic is exceeded.
 func (s *WMailServer) Archive(env *whisper.Envelope) {
 	defer recoverLevelDBPanics("Archive")
 
-	key := NewDbKey(env.Expiry-env.TTL, env.Hash())
-	rawEnvelope, err := rlp.EncodeToBytes(env)
-	if err != nil {
-		log.Error(fmt.Sprintf("rlp.EncodeToBytes failed: %s", err))
-		archivedErrorsCounter.Inc(1)
-	} else {
-		if err = s.db.Put(key.raw, rawEnvelope, nil); err != nil {
-			log.Error(fmt.Sprintf("Writing to DB failed: %s", err))
-			archivedErrorsCounter.Inc(1)
+	if s.archiveFilter != nil && !s.archiveFilter.allow(env.Topic) {
+		return
+	}
+	if s.archivePoW > 0 && env.PoW() < s.archivePoW {
+		lowArchivePoWCounter.Inc(1)
+		return
+	}
+
+	sent := env.Expiry - env.TTL
+	size := int64(whisper.EnvelopeHeaderLength + len(env.Data))
+	if s.topicQuota != nil {
+		// duplicates are not archived again, so they must not use the quota
+		if has, err := s.db.Has(NewDbKey(sent, env.Hash())); err == nil && has {
+			return
+		}
+		if !s.topicQuota.allowed(env.Topic, sent, size) {
+			quotaExceededCounter.Inc(1)
+			return
 		}
-		archivedMeter.Mark(1)
-		archivedSizeMeter.Mark(int64(whisper.EnvelopeHeaderLength + len(env.Data)))
 	}
+
+	if err := s.db.Archive(env); err != nil {
+		log.Error(fmt.Sprintf("Writing to DB failed: %s", err))
+		archivedErrorsCounter.Inc(1)
+		return
+	}
+	if s.topicQuota != nil {
+		s.topicQuota.charge(env.Topic, sent, size)
+	}
+	s.envelopes.add(1)
+	if s.cache != nil {
+		s.cache.Invalidate(env)
+	}
+	if s.archiveFilter != nil {
+		s.archiveFilter.markArchived()
+	}
+	archivedMeter.Mark(1)
+	archivedSizeMeter.Mark(size)
 }
 
 // DeliverMail sends mail to specified whisper peer.
@@ -242,50 +453,194 @@ func (s *WMailServer) DeliverMail(peer *whisper.Peer, request *whisper.Envelope)
 		log.Error("Whisper peer is nil")
 		return
 	}
-	if s.exceedsPeerRequests(peer.ID()) {
+	if err := s.checkRateLimits(peer.ID()); err != nil {
 		requestErrorsCounter.Inc(1)
+		rateLimitedCounter.Inc(1)
+		if err := s.sendHistoricMessageErrorResponse(peer, request, err); err != nil {
+			log.Error(fmt.Sprintf("SendHistoricMessageResponse error: %s", err))
+		}
 		return
 	}
 
 	defer recoverLevelDBPanics("DeliverMail")
 
-	if ok, lower, upper, bloom, limit, cursor := s.validateRequest(peer.ID(), request); ok {
-		_, lastEnvelopeHash, nextPageCursor, err := s.processRequest(peer, lower, upper, bloom, limit, cursor)
-		if err != nil {
-			log.Error(fmt.Sprintf("error in DeliverMail: %s", err))
-			return
+	payload, err := s.validateRequest(peer.ID(), request)
+	if err != nil {
+		log.Warn(fmt.Sprintf("Invalid p2p request: %s", err))
+		if err := s.sendHistoricMessageErrorResponse(peer, request, err); err != nil {
+			log.Error(fmt.Sprintf("SendHistoricMessageResponse error: %s", err))
 		}
+		return
+	}
 
-		if err := s.sendHistoricMessageResponse(peer, request, lastEnvelopeHash, nextPageCursor); err != nil {
+	query, chunkCursor := storageQuery(payload).split(uint32(queryChunkRange / time.Second))
+	mode := requestDeliveryMode(request.Hash(), payload)
+	_, lastEnvelopeHash, nextPageCursor, checksum, err := s.processRequest(peer, query, mode)
+	if err != nil {
+		log.Error(fmt.Sprintf("error in DeliverMail: %s", err))
+		// do not expose details of the storage errors
+		err = newRequestError(ErrorCodeInternal, errors.New("Failed to process p2p request"))
+		if err := s.sendHistoricMessageErrorResponse(peer, request, err); err != nil {
 			log.Error(fmt.Sprintf("SendHistoricMessageResponse error: %s", err))
 		}
+		return
+	}
+
+	// the current chunk is exhausted, the client continues with the next one
+	if nextPageCursor == nil {
+		nextPageCursor = chunkCursor
+	}
+
+	if mode.checksum {
+		err = s.sendHistoricMessageChecksumResponse(peer, request, lastEnvelopeHash, nextPageCursor, checksum)
+	} else {
+		err = s.sendHistoricMessageResponse(peer, request, lastEnvelopeHash, nextPageCursor)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("SendHistoricMessageResponse error: %s", err))
 	}
 }
 
-// exceedsPeerRequests in case limit its been setup on the current server and limit
-// allows the query, it will store/update new query time for the current peer.
-func (s *WMailServer) exceedsPeerRequests(peer []byte) bool {
+// SyncMail sends archived envelopes to another MailServer in batches.
+// Only peers configured in WhisperConfig.MailServerSyncPeers are allowed to sync.
+func (s *WMailServer) SyncMail(peer *whisper.Peer, request whisper.SyncMailRequest) error {
+	log.Info("Started syncing envelopes", "peer", peer.ID(), "req", request)
+	syncRequestsMeter.Mark(1)
+
+	defer recoverLevelDBPanics("SyncMail")
+
+	if _, ok := s.syncPeers[hex.EncodeToString(peer.ID())]; !ok {
+		return s.sendSyncErrorResponse(peer, errors.New("peer is not allowed to sync"))
+	}
+
+	if err := request.Validate(); err != nil {
+		return s.sendSyncErrorResponse(peer, err)
+	}
+
+	if len(request.Cursor) != 0 && len(request.Cursor) != dbKeyLength {
+		return s.sendSyncErrorResponse(peer, errors.New("invalid cursor size"))
+	}
+
+	query := StorageQuery{
+		Lower:  request.Lower,
+		Upper:  request.Upper,
+		Bloom:  request.Bloom,
+		Limit:  request.Limit,
+		Cursor: request.Cursor,
+	}
+	if len(query.Bloom) == 0 {
+		query.Bloom = whisper.MakeFullNodeBloom()
+	}
+	if query.Limit > maxSyncLimit {
+		query.Limit = maxSyncLimit
+	}
+	if len(query.Cursor) == 0 {
+		query.Cursor = nil
+	}
+
+	nextCursor, err := s.syncEnvelopes(peer, query)
+	if err != nil {
+		log.Error(fmt.Sprintf("error in SyncMail: %s", err))
+		// do not expose details of the storage errors
+		return s.sendSyncErrorResponse(peer, errors.New("failed to sync envelopes"))
+	}
+
+	return s.w.SendSyncResponse(peer, whisper.SyncResponse{Cursor: nextCursor, Final: true})
+}
+
+// syncEnvelopes sends envelopes matching the query in batches of syncBatchSize
+// and returns the cursor of the next page if the query limit was reached.
+func (s *WMailServer) syncEnvelopes(peer *whisper.Peer, query StorageQuery) (nextCursor cursorType, err error) {
+	i, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer i.Release()
+
+	var (
+		batch []*whisper.Envelope
+		sent  uint32
+	)
+	for i.Next() {
+		batch = append(batch, i.Envelope())
+		sent++
+
+		if sent == query.Limit {
+			nextCursor = i.Cursor()
+			break
+		}
+
+		if len(batch) == syncBatchSize {
+			if err := s.w.SendSyncResponse(peer, whisper.SyncResponse{Envelopes: batch}); err != nil {
+				return nil, err
+			}
+			batch = nil
+		}
+	}
+
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	if len(batch) > 0 {
+		if err := s.w.SendSyncResponse(peer, whisper.SyncResponse{Envelopes: batch}); err != nil {
+			return nil, err
+		}
+	}
+
+	sentEnvelopesMeter.Mark(int64(sent))
+	return nextCursor, nil
+}
+
+func (s *WMailServer) sendSyncErrorResponse(peer *whisper.Peer, syncErr error) error {
+	log.Warn(fmt.Sprintf("Invalid p2p sync request: %s", syncErr))
+	requestErrorsCounter.Inc(1)
+	return s.w.SendSyncResponse(peer, whisper.SyncResponse{Error: syncErr.Error()})
+}
+
+// checkRateLimits in case limit its been setup on the current server it checks
+// budgets of the peer and takes a request from them if the query is allowed.
+func (s *WMailServer) checkRateLimits(peer []byte) error {
 	s.muLimiter.RLock()
 	defer s.muLimiter.RUnlock()
 
 	if s.limiter != nil {
-		peerID := string(peer)
-		if !s.limiter.isAllowed(peerID) {
-			log.Info("peerID exceeded the number of requests per second")
-			return true
+		peerID := hex.EncodeToString(peer)
+		if err := s.limiter.allow(peerID); err != nil {
+			log.Info("peer exceeded rate limits", "peer", peerID, "err", err)
+			return newRequestError(ErrorCodeRateLimited, err)
 		}
-		s.limiter.add(peerID)
 	}
-	return false
+	return nil
+}
+
+// addSentBytes charges the peer for bytes sent in response to a query.
+func (s *WMailServer) addSentBytes(peer []byte, size int64) {
+	s.muLimiter.RLock()
+	defer s.muLimiter.RUnlock()
+
+	if s.limiter != nil {
+		s.limiter.addBytes(hex.EncodeToString(peer), size)
+	}
+}
+
+// query returns envelopes matching the query from the cache if it is enabled.
+func (s *WMailServer) query(query StorageQuery) (StorageIterator, error) {
+	if s.cache == nil {
+		return s.db.Query(query)
+	}
+	return s.cache.Query(query)
 }
 
 // processRequest processes the current request and re-sends all stored messages
-// accomplishing lower and upper limits. The limit parameter determines the maximum number of
+// accomplishing lower and upper limits. The query limit determines the maximum number of
 // messages to be sent back for the current request.
-// The cursor parameter is used for pagination.
+// The query cursor is used for pagination.
+// Envelopes are sent one by one or in batches depending on the delivery mode.
+// The returned checksum describes the sent envelopes in order.
 // After sending all the messages, a message of type p2pRequestCompleteCode is sent by the mailserver to
 // the peer.
-func (s *WMailServer) processRequest(peer *whisper.Peer, lower, upper uint32, bloom []byte, limit uint32, cursor cursorType) (ret []*whisper.Envelope, lastEnvelopeHash common.Hash, nextPageCursor cursorType, err error) {
+func (s *WMailServer) processRequest(peer *whisper.Peer, query StorageQuery, mode deliveryMode) (ret []*whisper.Envelope, lastEnvelopeHash common.Hash, nextPageCursor cursorType, checksum whisper.MailServerChecksum, err error) {
 	// Recover from possible goleveldb panics
 	defer func() {
 		if r := recover(); r != nil {
@@ -296,66 +651,83 @@ func (s *WMailServer) processRequest(peer *whisper.Peer, lower, upper uint32, bl
 	var (
 		sentEnvelopes     uint32
 		sentEnvelopesSize int64
-		zero              common.Hash
-		ku                []byte
-		kl                []byte
+		batch             []*whisper.Envelope
+		batchSize         int64
 	)
 
-	kl = NewDbKey(lower, zero).raw
-	if cursor != nil {
-		ku = cursor
-	} else {
-		ku = NewDbKey(upper+1, zero).raw
+	i, err := s.query(query)
+	if err != nil {
+		return
 	}
-
-	i := s.db.NewIterator(&util.Range{Start: kl, Limit: ku}, nil)
-	i.Seek(ku)
 	defer i.Release()
 
 	start := time.Now()
 
-	for i.Prev() {
-		var envelope whisper.Envelope
-		decodeErr := rlp.DecodeBytes(i.Value(), &envelope)
-		if decodeErr != nil {
-			log.Error(fmt.Sprintf("RLP decoding failed: %s", decodeErr))
-			continue
-		}
-
-		if whisper.BloomFilterMatch(bloom, envelope.Bloom()) {
-			if peer == nil {
-				// used for test purposes
-				ret = append(ret, &envelope)
-			} else {
-				err = s.w.SendP2PDirect(peer, &envelope)
-				if err != nil {
-					log.Error(fmt.Sprintf("Failed to send direct message to peer: %s", err))
+	for i.Next() {
+		envelope := i.Envelope()
+		size := whisper.EnvelopeHeaderLength + int64(len(envelope.Data))
+		if peer == nil {
+			// used for test purposes
+			ret = append(ret, envelope)
+		} else if mode.batch {
+			batch = append(batch, envelope)
+			batchSize += size
+			if batchSize >= maxBatchSize {
+				if err = s.sendBatch(peer, mode, batch); err != nil {
 					return
 				}
-				lastEnvelopeHash = envelope.Hash()
+				batch, batchSize = nil, 0
 			}
-			sentEnvelopes++
-			sentEnvelopesSize += whisper.EnvelopeHeaderLength + int64(len(envelope.Data))
-
-			if limit != noLimits && sentEnvelopes == limit {
-				nextPageCursor = i.Key()
-				break
+			lastEnvelopeHash = envelope.Hash()
+		} else {
+			err = s.w.SendP2PDirect(peer, envelope)
+			if err != nil {
+				log.Error(fmt.Sprintf("Failed to send direct message to peer: %s", err))
+				return
 			}
+			lastEnvelopeHash = envelope.Hash()
+		}
+		sentEnvelopes++
+		sentEnvelopesSize += size
+		checksum.Add(envelope.Hash())
+
+		if query.Limit != noLimits && sentEnvelopes == query.Limit {
+			nextPageCursor = i.Cursor()
+			break
+		}
+	}
+
+	if len(batch) > 0 {
+		if err = s.sendBatch(peer, mode, batch); err != nil {
+			return
 		}
 	}
 
 	requestProcessTimer.UpdateSince(start)
 	sentEnvelopesMeter.Mark(int64(sentEnvelopes))
 	sentEnvelopesSizeMeter.Mark(sentEnvelopesSize)
+	if peer != nil {
+		s.addSentBytes(peer.ID(), sentEnvelopesSize)
+	}
 
 	err = i.Error()
 	if err != nil {
-		log.Error(fmt.Sprintf("Level DB iterator error: %s", err))
+		log.Error(fmt.Sprintf("DB iterator error: %s", err))
 	}
 
 	return
 }
 
+// sendBatch sends many envelopes to the peer in a single p2p message.
+func (s *WMailServer) sendBatch(peer *whisper.Peer, mode deliveryMode, envelopes []*whisper.Envelope) error {
+	if err := s.w.SendP2PDirectBatch(peer, mode.requestID, envelopes, mode.compress); err != nil {
+		log.Error(fmt.Sprintf("Failed to send a batch of direct messages to peer: %s", err))
+		return err
+	}
+	sentBatchesMeter.Mark(1)
+	return nil
+}
+
 func (s *WMailServer) sendHistoricMessageResponse(peer *whisper.Peer, request *whisper.Envelope, lastEnvelopeHash common.Hash, cursor cursorType) error {
 	requestID := request.Hash()
 	payload := append(requestID[:], lastEnvelopeHash[:]...)
@@ -363,6 +735,32 @@ func (s *WMailServer) sendHistoricMessageResponse(peer *whisper.Peer, request *w
 	return s.w.SendHistoricMessageResponse(peer, payload)
 }
 
+// sendHistoricMessageChecksumResponse notifies the peer that its request is completed
+// and describes the sent envelopes, so that the peer can verify it received all of them.
+// The cursor is replaced with zeros if there are no more envelopes to keep the payload size fixed.
+func (s *WMailServer) sendHistoricMessageChecksumResponse(peer *whisper.Peer, request *whisper.Envelope, lastEnvelopeHash common.Hash, cursor cursorType, checksum whisper.MailServerChecksum) error {
+	requestID := request.Hash()
+	payload := append(requestID[:], lastEnvelopeHash[:]...)
+	if cursor == nil {
+		cursor = make(cursorType, dbKeyLength)
+	}
+	payload = append(payload, cursor...)
+	payload = append(payload, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(payload[len(payload)-4:], checksum.Envelopes)
+	payload = append(payload, checksum.Hash[:]...)
+	return s.w.SendHistoricMessageResponse(peer, payload)
+}
+
+// sendHistoricMessageErrorResponse notifies the peer that its request failed.
+// The error is sent instead of the cursor as an error code followed by the error message.
+// It must be shorter than a cursor in order to be distinguished from it.
+func (s *WMailServer) sendHistoricMessageErrorResponse(peer *whisper.Peer, request *whisper.Envelope, reqErr error) error {
+	requestID := request.Hash()
+	payload := append(requestID[:], make([]byte, common.HashLength)...)
+	payload = append(payload, encodeResponseError(reqErr)...)
+	return s.w.SendHistoricMessageResponse(peer, payload)
+}
+
 // openEnvelope tries to decrypt an envelope, first based on asymetric key (if
 // provided) and second on the symetric key (if provided)
 func (s *WMailServer) openEnvelope(request *whisper.Envelope) *whisper.ReceivedMessage {
@@ -379,70 +777,138 @@ func (s *WMailServer) openEnvelope(request *whisper.Envelope) *whisper.ReceivedM
 	return nil
 }
 
-// validateRequest runs different validations on the current request.
-func (s *WMailServer) validateRequest(peerID []byte, request *whisper.Envelope) (bool, uint32, uint32, []byte, uint32, cursorType) {
+// validateRequest runs different validations on the current request
+// and returns its decoded payload.
+func (s *WMailServer) validateRequest(peerID []byte, request *whisper.Envelope) (MessagesRequestPayload, error) {
+	var payload MessagesRequestPayload
+
 	if s.pow > 0.0 && request.PoW() < s.pow {
-		return false, 0, 0, nil, 0, nil
+		return payload, newRequestError(ErrorCodeLowPoW, fmt.Errorf("PoW too low (%f < %f)", request.PoW(), s.pow))
 	}
 
 	decrypted := s.openEnvelope(request)
 	if decrypted == nil {
-		log.Warn("Failed to decrypt p2p request")
-		return false, 0, 0, nil, 0, nil
+		return payload, newRequestError(ErrorCodeInvalidRequest, errors.New("Failed to decrypt p2p request"))
 	}
 
 	if err := s.checkMsgSignature(decrypted, peerID); err != nil {
-		log.Warn(err.Error())
-		return false, 0, 0, nil, 0, nil
+		return payload, newRequestError(ErrorCodeInvalidSignature, err)
+	}
+
+	if err := s.checkAllowedClient(decrypted); err != nil {
+		return payload, newRequestError(ErrorCodeUnauthorized, err)
 	}
 
-	bloom, err := s.bloomFromReceivedMessage(decrypted)
+	payload, err := s.decodeRequestPayload(decrypted)
 	if err != nil {
-		log.Warn(err.Error())
-		return false, 0, 0, nil, 0, nil
+		return payload, newRequestError(ErrorCodeInvalidRequest, err)
 	}
 
-	lower := binary.BigEndian.Uint32(decrypted.Payload[:4])
-	upper := binary.BigEndian.Uint32(decrypted.Payload[4:8])
+	if payload.Upper < payload.Lower {
+		err := fmt.Errorf("Query range is invalid: from > to (%d > %d)", payload.Lower, payload.Upper)
+		return payload, newRequestError(ErrorCodeInvalidRange, err)
+	}
 
-	if upper < lower {
-		log.Error(fmt.Sprintf("Query range is invalid: from > to (%d > %d)", lower, upper))
-		return false, 0, 0, nil, 0, nil
+	if err := payload.NormalizeWindows(); err != nil {
+		return payload, newRequestError(ErrorCodeInvalidRange, err)
 	}
 
-	lowerTime := time.Unix(int64(lower), 0)
-	upperTime := time.Unix(int64(upper), 0)
-	if upperTime.Sub(lowerTime) > maxQueryRange {
-		log.Warn(fmt.Sprintf("Query range too big for peer %s", string(peerID)))
-		return false, 0, 0, nil, 0, nil
+	if payload.QueryRange() > s.maxQueryRange {
+		err := fmt.Errorf("Query range too big (%s > %s)", payload.QueryRange(), s.maxQueryRange)
+		return payload, newRequestError(ErrorCodeInvalidRange, err)
 	}
 
-	var limit uint32
-	if len(decrypted.Payload) >= requestTimeRangeLength+whisper.BloomFilterSize+requestLimitLength {
-		limit = binary.BigEndian.Uint32(decrypted.Payload[requestTimeRangeLength+whisper.BloomFilterSize:])
+	// checked last, so that only requests which are processed are remembered
+	if s.replayGuard != nil {
+		signer := crypto.FromECDSAPub(decrypted.Src)
+		if err := s.replayGuard.check(signer, decrypted.Payload, payload.Timestamp); err != nil {
+			return payload, newRequestError(ErrorCodeStaleRequest, err)
+		}
 	}
 
-	var cursor cursorType
-	if len(decrypted.Payload) == requestTimeRangeLength+whisper.BloomFilterSize+requestLimitLength+dbKeyLength {
-		cursor = decrypted.Payload[requestTimeRangeLength+whisper.BloomFilterSize+requestLimitLength:]
+	return payload, nil
+}
+
+// decodeRequestPayload decodes an RLP-encoded MessagesRequestPayload
+// falling back to the legacy binary format.
+func (s *WMailServer) decodeRequestPayload(msg *whisper.ReceivedMessage) (MessagesRequestPayload, error) {
+	var payload MessagesRequestPayload
+
+	if err := rlp.DecodeBytes(msg.Payload, &payload); err != nil {
+		return s.decodeLegacyRequestPayload(msg)
+	}
+
+	if len(payload.Bloom) == 0 {
+		payload.Bloom = whisper.MakeFullNodeBloom()
+	} else if len(payload.Bloom) != whisper.BloomFilterSize {
+		return payload, errors.New("Invalid bloom filter size in p2p request")
+	}
+
+	if len(payload.Cursor) == 0 {
+		payload.Cursor = nil
+	} else if len(payload.Cursor) != dbKeyLength {
+		return payload, errors.New("Invalid cursor size in p2p request")
+	}
+
+	return payload, nil
+}
+
+// decodeLegacyRequestPayload decodes a payload in the binary format:
+// lower (4 bytes), upper (4 bytes), bloom (64 bytes, optional),
+// limit (4 bytes, optional) and cursor (36 bytes, optional).
+func (s *WMailServer) decodeLegacyRequestPayload(msg *whisper.ReceivedMessage) (MessagesRequestPayload, error) {
+	var payload MessagesRequestPayload
+
+	bloom, err := s.bloomFromReceivedMessage(msg)
+	if err != nil {
+		return payload, err
+	}
+
+	payload.Bloom = bloom
+	payload.Lower = binary.BigEndian.Uint32(msg.Payload[:4])
+	payload.Upper = binary.BigEndian.Uint32(msg.Payload[4:8])
+
+	if len(msg.Payload) >= requestTimeRangeLength+whisper.BloomFilterSize+requestLimitLength {
+		payload.Limit = binary.BigEndian.Uint32(msg.Payload[requestTimeRangeLength+whisper.BloomFilterSize:])
+	}
+
+	if len(msg.Payload) == requestTimeRangeLength+whisper.BloomFilterSize+requestLimitLength+dbKeyLength {
+		payload.Cursor = msg.Payload[requestTimeRangeLength+whisper.BloomFilterSize+requestLimitLength:]
 	}
 
-	return true, lower, upper, bloom, limit, cursor
+	return payload, nil
 }
 
-// checkMsgSignature returns an error in case the message is not correcly signed
+// checkMsgSignature returns an error in case the message is not correcly signed.
+// If verifyPeerSignature is set, the message must be signed by the peer which sent it.
 func (s *WMailServer) checkMsgSignature(msg *whisper.ReceivedMessage, id []byte) error {
 	src := crypto.FromECDSAPub(msg.Src)
 	if len(src)-len(id) == 1 {
 		src = src[1:]
 	}
 
-	// if you want to check the signature, you can do it here. e.g.:
-	// if !bytes.Equal(peerID, src) {
 	if src == nil {
 		return errors.New("Wrong signature of p2p request")
 	}
 
+	if s.verifyPeerSignature && !bytes.Equal(id, src) {
+		return errors.New("Request signer is not the peer")
+	}
+
+	return nil
+}
+
+// checkAllowedClient returns an error in case allowed clients are configured
+// and the message is not signed by one of them.
+func (s *WMailServer) checkAllowedClient(msg *whisper.ReceivedMessage) error {
+	if s.allowedClients == nil {
+		return nil
+	}
+
+	if _, ok := s.allowedClients[hex.EncodeToString(crypto.FromECDSAPub(msg.Src))]; !ok {
+		return errors.New("Client is not allowed")
+	}
+
 	return nil
 }
 
@@ -33,6 +33,14 @@ func (db *panicDB) NewIterator(r *util.Range, opts *opt.ReadOptions) iterator.It
 	panic("panicDB panic on NewIterator")
 }
 
+func (db *panicDB) SizeOf(r []util.Range) (leveldb.Sizes, error) {
+	panic("panicDB panic on SizeOf")
+}
+
+func (db *panicDB) CompactRange(r util.Range) error {
+	panic("panicDB panic on CompactRange")
+}
+
 func TestMailServerDBPanicSuite(t *testing.T) {
 	suite.Run(t, new(MailServerDBPanicSuite))
 }
@@ -44,7 +52,7 @@ type MailServerDBPanicSuite struct {
 
 func (s *MailServerDBPanicSuite) SetupTest() {
 	s.server = &WMailServer{}
-	s.server.db = &panicDB{}
+	s.server.db = NewLevelDBStorageWithDB(&panicDB{})
 }
 
 func (s *MailServerDBPanicSuite) TestArchive() {
@@ -54,7 +62,7 @@ func (s *MailServerDBPanicSuite) TestArchive() {
 
 func (s *MailServerDBPanicSuite) TestDeliverMail() {
 	defer s.testPanicRecover("DeliverMail")
-	_, _, _, err := s.server.processRequest(nil, 10, 20, []byte{}, 0, nil)
+	_, _, _, _, err := s.server.processRequest(nil, StorageQuery{Lower: 10, Upper: 20, Bloom: []byte{}}, deliveryMode{})
 	s.Error(err)
 	s.Equal("recovered from panic in processRequest: panicDB panic on NewIterator", err.Error())
 }
@@ -42,12 +42,15 @@ var seed = time.Now().Unix()
 var testPayload = []byte("test payload")
 
 type ServerTestParams struct {
-	topic whisper.TopicType
-	birth uint32
-	low   uint32
-	upp   uint32
-	limit uint32
-	key   *ecdsa.PrivateKey
+	topic  whisper.TopicType
+	birth  uint32
+	low    uint32
+	upp    uint32
+	limit  uint32
+	topics []whisper.TopicType
+	// timestamp is a signed time of the request; if set, the RLP payload is used
+	timestamp uint32
+	key       *ecdsa.PrivateKey
 }
 
 func TestMailserverSuite(t *testing.T) {
@@ -264,22 +267,73 @@ func (s *MailserverSuite) TestArchive() {
 
 	s.server.Archive(env)
 	key := NewDbKey(env.Expiry-env.TTL, env.Hash())
-	archivedEnvelope, err := s.server.db.Get(key.raw, nil)
+	archivedEnvelope, err := levelDB(s.server).Get(key.raw, nil)
 	s.NoError(err)
 
 	s.Equal(rawEnvelope, archivedEnvelope)
 }
 
+func (s *MailserverSuite) TestArchiveLowPoW() {
+	env, err := generateEnvelope(time.Now())
+	s.Require().NoError(err)
+
+	config := *s.config
+	config.MailServerArchivePoW = env.PoW() * 2
+	s.Require().NoError(s.server.Init(s.shh, &config))
+	defer s.server.Close()
+
+	s.server.Archive(env)
+	has, err := s.server.db.Has(NewDbKey(env.Expiry-env.TTL, env.Hash()))
+	s.Require().NoError(err)
+	s.False(has)
+}
+
+func (s *MailserverSuite) TestArchiveTopicQuota() {
+	env, err := generateEnvelope(time.Now())
+	s.Require().NoError(err)
+	size := int64(whisper.EnvelopeHeaderLength + len(env.Data))
+
+	config := *s.config
+	config.MailServerTopicDailyQuota = size
+	s.Require().NoError(s.server.Init(s.shh, &config))
+	defer s.server.Close()
+
+	s.server.Archive(env)
+	// a duplicate is not archived again, so it does not use the quota
+	s.server.Archive(env)
+	s.Equal(size, s.server.topicQuota.used[quotaDay(env.Expiry-env.TTL)][env.Topic])
+
+	// another envelope with the same topic is over the quota
+	next, err := generateEnvelope(time.Now().Add(time.Second))
+	s.Require().NoError(err)
+	s.server.Archive(next)
+
+	count, err := s.server.db.Count(0, uint32(time.Now().Add(time.Minute).Unix()))
+	s.Require().NoError(err)
+	s.Equal(1, count)
+
+	// a historic envelope uses the quota of the day it was sent
+	historic, err := generateEnvelope(time.Now().Add(-2 * day))
+	s.Require().NoError(err)
+	s.server.Archive(historic)
+
+	count, err = s.server.db.Count(0, uint32(time.Now().Add(time.Minute).Unix()))
+	s.Require().NoError(err)
+	s.Equal(2, count)
+}
+
 func (s *MailserverSuite) TestManageLimits() {
-	s.server.limiter = newLimiter(time.Duration(5) * time.Millisecond)
-	s.False(s.server.exceedsPeerRequests([]byte("peerID")))
+	s.server.limiter = newLimiter(limiterConfig{RequestsRate: 1, RequestsBurst: 1})
+	s.NoError(s.server.checkRateLimits([]byte("peerID")))
 	s.Equal(1, len(s.server.limiter.db))
-	firstSaved := s.server.limiter.db["peerID"]
+	firstSaved := s.server.limiter.db[hex.EncodeToString([]byte("peerID"))]
 
 	// second call when limit is not accomplished does not store a new limit
-	s.True(s.server.exceedsPeerRequests([]byte("peerID")))
+	err := s.server.checkRateLimits([]byte("peerID"))
+	s.EqualError(err, errPeerRequestsLimit.Error())
+	s.Equal(ErrorCodeRateLimited, errorCode(err))
 	s.Equal(1, len(s.server.limiter.db))
-	s.Equal(firstSaved, s.server.limiter.db["peerID"])
+	s.Equal(firstSaved, s.server.limiter.db[hex.EncodeToString([]byte("peerID"))])
 }
 
 func (s *MailserverSuite) TestDBKey() {
@@ -322,12 +376,13 @@ func (s *MailserverSuite) TestRequestPaginationLimit() {
 	params.limit = 6
 	request := s.createRequest(params)
 	src := crypto.FromECDSAPub(&params.key.PublicKey)
-	ok, lower, upper, bloom, limit, cursor := s.server.validateRequest(src, request)
-	s.True(ok)
-	s.Nil(cursor)
-	s.Equal(params.limit, limit)
+	payload, err := s.server.validateRequest(src, request)
+	s.NoError(err)
+	s.Nil(payload.Cursor)
+	s.Equal(params.limit, payload.Limit)
+	limit := payload.Limit
 
-	envelopes, _, cursor, err := s.server.processRequest(nil, lower, upper, bloom, limit, nil)
+	envelopes, _, cursor, _, err := s.server.processRequest(nil, storageQuery(payload), deliveryMode{})
 	s.NoError(err)
 	for _, env := range envelopes {
 		receivedHashes = append(receivedHashes, env.Hash())
@@ -344,7 +399,8 @@ func (s *MailserverSuite) TestRequestPaginationLimit() {
 
 	// second page
 	receivedHashes = []common.Hash{}
-	envelopes, _, cursor, err = s.server.processRequest(nil, lower, upper, bloom, limit, cursor)
+	payload.Cursor = cursor
+	envelopes, _, cursor, _, err = s.server.processRequest(nil, storageQuery(payload), deliveryMode{})
 	s.NoError(err)
 	for _, env := range envelopes {
 		receivedHashes = append(receivedHashes, env.Hash())
@@ -428,26 +484,189 @@ func (s *MailserverSuite) TestMailServer() {
 		s.T().Run(tc.info, func(*testing.T) {
 			request := s.createRequest(tc.params)
 			src := crypto.FromECDSAPub(&tc.params.key.PublicKey)
-			ok, lower, upper, bloom, limit, _ := s.server.validateRequest(src, request)
-			s.Equal(tc.isOK, ok)
-			if ok {
-				s.Equal(tc.params.low, lower)
-				s.Equal(tc.params.upp, upper)
-				s.Equal(tc.params.limit, limit)
-				s.Equal(whisper.TopicToBloom(tc.params.topic), bloom)
-				s.Equal(tc.expect, s.messageExists(env, tc.params.low, tc.params.upp, bloom, tc.params.limit))
+			payload, err := s.server.validateRequest(src, request)
+			s.Equal(tc.isOK, err == nil)
+			if err == nil {
+				s.Equal(tc.params.low, payload.Lower)
+				s.Equal(tc.params.upp, payload.Upper)
+				s.Equal(tc.params.limit, payload.Limit)
+				s.Equal(whisper.TopicToBloom(tc.params.topic), payload.Bloom)
+				s.Equal(tc.expect, s.messageExists(env, storageQuery(payload)))
 
 				src[0]++
-				ok, _, _, _, _, _ = s.server.validateRequest(src, request)
-				s.True(ok)
+				_, err = s.server.validateRequest(src, request)
+				s.NoError(err)
 			}
 		})
 	}
 }
 
-func (s *MailserverSuite) messageExists(envelope *whisper.Envelope, low, upp uint32, bloom []byte, limit uint32) bool {
+func (s *MailserverSuite) TestRequestWithTopics() {
+	s.setupServer(s.server)
+	defer s.server.Close()
+
+	env, err := generateEnvelope(time.Now())
+	s.NoError(err)
+
+	s.server.Archive(env)
+
+	otherTopic := whisper.TopicType{0x01, 0x02, 0x03, 0x04}
+	testCases := []struct {
+		topics []whisper.TopicType
+		expect bool
+		info   string
+	}{
+		{
+			topics: []whisper.TopicType{env.Topic},
+			expect: true,
+			info:   "Processing a request with the envelope topic should provide results",
+		},
+		{
+			topics: []whisper.TopicType{otherTopic, env.Topic},
+			expect: true,
+			info:   "Processing a request with many topics including the envelope topic should provide results",
+		},
+		{
+			topics: []whisper.TopicType{otherTopic},
+			expect: false,
+			info:   "Processing a request with another topic should not provide results even if bloom filter matches",
+		},
+	}
+	for _, tc := range testCases {
+		s.T().Run(tc.info, func(*testing.T) {
+			params := s.defaultServerParams(env)
+			params.topics = tc.topics
+			request := s.createRequest(params)
+			src := crypto.FromECDSAPub(&params.key.PublicKey)
+			payload, err := s.server.validateRequest(src, request)
+			s.Require().NoError(err)
+			s.Equal(tc.topics, payload.Topics)
+			s.Equal(tc.expect, s.messageExists(env, storageQuery(payload)))
+		})
+	}
+}
+
+func (s *MailserverSuite) TestMaxQueryRange() {
+	s.setupServer(s.server)
+	defer s.server.Close()
+
+	env, err := generateEnvelope(time.Now())
+	s.NoError(err)
+
+	params := s.defaultServerParams(env)
+	params.low = params.birth - uint32((36 * time.Hour).Seconds())
+	request := s.createRequest(params)
+	src := crypto.FromECDSAPub(&params.key.PublicKey)
+
+	_, err = s.server.validateRequest(src, request)
+	s.Error(err)
+	s.Equal(ErrorCodeInvalidRange, errorCode(err))
+
+	s.server.maxQueryRange = 48 * time.Hour
+	payload, err := s.server.validateRequest(src, request)
+	s.Require().NoError(err)
+
+	query, cursor := storageQuery(payload).split(uint32(queryChunkRange / time.Second))
+	s.Equal(params.upp-uint32(queryChunkRange/time.Second)+1, query.Lower)
+	s.NotNil(cursor)
+}
+
+func (s *MailserverSuite) TestVerifyPeerSignature() {
+	s.setupServer(s.server)
+	defer s.server.Close()
+	s.server.verifyPeerSignature = true
+
+	env, err := generateEnvelope(time.Now())
+	s.NoError(err)
+
+	params := s.defaultServerParams(env)
+	request := s.createRequest(params)
+	src := crypto.FromECDSAPub(&params.key.PublicKey)
+
+	// peer IDs are public keys without the format prefix
+	_, err = s.server.validateRequest(src[1:], request)
+	s.NoError(err)
+
+	// a request signed with a key of another peer is spoofed
+	// or replayed by the peer which intercepted it
+	otherKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	_, err = s.server.validateRequest(crypto.FromECDSAPub(&otherKey.PublicKey)[1:], request)
+	s.EqualError(err, "Request signer is not the peer")
+	s.Equal(ErrorCodeInvalidSignature, errorCode(err))
+
+	// the peer identity is not checked by default
+	s.server.verifyPeerSignature = false
+	_, err = s.server.validateRequest(crypto.FromECDSAPub(&otherKey.PublicKey)[1:], request)
+	s.NoError(err)
+}
+
+func (s *MailserverSuite) TestAllowedClients() {
+	s.setupServer(s.server)
+	defer s.server.Close()
+
+	env, err := generateEnvelope(time.Now())
+	s.NoError(err)
+
+	serverParams := s.defaultServerParams(env)
+	request := s.createRequest(serverParams)
+	src := crypto.FromECDSAPub(&serverParams.key.PublicKey)
+
+	otherKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	config := &params.WhisperConfig{
+		MailServerAllowedClients: []string{"0x" + hex.EncodeToString(crypto.FromECDSAPub(&otherKey.PublicKey))},
+	}
+	s.Require().NoError(s.server.setupRequestAuthentication(config))
+
+	_, err = s.server.validateRequest(src, request)
+	s.EqualError(err, "Client is not allowed")
+	s.Equal(ErrorCodeUnauthorized, errorCode(err))
+
+	config.MailServerAllowedClients = append(config.MailServerAllowedClients, hex.EncodeToString(src))
+	s.Require().NoError(s.server.setupRequestAuthentication(config))
+
+	_, err = s.server.validateRequest(src, request)
+	s.NoError(err)
+}
+
+func (s *MailserverSuite) TestReplayedRequest() {
+	s.setupServer(s.server)
+	defer s.server.Close()
+
+	env, err := generateEnvelope(time.Now())
+	s.NoError(err)
+
+	serverParams := s.defaultServerParams(env)
+	src := crypto.FromECDSAPub(&serverParams.key.PublicKey)
+
+	config := &params.WhisperConfig{MailServerRequestMaxSkew: 60}
+	s.Require().NoError(s.server.setupRequestAuthentication(config))
+
+	// requests without a signed timestamp can't be checked
+	_, err = s.server.validateRequest(src, s.createRequest(serverParams))
+	s.Equal(errNoTimestamp.Error(), err.Error())
+	s.Equal(ErrorCodeStaleRequest, errorCode(err))
+
+	serverParams.timestamp = uint32(time.Now().Unix())
+	request := s.createRequest(serverParams)
+	_, err = s.server.validateRequest(src, request)
+	s.NoError(err)
+
+	_, err = s.server.validateRequest(src, request)
+	s.Equal(errReplayedRequest.Error(), err.Error())
+	s.Equal(ErrorCodeStaleRequest, errorCode(err))
+
+	// the same signed payload in a new envelope is still a replay
+	rewrapped := s.createRequest(serverParams)
+	s.NotEqual(request.Hash(), rewrapped.Hash())
+	_, err = s.server.validateRequest(src, rewrapped)
+	s.Equal(errReplayedRequest.Error(), err.Error())
+}
+
+func (s *MailserverSuite) messageExists(envelope *whisper.Envelope, query StorageQuery) bool {
 	var exist bool
-	mail, _, _, err := s.server.processRequest(nil, low, upp, bloom, limit, nil)
+	mail, _, _, _, err := s.server.processRequest(nil, query, deliveryMode{})
 	s.NoError(err)
 	for _, msg := range mail {
 		if msg.Hash() == envelope.Hash() {
@@ -549,6 +768,19 @@ func (s *MailserverSuite) createRequest(p *ServerTestParams) *whisper.Envelope {
 		data = append(data, limitData...)
 	}
 
+	if len(p.topics) > 0 || p.timestamp != 0 {
+		var err error
+		data, err = rlp.EncodeToBytes(MessagesRequestPayload{
+			Lower:     p.low,
+			Upper:     p.upp,
+			Bloom:     whisper.MakeFullNodeBloom(),
+			Limit:     p.limit,
+			Topics:    p.topics,
+			Timestamp: p.timestamp,
+		})
+		s.Require().NoError(err)
+	}
+
 	key, err := s.shh.GetSymKey(keyID)
 	if err != nil {
 		s.T().Fatalf("failed to retrieve sym key with seed %d: %s.", seed, err)
@@ -0,0 +1,151 @@
+// Package protocol defines the wire format of historic messages requests
+// shared by mailservers and clients.
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+)
+
+// MessagesRequestPayload is an RLP-encoded payload of a historic messages request.
+// It supersedes the legacy fixed-size binary payload which can carry only a bloom filter.
+//
+// Fields can only be appended. Missing trailing fields are left empty on decoding
+// and unknown trailing fields are ignored, so clients and mailservers running
+// different versions can understand each other.
+type MessagesRequestPayload struct {
+	// Lower is a lower bound of time range for which messages are requested.
+	Lower uint32
+	// Upper is an upper bound of time range for which messages are requested.
+	Upper uint32
+	// Bloom is a bloom filter to match envelopes. It is used if Topics is empty.
+	Bloom []byte
+	// Limit is the max number of envelopes to return.
+	Limit uint32
+	// Cursor is used for pagination of the results.
+	Cursor []byte
+	// Topics is a list of exact topics to match.
+	Topics []whisper.TopicType
+	// Windows is an optional list of time windows within Lower and Upper.
+	// It allows to request many time ranges at once. Older mailservers ignore it
+	// and return all envelopes between Lower and Upper.
+	Windows []TimeWindow
+	// Batch is set if the client accepts many envelopes delivered in a single p2p message.
+	// Older mailservers ignore it and deliver envelopes one by one.
+	Batch bool
+	// Compress is set if the client accepts batches compressed with snappy.
+	Compress bool
+	// Checksum is set if the client wants the response to include the number of sent envelopes
+	// and a rolling hash of their hashes. It is used only with Batch because batches carry
+	// the ID of the request, so that the client can count envelopes of each request.
+	// Older mailservers ignore it.
+	Checksum bool
+	// Timestamp is the time the request was made. Unlike the envelope expiry,
+	// it is covered by the request signature, so it can't be changed by a third party
+	// replaying the request. Mailservers with the replay protection reject requests without it.
+	Timestamp uint32
+	// Nonce is a random value which makes identical requests made within one second different.
+	Nonce []byte
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (p *MessagesRequestPayload) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
+
+	fields := []interface{}{
+		&p.Lower,
+		&p.Upper,
+		&p.Bloom,
+		&p.Limit,
+		&p.Cursor,
+		&p.Topics,
+		&p.Windows,
+		&p.Batch,
+		&p.Compress,
+		&p.Checksum,
+		&p.Timestamp,
+		&p.Nonce,
+	}
+	for _, field := range fields {
+		if err := s.Decode(field); err == rlp.EOL {
+			return s.ListEnd()
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// skip fields added in newer versions
+	for {
+		if _, err := s.Raw(); err == rlp.EOL {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return s.ListEnd()
+}
+
+// NormalizeWindows validates time windows, sorts them from the newest
+// to the oldest and merges the overlapping ones.
+func (p *MessagesRequestPayload) NormalizeWindows() error {
+	if len(p.Windows) == 0 {
+		return nil
+	}
+
+	windows := make([]TimeWindow, len(p.Windows))
+	copy(windows, p.Windows)
+
+	for _, w := range windows {
+		if w.Upper < w.Lower {
+			return fmt.Errorf("Time window is invalid: from > to (%d > %d)", w.Lower, w.Upper)
+		}
+		if w.Lower < p.Lower || w.Upper > p.Upper {
+			return fmt.Errorf("Time window %d-%d is out of the query range", w.Lower, w.Upper)
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Upper > windows[j].Upper
+	})
+
+	merged := windows[:1]
+	for _, w := range windows[1:] {
+		last := &merged[len(merged)-1]
+		if w.Upper >= last.Lower || w.Upper+1 == last.Lower {
+			if w.Lower < last.Lower {
+				last.Lower = w.Lower
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+
+	p.Windows = merged
+	return nil
+}
+
+// QueryRange returns the duration of the requested time range.
+// If time windows are given, it is a sum of their durations.
+func (p MessagesRequestPayload) QueryRange() time.Duration {
+	if len(p.Windows) == 0 {
+		return time.Duration(p.Upper-p.Lower) * time.Second
+	}
+
+	var total time.Duration
+	for _, w := range p.Windows {
+		total += time.Duration(w.Upper-w.Lower) * time.Second
+	}
+	return total
+}
+
+// TimeWindow is a time range between two timestamps (both inclusive).
+type TimeWindow struct {
+	Lower uint32
+	Upper uint32
+}
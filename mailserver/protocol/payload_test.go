@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagesRequestPayloadDecodeMissingFields(t *testing.T) {
+	data, err := rlp.EncodeToBytes([]interface{}{uint32(10), uint32(20), whisper.MakeFullNodeBloom()})
+	require.NoError(t, err)
+
+	var payload MessagesRequestPayload
+	require.NoError(t, rlp.DecodeBytes(data, &payload))
+	require.Equal(t, uint32(10), payload.Lower)
+	require.Equal(t, uint32(20), payload.Upper)
+	require.Equal(t, whisper.MakeFullNodeBloom(), payload.Bloom)
+	require.Equal(t, uint32(0), payload.Limit)
+	require.Nil(t, payload.Topics)
+}
+
+func TestMessagesRequestPayloadDecodeUnknownFields(t *testing.T) {
+	topics := []whisper.TopicType{{0x01, 0x02, 0x03, 0x04}}
+	data, err := rlp.EncodeToBytes([]interface{}{
+		uint32(10), uint32(20), []byte{}, uint32(5), []byte{}, topics, []TimeWindow{{10, 20}}, true, true, true,
+		uint32(30), []byte{0x01}, "unknown field",
+	})
+	require.NoError(t, err)
+
+	var payload MessagesRequestPayload
+	require.NoError(t, rlp.DecodeBytes(data, &payload))
+	require.Equal(t, uint32(5), payload.Limit)
+	require.Equal(t, topics, payload.Topics)
+	require.Equal(t, []TimeWindow{{10, 20}}, payload.Windows)
+	require.True(t, payload.Batch)
+	require.True(t, payload.Compress)
+	require.True(t, payload.Checksum)
+	require.Equal(t, uint32(30), payload.Timestamp)
+	require.Equal(t, []byte{0x01}, payload.Nonce)
+}
+
+func TestMessagesRequestPayloadDecodeLegacyPayload(t *testing.T) {
+	// legacy payloads start with a timestamp and are not RLP lists
+	data := []byte{0x5b, 0x00, 0x00, 0x00, 0x5b, 0x00, 0x00, 0x01}
+
+	var payload MessagesRequestPayload
+	require.Error(t, rlp.DecodeBytes(data, &payload))
+}
+
+func TestMessagesRequestPayloadNormalizeWindows(t *testing.T) {
+	payload := MessagesRequestPayload{
+		Lower:   10,
+		Upper:   100,
+		Windows: []TimeWindow{{10, 20}, {50, 60}, {15, 30}, {31, 40}},
+	}
+	require.NoError(t, payload.NormalizeWindows())
+	require.Equal(t, []TimeWindow{{50, 60}, {10, 40}}, payload.Windows)
+	require.Equal(t, 40*time.Second, payload.QueryRange())
+
+	payload.Windows = []TimeWindow{{20, 10}}
+	require.Error(t, payload.NormalizeWindows())
+
+	payload.Windows = []TimeWindow{{90, 110}}
+	require.Error(t, payload.NormalizeWindows())
+}
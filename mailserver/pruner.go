@@ -0,0 +1,186 @@
+package mailserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// defaultPrunePeriod is used if WhisperConfig.MailServerCleanupPeriod is not set.
+	defaultPrunePeriod = time.Hour
+	// pruneStep is the time range of the oldest envelopes removed at once
+	// when the storage exceeds the max size.
+	pruneStep = 24 * time.Hour
+	// maxPruneSteps is the max number of steps in a single pruning to the max size,
+	// so that an inaccurate size estimate does not wipe the whole storage.
+	maxPruneSteps = 7
+)
+
+// compacter is implemented by storages whose size estimate is accurate only after compaction.
+type compacter interface {
+	Compact() error
+}
+
+var (
+	prunedEnvelopesCounter = metrics.NewRegisteredCounter("mailserver/prunedEnvelopes", nil)
+	pruneErrorsCounter     = metrics.NewRegisteredCounter("mailserver/pruneErrors", nil)
+	storageSizeGauge       = metrics.NewRegisteredGauge("mailserver/storageSize", nil)
+)
+
+// pruner periodically removes envelopes older than the retention window
+// and the oldest envelopes if the storage exceeds the max size.
+type pruner struct {
+	db MailServerStorage
+	// cache is purged when envelopes are removed, it is optional.
+	cache *queryCache
+	// envelopes is updated when envelopes are removed, it is optional.
+	envelopes *envelopesCount
+	retention time.Duration
+	maxSize   int64
+	period    time.Duration
+	now       func() time.Time
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+func newPruner(db MailServerStorage, retention time.Duration, maxSize int64, period time.Duration) *pruner {
+	return &pruner{
+		db:        db,
+		retention: retention,
+		maxSize:   maxSize,
+		period:    period,
+		now:       time.Now,
+	}
+}
+
+// Start runs pruning in the background.
+func (p *pruner) Start() {
+	p.quit = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.run()
+	}()
+}
+
+// Stop stops pruning and waits until the current pruning is done.
+func (p *pruner) Stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
+
+func (p *pruner) run() {
+	t := time.NewTicker(p.period)
+	defer t.Stop()
+
+	for {
+		if _, err := p.prune(); err != nil {
+			log.Error(fmt.Sprintf("failed to prune archived envelopes: %s", err))
+			pruneErrorsCounter.Inc(1)
+		}
+
+		select {
+		case <-p.quit:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// prune removes expired envelopes and returns how many have been removed.
+func (p *pruner) prune() (removed int, err error) {
+	defer func() {
+		prunedEnvelopesCounter.Inc(int64(removed))
+		if p.envelopes != nil {
+			p.envelopes.add(-removed)
+		}
+		if removed > 0 && p.cache != nil {
+			// cached results could contain removed envelopes
+			p.cache.Purge()
+		}
+	}()
+
+	if p.retention > 0 {
+		upper := uint32(p.now().Add(-p.retention).Unix())
+		removed, err = p.db.Prune(0, upper)
+		if err != nil {
+			return
+		}
+	}
+
+	if p.maxSize > 0 {
+		var n int
+		n, err = p.pruneToSize()
+		removed += n
+	}
+
+	if removed > 0 {
+		log.Info("pruned archived envelopes", "removed", removed)
+	}
+	return
+}
+
+// pruneToSize removes the oldest envelopes until the storage is not bigger than the max size.
+// The size is an estimate, so at most maxPruneSteps days are removed at once
+// and pruning stops if removing envelopes does not change the estimate.
+func (p *pruner) pruneToSize() (int, error) {
+	size, err := p.size()
+	if err != nil || size <= p.maxSize {
+		return 0, err
+	}
+
+	// deleted envelopes may still be counted until they are compacted
+	if c, ok := p.db.(compacter); ok {
+		if err = c.Compact(); err != nil {
+			return 0, err
+		}
+		if size, err = p.size(); err != nil || size <= p.maxSize {
+			return 0, err
+		}
+	}
+
+	removed := 0
+	for step := 0; step < maxPruneSteps && size > p.maxSize; step++ {
+		oldest, err := p.db.Oldest()
+		if err != nil || oldest == 0 {
+			return removed, err
+		}
+
+		n, err := p.db.Prune(0, oldest+uint32(pruneStep/time.Second))
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+
+		previous := size
+		if size, err = p.size(); err != nil {
+			return removed, err
+		}
+		if size == previous {
+			log.Warn("storage size did not change after pruning", "size", size, "maxSize", p.maxSize)
+			return removed, nil
+		}
+
+		select {
+		case <-p.quit:
+			return removed, nil
+		default:
+		}
+	}
+	return removed, nil
+}
+
+// size returns the estimated size of the storage.
+func (p *pruner) size() (int64, error) {
+	size, err := p.db.Size()
+	if err != nil {
+		return 0, err
+	}
+	storageSizeGauge.Update(size)
+	return size, nil
+}
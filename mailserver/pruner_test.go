@@ -0,0 +1,155 @@
+package mailserver
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPrunerRetention(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	s := NewLevelDBStorageWithDB(db)
+	defer s.Close()
+
+	now := time.Unix(1000000, 0)
+	for i := uint32(0); i < 10; i++ {
+		// an envelope per day
+		require.NoError(t, s.Archive(newTestEnvelope(uint32(now.Unix())-i*86400, testTopicA, uint64(i))))
+	}
+
+	envelopes := &envelopesCount{n: 10}
+	p := newPruner(s, 5*24*time.Hour, 0, time.Hour)
+	p.envelopes = envelopes
+	p.now = func() time.Time { return now }
+
+	removed, err := p.prune()
+	require.NoError(t, err)
+	require.Equal(t, 4, removed)
+
+	count, err := s.Count(0, uint32(now.Unix())+1)
+	require.NoError(t, err)
+	require.Equal(t, 6, count)
+	require.Equal(t, 6, envelopes.get(), "It updates the count of envelopes")
+}
+
+func TestPrunerPurgesCache(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	s := NewLevelDBStorageWithDB(db)
+	defer s.Close()
+
+	now := time.Unix(1000000, 0)
+	require.NoError(t, s.Archive(newTestEnvelope(uint32(now.Unix())-10*86400, testTopicA, 1)))
+
+	cache, err := newQueryCache(s, queryCacheSize)
+	require.NoError(t, err)
+	query := StorageQuery{Lower: 0, Upper: uint32(now.Unix()), Topics: []whisper.TopicType{testTopicA}}
+	require.Len(t, readAll(t, cache, query), 1)
+
+	p := newPruner(s, 5*24*time.Hour, 0, time.Hour)
+	p.cache = cache
+	p.now = func() time.Time { return now }
+
+	removed, err := p.prune()
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	// the pruned envelope is not returned from the cache
+	require.Empty(t, readAll(t, cache, query))
+}
+
+func TestPrunerMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailserver-pruner-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewSQLStorage(defaultSQLDriver, filepath.Join(dir, defaultSQLDatabase))
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := uint32(0); i < 10; i++ {
+		require.NoError(t, s.Archive(newTestEnvelope(100000+i*86400, testTopicA, uint64(i))))
+	}
+
+	oldest, err := s.Oldest()
+	require.NoError(t, err)
+	require.Equal(t, uint32(100000), oldest)
+
+	size, err := s.Size()
+	require.NoError(t, err)
+
+	p := newPruner(s, 0, size/2, time.Hour)
+	removed, err := p.prune()
+	require.NoError(t, err)
+	require.Equal(t, 5, removed)
+
+	oldest, err = s.Oldest()
+	require.NoError(t, err)
+	require.Equal(t, uint32(100000+5*86400), oldest)
+}
+
+func TestPrunerStop(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	s := NewLevelDBStorageWithDB(db)
+	defer s.Close()
+
+	p := newPruner(s, time.Hour, 0, time.Millisecond)
+	p.Start()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for pruner to stop")
+	}
+}
+
+// sizeStorage reports a fixed size, as an estimate which is not updated.
+type sizeStorage struct {
+	MailServerStorage
+	size int64
+}
+
+func (s sizeStorage) Size() (int64, error) {
+	return s.size, nil
+}
+
+func TestPrunerMaxSizeEstimate(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	s := NewLevelDBStorageWithDB(db)
+	defer s.Close()
+
+	for i := uint32(0); i < 2*maxPruneSteps; i++ {
+		require.NoError(t, s.Archive(newTestEnvelope(100000+i*86400, testTopicA, uint64(i))))
+	}
+
+	// pruning stops when the size estimate does not change
+	p := newPruner(sizeStorage{MailServerStorage: s, size: 100}, 0, 10, time.Hour)
+	removed, err := p.prune()
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	// at most maxPruneSteps days are removed at once
+	p = newPruner(s, 0, 1, time.Hour)
+	removed, err = p.prune()
+	require.NoError(t, err)
+	require.Equal(t, maxPruneSteps, removed)
+
+	count, err := s.Count(0, math.MaxUint32)
+	require.NoError(t, err)
+	require.Equal(t, maxPruneSteps-1, count)
+}
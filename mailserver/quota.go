@@ -0,0 +1,86 @@
+package mailserver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+)
+
+// maxQuotaDays is the max number of days the topic usage is kept for.
+// Usage of the oldest day is forgotten when envelopes from a new day arrive.
+const maxQuotaDays = 7
+
+var (
+	quotaExceededCounter = metrics.NewRegisteredCounter("mailserver/topicQuotaExceeded", nil)
+	lowArchivePoWCounter = metrics.NewRegisteredCounter("mailserver/archiveLowPoW", nil)
+)
+
+// topicQuota limits the number of bytes of envelopes with a single topic
+// archived for a UTC day. Envelopes are counted for the day they were sent,
+// so historic envelopes received from other MailServers do not use
+// the quota of the current day. Usage is kept in memory for the last
+// maxQuotaDays days, so it starts from zero when MailServer is restarted.
+type topicQuota struct {
+	mu sync.Mutex
+
+	limit int64
+	// used maps beginnings of days to the usage of topics during that day.
+	used map[int64]map[whisper.TopicType]int64
+}
+
+func newTopicQuota(limit int64) *topicQuota {
+	return &topicQuota{
+		limit: limit,
+		used:  make(map[int64]map[whisper.TopicType]int64),
+	}
+}
+
+// allowed returns true if an envelope with the given topic, size and send time
+// fits into the daily quota. It does not charge the topic.
+func (q *topicQuota) allowed(topic whisper.TopicType, sent uint32, size int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.used[quotaDay(sent)][topic]+size <= q.limit
+}
+
+// charge adds size bytes to the usage of the topic on the day the envelope was sent.
+// It should be called only after the envelope was archived.
+func (q *topicQuota) charge(topic whisper.TopicType, sent uint32, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d := quotaDay(sent)
+	usage, ok := q.used[d]
+	if !ok {
+		if len(q.used) >= maxQuotaDays {
+			oldest := q.oldestDay()
+			if d < oldest {
+				// the day is too old to be kept
+				return
+			}
+			delete(q.used, oldest)
+		}
+		usage = make(map[whisper.TopicType]int64)
+		q.used[d] = usage
+	}
+	usage[topic] += size
+}
+
+// oldestDay returns the oldest day the usage is kept for.
+func (q *topicQuota) oldestDay() int64 {
+	oldest := int64(-1)
+	for d := range q.used {
+		if oldest < 0 || d < oldest {
+			oldest = d
+		}
+	}
+	return oldest
+}
+
+// quotaDay returns the beginning of the UTC day of the timestamp.
+func quotaDay(timestamp uint32) int64 {
+	return time.Unix(int64(timestamp), 0).UTC().Truncate(day).Unix()
+}
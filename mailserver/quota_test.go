@@ -0,0 +1,49 @@
+package mailserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicQuota(t *testing.T) {
+	q := newTopicQuota(100)
+	sent := uint32(time.Date(2018, 10, 10, 23, 0, 0, 0, time.UTC).Unix())
+
+	require.True(t, q.allowed(testTopicA, sent, 60))
+	q.charge(testTopicA, sent, 60)
+	require.True(t, q.allowed(testTopicA, sent, 40))
+	q.charge(testTopicA, sent, 40)
+	require.False(t, q.allowed(testTopicA, sent, 1))
+
+	// other topics have their own quotas
+	require.True(t, q.allowed(testTopicB, sent, 100))
+	q.charge(testTopicB, sent, 100)
+	require.False(t, q.allowed(testTopicB, sent, 1))
+
+	// envelopes sent on other days use quotas of these days
+	nextDay := sent + uint32(time.Hour/time.Second)
+	require.True(t, q.allowed(testTopicA, nextDay, 100))
+	previousDay := sent - uint32(day/time.Second)
+	require.True(t, q.allowed(testTopicA, previousDay, 100))
+}
+
+func TestTopicQuotaMaxDays(t *testing.T) {
+	q := newTopicQuota(100)
+	start := uint32(time.Date(2018, 10, 10, 0, 0, 0, 0, time.UTC).Unix())
+	dayLength := uint32(day / time.Second)
+
+	for i := uint32(0); i < maxQuotaDays+1; i++ {
+		q.charge(testTopicA, start+i*dayLength, 100)
+	}
+	require.Len(t, q.used, maxQuotaDays)
+	// the oldest day is forgotten
+	require.True(t, q.allowed(testTopicA, start, 100))
+	require.False(t, q.allowed(testTopicA, start+dayLength, 1))
+
+	// days older than all kept days are not tracked
+	q.charge(testTopicA, start, 100)
+	require.Len(t, q.used, maxQuotaDays)
+	require.True(t, q.allowed(testTopicA, start, 100))
+}
@@ -0,0 +1,97 @@
+package mailserver
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	errStaleRequest    = errors.New("Request is too old")
+	errFutureRequest   = errors.New("Request is from the future")
+	errReplayedRequest = errors.New("Request was already processed")
+	errNoTimestamp     = errors.New("Request has no timestamp")
+
+	staleRequestsCounter    = metrics.NewRegisteredCounter("mailserver/staleRequests", nil)
+	replayedRequestsCounter = metrics.NewRegisteredCounter("mailserver/replayedRequests", nil)
+)
+
+// replayGuard rejects requests which were sent outside of the freshness window
+// or which were already processed. Both checks use only the signed request payload
+// which carries the timestamp and a random nonce. Envelope fields like expiry,
+// TTL or PoW nonce can be changed by anyone who captured the request.
+// Requests are identified by the hash of the signer and the payload.
+// Hashes are kept only as long as the requests are fresh,
+// so that the cache does not grow unbounded.
+type replayGuard struct {
+	mu sync.Mutex
+
+	maxSkew time.Duration
+	// seen maps hashes of processed requests to the time they become stale.
+	seen        map[common.Hash]time.Time
+	nextCleanup time.Time
+	now         func() time.Time
+}
+
+func newReplayGuard(maxSkew time.Duration) *replayGuard {
+	return &replayGuard{
+		maxSkew: maxSkew,
+		seen:    make(map[common.Hash]time.Time),
+		now:     time.Now,
+	}
+}
+
+// check returns an error if the request is not fresh or was already seen.
+// Otherwise, the request is remembered as seen. signer is the public key
+// which signed the request, payload is the signed payload and timestamp
+// is the time decoded from it.
+func (g *replayGuard) check(signer, payload []byte, timestamp uint32) error {
+	if timestamp == 0 {
+		staleRequestsCounter.Inc(1)
+		return errNoTimestamp
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	sent := time.Unix(int64(timestamp), 0)
+
+	if sent.Before(now.Add(-g.maxSkew)) {
+		staleRequestsCounter.Inc(1)
+		return errStaleRequest
+	}
+	if sent.After(now.Add(g.maxSkew)) {
+		staleRequestsCounter.Inc(1)
+		return errFutureRequest
+	}
+
+	g.deleteExpired(now)
+
+	hash := crypto.Keccak256Hash(signer, payload)
+	if _, ok := g.seen[hash]; ok {
+		replayedRequestsCounter.Inc(1)
+		return errReplayedRequest
+	}
+	g.seen[hash] = sent.Add(g.maxSkew)
+
+	return nil
+}
+
+// deleteExpired removes hashes of requests which are stale
+// and would be rejected anyway. It runs at most once per maxSkew.
+func (g *replayGuard) deleteExpired(now time.Time) {
+	if now.Before(g.nextCleanup) {
+		return
+	}
+	for hash, expiry := range g.seen {
+		if expiry.Before(now) {
+			delete(g.seen, hash)
+		}
+	}
+	g.nextCleanup = now.Add(g.maxSkew)
+}
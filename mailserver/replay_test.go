@@ -0,0 +1,74 @@
+package mailserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/status-im/status-go/mailserver/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+var testSigner = []byte("signer")
+
+func newTestRequest(t *testing.T, sent time.Time, nonce byte) ([]byte, uint32) {
+	timestamp := uint32(sent.Unix())
+	payload, err := rlp.EncodeToBytes(protocol.MessagesRequestPayload{
+		Lower:     10,
+		Upper:     20,
+		Timestamp: timestamp,
+		Nonce:     []byte{nonce},
+	})
+	require.NoError(t, err)
+	return payload, timestamp
+}
+
+func TestReplayGuardFreshness(t *testing.T) {
+	now := time.Now()
+	g := newReplayGuard(time.Minute)
+	g.now = func() time.Time { return now }
+
+	payload, timestamp := newTestRequest(t, now.Add(-59*time.Second), 1)
+	require.NoError(t, g.check(testSigner, payload, timestamp))
+	payload, timestamp = newTestRequest(t, now.Add(59*time.Second), 2)
+	require.NoError(t, g.check(testSigner, payload, timestamp))
+	payload, timestamp = newTestRequest(t, now.Add(-2*time.Minute), 3)
+	require.Equal(t, errStaleRequest, g.check(testSigner, payload, timestamp))
+	payload, timestamp = newTestRequest(t, now.Add(2*time.Minute), 4)
+	require.Equal(t, errFutureRequest, g.check(testSigner, payload, timestamp))
+	require.Equal(t, errNoTimestamp, g.check(testSigner, payload, 0))
+}
+
+func TestReplayGuardReplayedRequest(t *testing.T) {
+	now := time.Now()
+	g := newReplayGuard(time.Minute)
+	g.now = func() time.Time { return now }
+
+	payload, timestamp := newTestRequest(t, now, 1)
+	require.NoError(t, g.check(testSigner, payload, timestamp))
+	// the same signed payload is a replay no matter how it was wrapped in an envelope
+	require.Equal(t, errReplayedRequest, g.check(testSigner, payload, timestamp))
+	// the same payload signed by someone else is a different request
+	require.NoError(t, g.check([]byte("other signer"), payload, timestamp))
+	// the same time range with a different signed nonce is a new request
+	payload, timestamp = newTestRequest(t, now, 2)
+	require.NoError(t, g.check(testSigner, payload, timestamp))
+}
+
+func TestReplayGuardDeleteExpired(t *testing.T) {
+	start := time.Now()
+	now := start
+	g := newReplayGuard(time.Minute)
+	g.now = func() time.Time { return now }
+
+	payload, timestamp := newTestRequest(t, start, 1)
+	require.NoError(t, g.check(testSigner, payload, timestamp))
+	require.Len(t, g.seen, 1)
+
+	// the request is stale so it is not needed to remember it
+	now = start.Add(2 * time.Minute)
+	newPayload, newTimestamp := newTestRequest(t, now, 2)
+	require.NoError(t, g.check(testSigner, newPayload, newTimestamp))
+	require.Len(t, g.seen, 1)
+	require.Equal(t, errStaleRequest, g.check(testSigner, payload, timestamp))
+}
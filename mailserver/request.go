@@ -0,0 +1,38 @@
+package mailserver
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/status-im/status-go/mailserver/protocol"
+)
+
+// MessagesRequestPayload is an RLP-encoded payload of a historic messages request.
+// It's defined in the protocol package shared with clients.
+type MessagesRequestPayload = protocol.MessagesRequestPayload
+
+// TimeWindow is a time range between two timestamps (both inclusive).
+type TimeWindow = protocol.TimeWindow
+
+// storageQuery returns a query matching the requested envelopes.
+func storageQuery(p MessagesRequestPayload) StorageQuery {
+	return StorageQuery{
+		Lower:   p.Lower,
+		Upper:   p.Upper,
+		Bloom:   p.Bloom,
+		Topics:  p.Topics,
+		Limit:   p.Limit,
+		Cursor:  p.Cursor,
+		Windows: p.Windows,
+	}
+}
+
+// requestDeliveryMode returns how the envelopes requested by the request
+// with requestID are sent to the client. The checksum is sent only for batches
+// because only batches tell the client which request the envelopes belong to.
+func requestDeliveryMode(requestID common.Hash, p MessagesRequestPayload) deliveryMode {
+	return deliveryMode{
+		requestID: requestID,
+		batch:     p.Batch,
+		compress:  p.Batch && p.Compress,
+		checksum:  p.Batch && p.Checksum,
+	}
+}
@@ -0,0 +1,17 @@
+package mailserver
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDeliveryMode(t *testing.T) {
+	id := common.Hash{0x01}
+	require.Equal(t, deliveryMode{requestID: id}, requestDeliveryMode(id, MessagesRequestPayload{Compress: true}), "It does not compress single envelopes")
+	require.Equal(t, deliveryMode{requestID: id}, requestDeliveryMode(id, MessagesRequestPayload{Checksum: true}), "It does not count single envelopes")
+	require.Equal(t, deliveryMode{requestID: id, batch: true}, requestDeliveryMode(id, MessagesRequestPayload{Batch: true}))
+	require.Equal(t, deliveryMode{requestID: id, batch: true, compress: true}, requestDeliveryMode(id, MessagesRequestPayload{Batch: true, Compress: true}))
+	require.Equal(t, deliveryMode{requestID: id, batch: true, checksum: true}, requestDeliveryMode(id, MessagesRequestPayload{Batch: true, Checksum: true}))
+}
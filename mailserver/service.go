@@ -0,0 +1,49 @@
+package mailserver
+
+import (
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Make sure that AdminService implements node.Service interface.
+var _ node.Service = (*AdminService)(nil)
+
+// AdminService exposes the admin API of a MailServer.
+type AdminService struct {
+	server *WMailServer
+}
+
+// NewAdminService returns a new AdminService.
+func NewAdminService(server *WMailServer) *AdminService {
+	return &AdminService{server: server}
+}
+
+// Protocols returns a new protocols list. In this case, there are none.
+func (s *AdminService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{}
+}
+
+// APIs returns a list of new APIs.
+func (s *AdminService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "mailserver",
+			Version:   "1.0",
+			Service:   NewAdminAPI(s.server),
+			Public:    false,
+		},
+	}
+}
+
+// Start is run when a service is started.
+// It does nothing in this case but is required by `node.Service` interface.
+func (s *AdminService) Start(server *p2p.Server) error {
+	return nil
+}
+
+// Stop is run when a service is stopped.
+// It does nothing in this case but is required by `node.Service` interface.
+func (s *AdminService) Stop() error {
+	return nil
+}
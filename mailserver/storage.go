@@ -0,0 +1,213 @@
+package mailserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/params"
+)
+
+const (
+	// defaultSQLDriver is the database/sql driver used by the SQL storage
+	// when MailServerStorageDriver is not set.
+	defaultSQLDriver = "sqlite3"
+	// defaultSQLDatabase is the name of the SQL database file created
+	// in DataDir when MailServerStorageDataSource is not set.
+	defaultSQLDatabase = "mailserver.sql"
+)
+
+var errUnknownStorage = errors.New("unknown mailserver storage")
+
+// MailServerStorage is an interface implemented by the storage backends
+// used by WMailServer to archive envelopes and serve historic requests.
+type MailServerStorage interface {
+	// Archive stores an envelope. Storing the same envelope twice is a no-op.
+	Archive(env *whisper.Envelope) error
+	// Query returns an iterator over the archived envelopes matching the query.
+	// Envelopes are returned from the newest to the oldest.
+	Query(query StorageQuery) (StorageIterator, error)
+	// Prune removes envelopes sent between lower (inclusive) and upper (exclusive)
+	// timestamps and returns how many have been removed.
+	Prune(lower, upper uint32) (int, error)
+	// Count returns the number of envelopes sent between lower (inclusive)
+	// and upper (exclusive) timestamps.
+	Count(lower, upper uint32) (int, error)
+	// Has returns true if an envelope with the given key is archived.
+	Has(key *DBKey) (bool, error)
+	// Oldest returns the timestamp of the oldest envelope or 0 if there are no envelopes.
+	Oldest() (uint32, error)
+	// Size returns an approximate size of archived envelopes in bytes.
+	Size() (int64, error)
+	// Close closes the underlying database.
+	Close() error
+}
+
+// StorageQuery describes archived envelopes requested from a MailServerStorage.
+type StorageQuery struct {
+	// Lower and Upper are timestamps limiting the time range (both inclusive).
+	Lower uint32
+	Upper uint32
+	// Windows optionally narrows the time range to a list of time windows.
+	// Windows must not overlap and must be sorted from the newest to the oldest.
+	Windows []TimeWindow
+	// Bloom is used to match envelopes if Topics is empty.
+	Bloom []byte
+	// Topics is a list of exact topics to match. It takes precedence over Bloom.
+	Topics []whisper.TopicType
+	// Limit is a hint about the maximum number of envelopes that will be read.
+	Limit uint32
+	// Cursor is the key of the last envelope read in the previous page.
+	// Only older envelopes are returned if it is set.
+	Cursor cursorType
+}
+
+// timeWindows returns time windows covered by the query,
+// from the newest to the oldest.
+func (q StorageQuery) timeWindows() []TimeWindow {
+	if len(q.Windows) == 0 {
+		return []TimeWindow{{Lower: q.Lower, Upper: q.Upper}}
+	}
+	return q.Windows
+}
+
+// matches returns true if the envelope topic is accepted by the query.
+func (q StorageQuery) matches(topic whisper.TopicType) bool {
+	if len(q.Topics) == 0 {
+		return whisper.BloomFilterMatch(q.Bloom, whisper.TopicToBloom(topic))
+	}
+
+	for _, t := range q.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// covers returns true if an envelope with the timestamp and the topic
+// could be returned by the query.
+func (q StorageQuery) covers(timestamp uint32, topic whisper.TopicType) bool {
+	if !q.matches(topic) {
+		return false
+	}
+	for _, w := range q.timeWindows() {
+		if timestamp >= w.Lower && timestamp <= w.Upper {
+			return true
+		}
+	}
+	return false
+}
+
+// split returns a query limited to the newest part of the remaining time range
+// which is not longer than size seconds, and a cursor pointing to the rest
+// of the time range. The returned cursor is nil if the query does not need to be split.
+func (q StorageQuery) split(size uint32) (StorageQuery, cursorType) {
+	var zero common.Hash
+
+	top := q.Upper
+	if q.Cursor != nil {
+		t := binary.BigEndian.Uint32(q.Cursor)
+		// envelopes with the cursor timestamp may still be pending
+		// unless the cursor points to the beginning of a chunk
+		if t > 0 && bytes.Equal(q.Cursor[timestampLength:], zero[:]) {
+			t--
+		}
+		if t < top {
+			top = t
+		}
+	}
+
+	// windows which were not delivered yet
+	var remaining []TimeWindow
+	for _, w := range q.timeWindows() {
+		if w.Lower > top {
+			continue
+		}
+		if w.Upper > top {
+			w.Upper = top
+		}
+		remaining = append(remaining, w)
+	}
+	if len(remaining) == 0 {
+		return q, nil
+	}
+
+	var lower uint32
+	if remaining[0].Upper >= size {
+		lower = remaining[0].Upper - size + 1
+	}
+	if remaining[len(remaining)-1].Lower >= lower {
+		return q, nil
+	}
+
+	chunk := q
+	if chunk.Lower < lower {
+		chunk.Lower = lower
+	}
+	if len(q.Windows) > 0 {
+		chunk.Windows = nil
+		for _, w := range remaining {
+			if w.Upper < lower {
+				break
+			}
+			if w.Lower < lower {
+				w.Lower = lower
+			}
+			chunk.Windows = append(chunk.Windows, w)
+		}
+	}
+
+	return chunk, NewDbKey(lower, zero).raw
+}
+
+// StorageIterator iterates over the envelopes returned by MailServerStorage.Query.
+type StorageIterator interface {
+	// Next moves the iterator to the next matching envelope.
+	// It returns false when there are no more envelopes.
+	Next() bool
+	// Envelope returns the current envelope.
+	Envelope() *whisper.Envelope
+	// Cursor returns the key of the current envelope.
+	Cursor() cursorType
+	// Error returns an error encountered while iterating, if any.
+	Error() error
+	// Release releases resources associated with the iterator.
+	Release()
+}
+
+// NewStorage opens the storage backend selected in the config.
+func NewStorage(config *params.WhisperConfig) (MailServerStorage, error) {
+	switch config.MailServerStorage {
+	case "", params.MailServerStorageLevelDB:
+		storage, err := NewLevelDBStorage(config.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	case params.MailServerStorageSQL:
+		driver := config.MailServerStorageDriver
+		if driver == "" {
+			driver = defaultSQLDriver
+		}
+		dataSource := config.MailServerStorageDataSource
+		if dataSource == "" {
+			if err := os.MkdirAll(config.DataDir, os.ModePerm); err != nil {
+				return nil, err
+			}
+			dataSource = filepath.Join(config.DataDir, defaultSQLDatabase)
+		}
+		storage, err := NewSQLStorage(driver, dataSource)
+		if err != nil {
+			return nil, err
+		}
+		return storage, nil
+	default:
+		return nil, fmt.Errorf("%v: %s", errUnknownStorage, config.MailServerStorage)
+	}
+}
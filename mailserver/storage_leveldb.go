@@ -0,0 +1,244 @@
+package mailserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/db"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// dbImpl is an interface introduced to be able to test some unexpected
+// panics from leveldb that are difficult to reproduce.
+// normally the db implementation is leveldb.DB, but in TestMailServerDBPanicSuite
+// we use panicDB to test panics from the db.
+// more info about the panic errors:
+// https://github.com/syndtr/goleveldb/issues/224
+type dbImpl interface {
+	Close() error
+	Write(*leveldb.Batch, *opt.WriteOptions) error
+	Put([]byte, []byte, *opt.WriteOptions) error
+	Get([]byte, *opt.ReadOptions) ([]byte, error)
+	NewIterator(*util.Range, *opt.ReadOptions) iterator.Iterator
+	SizeOf([]util.Range) (leveldb.Sizes, error)
+	CompactRange(util.Range) error
+}
+
+// LevelDBStorage is a MailServerStorage which keeps envelopes in leveldb
+// using DBKey (timestamp + hash) as a key.
+type LevelDBStorage struct {
+	db dbImpl
+}
+
+// NewLevelDBStorage opens a leveldb database located at path.
+func NewLevelDBStorage(path string) (*LevelDBStorage, error) {
+	database, err := db.Open(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewLevelDBStorageWithDB(database), nil
+}
+
+// NewLevelDBStorageWithDB returns a new LevelDBStorage for db.
+func NewLevelDBStorageWithDB(db dbImpl) *LevelDBStorage {
+	return &LevelDBStorage{db: db}
+}
+
+// Archive stores an envelope.
+func (s *LevelDBStorage) Archive(env *whisper.Envelope) error {
+	key := NewDbKey(env.Expiry-env.TTL, env.Hash())
+	rawEnvelope, err := rlp.EncodeToBytes(env)
+	if err != nil {
+		return fmt.Errorf("rlp.EncodeToBytes failed: %s", err)
+	}
+	return s.db.Put(key.raw, rawEnvelope, nil)
+}
+
+// Query returns an iterator over the envelopes matching the query.
+func (s *LevelDBStorage) Query(query StorageQuery) (StorageIterator, error) {
+	i := &levelDBIterator{
+		db:      s.db,
+		query:   query,
+		windows: query.timeWindows(),
+	}
+	i.nextWindow()
+	return i, nil
+}
+
+// Prune removes envelopes sent between lower and upper timestamps.
+// The pruned range is compacted in order to reclaim disk space.
+func (s *LevelDBStorage) Prune(lower, upper uint32) (int, error) {
+	removed, err := NewCleanerWithDB(s.db).Prune(lower, upper)
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	var zero common.Hash
+	kl := NewDbKey(lower, zero)
+	ku := NewDbKey(upper, zero)
+	return removed, s.db.CompactRange(util.Range{Start: kl.raw, Limit: ku.raw})
+}
+
+// Count returns the number of envelopes sent between lower and upper timestamps.
+func (s *LevelDBStorage) Count(lower, upper uint32) (int, error) {
+	var zero common.Hash
+	kl := NewDbKey(lower, zero)
+	ku := NewDbKey(upper, zero)
+	i := s.db.NewIterator(&util.Range{Start: kl.raw, Limit: ku.raw}, nil)
+	defer i.Release()
+
+	count := 0
+	for i.Next() {
+		count++
+	}
+	return count, i.Error()
+}
+
+// Has returns true if an envelope with the given key is archived.
+func (s *LevelDBStorage) Has(key *DBKey) (bool, error) {
+	_, err := s.db.Get(key.raw, nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Oldest returns the timestamp of the oldest envelope.
+func (s *LevelDBStorage) Oldest() (uint32, error) {
+	i := s.db.NewIterator(nil, nil)
+	defer i.Release()
+
+	if !i.First() {
+		return 0, i.Error()
+	}
+	return binary.BigEndian.Uint32(i.Key()), nil
+}
+
+// Size returns an approximate size of leveldb tables.
+// Recently archived envelopes which were not yet flushed to the disk are not counted.
+func (s *LevelDBStorage) Size() (int64, error) {
+	var zero common.Hash
+	sizes, err := s.db.SizeOf([]util.Range{{
+		Start: NewDbKey(0, zero).raw,
+		Limit: NewDbKey(math.MaxUint32, zero).raw,
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return sizes.Sum(), nil
+}
+
+// Compact compacts all archived envelopes, so that the size of deleted ones
+// is not counted by Size anymore.
+func (s *LevelDBStorage) Compact() error {
+	var zero common.Hash
+	return s.db.CompactRange(util.Range{
+		Start: NewDbKey(0, zero).raw,
+		Limit: NewDbKey(math.MaxUint32, zero).raw,
+	})
+}
+
+// Close closes the leveldb database.
+func (s *LevelDBStorage) Close() error {
+	return s.db.Close()
+}
+
+// levelDBIterator walks leveldb keys backwards window by window
+// and skips envelopes that do not match the query.
+type levelDBIterator struct {
+	db       dbImpl
+	query    StorageQuery
+	windows  []TimeWindow
+	current  iterator.Iterator
+	envelope *whisper.Envelope
+	err      error
+}
+
+// nextWindow releases the current leveldb iterator and opens
+// a new one for the next time window. It returns false if there are no more windows.
+func (i *levelDBIterator) nextWindow() bool {
+	if i.current != nil {
+		i.err = i.current.Error()
+		i.current.Release()
+		i.current = nil
+	}
+
+	if i.err != nil || len(i.windows) == 0 {
+		return false
+	}
+
+	var zero common.Hash
+	window := i.windows[0]
+	i.windows = i.windows[1:]
+
+	kl := NewDbKey(window.Lower, zero).raw
+	ku := NewDbKey(window.Upper+1, zero).raw
+	if i.query.Cursor != nil && bytes.Compare(i.query.Cursor, ku) < 0 {
+		ku = i.query.Cursor
+	}
+
+	i.current = i.db.NewIterator(&util.Range{Start: kl, Limit: ku}, nil)
+	// seek to the end as we want to return envelopes in a descending order
+	i.current.Seek(ku)
+	return true
+}
+
+func (i *levelDBIterator) Next() bool {
+	for i.current != nil {
+		for i.current.Prev() {
+			var envelope whisper.Envelope
+			if err := rlp.DecodeBytes(i.current.Value(), &envelope); err != nil {
+				log.Error(fmt.Sprintf("RLP decoding failed: %s", err))
+				continue
+			}
+
+			if i.query.matches(envelope.Topic) {
+				i.envelope = &envelope
+				return true
+			}
+		}
+
+		if !i.nextWindow() {
+			return false
+		}
+	}
+	return false
+}
+
+func (i *levelDBIterator) Envelope() *whisper.Envelope {
+	return i.envelope
+}
+
+func (i *levelDBIterator) Cursor() cursorType {
+	// the key is only valid until the iterator moves
+	key := i.current.Key()
+	cursor := make(cursorType, len(key))
+	copy(cursor, key)
+	return cursor
+}
+
+func (i *levelDBIterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	if i.current != nil {
+		return i.current.Error()
+	}
+	return nil
+}
+
+func (i *levelDBIterator) Release() {
+	if i.current != nil {
+		i.current.Release()
+		i.current = nil
+	}
+}
@@ -0,0 +1,234 @@
+package mailserver
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+
+	_ "github.com/mutecomm/go-sqlcipher" // registers the default sqlite3 driver
+)
+
+// Queries are written to be understood both by sqlite and postgres.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS envelopes (
+		id BYTEA NOT NULL PRIMARY KEY,
+		timestamp BIGINT NOT NULL,
+		hash BYTEA NOT NULL,
+		topic BYTEA NOT NULL,
+		data BYTEA NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS envelopes_topic_id_idx ON envelopes (topic, id)`,
+	`CREATE INDEX IF NOT EXISTS envelopes_timestamp_idx ON envelopes (timestamp)`,
+	`CREATE INDEX IF NOT EXISTS envelopes_hash_idx ON envelopes (hash)`,
+}
+
+// SQLStorage is a MailServerStorage backed by an SQL database.
+// Envelopes are indexed by topic, timestamp and hash, so that topic queries
+// do not require a full scan of the requested time range.
+type SQLStorage struct {
+	db *sql.DB
+}
+
+// NewSQLStorage opens an SQL database using the given database/sql driver
+// and data source name and creates the schema if needed.
+func NewSQLStorage(driver, dataSource string) (*SQLStorage, error) {
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLStorage{db: db}
+	if err := s.setup(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) setup() error {
+	for _, stmt := range sqlSchema {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Archive stores an envelope.
+func (s *SQLStorage) Archive(env *whisper.Envelope) error {
+	timestamp := env.Expiry - env.TTL
+	hash := env.Hash()
+	key := NewDbKey(timestamp, hash)
+	rawEnvelope, err := rlp.EncodeToBytes(env)
+	if err != nil {
+		return fmt.Errorf("rlp.EncodeToBytes failed: %s", err)
+	}
+
+	_, err = s.db.Exec(
+		`INSERT INTO envelopes (id, timestamp, hash, topic, data)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (SELECT 1 FROM envelopes WHERE id = $1)`,
+		key.raw,
+		int64(timestamp),
+		hash[:],
+		env.Topic[:],
+		rawEnvelope,
+	)
+	return err
+}
+
+// Query returns an iterator over the envelopes matching the query.
+func (s *SQLStorage) Query(query StorageQuery) (StorageIterator, error) {
+	var (
+		zero   common.Hash
+		args   []interface{}
+		ranges []string
+	)
+
+	for _, window := range query.timeWindows() {
+		args = append(args, NewDbKey(window.Lower, zero).raw, NewDbKey(window.Upper+1, zero).raw)
+		ranges = append(ranges, fmt.Sprintf("(id >= $%d AND id < $%d)", len(args)-1, len(args)))
+	}
+	stmt := `SELECT id, topic, data FROM envelopes WHERE (` + strings.Join(ranges, " OR ") + `)`
+
+	if query.Cursor != nil {
+		args = append(args, []byte(query.Cursor))
+		stmt += fmt.Sprintf(` AND id < $%d`, len(args))
+	}
+
+	if len(query.Topics) > 0 {
+		placeholders := make([]string, len(query.Topics))
+		for i, topic := range query.Topics {
+			args = append(args, topic[:])
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		stmt += ` AND topic IN (` + strings.Join(placeholders, ", ") + `)`
+	}
+
+	stmt += ` ORDER BY id DESC`
+
+	// With a bloom filter some rows are skipped by the iterator,
+	// so the limit can be applied only when filtering by topics.
+	if len(query.Topics) > 0 && query.Limit != noLimits {
+		stmt += fmt.Sprintf(` LIMIT %d`, query.Limit)
+	}
+
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlIterator{rows: rows, query: query}, nil
+}
+
+// Prune removes envelopes sent between lower and upper timestamps.
+func (s *SQLStorage) Prune(lower, upper uint32) (int, error) {
+	var zero common.Hash
+	kl := NewDbKey(lower, zero)
+	ku := NewDbKey(upper, zero)
+
+	result, err := s.db.Exec(`DELETE FROM envelopes WHERE id >= $1 AND id < $2`, kl.raw, ku.raw)
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := result.RowsAffected()
+	return int(removed), err
+}
+
+// Count returns the number of envelopes sent between lower and upper timestamps.
+func (s *SQLStorage) Count(lower, upper uint32) (int, error) {
+	var (
+		zero  common.Hash
+		count int
+	)
+	kl := NewDbKey(lower, zero)
+	ku := NewDbKey(upper, zero)
+
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM envelopes WHERE id >= $1 AND id < $2`, kl.raw, ku.raw).Scan(&count)
+	return count, err
+}
+
+// Has returns true if an envelope with the given key is archived.
+func (s *SQLStorage) Has(key *DBKey) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM envelopes WHERE id = $1`, key.raw).Scan(&count)
+	return count > 0, err
+}
+
+// Oldest returns the timestamp of the oldest envelope.
+func (s *SQLStorage) Oldest() (uint32, error) {
+	var oldest int64
+	err := s.db.QueryRow(`SELECT COALESCE(MIN(timestamp), 0) FROM envelopes`).Scan(&oldest)
+	return uint32(oldest), err
+}
+
+// Size returns the size of archived envelopes data.
+func (s *SQLStorage) Size() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(LENGTH(data)), 0) FROM envelopes`).Scan(&size)
+	return size, err
+}
+
+// Close closes the database.
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
+
+type sqlIterator struct {
+	rows     *sql.Rows
+	query    StorageQuery
+	cursor   cursorType
+	envelope *whisper.Envelope
+	err      error
+}
+
+func (i *sqlIterator) Next() bool {
+	for i.err == nil && i.rows.Next() {
+		var (
+			id    []byte
+			topic []byte
+			data  []byte
+		)
+		if i.err = i.rows.Scan(&id, &topic, &data); i.err != nil {
+			return false
+		}
+
+		if !i.query.matches(whisper.BytesToTopic(topic)) {
+			continue
+		}
+
+		var envelope whisper.Envelope
+		if i.err = rlp.DecodeBytes(data, &envelope); i.err != nil {
+			return false
+		}
+
+		i.cursor = id
+		i.envelope = &envelope
+		return true
+	}
+	return false
+}
+
+func (i *sqlIterator) Envelope() *whisper.Envelope {
+	return i.envelope
+}
+
+func (i *sqlIterator) Cursor() cursorType {
+	return i.cursor
+}
+
+func (i *sqlIterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.rows.Err()
+}
+
+func (i *sqlIterator) Release() {
+	_ = i.rows.Close()
+}
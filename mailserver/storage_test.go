@@ -0,0 +1,260 @@
+package mailserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+var (
+	testTopicA = whisper.TopicType{0x01, 0x02, 0x03, 0x04}
+	testTopicB = whisper.TopicType{0x05, 0x06, 0x07, 0x08}
+)
+
+func TestLevelDBStorageSuite(t *testing.T) {
+	suite.Run(t, &StorageSuite{
+		newStorage: func(dir string) (MailServerStorage, error) {
+			db, err := leveldb.Open(storage.NewMemStorage(), nil)
+			if err != nil {
+				return nil, err
+			}
+			return NewLevelDBStorageWithDB(db), nil
+		},
+	})
+}
+
+func TestSQLStorageSuite(t *testing.T) {
+	suite.Run(t, &StorageSuite{
+		newStorage: func(dir string) (MailServerStorage, error) {
+			return NewSQLStorage(defaultSQLDriver, filepath.Join(dir, defaultSQLDatabase))
+		},
+	})
+}
+
+type StorageSuite struct {
+	suite.Suite
+	newStorage func(dir string) (MailServerStorage, error)
+	dir        string
+	storage    MailServerStorage
+}
+
+func (s *StorageSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "mailserver-storage-test")
+	s.Require().NoError(err)
+	s.dir = dir
+	s.storage, err = s.newStorage(dir)
+	s.Require().NoError(err)
+}
+
+func (s *StorageSuite) TearDownTest() {
+	s.NoError(s.storage.Close())
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func (s *StorageSuite) archive(envelopes ...*whisper.Envelope) {
+	for _, env := range envelopes {
+		s.Require().NoError(s.storage.Archive(env))
+	}
+}
+
+func (s *StorageSuite) query(q StorageQuery) (envelopes []*whisper.Envelope, cursor cursorType) {
+	i, err := s.storage.Query(q)
+	s.Require().NoError(err)
+	defer i.Release()
+
+	for i.Next() {
+		envelopes = append(envelopes, i.Envelope())
+		cursor = i.Cursor()
+		if q.Limit != noLimits && uint32(len(envelopes)) == q.Limit {
+			break
+		}
+	}
+	s.Require().NoError(i.Error())
+	return
+}
+
+func (s *StorageSuite) TestArchiveTwice() {
+	env := newTestEnvelope(100, testTopicA, 1)
+	s.archive(env, env)
+
+	count, err := s.storage.Count(0, 200)
+	s.NoError(err)
+	s.Equal(1, count)
+}
+
+func (s *StorageSuite) TestHas() {
+	env := newTestEnvelope(100, testTopicA, 1)
+	key := NewDbKey(env.Expiry-env.TTL, env.Hash())
+
+	exists, err := s.storage.Has(key)
+	s.NoError(err)
+	s.False(exists)
+
+	s.archive(env)
+	exists, err = s.storage.Has(key)
+	s.NoError(err)
+	s.True(exists)
+}
+
+func (s *StorageSuite) TestQueryTimeRange() {
+	first := newTestEnvelope(100, testTopicA, 1)
+	second := newTestEnvelope(101, testTopicA, 2)
+	third := newTestEnvelope(102, testTopicA, 3)
+	s.archive(first, second, third)
+
+	envelopes, _ := s.query(StorageQuery{Lower: 101, Upper: 102, Bloom: whisper.MakeFullNodeBloom()})
+	s.Require().Len(envelopes, 2)
+	s.Equal(third.Hash(), envelopes[0].Hash())
+	s.Equal(second.Hash(), envelopes[1].Hash())
+}
+
+func (s *StorageSuite) TestQueryTopics() {
+	s.archive(
+		newTestEnvelope(100, testTopicA, 1),
+		newTestEnvelope(101, testTopicB, 2),
+		newTestEnvelope(102, testTopicA, 3),
+	)
+
+	envelopes, _ := s.query(StorageQuery{Lower: 0, Upper: 200, Topics: []whisper.TopicType{testTopicB}})
+	s.Require().Len(envelopes, 1)
+	s.Equal(testTopicB, envelopes[0].Topic)
+
+	envelopes, _ = s.query(StorageQuery{Lower: 0, Upper: 200, Bloom: whisper.TopicToBloom(testTopicA)})
+	s.Require().Len(envelopes, 2)
+	s.Equal(testTopicA, envelopes[0].Topic)
+	s.Equal(testTopicA, envelopes[1].Topic)
+}
+
+func (s *StorageSuite) TestQueryCursor() {
+	for i := uint32(0); i < 5; i++ {
+		s.archive(newTestEnvelope(100+i, testTopicA, uint64(i)))
+	}
+
+	query := StorageQuery{Lower: 0, Upper: 200, Topics: []whisper.TopicType{testTopicA}, Limit: 3}
+	envelopes, cursor := s.query(query)
+	s.Require().Len(envelopes, 3)
+	s.Equal(uint32(104), envelopes[0].Expiry-envelopes[0].TTL)
+
+	query.Cursor = cursor
+	envelopes, _ = s.query(query)
+	s.Require().Len(envelopes, 2)
+	s.Equal(uint32(101), envelopes[0].Expiry-envelopes[0].TTL)
+	s.Equal(uint32(100), envelopes[1].Expiry-envelopes[1].TTL)
+}
+
+func (s *StorageSuite) TestQueryWindows() {
+	for i := uint32(0); i < 10; i++ {
+		s.archive(newTestEnvelope(100+i, testTopicA, uint64(i)))
+	}
+
+	query := StorageQuery{
+		Lower:   100,
+		Upper:   109,
+		Topics:  []whisper.TopicType{testTopicA},
+		Windows: []TimeWindow{{Lower: 107, Upper: 108}, {Lower: 101, Upper: 102}},
+		Limit:   3,
+	}
+	envelopes, cursor := s.query(query)
+	s.Require().Len(envelopes, 3)
+	s.Equal(uint32(108), envelopes[0].Expiry-envelopes[0].TTL)
+	s.Equal(uint32(107), envelopes[1].Expiry-envelopes[1].TTL)
+	s.Equal(uint32(102), envelopes[2].Expiry-envelopes[2].TTL)
+
+	query.Cursor = cursor
+	envelopes, _ = s.query(query)
+	s.Require().Len(envelopes, 1)
+	s.Equal(uint32(101), envelopes[0].Expiry-envelopes[0].TTL)
+}
+
+func (s *StorageSuite) TestPrune() {
+	s.archive(
+		newTestEnvelope(100, testTopicA, 1),
+		newTestEnvelope(101, testTopicA, 2),
+		newTestEnvelope(102, testTopicA, 3),
+	)
+
+	removed, err := s.storage.Prune(0, 102)
+	s.NoError(err)
+	s.Equal(2, removed)
+
+	count, err := s.storage.Count(0, 200)
+	s.NoError(err)
+	s.Equal(1, count)
+}
+
+func (s *StorageSuite) TestOldest() {
+	oldest, err := s.storage.Oldest()
+	s.NoError(err)
+	s.Equal(uint32(0), oldest)
+
+	s.archive(
+		newTestEnvelope(102, testTopicA, 1),
+		newTestEnvelope(100, testTopicA, 2),
+		newTestEnvelope(101, testTopicA, 3),
+	)
+
+	oldest, err = s.storage.Oldest()
+	s.NoError(err)
+	s.Equal(uint32(100), oldest)
+}
+
+func newTestEnvelope(timestamp uint32, topic whisper.TopicType, nonce uint64) *whisper.Envelope {
+	return &whisper.Envelope{
+		Expiry: timestamp + 10,
+		TTL:    10,
+		Topic:  topic,
+		Data:   []byte("test data"),
+		Nonce:  nonce,
+	}
+}
+
+func TestStorageQuerySplit(t *testing.T) {
+	// fits in a single chunk
+	query := StorageQuery{Lower: 100, Upper: 199}
+	chunk, cursor := query.split(100)
+	require.Equal(t, query, chunk)
+	require.Nil(t, cursor)
+
+	// the newest chunk is returned first
+	query = StorageQuery{Lower: 100, Upper: 299}
+	chunk, cursor = query.split(100)
+	require.Equal(t, uint32(200), chunk.Lower)
+	require.Equal(t, uint32(299), chunk.Upper)
+	require.Equal(t, NewDbKey(200, common.Hash{}).raw, []byte(cursor))
+
+	// the cursor moves to the next chunk
+	query.Cursor = cursor
+	chunk, cursor = query.split(100)
+	require.Equal(t, uint32(100), chunk.Lower)
+	require.Nil(t, cursor)
+
+	// windows are clipped to the chunk
+	query = StorageQuery{
+		Lower:   100,
+		Upper:   400,
+		Windows: []TimeWindow{{Lower: 350, Upper: 400}, {Lower: 250, Upper: 320}, {Lower: 100, Upper: 110}},
+	}
+	chunk, cursor = query.split(100)
+	require.Equal(t, []TimeWindow{{Lower: 350, Upper: 400}, {Lower: 301, Upper: 320}}, chunk.Windows)
+	require.Equal(t, NewDbKey(301, common.Hash{}).raw, []byte(cursor))
+
+	// a gap between windows is skipped
+	query.Cursor = cursor
+	chunk, cursor = query.split(100)
+	require.Equal(t, []TimeWindow{{Lower: 250, Upper: 300}}, chunk.Windows)
+	require.Equal(t, NewDbKey(201, common.Hash{}).raw, []byte(cursor))
+
+	// the rest is limited by the cursor
+	query.Cursor = cursor
+	chunk, cursor = query.split(100)
+	require.Equal(t, query, chunk)
+	require.Nil(t, cursor)
+}
@@ -0,0 +1,190 @@
+package mailserver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+)
+
+const (
+	// defaultSyncPeriod is used if WhisperConfig.MailServerSyncPeriod is not set.
+	defaultSyncPeriod = time.Hour
+	// defaultSyncRange is used if WhisperConfig.MailServerSyncRange is not set.
+	defaultSyncRange = 24 * time.Hour
+	// syncRetryPeriod is the time after which a failed sync is retried,
+	// e.g. if the peer was not connected.
+	syncRetryPeriod = time.Minute
+	// syncOverlap is added to the time range of consecutive syncs
+	// in order not to miss envelopes which reached the peer with a delay.
+	syncOverlap = 5 * time.Minute
+	// syncTimeout is the max time to wait for a page of envelopes.
+	syncTimeout = 30 * time.Second
+	// maxSyncLimit is the max number of envelopes sent in response to a single sync request.
+	maxSyncLimit = 1000
+	// syncBatchSize is the max number of envelopes sent in a single sync response message.
+	syncBatchSize = 100
+)
+
+var (
+	errSyncTimeout = errors.New("sync response timed out")
+	errSyncStopped = errors.New("syncer stopped")
+
+	syncRequestsMeter = metrics.NewRegisteredMeter("mailserver/syncRequests", nil)
+	syncErrorsCounter = metrics.NewRegisteredCounter("mailserver/syncErrors", nil)
+)
+
+// syncer periodically requests archived envelopes from other MailServers
+// in order to fill gaps, e.g. when this MailServer was offline.
+// Received envelopes are archived by whisper and duplicates are skipped by the storage.
+type syncer struct {
+	w         *whisper.Whisper
+	peers     []discover.NodeID
+	period    time.Duration
+	syncRange time.Duration
+	timeout   time.Duration
+	now       func() time.Time
+
+	// synced is the upper bound of the last successful sync with the peer.
+	synced map[discover.NodeID]uint32
+	// next is the time of the next sync with the peer.
+	next map[discover.NodeID]time.Time
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+func newSyncer(w *whisper.Whisper, peers []discover.NodeID, period, syncRange time.Duration) *syncer {
+	return &syncer{
+		w:         w,
+		peers:     peers,
+		period:    period,
+		syncRange: syncRange,
+		timeout:   syncTimeout,
+		now:       time.Now,
+		synced:    make(map[discover.NodeID]uint32),
+		next:      make(map[discover.NodeID]time.Time),
+		quit:      make(chan struct{}),
+	}
+}
+
+// Start runs syncing in the background.
+func (s *syncer) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run()
+	}()
+}
+
+// Stop stops syncing and waits until the current sync is interrupted.
+func (s *syncer) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+func (s *syncer) run() {
+	t := time.NewTicker(syncRetryPeriod)
+	defer t.Stop()
+
+	for {
+		s.syncAll()
+
+		select {
+		case <-s.quit:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// syncAll syncs with the peers which are due.
+func (s *syncer) syncAll() {
+	for _, peer := range s.peers {
+		if s.now().Before(s.next[peer]) {
+			continue
+		}
+
+		if err := s.syncPeer(peer); err != nil {
+			if err == errSyncStopped {
+				return
+			}
+			log.Warn(fmt.Sprintf("failed to sync envelopes with %s: %s", peer.TerminalString(), err))
+			syncErrorsCounter.Inc(1)
+			s.next[peer] = s.now().Add(syncRetryPeriod)
+			continue
+		}
+		s.next[peer] = s.now().Add(s.period)
+	}
+}
+
+// syncPeer requests envelopes sent since the last successful sync with the peer
+// page by page until there are no more envelopes.
+func (s *syncer) syncPeer(peer discover.NodeID) error {
+	now := s.now()
+	request := whisper.SyncMailRequest{
+		Lower: uint32(now.Add(-s.syncRange).Unix()),
+		Upper: uint32(now.Unix()),
+		Bloom: whisper.MakeFullNodeBloom(),
+		Limit: maxSyncLimit,
+	}
+	if synced, ok := s.synced[peer]; ok {
+		request.Lower = synced - uint32(syncOverlap/time.Second)
+	}
+
+	events := make(chan whisper.EnvelopeEvent, 10)
+	sub := s.w.SubscribeEnvelopeEvents(events)
+	defer sub.Unsubscribe()
+
+	for {
+		if err := s.w.SyncMessages(peer[:], request); err != nil {
+			return err
+		}
+
+		cursor, err := s.waitSyncResponse(peer, events)
+		if err != nil {
+			return err
+		}
+		if len(cursor) == 0 {
+			break
+		}
+		request.Cursor = cursor
+	}
+
+	log.Info("synced envelopes", "peer", peer.TerminalString(), "lower", request.Lower, "upper", request.Upper)
+	s.synced[peer] = request.Upper
+	return nil
+}
+
+// waitSyncResponse waits for the last response to a sync request
+// and returns the cursor of the next page.
+func (s *syncer) waitSyncResponse(peer discover.NodeID, events <-chan whisper.EnvelopeEvent) ([]byte, error) {
+	timeout := time.NewTimer(s.timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Event != whisper.EventMailServerSyncFinished || ev.Peer != peer {
+				continue
+			}
+			resp, ok := ev.Data.(whisper.SyncEventResponse)
+			if !ok {
+				continue
+			}
+			if resp.Error != "" {
+				return nil, errors.New(resp.Error)
+			}
+			return resp.Cursor, nil
+		case <-timeout.C:
+			return nil, errSyncTimeout
+		case <-s.quit:
+			return nil, errSyncStopped
+		}
+	}
+}
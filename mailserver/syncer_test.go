@@ -0,0 +1,146 @@
+package mailserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/params"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSyncerSuite(t *testing.T) {
+	suite.Run(t, new(SyncerSuite))
+}
+
+// SyncerSuite runs two in-process nodes with MailServers.
+type SyncerSuite struct {
+	suite.Suite
+
+	nodes   []*node.Node
+	whisper []*whisper.Whisper
+	servers []*WMailServer
+	dataDir string
+}
+
+func (s *SyncerSuite) SetupTest() {
+	dataDir, err := ioutil.TempDir("", "mailserver-syncer-test")
+	s.Require().NoError(err)
+	s.dataDir = dataDir
+
+	s.nodes, s.whisper = startTestNodes(s.T(), 2)
+	s.servers = make([]*WMailServer, 2)
+}
+
+// startTestNodes starts in-process nodes running whisper.
+func startTestNodes(t *testing.T, n int) ([]*node.Node, []*whisper.Whisper) {
+	nodes := make([]*node.Node, n)
+	shh := make([]*whisper.Whisper, n)
+	for i := range nodes {
+		i := i // bind i to be usable in service constructors
+		stack, err := node.New(&node.Config{
+			Name: fmt.Sprintf("node-%d", i),
+			P2P: p2p.Config{
+				NoDiscovery: true,
+				MaxPeers:    1,
+				ListenAddr:  ":0",
+			},
+		})
+		require.NoError(t, err)
+		shh[i] = whisper.New(nil)
+		require.NoError(t, stack.Register(func(*node.ServiceContext) (node.Service, error) {
+			return shh[i], nil
+		}))
+		require.NoError(t, stack.Start())
+		nodes[i] = stack
+	}
+	return nodes, shh
+}
+
+func (s *SyncerSuite) TearDownTest() {
+	for i := range s.nodes {
+		if s.servers[i] != nil {
+			s.servers[i].Close()
+		}
+		s.NoError(s.nodes[i].Stop())
+	}
+	s.NoError(os.RemoveAll(s.dataDir))
+}
+
+// startMailServer registers a MailServer on the i-th node
+// which allows the given nodes to sync.
+func (s *SyncerSuite) startMailServer(i int, syncPeers ...*node.Node) {
+	config := &params.WhisperConfig{
+		DataDir:            fmt.Sprintf("%s/%d", s.dataDir, i),
+		MailServerPassword: "testpassword",
+	}
+	for _, peer := range syncPeers {
+		config.MailServerSyncPeers = append(config.MailServerSyncPeers, peer.Server().Self().String())
+	}
+
+	s.servers[i] = &WMailServer{}
+	s.whisper[i].RegisterServer(s.servers[i])
+	s.Require().NoError(s.servers[i].Init(s.whisper[i], config))
+	// the background syncer is not needed as syncing is triggered manually
+	if s.servers[i].syncer != nil {
+		s.servers[i].syncer.Stop()
+		s.servers[i].syncer = nil
+	}
+}
+
+func (s *SyncerSuite) connect() {
+	s.nodes[0].Server().AddPeer(s.nodes[1].Server().Self())
+}
+
+// syncPeer syncs the first node with the second one retrying
+// until whisper finishes the handshake with the peer.
+func (s *SyncerSuite) syncPeer() error {
+	peer := s.nodes[1].Server().Self().ID
+	syncer := newSyncer(s.whisper[0], []discover.NodeID{peer}, time.Hour, time.Hour)
+	syncer.timeout = time.Second
+
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = syncer.syncPeer(peer); err == nil || err.Error() != fmt.Sprintf("Could not find peer with ID: %x", peer[:]) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
+
+func (s *SyncerSuite) TestSyncEnvelopes() {
+	s.startMailServer(0)
+	s.startMailServer(1, s.nodes[0])
+
+	now := uint32(time.Now().Unix())
+	// more envelopes than the sync limit in order to receive them in pages
+	total := maxSyncLimit + syncBatchSize + 1
+	for i := 0; i < total; i++ {
+		s.Require().NoError(s.servers[1].db.Archive(newTestEnvelope(now-uint32(i%1800), testTopicA, uint64(i))))
+	}
+	// an envelope which the first node already has
+	s.Require().NoError(s.servers[0].db.Archive(newTestEnvelope(now, testTopicA, 0)))
+
+	s.connect()
+	s.Require().NoError(s.syncPeer())
+
+	count, err := s.servers[0].db.Count(0, now+1)
+	s.Require().NoError(err)
+	s.Equal(total, count)
+}
+
+func (s *SyncerSuite) TestSyncNotAllowed() {
+	s.startMailServer(0)
+	s.startMailServer(1)
+
+	s.connect()
+	s.EqualError(s.syncPeer(), "peer is not allowed to sync")
+}
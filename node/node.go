@@ -246,9 +246,8 @@ func activatePeerService(stack *node.Node) error {
 	})
 }
 
-func registerMailServer(whisperService *whisper.Whisper, config *params.WhisperConfig) (err error) {
-	var mailServer mailserver.WMailServer
-	whisperService.RegisterServer(&mailServer)
+func registerMailServer(whisperService *whisper.Whisper, mailServer *mailserver.WMailServer, config *params.WhisperConfig) (err error) {
+	whisperService.RegisterServer(mailServer)
 
 	return mailServer.Init(whisperService, config)
 }
@@ -267,6 +266,9 @@ func activateShhService(stack *node.Node, config *params.NodeConfig, db *leveldb
 		}
 	}
 
+	// mailServer is set when whisper is constructed and used by the admin service
+	var mailServer *mailserver.WMailServer
+
 	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
 		whisperServiceConfig := &whisper.Config{
 			MaxMessageSize:     whisper.DefaultMaxMessageSize,
@@ -284,7 +286,8 @@ func activateShhService(stack *node.Node, config *params.NodeConfig, db *leveldb
 
 		// enable mail service
 		if config.WhisperConfig.EnableMailServer {
-			if err := registerMailServer(whisperService, &config.WhisperConfig); err != nil {
+			mailServer = &mailserver.WMailServer{}
+			if err := registerMailServer(whisperService, mailServer, &config.WhisperConfig); err != nil {
 				return nil, fmt.Errorf("failed to register MailServer: %v", err)
 			}
 		}
@@ -302,6 +305,15 @@ func activateShhService(stack *node.Node, config *params.NodeConfig, db *leveldb
 		return
 	}
 
+	if config.WhisperConfig.EnableMailServer {
+		err = stack.Register(func(*node.ServiceContext) (node.Service, error) {
+			return mailserver.NewAdminService(mailServer), nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
 	// TODO(dshulyak) add a config option to enable it by default, but disable if app is started from statusd
 	return stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
 		var whisper *whisper.Whisper
@@ -314,6 +326,10 @@ func activateShhService(stack *node.Node, config *params.NodeConfig, db *leveldb
 			InstallationID: config.InstallationID,
 			Debug:          config.DebugAPIEnabled,
 			PFSEnabled:     config.PFSEnabled,
+			MailServers:    parseNodes(config.ClusterConfig.TrustedMailServers),
+
+			BundleRotationPeriod: time.Duration(config.PFSBundleRotationPeriod) * time.Second,
+			BundleGracePeriod:    time.Duration(config.PFSBundleGracePeriod) * time.Second,
 		}
 
 		svc := shhext.New(whisper, shhext.EnvelopeSignalHandler{}, db, config)
@@ -13,6 +13,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/les"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
@@ -22,8 +23,10 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/syndtr/goleveldb/leveldb"
 
+	"github.com/status-im/status-go/contracts"
 	"github.com/status-im/status-go/db"
 	"github.com/status-im/status-go/discovery"
+	"github.com/status-im/status-go/mailserver/registry"
 	"github.com/status-im/status-go/params"
 	"github.com/status-im/status-go/peers"
 	"github.com/status-im/status-go/rpc"
@@ -111,6 +114,10 @@ func (n *StatusNode) startWithDB(config *params.NodeConfig, db *leveldb.DB, serv
 		return err
 	}
 
+	if err := n.setupMailServerVerifier(); err != nil {
+		return err
+	}
+
 	if n.discoveryEnabled() {
 		return n.startDiscovery()
 	}
@@ -165,6 +172,30 @@ func (n *StatusNode) start(services []node.ServiceConstructor) error {
 	return n.gethNode.Start()
 }
 
+// setupMailServerVerifier makes shhext verify discovered MailServers
+// with the on-chain registry if it is configured.
+func (n *StatusNode) setupMailServerVerifier() error {
+	if n.config.MailServerRegistryAddress == "" {
+		return nil
+	}
+
+	var service *shhext.Service
+	if err := n.gethService(&service); err == node.ErrServiceUnknown {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	caller := contracts.NewContractCaller(n.rpcClient)
+	address := common.HexToAddress(n.config.MailServerRegistryAddress)
+	verifier, err := registry.NewVerifier(caller, address)
+	if err != nil {
+		return err
+	}
+	service.SetMailServerVerifier(verifier)
+	return nil
+}
+
 func (n *StatusNode) setupRPCClient() (err error) {
 	// setup public RPC client
 	gethNodeClient, err := n.gethNode.AttachPublic()
@@ -1,6 +1,7 @@
 package params
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -61,12 +62,112 @@ type WhisperConfig struct {
 	// MailServerAsymKey is an hex-encoded asymmetric key to decrypt messages sent to MailServer.
 	MailServerAsymKey string
 
-	// RateLimit minimum time between queries to mail server per peer
+	// RateLimit minimum time between queries to mail server per peer.
+	// Peers earn a new query every MailServerRateLimit seconds and can save up
+	// to MailServerRateLimitBurst queries.
 	MailServerRateLimit int
 
+	// MailServerRateLimitBurst is the max number of queries a peer can make at once.
+	// It is used only if MailServerRateLimit is set. Default is 5.
+	MailServerRateLimitBurst int
+
+	// MailServerBytesLimit is the max number of bytes per second sent to a single peer
+	// on average. Zero means no limit.
+	MailServerBytesLimit int
+
+	// MailServerBytesLimitBurst is the max number of bytes sent to a single peer at once.
+	// It is used only if MailServerBytesLimit is set. Default is 60 seconds worth of MailServerBytesLimit.
+	MailServerBytesLimitBurst int
+
+	// MailServerGlobalRateLimit is the max number of queries per second from all peers.
+	// Zero means no limit.
+	MailServerGlobalRateLimit int
+
+	// MailServerRateLimitWhitelist is a list of hex-encoded node IDs of trusted peers
+	// which are not rate limited.
+	MailServerRateLimitWhitelist []string
+
+	// MailServerVerifyPeerSignature requires requests to be signed with the key of the peer
+	// which sent them, so that requests can't be spoofed or replayed by other peers.
+	MailServerVerifyPeerSignature bool
+
+	// MailServerAllowedClients is a list of hex-encoded public keys of clients
+	// allowed to request envelopes. If empty, all clients are allowed.
+	MailServerAllowedClients []string
+
+	// MailServerRequestMaxSkew is the max difference in seconds between the time a request
+	// was sent, as signed in its payload, and the time it is received by MailServer.
+	// Older requests, requests without the signed time and requests which were already
+	// processed are rejected in order to prevent replays.
+	// Zero disables the replay protection.
+	MailServerRequestMaxSkew int
+
 	// MailServerCleanupPeriod time in seconds to wait to run mail server cleanup
 	MailServerCleanupPeriod int
 
+	// MailServerDataRetention is the number of days envelopes are kept by MailServer.
+	// Older envelopes are removed every MailServerCleanupPeriod. Zero means envelopes are kept forever.
+	MailServerDataRetention int
+
+	// MailServerMaxStorageSize is the max size in bytes of envelopes archived by MailServer.
+	// The oldest envelopes are removed every MailServerCleanupPeriod to keep the storage
+	// below this size, up to a week of envelopes at once. Zero means no limit.
+	MailServerMaxStorageSize int64
+
+	// MailServerMaxQueryRange is the max time range in seconds which can be requested
+	// from MailServer at once. Big time ranges are delivered in pages.
+	// Default is 24 hours.
+	MailServerMaxQueryRange int
+
+	// MailServerStorage is a storage backend used by MailServer to archive envelopes.
+	// It can be "leveldb" (default) or "sql".
+	MailServerStorage string
+
+	// MailServerStorageDriver is a database/sql driver name used by the "sql" storage.
+	// Default is "sqlite3".
+	MailServerStorageDriver string
+
+	// MailServerStorageDataSource is a data source name used by the "sql" storage.
+	// Default is a sqlite database file in DataDir.
+	MailServerStorageDataSource string
+
+	// MailServerSyncPeers is a list of enode URLs of other MailServers.
+	// MailServer periodically requests envelopes it missed from these peers
+	// and serves sync requests only from them. Peers must be connected, e.g. as static nodes.
+	MailServerSyncPeers []string
+
+	// MailServerSyncPeriod is the time in seconds between syncs with MailServerSyncPeers.
+	// Default is 1 hour.
+	MailServerSyncPeriod int
+
+	// MailServerSyncRange is the time range in seconds synced with each of MailServerSyncPeers
+	// for the first time after MailServer starts. Default is 24 hours.
+	MailServerSyncRange int
+
+	// MailServerArchiveTopics is a list of hex-encoded topics archived by MailServer.
+	// If it is empty, envelopes with all topics are archived.
+	// It can be changed at runtime with the mailserver_setArchiveTopics API.
+	MailServerArchiveTopics []string
+
+	// MailServerExcludeTopics is a list of hex-encoded topics which are never archived by MailServer.
+	// It takes precedence over MailServerArchiveTopics.
+	MailServerExcludeTopics []string
+
+	// MailServerArchivePoW is the minimum PoW of envelopes archived by MailServer.
+	// It can be higher than MinimumPoW in order to keep cheap envelopes out of the storage.
+	// Zero means all envelopes accepted by whisper are archived.
+	MailServerArchivePoW float64
+
+	// MailServerTopicDailyQuota is the max number of bytes of envelopes with a single topic
+	// sent during a UTC day that are archived by MailServer. Envelopes over the quota are not archived.
+	// Zero means there is no quota.
+	MailServerTopicDailyQuota int64
+
+	// MailServerDisableQueryCache disables caching results of recent queries in memory.
+	// The cache helps when many peers request the same envelopes at once,
+	// e.g. when a popular public chat is opened.
+	MailServerDisableQueryCache bool
+
 	// TTL time to live for messages, in seconds
 	TTL int
 
@@ -171,6 +272,14 @@ type NodeConfig struct {
 	BackupDisabledDataDir string `validate:"required"`
 	PFSEnabled            bool
 
+	// PFSBundleRotationPeriod is the time in seconds after which the signed prekey of the PFS bundle is rotated.
+	// Default is 14 days.
+	PFSBundleRotationPeriod int
+
+	// PFSBundleGracePeriod is the time in seconds expired PFS bundles are kept after the rotation,
+	// so that delayed messages can be decrypted. Default is 7 days.
+	PFSBundleGracePeriod int
+
 	// KeyStoreDir is the file system folder that contains private keys.
 	KeyStoreDir string `validate:"required"`
 
@@ -508,6 +617,14 @@ func (c *NodeConfig) Validate() error {
 		return fmt.Errorf("PFSEnabled is true, but InstallationID is empty")
 	}
 
+	if c.PFSBundleRotationPeriod < 0 {
+		return fmt.Errorf("PFSBundleRotationPeriod must not be negative")
+	}
+
+	if c.PFSBundleGracePeriod < 0 {
+		return fmt.Errorf("PFSBundleGracePeriod must not be negative")
+	}
+
 	if len(c.ClusterConfig.RendezvousNodes) == 0 {
 		if c.Rendezvous {
 			return fmt.Errorf("Rendezvous is enabled, but ClusterConfig.RendezvousNodes is empty")
@@ -610,11 +727,88 @@ func (c *WhisperConfig) Validate(validate *validator.Validate) error {
 				return fmt.Errorf("WhisperConfig.MailServerAsymKey is invalid: %s", c.MailServerAsymKey)
 			}
 		}
+
+		for _, id := range c.MailServerRateLimitWhitelist {
+			if _, err := discv5.HexID(id); err != nil {
+				return fmt.Errorf("WhisperConfig.MailServerRateLimitWhitelist contains invalid node ID %s: %v", id, err)
+			}
+		}
+
+		for _, key := range c.MailServerAllowedClients {
+			if _, err := crypto.UnmarshalPubkey(common.FromHex(key)); err != nil {
+				return fmt.Errorf("WhisperConfig.MailServerAllowedClients contains invalid public key %s: %v", key, err)
+			}
+		}
+
+		if c.MailServerRequestMaxSkew < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerRequestMaxSkew must not be negative")
+		}
+
+		if c.MailServerDataRetention < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerDataRetention must not be negative")
+		}
+
+		if c.MailServerMaxStorageSize < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerMaxStorageSize must not be negative")
+		}
+
+		if c.MailServerMaxQueryRange < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerMaxQueryRange must not be negative")
+		}
+
+		for _, enode := range c.MailServerSyncPeers {
+			if _, err := discv5.ParseNode(enode); err != nil {
+				return fmt.Errorf("WhisperConfig.MailServerSyncPeers contains invalid enode %s: %v", enode, err)
+			}
+		}
+
+		if c.MailServerSyncPeriod < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerSyncPeriod must not be negative")
+		}
+
+		if c.MailServerSyncRange < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerSyncRange must not be negative")
+		}
+
+		if c.MailServerArchivePoW < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerArchivePoW must not be negative")
+		}
+
+		if c.MailServerTopicDailyQuota < 0 {
+			return fmt.Errorf("WhisperConfig.MailServerTopicDailyQuota must not be negative")
+		}
+
+		for _, topic := range c.MailServerArchiveTopics {
+			if !isValidTopic(topic) {
+				return fmt.Errorf("WhisperConfig.MailServerArchiveTopics contains invalid topic %s", topic)
+			}
+		}
+
+		for _, topic := range c.MailServerExcludeTopics {
+			if !isValidTopic(topic) {
+				return fmt.Errorf("WhisperConfig.MailServerExcludeTopics contains invalid topic %s", topic)
+			}
+		}
+
+		switch c.MailServerStorage {
+		case "", MailServerStorageLevelDB, MailServerStorageSQL:
+		default:
+			return fmt.Errorf("WhisperConfig.MailServerStorage is invalid: %s", c.MailServerStorage)
+		}
 	}
 
 	return nil
 }
 
+// whisperTopicLength is the length of a whisper topic in bytes.
+const whisperTopicLength = 4
+
+// isValidTopic returns true if topic is a hex-encoded whisper topic.
+func isValidTopic(topic string) bool {
+	b, err := hex.DecodeString(strings.TrimPrefix(topic, "0x"))
+	return err == nil && len(b) == whisperTopicLength
+}
+
 // Validate validates the SwarmConfig struct and returns an error if inconsistent values are found
 func (c *SwarmConfig) Validate(validate *validator.Validate) error {
 	if !c.Enabled {
@@ -351,6 +351,222 @@ func TestNodeConfigValidate(t *testing.T) {
 			}`,
 			Error: "WhisperConfig.MailServerAsymKey is invalid",
 		},
+		{
+			Name: "Validate that WhisperConfig.MailServerStorage is checked for validity",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerStorage": "bar"
+				}
+			}`,
+			Error: "WhisperConfig.MailServerStorage is invalid",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerMaxQueryRange is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerMaxQueryRange": -1
+				}
+			}`,
+			Error: "WhisperConfig.MailServerMaxQueryRange must not be negative",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerSyncPeers contains enodes",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerSyncPeers": ["enode://foo"]
+				}
+			}`,
+			Error: "WhisperConfig.MailServerSyncPeers contains invalid enode enode://foo",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerSyncPeriod is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerSyncPeriod": -1
+				}
+			}`,
+			Error: "WhisperConfig.MailServerSyncPeriod must not be negative",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerRequestMaxSkew is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerRequestMaxSkew": -1
+				}
+			}`,
+			Error: "WhisperConfig.MailServerRequestMaxSkew must not be negative",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerArchivePoW is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerArchivePoW": -0.5
+				}
+			}`,
+			Error: "WhisperConfig.MailServerArchivePoW must not be negative",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerTopicDailyQuota is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerTopicDailyQuota": -1
+				}
+			}`,
+			Error: "WhisperConfig.MailServerTopicDailyQuota must not be negative",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerArchiveTopics contains topics",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerArchiveTopics": ["0x01020304", "0x0102"]
+				}
+			}`,
+			Error: "WhisperConfig.MailServerArchiveTopics contains invalid topic 0x0102",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerExcludeTopics contains topics",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerExcludeTopics": ["foo"]
+				}
+			}`,
+			Error: "WhisperConfig.MailServerExcludeTopics contains invalid topic foo",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerDataRetention is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerDataRetention": -30
+				}
+			}`,
+			Error: "WhisperConfig.MailServerDataRetention must not be negative",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerRateLimitWhitelist contains node IDs",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerRateLimitWhitelist": ["0x1234"]
+				}
+			}`,
+			Error: "WhisperConfig.MailServerRateLimitWhitelist contains invalid node ID",
+		},
+		{
+			Name: "Validate that WhisperConfig.MailServerAllowedClients contains public keys",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true,
+				"WhisperConfig": {
+					"Enabled": true,
+					"EnableMailServer": true,
+					"DataDir": "/foo",
+					"MailServerPassword": "foo",
+					"MailServerAllowedClients": ["0x1234"]
+				}
+			}`,
+			Error: "WhisperConfig.MailServerAllowedClients contains invalid public key",
+		},
 		{
 			Name: "Validate that PFSEnabled & InstallationID are checked for validity",
 			Config: `{
@@ -367,6 +583,34 @@ func TestNodeConfigValidate(t *testing.T) {
 			}`,
 			Error: "PFSEnabled is true, but InstallationID is empty",
 		},
+		{
+			Name: "Validate that PFSBundleRotationPeriod is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"PFSEnabled": true,
+				"InstallationID": "1",
+				"PFSBundleRotationPeriod": -1,
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true
+			}`,
+			Error: "PFSBundleRotationPeriod must not be negative",
+		},
+		{
+			Name: "Validate that PFSBundleGracePeriod is not negative",
+			Config: `{
+				"NetworkId": 1,
+				"DataDir": "/some/dir",
+				"PFSEnabled": true,
+				"InstallationID": "1",
+				"PFSBundleGracePeriod": -1,
+				"BackupDisabledDataDir": "/some/dir",
+				"KeyStoreDir": "/some/dir",
+				"NoDiscovery": true
+			}`,
+			Error: "PFSBundleGracePeriod must not be negative",
+		},
 	}
 
 	for _, tc := range testCases {
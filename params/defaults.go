@@ -59,6 +59,12 @@ const (
 
 	// LESDiscoveryIdentifier is a prefix for topic used for LES peers discovery.
 	LESDiscoveryIdentifier = "LES2@"
+
+	// MailServerStorageLevelDB is a MailServer storage which keeps envelopes in leveldb.
+	MailServerStorageLevelDB = "leveldb"
+
+	// MailServerStorageSQL is a MailServer storage which keeps envelopes in an SQL database.
+	MailServerStorageSQL = "sql"
 )
 
 var (
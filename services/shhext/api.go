@@ -3,7 +3,7 @@ package shhext
 import (
 	"context"
 	"crypto/ecdsa"
-	"encoding/binary"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -14,7 +14,9 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
 	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/mailserver/protocol"
 	"github.com/status-im/status-go/services/shhext/chat"
 )
 
@@ -23,6 +25,8 @@ const (
 	defaultWorkTime = 5
 	// defaultRequestTimeout is the default request timeout in seconds
 	defaultRequestTimeout = 10
+	// requestNonceSize is the size of a random nonce in requests sent to MailServer nodes.
+	requestNonceSize = 8
 )
 
 var (
@@ -36,6 +40,17 @@ var (
 	// ErrPFSNotEnabled is returned when an endpoint PFS only is called but
 	// PFS is disabled
 	ErrPFSNotEnabled = errors.New("pfs not enabled")
+	// ErrNoTopics is returned when a batch request does not contain any topics.
+	ErrNoTopics = errors.New("no topics")
+	// ErrNoMailServers is returned when MailServerPeer is not set
+	// and there are no known MailServers to select.
+	ErrNoMailServers = errors.New("no mailservers")
+	// ErrSyncStateNotAvailable is returned when the sync state is not persisted.
+	ErrSyncStateNotAvailable = errors.New("sync state is not available")
+	// ErrNoMailServerConnected is returned when MailServerPeer is not set
+	// and none of known MailServers is connected. Connections are initiated,
+	// so the request can be retried later.
+	ErrNoMailServerConnected = errors.New("no mailserver connected")
 )
 
 // -----
@@ -44,7 +59,9 @@ var (
 
 // MessagesRequest is a payload send to a MailServer to get messages.
 type MessagesRequest struct {
-	// MailServerPeer is MailServer's enode address.
+	// MailServerPeer is MailServer's enode address (optional).
+	// If not set, the best known MailServer is selected and the request
+	// is sent again to the best one if it expires.
 	MailServerPeer string `json:"mailServerPeer"`
 
 	// From is a lower bound of time range (optional).
@@ -65,6 +82,17 @@ type MessagesRequest struct {
 	// Topic is a regular Whisper topic.
 	Topic whisper.TopicType `json:"topic"`
 
+	// Topics is a list of Whisper topics (optional).
+	// If set, only envelopes with exactly these topics are returned
+	// instead of all envelopes matching a bloom filter. Topic is ignored in such a case.
+	// It requires a MailServer supporting RLP-encoded requests.
+	Topics []whisper.TopicType `json:"topics"`
+
+	// Batch asks MailServer to deliver envelopes in compressed batches
+	// instead of one by one (optional).
+	// It requires a MailServer supporting RLP-encoded requests.
+	Batch bool `json:"batch"`
+
 	// SymKeyID is an ID of a symmetric key to authenticate to MailServer.
 	// It's derived from MailServer password.
 	//
@@ -72,6 +100,11 @@ type MessagesRequest struct {
 	// public key.
 	SymKeyID string `json:"symKeyID"`
 
+	// Priority determines the order in which queued requests to the same
+	// MailServer are sent (optional). Requests with higher priority are sent first,
+	// e.g. 1 for the active chat and 0 (default) for background chats.
+	Priority int `json:"priority"`
+
 	// Timeout is the time to live of the request specified in seconds.
 	// Default is 10 seconds
 	Timeout time.Duration `json:"timeout"`
@@ -97,6 +130,94 @@ func (r *MessagesRequest) setDefaults(now time.Time) {
 	}
 }
 
+// TimeWindow is a time range between two timestamps (both inclusive).
+type TimeWindow struct {
+	// From is a lower bound of the time window.
+	From uint32 `json:"from"`
+
+	// To is an upper bound of the time window.
+	To uint32 `json:"to"`
+}
+
+// MessagesBatchRequest is a payload send to a MailServer to get messages
+// for many topics and many time windows in a single request.
+type MessagesBatchRequest struct {
+	// MailServerPeer is MailServer's enode address (optional).
+	// If not set, the best known MailServer is selected and the request
+	// is sent again to the best one if it expires.
+	MailServerPeer string `json:"mailServerPeer"`
+
+	// Windows is a list of time windows (optional).
+	// Default is a single window from 24 hours back to now.
+	Windows []TimeWindow `json:"windows"`
+
+	// Topics is a list of Whisper topics.
+	Topics []whisper.TopicType `json:"topics"`
+
+	// Limit determines the number of messages sent by the mail server
+	// for the current paginated request. The limit is shared by all windows.
+	Limit uint32 `json:"limit"`
+
+	// Cursor is used as starting point for paginated requests.
+	// It is shared by all windows.
+	Cursor string `json:"cursor"`
+
+	// SymKeyID is an ID of a symmetric key to authenticate to MailServer.
+	// It's derived from MailServer password.
+	//
+	// It's also possible to authenticate request with MailServerPeer
+	// public key.
+	SymKeyID string `json:"symKeyID"`
+
+	// Priority determines the order in which queued requests to the same
+	// MailServer are sent (optional). Requests with higher priority are sent first,
+	// e.g. 1 for the active chat and 0 (default) for background chats.
+	Priority int `json:"priority"`
+
+	// Timeout is the time to live of the request specified in seconds.
+	// Default is 10 seconds
+	Timeout time.Duration `json:"timeout"`
+}
+
+func (r *MessagesBatchRequest) setDefaults(now time.Time) {
+	if len(r.Windows) == 0 {
+		defaults := MessagesRequest{}
+		defaults.setDefaults(now)
+		r.Windows = []TimeWindow{{From: defaults.From, To: defaults.To}}
+	}
+
+	if r.Timeout == 0 {
+		r.Timeout = defaultRequestTimeout
+	}
+}
+
+// SyncMessagesRequest is a payload used to get messages sent since they
+// were last synced from a MailServer.
+type SyncMessagesRequest struct {
+	// MailServerPeer is MailServer's enode address (optional).
+	// If not set, the best known MailServer is selected.
+	MailServerPeer string `json:"mailServerPeer"`
+
+	// Topics is a list of Whisper topics.
+	Topics []whisper.TopicType `json:"topics"`
+
+	// SymKeyID is an ID of a symmetric key to authenticate to MailServer.
+	// It's derived from MailServer password.
+	//
+	// It's also possible to authenticate request with MailServerPeer
+	// public key.
+	SymKeyID string `json:"symKeyID"`
+
+	// Priority determines the order in which queued requests to the same
+	// MailServer are sent (optional). Requests with higher priority are sent first,
+	// e.g. 1 for the active chat and 0 (default) for background chats.
+	Priority int `json:"priority"`
+
+	// Timeout is the time to live of the request specified in seconds.
+	// Default is 10 seconds
+	Timeout time.Duration `json:"timeout"`
+}
+
 // -----
 // PUBLIC API
 // -----
@@ -131,7 +252,13 @@ func (api *PublicAPI) Post(ctx context.Context, req whisper.NewMessage) (hash he
 // RequestMessages sends a request for historic messages to a MailServer.
 func (api *PublicAPI) RequestMessages(_ context.Context, r MessagesRequest) (hexutil.Bytes, error) {
 	api.log.Info("RequestMessages", "request", r)
-	shh := api.service.w
+	return api.requestMessages(r, false)
+}
+
+// requestMessages sends a request for historic messages. If resync is set,
+// the requested time range updates the sync state of the topics even if
+// there is a gap since their last synced time.
+func (api *PublicAPI) requestMessages(r MessagesRequest, resync bool) (hexutil.Bytes, error) {
 	now := api.service.w.GetCurrentTime()
 	r.setDefaults(now)
 
@@ -139,11 +266,148 @@ func (api *PublicAPI) RequestMessages(_ context.Context, r MessagesRequest) (hex
 		return nil, fmt.Errorf("Query range is invalid: from > to (%d > %d)", r.From, r.To)
 	}
 
-	var err error
+	next := func(cursor []byte) (common.Hash, error) {
+		if cursor != nil {
+			r.Cursor = hex.EncodeToString(cursor)
+		}
+
+		payload, err := makeMessagesRequestPayload(r, api.service.w.GetCurrentTime())
+		if err != nil {
+			return common.Hash{}, err
+		}
+
+		return api.sendMessagesRequest(r.MailServerPeer, r.SymKeyID, payload, api.service.w.GetCurrentTime())
+	}
+
+	topics := r.Topics
+	if len(topics) == 0 {
+		topics = []whisper.TopicType{r.Topic}
+	}
+	return api.requestPages(r.MailServerPeer, &pagedRequest{
+		timeout: r.Timeout * time.Second,
+		next:    next,
+		sync:    &syncRange{topics: topics, from: r.From, to: r.To, resync: resync},
+	}, r.Priority)
+}
+
+// RequestMessagesBatch sends a single request for historic messages
+// with many topics and many time windows to a MailServer.
+// The returned request ID is tracked like in RequestMessages.
+func (api *PublicAPI) RequestMessagesBatch(_ context.Context, r MessagesBatchRequest) (hexutil.Bytes, error) {
+	api.log.Info("RequestMessagesBatch", "request", r)
+	now := api.service.w.GetCurrentTime()
+	r.setDefaults(now)
+
+	if len(r.Topics) == 0 {
+		return nil, ErrNoTopics
+	}
+
+	for _, w := range r.Windows {
+		if w.From > w.To {
+			return nil, fmt.Errorf("Query range is invalid: from > to (%d > %d)", w.From, w.To)
+		}
+	}
+
+	next := func(cursor []byte) (common.Hash, error) {
+		if cursor != nil {
+			r.Cursor = hex.EncodeToString(cursor)
+		}
+
+		payload, err := makeMessagesBatchRequestPayload(r, api.service.w.GetCurrentTime())
+		if err != nil {
+			return common.Hash{}, err
+		}
+
+		return api.sendMessagesRequest(r.MailServerPeer, r.SymKeyID, payload, api.service.w.GetCurrentTime())
+	}
+
+	p := &pagedRequest{
+		timeout: r.Timeout * time.Second,
+		next:    next,
+	}
+	// only a single time range can be recorded as synced
+	if len(r.Windows) == 1 {
+		p.sync = &syncRange{topics: r.Topics, from: r.Windows[0].From, to: r.Windows[0].To}
+	}
+	return api.requestPages(r.MailServerPeer, p, r.Priority)
+}
 
-	mailServerNode, err := discover.ParseNode(r.MailServerPeer)
+// SyncMessages requests envelopes with the topics sent since they were last synced
+// from the MailServer. Topics with the same last synced time are requested together,
+// long time ranges in many requests, and IDs of all sent requests are returned.
+// Topics synced up to now are not requested.
+// The MailServer is selected once, so that the sync state is kept per MailServer.
+func (api *PublicAPI) SyncMessages(_ context.Context, r SyncMessagesRequest) ([]hexutil.Bytes, error) {
+	api.log.Info("SyncMessages", "request", r)
+	if api.service.syncState == nil {
+		return nil, ErrSyncStateNotAvailable
+	}
+	if len(r.Topics) == 0 {
+		return nil, ErrNoTopics
+	}
+
+	mailServerNode, err := api.mailServerNode(r.MailServerPeer)
 	if err != nil {
-		return nil, fmt.Errorf("%v: %v", ErrInvalidMailServerPeer, err)
+		return nil, err
+	}
+
+	now := uint32(api.service.w.GetCurrentTime().UTC().Unix())
+	ranges, err := api.service.syncState.Ranges(mailServerNode.ID, r.Topics, now)
+	if err != nil {
+		return nil, err
+	}
+
+	requestIDs := make([]hexutil.Bytes, 0, len(ranges))
+	for _, sr := range ranges {
+		requestID, err := api.requestMessages(MessagesRequest{
+			MailServerPeer: mailServerNode.String(),
+			From:           sr.from,
+			To:             sr.to,
+			Topics:         sr.topics,
+			Batch:          true,
+			SymKeyID:       r.SymKeyID,
+			Priority:       r.Priority,
+			Timeout:        r.Timeout,
+		}, sr.resync)
+		if err != nil {
+			return nil, err
+		}
+		requestIDs = append(requestIDs, requestID)
+	}
+	return requestIDs, nil
+}
+
+// requestPages schedules the first request, which is sent immediately or queued
+// if the MailServer processes other requests. Next pages are requested
+// by the tracker as long as MailServer returns a cursor.
+func (api *PublicAPI) requestPages(mailServerPeer string, p *pagedRequest, priority int) (hexutil.Bytes, error) {
+	// requests to automatically selected MailServers share a single queue
+	var key string
+	if mailServerPeer != "" {
+		n, err := discover.ParseNode(mailServerPeer)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrInvalidMailServerPeer, err)
+		}
+		key = n.ID.String()
+	}
+
+	requestID, err := api.service.scheduler.Schedule(key, p, priority)
+	if err != nil {
+		return nil, err
+	}
+	return requestID[:], nil
+}
+
+// sendMessagesRequest sends a payload to a MailServer in an envelope
+// encrypted with a symmetric key or MailServer's public key.
+// If mailServerPeer is empty, the best known MailServer is selected.
+func (api *PublicAPI) sendMessagesRequest(mailServerPeer, symKeyID string, payload []byte, now time.Time) (common.Hash, error) {
+	var hash common.Hash
+	shh := api.service.w
+
+	mailServerNode, err := api.mailServerNode(mailServerPeer)
+	if err != nil {
+		return hash, err
 	}
 
 	var (
@@ -151,20 +415,20 @@ func (api *PublicAPI) RequestMessages(_ context.Context, r MessagesRequest) (hex
 		publicKey *ecdsa.PublicKey
 	)
 
-	if r.SymKeyID != "" {
-		symKey, err = shh.GetSymKey(r.SymKeyID)
+	if symKeyID != "" {
+		symKey, err = shh.GetSymKey(symKeyID)
 		if err != nil {
-			return nil, fmt.Errorf("%v: %v", ErrInvalidSymKeyID, err)
+			return hash, fmt.Errorf("%v: %v", ErrInvalidSymKeyID, err)
 		}
 	} else {
 		publicKey, err = mailServerNode.ID.Pubkey()
 		if err != nil {
-			return nil, fmt.Errorf("%v: %v", ErrInvalidPublicKey, err)
+			return hash, fmt.Errorf("%v: %v", ErrInvalidPublicKey, err)
 		}
 	}
 
 	envelope, err := makeEnvelop(
-		makePayload(r),
+		payload,
 		symKey,
 		publicKey,
 		api.service.nodeID,
@@ -172,17 +436,29 @@ func (api *PublicAPI) RequestMessages(_ context.Context, r MessagesRequest) (hex
 		now,
 	)
 	if err != nil {
-		return nil, err
+		return hash, err
 	}
 
-	hash := envelope.Hash()
+	hash = envelope.Hash()
+	api.service.mailServers.RequestSent(hash, mailServerNode.ID)
 	if err := shh.RequestHistoricMessages(mailServerNode.ID[:], envelope); err != nil {
-		return nil, err
+		api.service.mailServers.RequestFinished(hash, false)
+		return hash, err
 	}
+	return hash, nil
+}
 
-	api.service.tracker.AddRequest(hash, time.After(r.Timeout*time.Second))
-
-	return hash[:], nil
+// mailServerNode parses MailServer's enode address or selects
+// the best known MailServer if the address is empty.
+func (api *PublicAPI) mailServerNode(mailServerPeer string) (*discover.Node, error) {
+	if mailServerPeer == "" {
+		return api.service.mailServers.Select()
+	}
+	n, err := discover.ParseNode(mailServerPeer)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidMailServerPeer, err)
+	}
+	return n, nil
 }
 
 // GetNewFilterMessages is a prototype method with deduplication
@@ -196,12 +472,19 @@ func (api *PublicAPI) GetNewFilterMessages(filterID string) ([]*whisper.Message,
 
 	if api.service.pfsEnabled {
 		// Attempt to decrypt message, otherwise leave unchanged
+		messages := make([]*whisper.Message, 0, len(dedupMessages))
 		for _, msg := range dedupMessages {
 
 			if err := api.processPFSMessage(msg); err != nil {
 				return nil, err
 			}
+
+			// Pairing messages are processed by the protocol and have no payload
+			if msg.Payload != nil {
+				messages = append(messages, msg)
+			}
 		}
+		dedupMessages = messages
 	}
 
 	return dedupMessages, nil
@@ -331,9 +614,101 @@ func (api *PublicAPI) SendGroupMessage(ctx context.Context, msg chat.SendGroupMe
 	return response, nil
 }
 
+// SendPairingMessage sends signed metadata of our installation to our other installations,
+// so that they can show the device name
+func (api *PublicAPI) SendPairingMessage(ctx context.Context, msg chat.SendPairingMessageRPC) (hexutil.Bytes, error) {
+	if !api.service.pfsEnabled {
+		return nil, ErrPFSNotEnabled
+	}
+
+	privateKey, err := api.service.w.GetPrivateKey(msg.Sig)
+	if err != nil {
+		return nil, err
+	}
+
+	// This is transport layer-agnostic
+	protocolMessage, err := api.service.protocol.BuildPairingMessage(privateKey, msg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The message is sent to our own public key
+	directMessage := chat.SendDirectMessageRPC{
+		PubKey: crypto.FromECDSAPub(&privateKey.PublicKey),
+		Sig:    msg.Sig,
+	}
+
+	// Enrich with transport layer info
+	whisperMessage := chat.DirectMessageToWhisper(&directMessage, protocolMessage)
+
+	// And dispatch
+	return api.Post(ctx, *whisperMessage)
+}
+
+// GetOurInstallations returns other installations of the identity of the given key
+func (api *PublicAPI) GetOurInstallations(sig string) ([]*chat.Installation, error) {
+	if !api.service.pfsEnabled {
+		return nil, ErrPFSNotEnabled
+	}
+
+	privateKey, err := api.service.w.GetPrivateKey(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.service.protocol.GetOurInstallations(privateKey)
+}
+
+// EnableInstallation enables sending messages to another installation of the identity of the given key
+func (api *PublicAPI) EnableInstallation(sig string, installationID string) error {
+	if !api.service.pfsEnabled {
+		return ErrPFSNotEnabled
+	}
+
+	privateKey, err := api.service.w.GetPrivateKey(sig)
+	if err != nil {
+		return err
+	}
+
+	return api.service.protocol.EnableInstallation(privateKey, installationID)
+}
+
+// DisableInstallation stops sending messages to another installation of the identity of the given key,
+// for instance if the device was lost
+func (api *PublicAPI) DisableInstallation(sig string, installationID string) error {
+	if !api.service.pfsEnabled {
+		return ErrPFSNotEnabled
+	}
+
+	privateKey, err := api.service.w.GetPrivateKey(sig)
+	if err != nil {
+		return err
+	}
+
+	return api.service.protocol.DisableInstallation(privateKey, installationID)
+}
+
+// RotateBundle replaces the signed prekey of the bundle of the identity of the given key with a new one,
+// for instance if it could be compromised. The previous signed prekey is kept for the grace period,
+// so that messages sent with it can still be decrypted
+func (api *PublicAPI) RotateBundle(sig string) error {
+	if !api.service.pfsEnabled {
+		return ErrPFSNotEnabled
+	}
+
+	privateKey, err := api.service.w.GetPrivateKey(sig)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.service.protocol.RotateBundle(privateKey)
+	return err
+}
+
 func (api *PublicAPI) processPFSMessage(msg *whisper.Message) error {
 	var privateKey *ecdsa.PrivateKey
 	var publicKey *ecdsa.PublicKey
+	var keyID string
 
 	// Msg.Dst is empty is a public message, nothing to do
 	if msg.Dst != nil {
@@ -342,8 +717,9 @@ func (api *PublicAPI) processPFSMessage(msg *whisper.Message) error {
 		if err != nil {
 			return err
 		}
+		keyID = string(keyBytes)
 
-		privateKey, err = api.service.w.GetPrivateKey(string(keyBytes))
+		privateKey, err = api.service.w.GetPrivateKey(keyID)
 		if err != nil {
 			return err
 		}
@@ -357,16 +733,24 @@ func (api *PublicAPI) processPFSMessage(msg *whisper.Message) error {
 
 	payload, err := api.service.protocol.HandleMessage(privateKey, publicKey, msg.Payload)
 
-	if err != nil {
+	// Messages received twice are dropped, the session is not broken
+	if err == chat.ErrMessageConsumed {
+		api.log.Debug("Message was already received", "err", err)
+	} else if err != nil {
 		api.log.Error("Failed handling message with error", "err", err)
 	}
 
-	// Notify that someone tried to contact us using an invalid bundle
-	if err == chat.ErrSessionNotFound {
-		api.log.Warn("Session not found, sending signal", "err", err)
+	// Notify that someone tried to contact us using an invalid bundle or a broken session,
+	// and ask them to establish a new session
+	if err == chat.ErrSessionNotFound || err == chat.ErrDecryptionFailed {
+		api.log.Warn("Session not found or broken, sending signal", "err", err)
 		keyString := fmt.Sprintf("0x%x", crypto.FromECDSAPub(publicKey))
 		handler := EnvelopeSignalHandler{}
 		handler.DecryptMessageFailed(keyString)
+
+		if err := api.sendSessionReset(privateKey, keyID, publicKey, msg.Payload); err != nil && err != chat.ErrSessionResetRateLimited {
+			api.log.Warn("Failed to send session reset", "err", err)
+		}
 	}
 
 	// Ignore errors for now
@@ -377,6 +761,29 @@ func (api *PublicAPI) processPFSMessage(msg *whisper.Message) error {
 
 }
 
+// sendSessionReset sends our bundle to the sender of a message which could not be decrypted,
+// so that they discard the session with our installation and establish a new one
+func (api *PublicAPI) sendSessionReset(privateKey *ecdsa.PrivateKey, sig string, theirPublicKey *ecdsa.PublicKey, payload []byte) error {
+	protocolMessage, err := api.service.protocol.BuildSessionResetMessage(privateKey, theirPublicKey)
+	if err != nil {
+		return err
+	}
+
+	directMessage := chat.SendDirectMessageRPC{
+		PubKey: crypto.FromECDSAPub(theirPublicKey),
+		Sig:    sig,
+	}
+
+	whisperMessage := chat.DirectMessageToWhisper(&directMessage, protocolMessage)
+	if _, err = api.Post(context.Background(), *whisperMessage); err != nil {
+		api.service.protocol.SessionResetFailed(theirPublicKey)
+		return err
+	}
+
+	// our broken session is discarded only once they are asked to establish a new one
+	return api.service.protocol.SessionResetSent(theirPublicKey, payload)
+}
+
 // -----
 // HELPER
 // -----
@@ -412,32 +819,100 @@ func makeEnvelop(
 	return message.Wrap(&params, now)
 }
 
-// makePayload makes a specific payload for MailServer to request historic messages.
-func makePayload(r MessagesRequest) []byte {
-	// Payload format:
-	// 4  bytes for lower
-	// 4  bytes for upper
-	// 64 bytes for the bloom filter
-	// 4  bytes for limit
-	// 36 bytes for the cursor. optional.
-	data := make([]byte, 12+whisper.BloomFilterSize)
-
-	// from
-	binary.BigEndian.PutUint32(data, r.From)
-	// to
-	binary.BigEndian.PutUint32(data[4:], r.To)
-	// bloom
-	copy(data[8:], whisper.TopicToBloom(r.Topic))
-	// limit
-	binary.BigEndian.PutUint32(data[8+whisper.BloomFilterSize:], r.Limit)
-
-	// cursor is the key of an envelope in leveldb.
-	// it's 36 bytes. 4 bytes for the timestamp + 32 bytes for the envelope hash
-	expectedCursorSize := common.HashLength + 4
-	cursorBytes, err := hex.DecodeString(r.Cursor)
-	if err != nil || len(cursorBytes) != expectedCursorSize {
-		return data
-	}
-
-	return append(data, cursorBytes...)
+// makeMessagesRequestPayload makes an RLP-encoded payload for MailServer
+// to request historic messages, signed with a timestamp and a nonce.
+func makeMessagesRequestPayload(r MessagesRequest, now time.Time) ([]byte, error) {
+	cursor, err := hex.DecodeString(r.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	nonce, err := makeRequestNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	bloom := topicsToBloom(r.Topics...)
+	if len(r.Topics) == 0 {
+		bloom = topicsToBloom(r.Topic)
+	}
+
+	payload := protocol.MessagesRequestPayload{
+		Lower:     r.From,
+		Upper:     r.To,
+		Bloom:     bloom,
+		Limit:     r.Limit,
+		Cursor:    cursor,
+		Topics:    r.Topics,
+		Batch:     r.Batch,
+		Compress:  r.Batch,
+		Checksum:  r.Batch,
+		Timestamp: uint32(now.Unix()),
+		Nonce:     nonce,
+	}
+
+	return rlp.EncodeToBytes(payload)
+}
+
+// makeMessagesBatchRequestPayload makes an RLP-encoded payload
+// for a batch request. Lower and Upper cover all time windows, so MailServers
+// which do not understand time windows still return all requested envelopes.
+func makeMessagesBatchRequestPayload(r MessagesBatchRequest, now time.Time) ([]byte, error) {
+	cursor, err := hex.DecodeString(r.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+
+	nonce, err := makeRequestNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := protocol.MessagesRequestPayload{
+		Lower:     r.Windows[0].From,
+		Upper:     r.Windows[0].To,
+		Bloom:     topicsToBloom(r.Topics...),
+		Limit:     r.Limit,
+		Cursor:    cursor,
+		Topics:    r.Topics,
+		Windows:   make([]protocol.TimeWindow, len(r.Windows)),
+		Batch:     true,
+		Compress:  true,
+		Checksum:  true,
+		Timestamp: uint32(now.Unix()),
+		Nonce:     nonce,
+	}
+	for i, w := range r.Windows {
+		if w.From < payload.Lower {
+			payload.Lower = w.From
+		}
+		if w.To > payload.Upper {
+			payload.Upper = w.To
+		}
+		payload.Windows[i] = protocol.TimeWindow{Lower: w.From, Upper: w.To}
+	}
+
+	return rlp.EncodeToBytes(payload)
+}
+
+// makeRequestNonce returns random bytes which make every request unique,
+// so that MailServers with the replay protection don't reject
+// identical requests sent within one second.
+func makeRequestNonce() ([]byte, error) {
+	nonce := make([]byte, requestNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate request nonce: %v", err)
+	}
+	return nonce, nil
+}
+
+// topicsToBloom returns a bloom filter matching all given topics.
+func topicsToBloom(topics ...whisper.TopicType) []byte {
+	bloom := make([]byte, whisper.BloomFilterSize)
+	for _, topic := range topics {
+		for i, b := range whisper.TopicToBloom(topic) {
+			bloom[i] |= b
+		}
+	}
+	return bloom
 }
@@ -1,10 +1,14 @@
 package shhext
 
 import (
+	"encoding/hex"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/rlp"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/mailserver/protocol"
 	"github.com/stretchr/testify/require"
 )
 
@@ -52,3 +56,75 @@ func TestMessagesRequest_setDefaults(t *testing.T) {
 		})
 	}
 }
+
+func TestMakeMessagesRequestPayload(t *testing.T) {
+	topics := []whisper.TopicType{{0x01, 0x02, 0x03, 0x04}, {0x05, 0x06, 0x07, 0x08}}
+	cursor := make([]byte, 36)
+	cursor[0] = 0x01
+
+	data, err := makeMessagesRequestPayload(MessagesRequest{
+		From:   10,
+		To:     20,
+		Limit:  100,
+		Cursor: hex.EncodeToString(cursor),
+		Topics: topics,
+	}, time.Unix(1000, 0))
+	require.NoError(t, err)
+
+	var payload protocol.MessagesRequestPayload
+	require.NoError(t, rlp.DecodeBytes(data, &payload))
+	require.Equal(t, uint32(10), payload.Lower)
+	require.Equal(t, uint32(20), payload.Upper)
+	require.Equal(t, uint32(100), payload.Limit)
+	require.Equal(t, uint32(1000), payload.Timestamp)
+	require.Len(t, payload.Nonce, requestNonceSize)
+	require.Equal(t, cursor, payload.Cursor)
+	require.Equal(t, topics, payload.Topics)
+	require.True(t, whisper.BloomFilterMatch(payload.Bloom, whisper.TopicToBloom(topics[0])))
+	require.True(t, whisper.BloomFilterMatch(payload.Bloom, whisper.TopicToBloom(topics[1])))
+
+	_, err = makeMessagesRequestPayload(MessagesRequest{Cursor: "not-hex", Topics: topics}, time.Now())
+	require.Error(t, err)
+}
+
+func TestMakeMessagesRequestPayloadBatch(t *testing.T) {
+	topic := whisper.TopicType{0x01, 0x02, 0x03, 0x04}
+
+	data, err := makeMessagesRequestPayload(MessagesRequest{From: 10, To: 20, Topic: topic, Batch: true}, time.Now())
+	require.NoError(t, err)
+
+	var payload protocol.MessagesRequestPayload
+	require.NoError(t, rlp.DecodeBytes(data, &payload))
+	require.True(t, payload.Batch)
+	require.True(t, payload.Compress)
+	require.Empty(t, payload.Topics)
+	require.Equal(t, whisper.TopicToBloom(topic), payload.Bloom)
+}
+
+func TestMakeMessagesBatchRequestPayload(t *testing.T) {
+	topics := []whisper.TopicType{{0x01, 0x02, 0x03, 0x04}}
+
+	data, err := makeMessagesBatchRequestPayload(MessagesBatchRequest{
+		Windows: []TimeWindow{{From: 30, To: 40}, {From: 10, To: 20}},
+		Topics:  topics,
+	}, time.Unix(1000, 0))
+	require.NoError(t, err)
+
+	var payload protocol.MessagesRequestPayload
+	require.NoError(t, rlp.DecodeBytes(data, &payload))
+	require.Equal(t, uint32(10), payload.Lower)
+	require.Equal(t, uint32(40), payload.Upper)
+	require.Equal(t, topics, payload.Topics)
+	require.Equal(t, []protocol.TimeWindow{{Lower: 30, Upper: 40}, {Lower: 10, Upper: 20}}, payload.Windows)
+	require.True(t, payload.Batch)
+	require.Equal(t, uint32(1000), payload.Timestamp)
+	require.Len(t, payload.Nonce, requestNonceSize)
+}
+
+func TestMessagesBatchRequestDefaults(t *testing.T) {
+	now := time.Unix(100000, 0)
+	r := MessagesBatchRequest{}
+	r.setDefaults(now)
+	require.Equal(t, []TimeWindow{{From: 100000 - 86400, To: 100000}}, r.Windows)
+	require.EqualValues(t, defaultRequestTimeout, r.Timeout)
+}
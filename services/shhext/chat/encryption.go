@@ -18,28 +18,70 @@ import (
 
 var ErrSessionNotFound = errors.New("session not found")
 
+// ErrDecryptionFailed is returned when a message can't be decrypted with the double ratchet session,
+// for instance if the session is not in sync with theirs
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// ErrMessageConsumed is returned when the key of a message was already used by the double ratchet session,
+// for instance if the message was received twice
+var ErrMessageConsumed = errors.New("message key already used")
+
+const (
+	// minOneTimePreKeys is the number of unused one-time prekeys below which new ones are generated
+	minOneTimePreKeys = 5
+	// maxOneTimePreKeys is the number of one-time prekeys published in our bundle after they are replenished
+	maxOneTimePreKeys = 10
+)
+
+// ErrInstallationNotFound is returned when an installation of our identity is not known
+var ErrInstallationNotFound = errors.New("installation not found")
+
+// ErrNotOurInstallation is returned when installation metadata is sent by another identity
+var ErrNotOurInstallation = errors.New("installation metadata of another identity")
+
+// EncryptionServiceConfig holds the configuration of the EncryptionService
+type EncryptionServiceConfig struct {
+	InstallationID string
+	// BundleRotationPeriod is the time after which the signed prekey of our bundle is rotated
+	BundleRotationPeriod time.Duration
+	// BundleGracePeriod is the time expired bundles are kept after the rotation,
+	// so that messages sent using them can still be decrypted
+	BundleGracePeriod time.Duration
+}
+
+// DefaultEncryptionServiceConfig returns the default configuration for the installation
+func DefaultEncryptionServiceConfig(installationID string) EncryptionServiceConfig {
+	return EncryptionServiceConfig{
+		InstallationID:       installationID,
+		BundleRotationPeriod: 14 * 24 * time.Hour,
+		BundleGracePeriod:    7 * 24 * time.Hour,
+	}
+}
+
 // EncryptionService defines a service that is responsible for the encryption aspect of the protocol
 type EncryptionService struct {
 	log            log.Logger
 	persistence    PersistenceService
 	installationID string
+	config         EncryptionServiceConfig
 	mutex          sync.Mutex
 }
 
 // NewEncryptionService creates a new EncryptionService instance
-func NewEncryptionService(p PersistenceService, installationID string) *EncryptionService {
+func NewEncryptionService(p PersistenceService, config EncryptionServiceConfig) *EncryptionService {
 	logger := log.New("package", "status-go/services/sshext.chat")
-	logger.Info("Initialized encryption service", "installationID", installationID)
+	logger.Info("Initialized encryption service", "installationID", config.InstallationID)
 	return &EncryptionService{
 		log:            logger,
 		persistence:    p,
-		installationID: installationID,
+		installationID: config.InstallationID,
+		config:         config,
 		mutex:          sync.Mutex{},
 	}
 }
 
-func (s *EncryptionService) keyFromActiveX3DH(theirIdentityKey []byte, theirSignedPreKey []byte, myIdentityKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PublicKey, error) {
-	sharedKey, ephemeralPubKey, err := PerformActiveX3DH(theirIdentityKey, theirSignedPreKey, myIdentityKey)
+func (s *EncryptionService) keyFromActiveX3DH(theirIdentityKey []byte, theirSignedPreKey []byte, theirOneTimePreKey []byte, myIdentityKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PublicKey, error) {
+	sharedKey, ephemeralPubKey, err := PerformActiveX3DH(theirIdentityKey, theirSignedPreKey, theirOneTimePreKey, myIdentityKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -49,14 +91,22 @@ func (s *EncryptionService) keyFromActiveX3DH(theirIdentityKey []byte, theirSign
 
 // CreateBundle retrieves or creates an X3DH bundle given a private key
 func (s *EncryptionService) CreateBundle(privateKey *ecdsa.PrivateKey) (*Bundle, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.createBundle(privateKey)
+}
+
+func (s *EncryptionService) createBundle(privateKey *ecdsa.PrivateKey) (*Bundle, error) {
 	ourIdentityKeyC := ecrypto.CompressPubkey(&privateKey.PublicKey)
 	bundleContainer, err := s.persistence.GetAnyPrivateBundle(ourIdentityKeyC)
 	if err != nil {
 		return nil, err
 	}
 
-	// If the bundle has expired we create a new one
-	if bundleContainer != nil && bundleContainer.Timestamp < time.Now().AddDate(0, 0, -14).UnixNano() {
+	// If the bundle has expired we create a new one.
+	// The timestamp is zero if only bundles of our other installations are known
+	if bundleContainer != nil && bundleContainer.Timestamp < time.Now().Add(-s.config.BundleRotationPeriod).UnixNano() {
 		// Mark sessions has expired
 		if err := s.persistence.MarkBundleExpired(bundleContainer.GetBundle().GetIdentity()); err != nil {
 			return nil, err
@@ -67,11 +117,17 @@ func (s *EncryptionService) CreateBundle(privateKey *ecdsa.PrivateKey) (*Bundle,
 		if err != nil {
 			return nil, err
 		}
+
+		// One-time prekeys are not signed, so they are added after signing the bundle
+		if signedPreKey := bundleContainer.GetBundle().GetSignedPreKeys()[s.installationID]; signedPreKey != nil {
+			signedPreKey.OneTimePreKeys, err = s.oneTimePreKeys(ourIdentityKeyC)
+			if err != nil {
+				return nil, err
+			}
+		}
 		return bundleContainer.GetBundle(), nil
 	}
 
-	// needs transaction/mutex to avoid creating multiple bundles
-	// although not a problem
 	bundleContainer, err = NewBundleContainer(privateKey, s.installationID)
 	if err != nil {
 		return nil, err
@@ -81,7 +137,61 @@ func (s *EncryptionService) CreateBundle(privateKey *ecdsa.PrivateKey) (*Bundle,
 		return nil, err
 	}
 
-	return s.CreateBundle(privateKey)
+	return s.createBundle(privateKey)
+}
+
+// RotateBundle expires our bundle and creates a new one with a new signed prekey,
+// for instance if the signed prekey could be compromised
+func (s *EncryptionService) RotateBundle(privateKey *ecdsa.PrivateKey) (*Bundle, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.persistence.MarkBundleExpired(ecrypto.CompressPubkey(&privateKey.PublicKey)); err != nil {
+		return nil, err
+	}
+
+	return s.createBundle(privateKey)
+}
+
+// RotateBundles expires our bundles older than the rotation period, new ones are created when they are needed.
+// Bundles which expired before the grace period are deleted together with sessions established with them
+func (s *EncryptionService) RotateBundles() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if err := s.persistence.MarkBundlesExpired(now.Add(-s.config.BundleRotationPeriod).UnixNano()); err != nil {
+		return err
+	}
+
+	return s.persistence.DeleteExpiredBundles(now.Add(-s.config.BundleGracePeriod).UnixNano())
+}
+
+// oneTimePreKeys returns our one-time prekeys which were not used yet,
+// generating new ones when running low
+func (s *EncryptionService) oneTimePreKeys(ourIdentityKeyC []byte) ([][]byte, error) {
+	keys, err := s.persistence.GetOneTimePreKeys(ourIdentityKeyC, s.installationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) >= minOneTimePreKeys {
+		return keys, nil
+	}
+
+	generated, err := GenerateOneTimePreKeys(maxOneTimePreKeys - len(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.persistence.AddPrivateOneTimePreKeys(ourIdentityKeyC, s.installationID, generated); err != nil {
+		return nil, err
+	}
+
+	for _, key := range generated {
+		keys = append(keys, ecrypto.CompressPubkey(&key.PublicKey))
+	}
+	return keys, nil
 }
 
 // DecryptWithDH decrypts message sent with a DH key exchange, and throws away the key after decryption
@@ -99,7 +209,7 @@ func (s *EncryptionService) DecryptWithDH(myIdentityKey *ecdsa.PrivateKey, their
 }
 
 // keyFromPassiveX3DH decrypts message sent with a X3DH key exchange, storing the key for future exchanges
-func (s *EncryptionService) keyFromPassiveX3DH(myIdentityKey *ecdsa.PrivateKey, theirIdentityKey *ecdsa.PublicKey, theirEphemeralKey *ecdsa.PublicKey, ourBundleID []byte) ([]byte, error) {
+func (s *EncryptionService) keyFromPassiveX3DH(myIdentityKey *ecdsa.PrivateKey, theirIdentityKey *ecdsa.PublicKey, theirEphemeralKey *ecdsa.PublicKey, ourBundleID []byte, ourOneTimePreKey []byte) ([]byte, error) {
 	bundlePrivateKey, err := s.persistence.GetPrivateKeyBundle(ourBundleID)
 	if err != nil {
 		s.log.Error("Could not get private bundle", "err", err)
@@ -116,11 +226,32 @@ func (s *EncryptionService) keyFromPassiveX3DH(myIdentityKey *ecdsa.PrivateKey,
 		return nil, err
 	}
 
+	var oneTimePreKey *ecdsa.PrivateKey
+	if ourOneTimePreKey != nil {
+		oneTimePreKeyPrivateKey, err := s.persistence.GetPrivateOneTimePreKey(ourOneTimePreKey)
+		if err != nil {
+			s.log.Error("Could not get one-time prekey", "err", err)
+			return nil, err
+		}
+
+		// The key was already consumed by another session
+		if oneTimePreKeyPrivateKey == nil {
+			return nil, ErrSessionNotFound
+		}
+
+		oneTimePreKey, err = ecrypto.ToECDSA(oneTimePreKeyPrivateKey)
+		if err != nil {
+			s.log.Error("Could not convert to ecdsa", "err", err)
+			return nil, err
+		}
+	}
+
 	key, err := PerformPassiveX3DH(
 		theirIdentityKey,
 		signedPreKey,
 		theirEphemeralKey,
 		myIdentityKey,
+		oneTimePreKey,
 	)
 	if err != nil {
 		s.log.Error("Could not perform passive x3dh", "err", err)
@@ -136,7 +267,93 @@ func (s *EncryptionService) ProcessPublicBundle(myIdentityKey *ecdsa.PrivateKey,
 	if err != nil {
 		return err
 	}
-	return s.persistence.AddPublicBundle(b)
+	if err := s.persistence.AddPublicBundle(b); err != nil {
+		return err
+	}
+
+	if myIdentityKey == nil || !bytes.Equal(b.GetIdentity(), ecrypto.CompressPubkey(&myIdentityKey.PublicKey)) {
+		return nil
+	}
+
+	// Our other installations are enabled so that they keep receiving our messages,
+	// a lost device has to be disabled explicitly
+	var installationIDs []string
+	for installationID := range b.GetSignedPreKeys() {
+		if installationID != s.installationID {
+			installationIDs = append(installationIDs, installationID)
+		}
+	}
+	return s.persistence.AddInstallations(b.GetIdentity(), installationIDs, true)
+}
+
+// CreateInstallationMetadata returns signed metadata of our installation,
+// sent to our other installations when pairing them
+func (s *EncryptionService) CreateInstallationMetadata(myIdentityKey *ecdsa.PrivateKey, name string) (*InstallationMetadata, error) {
+	metadata := &InstallationMetadata{
+		Identity:       ecrypto.CompressPubkey(&myIdentityKey.PublicKey),
+		InstallationId: s.installationID,
+		Name:           name,
+		Timestamp:      time.Now().UnixNano(),
+	}
+
+	if err := SignInstallationMetadata(myIdentityKey, metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// ProcessInstallationMetadata persists metadata sent by another installation of our identity
+func (s *EncryptionService) ProcessInstallationMetadata(myIdentityKey *ecdsa.PrivateKey, metadata *InstallationMetadata) error {
+	if err := VerifyInstallationMetadata(metadata); err != nil {
+		return err
+	}
+
+	if myIdentityKey == nil || !bytes.Equal(metadata.GetIdentity(), ecrypto.CompressPubkey(&myIdentityKey.PublicKey)) {
+		return ErrNotOurInstallation
+	}
+
+	if metadata.GetInstallationId() == s.installationID {
+		return nil
+	}
+
+	return s.persistence.SetInstallationMetadata(
+		metadata.GetIdentity(),
+		metadata.GetInstallationId(),
+		metadata.GetName(),
+		metadata.GetTimestamp(),
+	)
+}
+
+// GetOurInstallations returns other installations of our identity
+func (s *EncryptionService) GetOurInstallations(myIdentityKey *ecdsa.PrivateKey) ([]*Installation, error) {
+	return s.persistence.GetInstallations(ecrypto.CompressPubkey(&myIdentityKey.PublicKey))
+}
+
+// EnableInstallation enables encryption of messages for another installation of our identity
+func (s *EncryptionService) EnableInstallation(myIdentityKey *ecdsa.PrivateKey, installationID string) error {
+	return s.persistence.SetInstallationEnabled(ecrypto.CompressPubkey(&myIdentityKey.PublicKey), installationID, true)
+}
+
+// DisableInstallation stops encryption of messages for another installation of our identity
+func (s *EncryptionService) DisableInstallation(myIdentityKey *ecdsa.PrivateKey, installationID string) error {
+	return s.persistence.SetInstallationEnabled(ecrypto.CompressPubkey(&myIdentityKey.PublicKey), installationID, false)
+}
+
+// enabledInstallations returns IDs of enabled installations of the identity
+func (s *EncryptionService) enabledInstallations(identity []byte) (map[string]bool, error) {
+	installations, err := s.persistence.GetInstallations(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := make(map[string]bool)
+	for _, installation := range installations {
+		if installation.Enabled {
+			enabled[installation.ID] = true
+		}
+	}
+	return enabled, nil
 }
 
 // DecryptPayload decrypts the payload of a DirectMessageProtocol, given an identity private key and the sender's public key
@@ -155,21 +372,7 @@ func (s *EncryptionService) DecryptPayload(myIdentityKey *ecdsa.PrivateKey, thei
 	payload := msg.GetPayload()
 
 	if x3dhHeader := msg.GetX3DHHeader(); x3dhHeader != nil {
-		bundleID := x3dhHeader.GetId()
-		theirEphemeralKey, err := ecrypto.DecompressPubkey(x3dhHeader.GetKey())
-
-		if err != nil {
-			return nil, err
-		}
-
-		symmetricKey, err := s.keyFromPassiveX3DH(myIdentityKey, theirIdentityKey, theirEphemeralKey, bundleID)
-		if err != nil {
-			return nil, err
-		}
-
-		theirIdentityKeyC := ecrypto.CompressPubkey(theirIdentityKey)
-		err = s.persistence.AddRatchetInfo(symmetricKey, theirIdentityKeyC, bundleID, nil, x3dhHeader.GetInstallationId())
-		if err != nil {
+		if err := s.handleX3DHHeader(myIdentityKey, theirIdentityKey, x3dhHeader); err != nil {
 			return nil, err
 		}
 	}
@@ -189,23 +392,24 @@ func (s *EncryptionService) DecryptPayload(myIdentityKey *ecdsa.PrivateKey, thei
 
 		theirIdentityKeyC := ecrypto.CompressPubkey(theirIdentityKey)
 
-		drInfo, err := s.persistence.GetRatchetInfo(drHeader.GetId(), theirIdentityKeyC)
+		// Each installation of the sender has its own session with our bundle
+		drInfo, err := s.persistence.GetRatchetInfo(drHeader.GetId(), theirIdentityKeyC, drHeader.GetInstallationId())
 		if err != nil {
 			s.log.Error("Could not get ratchet info", "err", err)
 			return nil, err
 		}
 
-		// We mark the exchange as successful so we stop sending x3dh header
-		if err = s.persistence.RatchetInfoConfirmed(drHeader.GetId(), theirIdentityKeyC); err != nil {
-			s.log.Error("Could not confirm ratchet info", "err", err)
-			return nil, err
-		}
-
 		if drInfo == nil {
 			s.log.Error("Could not find a session")
 			return nil, ErrSessionNotFound
 		}
 
+		// We mark the exchange as successful so we stop sending x3dh header
+		if err = s.persistence.RatchetInfoConfirmed(drHeader.GetId(), theirIdentityKeyC, drInfo.InstallationID); err != nil {
+			s.log.Error("Could not confirm ratchet info", "err", err)
+			return nil, err
+		}
+
 		return s.decryptUsingDR(theirIdentityKey, drInfo, drMessage)
 	}
 
@@ -221,6 +425,62 @@ func (s *EncryptionService) DecryptPayload(myIdentityKey *ecdsa.PrivateKey, thei
 	return nil, errors.New("no key specified")
 }
 
+// ResetSession discards the sessions established with an installation of their identity,
+// so that a new session is established with X3DH when a message is sent to it
+func (s *EncryptionService) ResetSession(theirIdentityKey *ecdsa.PublicKey, installationID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.persistence.DeleteRatchetInfo(ecrypto.CompressPubkey(theirIdentityKey), installationID)
+}
+
+// handleX3DHHeader establishes the session initiated by their X3DH key exchange
+func (s *EncryptionService) handleX3DHHeader(myIdentityKey *ecdsa.PrivateKey, theirIdentityKey *ecdsa.PublicKey, x3dhHeader *X3DHHeader) error {
+	bundleID := x3dhHeader.GetId()
+	oneTimePreKey := x3dhHeader.GetOneTimePreKey()
+	theirIdentityKeyC := ecrypto.CompressPubkey(theirIdentityKey)
+
+	drInfo, err := s.persistence.GetRatchetInfo(bundleID, theirIdentityKeyC, x3dhHeader.GetInstallationId())
+	if err != nil {
+		return err
+	}
+
+	// The header is sent until the session is confirmed, but our one-time prekey
+	// is deleted after the first message, so the session is kept as it is
+	if oneTimePreKey != nil && drInfo != nil && bytes.Equal(drInfo.OneTimePreKey, oneTimePreKey) {
+		return nil
+	}
+
+	theirEphemeralKey, err := ecrypto.DecompressPubkey(x3dhHeader.GetKey())
+	if err != nil {
+		return err
+	}
+
+	symmetricKey, err := s.keyFromPassiveX3DH(myIdentityKey, theirIdentityKey, theirEphemeralKey, bundleID, oneTimePreKey)
+	if err != nil {
+		return err
+	}
+
+	// A new key exchange with the same installation replaces the previous session,
+	// which they discarded, for instance after a session reset
+	if drInfo != nil && drInfo.InstallationID == x3dhHeader.GetInstallationId() && !bytes.Equal(drInfo.Sk, symmetricKey) {
+		if err = s.persistence.DeleteRatchetInfo(theirIdentityKeyC, drInfo.InstallationID); err != nil {
+			return err
+		}
+	}
+
+	err = s.persistence.AddRatchetInfo(symmetricKey, theirIdentityKeyC, bundleID, nil, oneTimePreKey, x3dhHeader.GetInstallationId())
+	if err != nil {
+		return err
+	}
+
+	if oneTimePreKey == nil {
+		return nil
+	}
+
+	return s.persistence.DeleteOneTimePreKey(oneTimePreKey)
+}
+
 func (s *EncryptionService) createNewSession(drInfo *RatchetInfo, sk [32]byte, keyPair crypto.DHPair) (dr.Session, error) {
 	var err error
 	var session dr.Session
@@ -286,10 +546,11 @@ func (s *EncryptionService) encryptUsingDR(theirIdentityKey *ecdsa.PublicKey, dr
 	}
 
 	header := &DRHeader{
-		Id:  drInfo.BundleID,
-		Key: response.Header.DH[:],
-		N:   response.Header.N,
-		Pn:  response.Header.PN,
+		Id:             drInfo.BundleID,
+		Key:            response.Header.DH[:],
+		N:              response.Header.N,
+		Pn:             response.Header.PN,
+		InstallationId: s.installationID,
 	}
 
 	return response.Ciphertext, header, nil
@@ -329,12 +590,37 @@ func (s *EncryptionService) decryptUsingDR(theirIdentityKey *ecdsa.PublicKey, dr
 
 	plaintext, err := session.RatchetDecrypt(*payload, nil)
 	if err != nil {
-		return nil, err
+		consumed, cErr := s.messageConsumed(drInfo.ID, payload.Header)
+		if cErr != nil {
+			return nil, cErr
+		}
+		if consumed {
+			return nil, ErrMessageConsumed
+		}
+
+		s.log.Error("Could not decrypt the message with the session", "err", err)
+		return nil, ErrDecryptionFailed
 	}
 
 	return plaintext, nil
 }
 
+// messageConsumed returns true if the message precedes the state of the session,
+// so its key was used already and the session does not need to be reset
+func (s *EncryptionService) messageConsumed(sessionID []byte, header dr.MessageHeader) (bool, error) {
+	state, err := s.persistence.GetSessionStorage().Load(sessionID)
+	if err != nil || state == nil {
+		return false, err
+	}
+
+	if header.DH == state.DHr {
+		return header.N < state.RecvCh.N, nil
+	}
+
+	// A chain they used before the current one
+	return s.persistence.IsRatchetKeyReceived(sessionID, header.DH[:])
+}
+
 func (s *EncryptionService) encryptWithDH(theirIdentityKey *ecdsa.PublicKey, payload []byte) (*DirectMessageProtocol, error) {
 	symmetricKey, ourEphemeralKey, err := PerformActiveDH(theirIdentityKey)
 	if err != nil {
@@ -354,114 +640,129 @@ func (s *EncryptionService) encryptWithDH(theirIdentityKey *ecdsa.PublicKey, pay
 	}, nil
 }
 
-// EncryptPayload returns a new DirectMessageProtocol with a given payload encrypted, given a recipient's public key and the sender private identity key
-// TODO: refactor this
-// nolint: gocyclo
-func (s *EncryptionService) EncryptPayload(theirIdentityKey *ecdsa.PublicKey, myIdentityKey *ecdsa.PrivateKey, payload []byte) (map[string]*DirectMessageProtocol, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	response := make(map[string]*DirectMessageProtocol)
+// encryptForInstallation encrypts the payload for a single installation of the recipient
+// using an existing double ratchet session or a new one established with X3DH.
+func (s *EncryptionService) encryptForInstallation(theirIdentityKey *ecdsa.PublicKey, myIdentityKey *ecdsa.PrivateKey, installationID string, theirSignedPreKey []byte, payload []byte) (*DirectMessageProtocol, error) {
 	theirIdentityKeyC := ecrypto.CompressPubkey(theirIdentityKey)
 
-	// Get their latest bundle
-	theirBundle, err := s.persistence.GetPublicBundle(theirIdentityKey)
+	// See if a session is there already
+	drInfo, err := s.persistence.GetAnyRatchetInfo(theirIdentityKeyC, installationID)
 	if err != nil {
 		return nil, err
 	}
 
-	// We don't have any, send a message with DH
-	if theirBundle == nil && !bytes.Equal(theirIdentityKeyC, ecrypto.CompressPubkey(&myIdentityKey.PublicKey)) {
-		dmp, err := s.encryptWithDH(theirIdentityKey, payload)
+	if drInfo == nil {
+		// A one-time prekey is used if they published any
+		theirOneTimePreKey, err := s.persistence.GetAnyOneTimePreKey(theirIdentityKeyC, installationID)
 		if err != nil {
 			return nil, err
 		}
 
-		response["none"] = dmp
-		return response, nil
-	}
-
-	for installationID, signedPreKeyContainer := range theirBundle.GetSignedPreKeys() {
-		if s.installationID == installationID {
-			continue
+		sharedKey, ourEphemeralKey, err := s.keyFromActiveX3DH(theirIdentityKeyC, theirSignedPreKey, theirOneTimePreKey, myIdentityKey)
+		if err != nil {
+			return nil, err
 		}
+		ourEphemeralKeyC := ecrypto.CompressPubkey(ourEphemeralKey)
 
-		theirSignedPreKey := signedPreKeyContainer.GetSignedPreKey()
-		// See if a session is there already
-		drInfo, err := s.persistence.GetAnyRatchetInfo(theirIdentityKeyC, installationID)
+		err = s.persistence.AddRatchetInfo(sharedKey, theirIdentityKeyC, theirSignedPreKey, ourEphemeralKeyC, theirOneTimePreKey, installationID)
 		if err != nil {
 			return nil, err
 		}
 
-		if drInfo != nil {
-			encryptedPayload, drHeader, err := s.encryptUsingDR(theirIdentityKey, drInfo, payload)
-			if err != nil {
+		if theirOneTimePreKey != nil {
+			if err = s.persistence.MarkOneTimePreKeyUsed(theirOneTimePreKey); err != nil {
 				return nil, err
 			}
+		}
 
-			dmp := DirectMessageProtocol{
-				Payload:  encryptedPayload,
-				DRHeader: drHeader,
-			}
+		drInfo, err = s.persistence.GetAnyRatchetInfo(theirIdentityKeyC, installationID)
+		if err != nil {
+			return nil, err
+		}
+		if drInfo == nil {
+			return nil, ErrSessionNotFound
+		}
+	}
 
-			if drInfo.EphemeralKey != nil {
-				dmp.X3DHHeader = &X3DHHeader{
-					Key:            drInfo.EphemeralKey,
-					Id:             drInfo.BundleID,
-					InstallationId: s.installationID,
-				}
-			}
+	encryptedPayload, drHeader, err := s.encryptUsingDR(theirIdentityKey, drInfo, payload)
+	if err != nil {
+		return nil, err
+	}
 
-			response[drInfo.InstallationID] = &dmp
+	dmp := &DirectMessageProtocol{
+		Payload:  encryptedPayload,
+		DRHeader: drHeader,
+	}
 
-			return response, nil
+	// The X3DH header is sent until they confirm the session
+	if drInfo.EphemeralKey != nil {
+		dmp.X3DHHeader = &X3DHHeader{
+			Key:            drInfo.EphemeralKey,
+			Id:             drInfo.BundleID,
+			InstallationId: s.installationID,
+			OneTimePreKey:  drInfo.OneTimePreKey,
 		}
+	}
+
+	return dmp, nil
+}
+
+// EncryptPayload returns a new DirectMessageProtocol with a given payload encrypted, given a recipient's public key and the sender private identity key.
+// The payload is encrypted separately for each known installation of the recipient, keyed by the installation ID.
+// Only enabled installations are used if the recipient is our own identity.
+func (s *EncryptionService) EncryptPayload(theirIdentityKey *ecdsa.PublicKey, myIdentityKey *ecdsa.PrivateKey, payload []byte) (map[string]*DirectMessageProtocol, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	response := make(map[string]*DirectMessageProtocol)
+	theirIdentityKeyC := ecrypto.CompressPubkey(theirIdentityKey)
+
+	// Get their latest bundle
+	theirBundle, err := s.persistence.GetPublicBundle(theirIdentityKey)
+	if err != nil {
+		return nil, err
+	}
 
-		// check if a bundle is there
-		theirBundle, err := s.persistence.GetPublicBundle(theirIdentityKey)
+	ours := bytes.Equal(theirIdentityKeyC, ecrypto.CompressPubkey(&myIdentityKey.PublicKey))
+
+	// We don't have any, send a message with DH
+	if theirBundle == nil && !ours {
+		dmp, err := s.encryptWithDH(theirIdentityKey, payload)
 		if err != nil {
 			return nil, err
 		}
 
-		if theirBundle != nil {
-			sharedKey, ourEphemeralKey, err := s.keyFromActiveX3DH(theirIdentityKeyC, theirSignedPreKey, myIdentityKey)
-			if err != nil {
-				return nil, err
-			}
-			theirIdentityKeyC := ecrypto.CompressPubkey(theirIdentityKey)
-			ourEphemeralKeyC := ecrypto.CompressPubkey(ourEphemeralKey)
-
-			err = s.persistence.AddRatchetInfo(sharedKey, theirIdentityKeyC, theirSignedPreKey, ourEphemeralKeyC, installationID)
-			if err != nil {
-				return nil, err
-			}
+		response["none"] = dmp
+		return response, nil
+	}
 
-			x3dhHeader := &X3DHHeader{
-				Key:            ourEphemeralKeyC,
-				Id:             theirSignedPreKey,
-				InstallationId: s.installationID,
-			}
+	var enabled map[string]bool
+	if ours {
+		enabled, err = s.enabledInstallations(theirIdentityKeyC)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			drInfo, err := s.persistence.GetAnyRatchetInfo(theirIdentityKeyC, installationID)
-			if err != nil {
-				return nil, err
-			}
+	// A failing installation does not prevent sending the message to the other ones
+	var lastErr error
+	for installationID, signedPreKeyContainer := range theirBundle.GetSignedPreKeys() {
+		if s.installationID == installationID || (ours && !enabled[installationID]) {
+			continue
+		}
 
-			if drInfo != nil {
-				encryptedPayload, drHeader, err := s.encryptUsingDR(theirIdentityKey, drInfo, payload)
-				if err != nil {
-					return nil, err
-				}
+		dmp, err := s.encryptForInstallation(theirIdentityKey, myIdentityKey, installationID, signedPreKeyContainer.GetSignedPreKey(), payload)
+		if err != nil {
+			s.log.Error("Could not encrypt for installation", "installationID", installationID, "err", err)
+			lastErr = err
+			continue
+		}
 
-				dmp := &DirectMessageProtocol{
-					Payload:    encryptedPayload,
-					X3DHHeader: x3dhHeader,
-					DRHeader:   drHeader,
-				}
+		response[installationID] = dmp
+	}
 
-				response[drInfo.InstallationID] = dmp
-			}
-		}
+	if len(response) == 0 && lastErr != nil {
+		return nil, lastErr
 	}
 
 	return response, nil
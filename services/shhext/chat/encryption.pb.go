@@ -19,8 +19,10 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type SignedPreKey struct {
-	SignedPreKey         []byte   `protobuf:"bytes,1,opt,name=signed_pre_key,json=signedPreKey,proto3" json:"signed_pre_key,omitempty"`
-	Version              uint32   `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	SignedPreKey []byte `protobuf:"bytes,1,opt,name=signed_pre_key,json=signedPreKey,proto3" json:"signed_pre_key,omitempty"`
+	Version      uint32 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	// One-time prekeys, each used for a single X3DH key exchange
+	OneTimePreKeys       [][]byte `protobuf:"bytes,3,rep,name=one_time_pre_keys,json=oneTimePreKeys,proto3" json:"one_time_pre_keys,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -64,6 +66,13 @@ func (m *SignedPreKey) GetVersion() uint32 {
 	return 0
 }
 
+func (m *SignedPreKey) GetOneTimePreKeys() [][]byte {
+	if m != nil {
+		return m.OneTimePreKeys
+	}
+	return nil
+}
+
 // X3DH prekey bundle
 type Bundle struct {
 	// Identity key
@@ -187,7 +196,9 @@ type DRHeader struct {
 	// Length of the previous sending chain
 	Pn uint32 `protobuf:"varint,3,opt,name=pn,proto3" json:"pn,omitempty"`
 	// Bundle ID
-	Id                   []byte   `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
+	Id []byte `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
+	// The sender installation ID
+	InstallationId       string   `protobuf:"bytes,5,opt,name=installation_id,json=installationId,proto3" json:"installation_id,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -245,6 +256,13 @@ func (m *DRHeader) GetId() []byte {
 	return nil
 }
 
+func (m *DRHeader) GetInstallationId() string {
+	if m != nil {
+		return m.InstallationId
+	}
+	return ""
+}
+
 type DHHeader struct {
 	// Compressed ephemeral public key
 	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
@@ -290,7 +308,9 @@ type X3DHHeader struct {
 	// Used bundle's signed prekey
 	Id []byte `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
 	// The device id
-	InstallationId       string   `protobuf:"bytes,3,opt,name=installation_id,json=installationId,proto3" json:"installation_id,omitempty"`
+	InstallationId string `protobuf:"bytes,3,opt,name=installation_id,json=installationId,proto3" json:"installation_id,omitempty"`
+	// Used one-time prekey, if any
+	OneTimePreKey        []byte   `protobuf:"bytes,5,opt,name=one_time_pre_key,json=oneTimePreKey,proto3" json:"one_time_pre_key,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -341,6 +361,13 @@ func (m *X3DHHeader) GetInstallationId() string {
 	return ""
 }
 
+func (m *X3DHHeader) GetOneTimePreKey() []byte {
+	if m != nil {
+		return m.OneTimePreKey
+	}
+	return nil
+}
+
 // Direct message value
 type DirectMessageProtocol struct {
 	X3DHHeader *X3DHHeader `protobuf:"bytes,1,opt,name=X3DH_header,json=x3DHHeader,proto3" json:"X3DH_header,omitempty"`
@@ -405,6 +432,122 @@ func (m *DirectMessageProtocol) GetPayload() []byte {
 	return nil
 }
 
+// Metadata of an installation, exchanged between installations of the same identity
+type InstallationMetadata struct {
+	// Identity key
+	Identity []byte `protobuf:"bytes,1,opt,name=identity,proto3" json:"identity,omitempty"`
+	// Installation id
+	InstallationId string `protobuf:"bytes,2,opt,name=installation_id,json=installationId,proto3" json:"installation_id,omitempty"`
+	// Name of the device
+	Name string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	// Local time of creation
+	Timestamp int64 `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// Metadata signature
+	Signature            []byte   `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InstallationMetadata) Reset()         { *m = InstallationMetadata{} }
+func (m *InstallationMetadata) String() string { return proto.CompactTextString(m) }
+func (*InstallationMetadata) ProtoMessage()    {}
+func (*InstallationMetadata) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8293a649ce9418c6, []int{7}
+}
+func (m *InstallationMetadata) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InstallationMetadata.Unmarshal(m, b)
+}
+func (m *InstallationMetadata) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InstallationMetadata.Marshal(b, m, deterministic)
+}
+func (m *InstallationMetadata) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InstallationMetadata.Merge(m, src)
+}
+func (m *InstallationMetadata) XXX_Size() int {
+	return xxx_messageInfo_InstallationMetadata.Size(m)
+}
+func (m *InstallationMetadata) XXX_DiscardUnknown() {
+	xxx_messageInfo_InstallationMetadata.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InstallationMetadata proto.InternalMessageInfo
+
+func (m *InstallationMetadata) GetIdentity() []byte {
+	if m != nil {
+		return m.Identity
+	}
+	return nil
+}
+
+func (m *InstallationMetadata) GetInstallationId() string {
+	if m != nil {
+		return m.InstallationId
+	}
+	return ""
+}
+
+func (m *InstallationMetadata) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *InstallationMetadata) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *InstallationMetadata) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// Sent when a message could not be decrypted, so that the sender establishes a new session
+type SessionReset struct {
+	// Installation id which could not decrypt the message
+	InstallationId       string   `protobuf:"bytes,1,opt,name=installation_id,json=installationId,proto3" json:"installation_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SessionReset) Reset()         { *m = SessionReset{} }
+func (m *SessionReset) String() string { return proto.CompactTextString(m) }
+func (*SessionReset) ProtoMessage()    {}
+func (*SessionReset) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8293a649ce9418c6, []int{8}
+}
+func (m *SessionReset) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionReset.Unmarshal(m, b)
+}
+func (m *SessionReset) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionReset.Marshal(b, m, deterministic)
+}
+func (m *SessionReset) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionReset.Merge(m, src)
+}
+func (m *SessionReset) XXX_Size() int {
+	return xxx_messageInfo_SessionReset.Size(m)
+}
+func (m *SessionReset) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionReset.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionReset proto.InternalMessageInfo
+
+func (m *SessionReset) GetInstallationId() string {
+	if m != nil {
+		return m.InstallationId
+	}
+	return ""
+}
+
 // Top-level protocol message
 type ProtocolMessage struct {
 	// An optional bundle is exchanged with each message
@@ -412,17 +555,21 @@ type ProtocolMessage struct {
 	// One to one message, encrypted, indexed by installation_id
 	DirectMessage map[string]*DirectMessageProtocol `protobuf:"bytes,101,rep,name=direct_message,json=directMessage,proto3" json:"direct_message,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	// Public chats, not encrypted
-	PublicMessage        []byte   `protobuf:"bytes,102,opt,name=public_message,json=publicMessage,proto3" json:"public_message,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	PublicMessage []byte `protobuf:"bytes,102,opt,name=public_message,json=publicMessage,proto3" json:"public_message,omitempty"`
+	// Metadata sent when pairing installations of the same identity
+	InstallationMetadata *InstallationMetadata `protobuf:"bytes,103,opt,name=installation_metadata,json=installationMetadata,proto3" json:"installation_metadata,omitempty"`
+	// Request to discard the session with the installation and establish a new one
+	SessionReset         *SessionReset `protobuf:"bytes,104,opt,name=session_reset,json=sessionReset,proto3" json:"session_reset,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
 }
 
 func (m *ProtocolMessage) Reset()         { *m = ProtocolMessage{} }
 func (m *ProtocolMessage) String() string { return proto.CompactTextString(m) }
 func (*ProtocolMessage) ProtoMessage()    {}
 func (*ProtocolMessage) Descriptor() ([]byte, []int) {
-	return fileDescriptor_8293a649ce9418c6, []int{7}
+	return fileDescriptor_8293a649ce9418c6, []int{9}
 }
 func (m *ProtocolMessage) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ProtocolMessage.Unmarshal(m, b)
@@ -463,6 +610,20 @@ func (m *ProtocolMessage) GetPublicMessage() []byte {
 	return nil
 }
 
+func (m *ProtocolMessage) GetInstallationMetadata() *InstallationMetadata {
+	if m != nil {
+		return m.InstallationMetadata
+	}
+	return nil
+}
+
+func (m *ProtocolMessage) GetSessionReset() *SessionReset {
+	if m != nil {
+		return m.SessionReset
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*SignedPreKey)(nil), "chat.SignedPreKey")
 	proto.RegisterType((*Bundle)(nil), "chat.Bundle")
@@ -472,6 +633,8 @@ func init() {
 	proto.RegisterType((*DHHeader)(nil), "chat.DHHeader")
 	proto.RegisterType((*X3DHHeader)(nil), "chat.X3DHHeader")
 	proto.RegisterType((*DirectMessageProtocol)(nil), "chat.DirectMessageProtocol")
+	proto.RegisterType((*InstallationMetadata)(nil), "chat.InstallationMetadata")
+	proto.RegisterType((*SessionReset)(nil), "chat.SessionReset")
 	proto.RegisterType((*ProtocolMessage)(nil), "chat.ProtocolMessage")
 	proto.RegisterMapType((map[string]*DirectMessageProtocol)(nil), "chat.ProtocolMessage.DirectMessageEntry")
 }
@@ -479,39 +642,48 @@ func init() {
 func init() { proto.RegisterFile("encryption.proto", fileDescriptor_8293a649ce9418c6) }
 
 var fileDescriptor_8293a649ce9418c6 = []byte{
-	// 535 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0x51, 0x8b, 0xd3, 0x40,
-	0x10, 0x26, 0xe9, 0xd9, 0x6b, 0xa7, 0x69, 0x5a, 0x56, 0x94, 0x50, 0x0b, 0x96, 0x70, 0x62, 0x40,
-	0x28, 0x5c, 0xfb, 0x22, 0x3e, 0x6a, 0xc5, 0xaa, 0xa8, 0xc7, 0x2a, 0xe8, 0x8b, 0x84, 0x6d, 0x77,
-	0xbc, 0x5b, 0x4c, 0x37, 0x61, 0xb3, 0x2d, 0xf6, 0x2f, 0xf8, 0xe0, 0x4f, 0xf2, 0xbf, 0xf8, 0x4f,
-	0x24, 0xbb, 0x49, 0x9b, 0xf6, 0xee, 0xe0, 0xde, 0x32, 0xb3, 0xb3, 0xdf, 0x7c, 0xdf, 0x37, 0x3b,
-	0x81, 0x3e, 0xca, 0xa5, 0xda, 0x66, 0x5a, 0xa4, 0x72, 0x9c, 0xa9, 0x54, 0xa7, 0xe4, 0x64, 0x79,
-	0xc5, 0x74, 0xf8, 0x11, 0xbc, 0xcf, 0xe2, 0x52, 0x22, 0xbf, 0x50, 0xf8, 0x1e, 0xb7, 0xe4, 0x0c,
-	0xfc, 0xdc, 0xc4, 0x71, 0xa6, 0x30, 0xfe, 0x89, 0xdb, 0xc0, 0x19, 0x39, 0x91, 0x47, 0xbd, 0xbc,
-	0x5e, 0x15, 0xc0, 0xe9, 0x06, 0x55, 0x2e, 0x52, 0x19, 0xb8, 0x23, 0x27, 0xea, 0xd2, 0x2a, 0x0c,
-	0xff, 0x39, 0xd0, 0x7c, 0xb9, 0x96, 0x3c, 0x41, 0x32, 0x80, 0x96, 0xe0, 0x28, 0xb5, 0xd0, 0x15,
-	0xc8, 0x2e, 0x26, 0x6f, 0xa0, 0x77, 0xd8, 0x26, 0x0f, 0xdc, 0x51, 0x23, 0xea, 0x4c, 0x1e, 0x8f,
-	0x0b, 0x5a, 0x63, 0x0b, 0x31, 0xae, 0x53, 0xcb, 0x5f, 0x4b, 0xad, 0xb6, 0xb4, 0x5b, 0x27, 0x92,
-	0x93, 0x21, 0xb4, 0x8b, 0x04, 0xd3, 0x6b, 0x85, 0xc1, 0x89, 0xe9, 0xb2, 0x4f, 0x0c, 0xbe, 0x00,
-	0xb9, 0x0e, 0x41, 0xfa, 0xd0, 0xa8, 0x84, 0xb5, 0x69, 0xf1, 0x49, 0x22, 0xb8, 0xb7, 0x61, 0xc9,
-	0x1a, 0x8d, 0x9a, 0xce, 0x84, 0x58, 0x12, 0xf5, 0xab, 0xd4, 0x16, 0xbc, 0x70, 0x9f, 0x3b, 0xe1,
-	0x6f, 0x07, 0x7a, 0x96, 0xe0, 0xab, 0x54, 0x6a, 0x26, 0x24, 0x2a, 0x72, 0x06, 0xcd, 0x85, 0x49,
-	0x19, 0xd8, 0xce, 0xc4, 0xab, 0xeb, 0xa0, 0xe5, 0x19, 0x99, 0xc2, 0xc3, 0x4c, 0x89, 0x0d, 0xd3,
-	0x18, 0x1f, 0xb9, 0xec, 0x1a, 0xea, 0xf7, 0xcb, 0xd3, 0x83, 0x91, 0x0c, 0xa1, 0xad, 0xc5, 0x0a,
-	0x73, 0xcd, 0x56, 0x59, 0xd0, 0x18, 0x39, 0x51, 0x83, 0xee, 0x13, 0xe1, 0x3b, 0x68, 0xcd, 0xe8,
-	0x1c, 0x19, 0x47, 0x55, 0x17, 0xe6, 0x59, 0x61, 0x1e, 0x38, 0xd5, 0x88, 0x1c, 0x49, 0x7c, 0x70,
-	0x33, 0x69, 0x20, 0xba, 0xd4, 0xcd, 0x4c, 0x2c, 0x78, 0xe9, 0x9a, 0x2b, 0x78, 0x38, 0x84, 0xd6,
-	0x6c, 0x7e, 0x1b, 0x56, 0xf8, 0x15, 0xe0, 0xdb, 0xf4, 0xf6, 0xf3, 0x63, 0x34, 0xf2, 0x14, 0x7a,
-	0x42, 0xe6, 0x9a, 0x25, 0x09, 0x2b, 0x9e, 0x5d, 0x2c, 0xb8, 0x69, 0xdd, 0xa6, 0x7e, 0x3d, 0xfd,
-	0x96, 0x87, 0x7f, 0x1d, 0x78, 0x30, 0x13, 0x0a, 0x97, 0xfa, 0x03, 0xe6, 0x39, 0xbb, 0xc4, 0x8b,
-	0xe2, 0x81, 0x2e, 0xd3, 0x84, 0x9c, 0x43, 0xa7, 0x68, 0x19, 0x5f, 0x99, 0x9e, 0xa5, 0xb5, 0x7d,
-	0x6b, 0xed, 0x9e, 0x0b, 0x85, 0x5f, 0x7b, 0x5e, 0xcf, 0xa0, 0x3d, 0xa3, 0xd5, 0x05, 0x3b, 0x4e,
-	0xdf, 0x5e, 0xa8, 0x6c, 0xa2, 0x2d, 0x4e, 0x6b, 0xc5, 0x3b, 0x74, 0x3c, 0x28, 0x9e, 0xef, 0x8a,
-	0x2b, 0xe4, 0x00, 0x4e, 0x33, 0xb6, 0x4d, 0x52, 0x66, 0x75, 0x78, 0xb4, 0x0a, 0xc3, 0x3f, 0x2e,
-	0xf4, 0x2a, 0xce, 0xa5, 0x84, 0x3b, 0x3e, 0x88, 0x4f, 0xe0, 0x73, 0xa3, 0x3c, 0x5e, 0xd9, 0x7b,
-	0x01, 0x9a, 0x35, 0x88, 0x6c, 0xf5, 0x11, 0xe8, 0xf8, 0xc0, 0xa5, 0x72, 0x1f, 0x78, 0x3d, 0x47,
-	0x9e, 0x80, 0x9f, 0xad, 0x17, 0x89, 0x58, 0xee, 0x00, 0x7f, 0x18, 0xae, 0x5d, 0x9b, 0x2d, 0xcb,
-	0x06, 0xdf, 0x81, 0x5c, 0xc7, 0xba, 0x61, 0x31, 0xce, 0x0f, 0x17, 0xe3, 0x51, 0x69, 0xce, 0x4d,
-	0xc3, 0xaa, 0x6d, 0xc8, 0xa2, 0x69, 0x7e, 0x31, 0xd3, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff, 0x23,
-	0x4b, 0xe1, 0x7c, 0x76, 0x04, 0x00, 0x00,
+	// 677 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x55, 0xcd, 0x6e, 0xd3, 0x40,
+	0x10, 0x96, 0xed, 0xb4, 0x4d, 0x26, 0xce, 0x0f, 0x4b, 0x8b, 0xac, 0x50, 0x89, 0xc8, 0x2a, 0x6a,
+	0x10, 0x52, 0xa4, 0xb6, 0x87, 0x22, 0x8e, 0x10, 0x44, 0x2b, 0x54, 0xb5, 0xda, 0xf6, 0xc0, 0x05,
+	0x59, 0xdb, 0x78, 0x68, 0x57, 0x38, 0x6b, 0xcb, 0xbb, 0xa9, 0xc8, 0x8d, 0x33, 0x4f, 0xc0, 0x3b,
+	0xf0, 0x0e, 0xbc, 0x0b, 0x6f, 0x82, 0xbc, 0x6b, 0x27, 0x76, 0xea, 0xa2, 0xde, 0x32, 0xe3, 0xd9,
+	0x99, 0xf9, 0xbe, 0x6f, 0x66, 0x02, 0x7d, 0x14, 0xd3, 0x74, 0x91, 0x28, 0x1e, 0x8b, 0x71, 0x92,
+	0xc6, 0x2a, 0x26, 0x8d, 0xe9, 0x2d, 0x53, 0xfe, 0x02, 0xdc, 0x4b, 0x7e, 0x23, 0x30, 0xbc, 0x48,
+	0xf1, 0x13, 0x2e, 0xc8, 0x1e, 0x74, 0xa5, 0xb6, 0x83, 0x24, 0xc5, 0xe0, 0x1b, 0x2e, 0x3c, 0x6b,
+	0x68, 0x8d, 0x5c, 0xea, 0xca, 0x72, 0x94, 0x07, 0x5b, 0x77, 0x98, 0x4a, 0x1e, 0x0b, 0xcf, 0x1e,
+	0x5a, 0xa3, 0x0e, 0x2d, 0x4c, 0xf2, 0x0a, 0x9e, 0xc4, 0x02, 0x03, 0xc5, 0x67, 0x58, 0x64, 0x90,
+	0x9e, 0x33, 0x74, 0x46, 0x2e, 0xed, 0xc6, 0x02, 0xaf, 0xf8, 0x0c, 0x4d, 0x0e, 0xe9, 0xff, 0xb5,
+	0x60, 0xf3, 0xdd, 0x5c, 0x84, 0x11, 0x92, 0x01, 0x34, 0x79, 0x88, 0x42, 0x71, 0x55, 0xd4, 0x5b,
+	0xda, 0xe4, 0x23, 0xf4, 0xaa, 0x1d, 0x49, 0xcf, 0x1e, 0x3a, 0xa3, 0xf6, 0xe1, 0x8b, 0x71, 0x86,
+	0x60, 0x6c, 0x52, 0x8c, 0xcb, 0x28, 0xe4, 0x07, 0xa1, 0xd2, 0x05, 0xed, 0x94, 0x7b, 0x96, 0x64,
+	0x17, 0x5a, 0x99, 0x83, 0xa9, 0x79, 0x8a, 0x5e, 0x43, 0x57, 0x59, 0x39, 0x06, 0x57, 0x40, 0xee,
+	0xa7, 0x20, 0x7d, 0x70, 0x0a, 0x0e, 0x5a, 0x34, 0xfb, 0x49, 0x46, 0xb0, 0x71, 0xc7, 0xa2, 0x39,
+	0x6a, 0xe0, 0xed, 0x43, 0x62, 0x9a, 0x28, 0x3f, 0xa5, 0x26, 0xe0, 0xad, 0xfd, 0xc6, 0xf2, 0x7f,
+	0x5a, 0xd0, 0x33, 0x0d, 0xbe, 0x8f, 0x85, 0x62, 0x5c, 0x60, 0x4a, 0xf6, 0x60, 0xf3, 0x5a, 0xbb,
+	0x74, 0xda, 0xf6, 0xa1, 0x5b, 0xc6, 0x41, 0xf3, 0x6f, 0xe4, 0x08, 0x9e, 0x25, 0x29, 0xbf, 0x63,
+	0x0a, 0x83, 0x35, 0x41, 0x6c, 0xdd, 0xfa, 0xd3, 0xfc, 0x6b, 0x45, 0xbd, 0x5d, 0x68, 0x65, 0xcc,
+	0x4b, 0xc5, 0x66, 0x89, 0xe7, 0x0c, 0xad, 0x91, 0x43, 0x57, 0x0e, 0x5f, 0x42, 0x73, 0x42, 0x4f,
+	0x90, 0x85, 0x98, 0x96, 0x81, 0xb9, 0x06, 0x98, 0x0b, 0x56, 0xa1, 0xa6, 0x25, 0x48, 0x17, 0xec,
+	0x44, 0xe8, 0x14, 0x1d, 0x6a, 0x27, 0xda, 0xe6, 0x61, 0xce, 0x9a, 0xcd, 0x43, 0xb2, 0x0f, 0x3d,
+	0x2e, 0xa4, 0x62, 0x51, 0xc4, 0xb2, 0x99, 0x0a, 0x78, 0xe8, 0x6d, 0x68, 0x92, 0xba, 0x65, 0xf7,
+	0x69, 0xe8, 0xef, 0x42, 0x73, 0x72, 0xf2, 0x50, 0x51, 0xff, 0x87, 0x05, 0xf0, 0xf9, 0xe8, 0xe1,
+	0x80, 0xc7, 0xd4, 0x75, 0xea, 0xea, 0x92, 0x7d, 0xe8, 0xaf, 0x0f, 0xa2, 0xee, 0xd0, 0xa5, 0x9d,
+	0xca, 0x1c, 0xfa, 0x7f, 0x2c, 0xd8, 0x99, 0xf0, 0x14, 0xa7, 0xea, 0x0c, 0xa5, 0x64, 0x37, 0x78,
+	0x91, 0xad, 0xc7, 0x34, 0x8e, 0xc8, 0x01, 0xb4, 0xb3, 0xde, 0x82, 0x5b, 0xdd, 0x5c, 0xae, 0x56,
+	0xdf, 0xa8, 0xb5, 0x6a, 0x9a, 0xc2, 0xf7, 0x15, 0x80, 0xd7, 0xd0, 0x9a, 0xd0, 0xe2, 0x81, 0x99,
+	0x90, 0xae, 0x79, 0x50, 0x30, 0x4f, 0x9b, 0x21, 0x2d, 0x05, 0x2f, 0xb3, 0x63, 0x25, 0xf8, 0x64,
+	0x19, 0x5c, 0x64, 0xf6, 0x60, 0x2b, 0x61, 0x8b, 0x28, 0x66, 0x06, 0xb0, 0x4b, 0x0b, 0xd3, 0xff,
+	0x6d, 0xc1, 0xf6, 0x69, 0x09, 0xfc, 0x19, 0x2a, 0x16, 0x32, 0xc5, 0xfe, 0xbb, 0x55, 0x35, 0x3c,
+	0xda, 0xb5, 0x3c, 0x12, 0x68, 0x08, 0x36, 0xc3, 0x9c, 0x65, 0xfd, 0xbb, 0x3a, 0x66, 0x8d, 0xb5,
+	0x31, 0xab, 0xee, 0xd9, 0xc6, 0xda, 0x9e, 0xf9, 0xc7, 0xe0, 0x5e, 0xa2, 0xcc, 0x6e, 0x05, 0x45,
+	0x89, 0xaa, 0xae, 0x11, 0xab, 0x76, 0x90, 0x7e, 0x39, 0xd0, 0x2b, 0xa4, 0xc9, 0x95, 0x7a, 0xe4,
+	0x2a, 0x9d, 0x43, 0x37, 0xd4, 0x02, 0x07, 0x33, 0xf3, 0xce, 0x43, 0x7d, 0x40, 0x46, 0x26, 0x7a,
+	0x2d, 0xe9, 0xb8, 0x32, 0x0c, 0xf9, 0x25, 0x09, 0xcb, 0x3e, 0xf2, 0x12, 0xba, 0xc9, 0xfc, 0x3a,
+	0xe2, 0xd3, 0x65, 0xc2, 0xaf, 0x66, 0xb2, 0x8c, 0xb7, 0x08, 0x3b, 0x87, 0x9d, 0x0a, 0xb4, 0x59,
+	0x2e, 0x8c, 0x77, 0xa3, 0x9b, 0x1d, 0x98, 0xf2, 0x75, 0xd2, 0xd1, 0x6d, 0x5e, 0x27, 0xe8, 0x31,
+	0x74, 0xa4, 0xe1, 0x2e, 0x48, 0x33, 0xf2, 0xbc, 0xdb, 0xca, 0x0d, 0x2a, 0xd1, 0x4a, 0x5d, 0x59,
+	0xb2, 0x06, 0x5f, 0x80, 0xdc, 0x47, 0x55, 0x73, 0xdc, 0x0e, 0xaa, 0xc7, 0xed, 0x79, 0x3e, 0x8d,
+	0x75, 0xdb, 0x51, 0xba, 0x72, 0xd7, 0x9b, 0xfa, 0x1f, 0xe5, 0xe8, 0xdf, 0x00, 0xfd, 0x60, 0xf9,
+	0x3c, 0x65, 0x06, 0x00, 0x00,
 }
@@ -56,11 +56,11 @@ func (s *EncryptionServiceMultiDeviceSuite) SetupTest() {
 		panic(err)
 	}
 
-	s.alice1 = NewEncryptionService(alicePersistence1, "alice1")
-	s.bob1 = NewEncryptionService(bobPersistence1, "bob1")
+	s.alice1 = NewEncryptionService(alicePersistence1, DefaultEncryptionServiceConfig("alice1"))
+	s.bob1 = NewEncryptionService(bobPersistence1, DefaultEncryptionServiceConfig("bob1"))
 
-	s.alice2 = NewEncryptionService(alicePersistence2, "alice2")
-	s.bob2 = NewEncryptionService(bobPersistence2, "bob2")
+	s.alice2 = NewEncryptionService(alicePersistence2, DefaultEncryptionServiceConfig("alice2"))
+	s.bob2 = NewEncryptionService(bobPersistence2, DefaultEncryptionServiceConfig("bob2"))
 
 }
 
@@ -83,3 +83,283 @@ func (s *EncryptionServiceMultiDeviceSuite) TestProcessPublicBundle() {
 	s.Require().NotNil(alice1MergedBundle1.GetSignedPreKeys()["alice1"])
 	s.Require().NotNil(alice1MergedBundle1.GetSignedPreKeys()["alice2"])
 }
+
+func (s *EncryptionServiceMultiDeviceSuite) TestEncryptPayloadAllInstallations() {
+	cleartext := []byte("message")
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	_, err = s.bob1.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	bob2Bundle, err := s.bob2.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	// Bob's first device knows about the second one
+	err = s.bob1.ProcessPublicBundle(bobKey, bob2Bundle)
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob1.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	err = s.alice1.ProcessPublicBundle(aliceKey, bobBundle)
+	s.Require().NoError(err)
+
+	// Alice sends a message to both devices
+	encryptionResponse, err := s.alice1.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Require().Len(encryptionResponse, 2)
+	s.Require().NotNil(encryptionResponse["bob1"].GetX3DHHeader())
+	s.Require().NotNil(encryptionResponse["bob2"].GetX3DHHeader())
+
+	decryptedPayload, err := s.bob1.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+
+	decryptedPayload, err = s.bob2.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+}
+
+func (s *EncryptionServiceMultiDeviceSuite) TestEncryptPayloadMixedSessions() {
+	cleartext1 := []byte("message 1")
+	cleartext2 := []byte("message 2")
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceBundle, err := s.alice1.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+
+	bob1Bundle, err := s.bob1.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	err = s.alice1.ProcessPublicBundle(aliceKey, bob1Bundle)
+	s.Require().NoError(err)
+
+	err = s.bob1.ProcessPublicBundle(bobKey, aliceBundle)
+	s.Require().NoError(err)
+
+	// Alice and Bob's first device establish a session
+	encryptionResponse, err := s.alice1.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext1)
+	s.Require().NoError(err)
+	s.Require().Len(encryptionResponse, 1)
+
+	_, err = s.bob1.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.bob1.EncryptPayload(&aliceKey.PublicKey, bobKey, cleartext1)
+	s.Require().NoError(err)
+
+	_, err = s.alice1.DecryptPayload(aliceKey, &bobKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	// Bob pairs a second device and Alice receives the updated bundle
+	bob2Bundle, err := s.bob2.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	err = s.bob1.ProcessPublicBundle(bobKey, bob2Bundle)
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob1.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	err = s.alice1.ProcessPublicBundle(aliceKey, bobBundle)
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.alice1.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext2)
+	s.Require().NoError(err)
+	s.Require().Len(encryptionResponse, 2)
+
+	// The existing session is used for the first device
+	s.Require().NotNil(encryptionResponse["bob1"])
+	s.Nil(encryptionResponse["bob1"].GetX3DHHeader(), "It does not add an x3dh header")
+	s.NotNil(encryptionResponse["bob1"].GetDRHeader(), "It adds a DR header")
+
+	// A new session is started with the second device
+	s.Require().NotNil(encryptionResponse["bob2"])
+	s.NotNil(encryptionResponse["bob2"].GetX3DHHeader(), "It adds an x3dh header")
+	s.Equal(bob2Bundle.GetSignedPreKeys()["bob2"].GetSignedPreKey(), encryptionResponse["bob2"].GetX3DHHeader().GetId())
+
+	decryptedPayload, err := s.bob1.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext2, decryptedPayload)
+
+	decryptedPayload, err = s.bob2.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext2, decryptedPayload)
+}
+
+func (s *EncryptionServiceMultiDeviceSuite) TestDecryptPayloadSenderInstallations() {
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	// Both devices establish a session with the same signed prekey of Bob's first device,
+	// the second device receives the bundle once the first one used a one-time prekey
+	for _, alice := range []*EncryptionService{s.alice1, s.alice2} {
+		bobBundle, err := s.bob1.CreateBundle(bobKey)
+		s.Require().NoError(err)
+		s.Require().NoError(alice.ProcessPublicBundle(aliceKey, bobBundle))
+
+		encryptionResponse, err := alice.EncryptPayload(&bobKey.PublicKey, aliceKey, []byte("hello"))
+		s.Require().NoError(err)
+
+		_, err = s.bob1.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+		s.Require().NoError(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, alice := range []*EncryptionService{s.alice1, s.alice2} {
+			cleartext := []byte("message from " + alice.installationID)
+
+			encryptionResponse, err := alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+			s.Require().NoError(err)
+			s.Require().Len(encryptionResponse, 1)
+			s.Equal(alice.installationID, encryptionResponse["bob1"].GetDRHeader().GetInstallationId())
+
+			decryptedPayload, err := s.bob1.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+			s.Require().NoError(err)
+			s.Equal(cleartext, decryptedPayload)
+		}
+	}
+
+	// Bob replies to both devices using their sessions
+	alice2Bundle, err := s.alice2.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.alice1.ProcessPublicBundle(aliceKey, alice2Bundle))
+
+	aliceBundle, err := s.alice1.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.bob1.ProcessPublicBundle(bobKey, aliceBundle))
+
+	cleartext := []byte("reply")
+	encryptionResponse, err := s.bob1.EncryptPayload(&aliceKey.PublicKey, bobKey, cleartext)
+	s.Require().NoError(err)
+	s.Require().Len(encryptionResponse, 2)
+	s.Nil(encryptionResponse["alice1"].GetX3DHHeader(), "It uses the session with the first device")
+	s.Nil(encryptionResponse["alice2"].GetX3DHHeader(), "It uses the session with the second device")
+
+	decryptedPayload, err := s.alice1.DecryptPayload(aliceKey, &bobKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+
+	decryptedPayload, err = s.alice2.DecryptPayload(aliceKey, &bobKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+}
+
+func (s *EncryptionServiceMultiDeviceSuite) TestEncryptPayloadOwnInstallations() {
+	cleartext := []byte("message")
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	_, err = s.alice1.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+
+	alice2Bundle, err := s.alice2.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+
+	err = s.alice1.ProcessPublicBundle(aliceKey, alice2Bundle)
+	s.Require().NoError(err)
+
+	// Messages to own identity are sent to other devices only,
+	// which are enabled when they are learnt from their bundles
+	encryptionResponse, err := s.alice1.EncryptPayload(&aliceKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Require().Len(encryptionResponse, 1)
+	s.Require().NotNil(encryptionResponse["alice2"])
+
+	decryptedPayload, err := s.alice2.DecryptPayload(aliceKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+
+	// A lost device is disabled
+	err = s.alice1.DisableInstallation(aliceKey, "alice2")
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.alice1.EncryptPayload(&aliceKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Require().Empty(encryptionResponse)
+
+	// A disabled device is not enabled again by its next bundle
+	err = s.alice1.ProcessPublicBundle(aliceKey, alice2Bundle)
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.alice1.EncryptPayload(&aliceKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Require().Empty(encryptionResponse)
+
+	err = s.alice1.EnableInstallation(aliceKey, "alice2")
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.alice1.EncryptPayload(&aliceKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Require().Len(encryptionResponse, 1)
+}
+
+func (s *EncryptionServiceMultiDeviceSuite) TestPairInstallations() {
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	// Unknown installations can't be enabled
+	err = s.alice1.EnableInstallation(aliceKey, "alice2")
+	s.Require().Equal(ErrInstallationNotFound, err)
+
+	metadata, err := s.alice2.CreateInstallationMetadata(aliceKey, "desktop")
+	s.Require().NoError(err)
+
+	err = s.alice1.ProcessInstallationMetadata(aliceKey, metadata)
+	s.Require().NoError(err)
+
+	installations, err := s.alice1.GetOurInstallations(aliceKey)
+	s.Require().NoError(err)
+	s.Require().Equal([]*Installation{{
+		ID:        "alice2",
+		Name:      "desktop",
+		Timestamp: metadata.GetTimestamp(),
+		Enabled:   true,
+	}}, installations)
+
+	err = s.alice1.DisableInstallation(aliceKey, "alice2")
+	s.Require().NoError(err)
+
+	// Older metadata is ignored
+	olderMetadata, err := s.alice2.CreateInstallationMetadata(aliceKey, "old name")
+	s.Require().NoError(err)
+	olderMetadata.Timestamp = metadata.GetTimestamp() - 1
+	s.Require().NoError(SignInstallationMetadata(aliceKey, olderMetadata))
+
+	err = s.alice1.ProcessInstallationMetadata(aliceKey, olderMetadata)
+	s.Require().NoError(err)
+
+	installations, err = s.alice1.GetOurInstallations(aliceKey)
+	s.Require().NoError(err)
+	s.Require().Len(installations, 1)
+	s.Equal("desktop", installations[0].Name)
+	s.False(installations[0].Enabled)
+
+	// Metadata of other identities is rejected
+	bobMetadata, err := s.bob1.CreateInstallationMetadata(bobKey, "bob's phone")
+	s.Require().NoError(err)
+	err = s.alice1.ProcessInstallationMetadata(aliceKey, bobMetadata)
+	s.Require().Equal(ErrNotOurInstallation, err)
+
+	// Metadata with an invalid signature is rejected
+	metadata.Name = "changed"
+	err = s.alice1.ProcessInstallationMetadata(aliceKey, metadata)
+	s.Require().Error(err)
+}
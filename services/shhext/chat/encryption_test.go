@@ -47,8 +47,8 @@ func (s *EncryptionServiceTestSuite) initDatabases() {
 		panic(err)
 	}
 
-	s.alice = NewEncryptionService(alicePersistence, aliceInstallationID)
-	s.bob = NewEncryptionService(bobPersistence, bobInstallationID)
+	s.alice = NewEncryptionService(alicePersistence, DefaultEncryptionServiceConfig(aliceInstallationID))
+	s.bob = NewEncryptionService(bobPersistence, DefaultEncryptionServiceConfig(bobInstallationID))
 }
 
 func (s *EncryptionServiceTestSuite) SetupTest() {
@@ -166,7 +166,148 @@ func (s *EncryptionServiceTestSuite) TestEncryptPayloadBundle() {
 	s.Equal(cleartext, decryptedPayload1, "It correctly decrypts the payload using X3DH")
 }
 
+// Alice has Bob's bundle with an installation with an invalid signed prekey
+// Alice sends Bob an encrypted message
+// The message is encrypted for Bob's other installation
+func (s *EncryptionServiceTestSuite) TestEncryptPayloadInstallationFailed() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	// The bundle is stored directly as the broken installation is not signed
+	bobBundle.SignedPreKeys["broken"] = &SignedPreKey{SignedPreKey: []byte{0x01}}
+	err = s.alice.persistence.AddPublicBundle(bobBundle)
+	s.Require().NoError(err)
+
+	encryptionResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Len(encryptionResponse, 1)
+	s.NotNil(encryptionResponse[bobInstallationID])
+
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+
+	// An error is returned if the message can't be encrypted for any installation
+	charlieKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	err = s.alice.persistence.AddPublicBundle(&Bundle{
+		Identity:      crypto.CompressPubkey(&charlieKey.PublicKey),
+		SignedPreKeys: map[string]*SignedPreKey{"broken": {SignedPreKey: []byte{0x02}}},
+	})
+	s.Require().NoError(err)
+
+	_, err = s.alice.EncryptPayload(&charlieKey.PublicKey, aliceKey, cleartext)
+	s.Error(err)
+}
+
 // Alice has Bob's bundle
+// Alice has Bob's bundle with one-time prekeys
+// Alice sends Bob 2 encrypted messages with X3DH using one of them.
+// Bob consumes the one-time prekey and is able to decrypt both messages.
+// Bob's next bundle does not include the consumed key
+func (s *EncryptionServiceTestSuite) TestOneTimePreKeys() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	oneTimePreKeys := bobBundle.GetSignedPreKeys()[bobInstallationID].GetOneTimePreKeys()
+	s.Require().Len(oneTimePreKeys, maxOneTimePreKeys, "It publishes one-time prekeys")
+
+	err = s.alice.ProcessPublicBundle(aliceKey, bobBundle)
+	s.Require().NoError(err)
+
+	encryptionResponse1, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	encryptionResponse2, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+
+	oneTimePreKey := encryptionResponse1[bobInstallationID].GetX3DHHeader().GetOneTimePreKey()
+	s.Require().NotNil(oneTimePreKey, "It uses a one-time prekey")
+	s.Contains(oneTimePreKeys, oneTimePreKey, "It uses a key from the bundle")
+	s.Equal(oneTimePreKey, encryptionResponse2[bobInstallationID].GetX3DHHeader().GetOneTimePreKey(), "It sends the same key until the session is confirmed")
+
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse1)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts the payload using X3DH with a one-time prekey")
+
+	decryptedPayload, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse2)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts the next payload after the key was consumed")
+
+	bobBundle, err = s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.NotContains(bobBundle.GetSignedPreKeys()[bobInstallationID].GetOneTimePreKeys(), oneTimePreKey, "It does not publish the consumed key")
+	s.Len(bobBundle.GetSignedPreKeys()[bobInstallationID].GetOneTimePreKeys(), maxOneTimePreKeys-1)
+}
+
+// Bob publishes one-time prekeys which are consumed by different senders
+// until new ones are generated
+func (s *EncryptionServiceTestSuite) TestOneTimePreKeysReplenished() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	for i := 0; i <= maxOneTimePreKeys-minOneTimePreKeys; i++ {
+		bobBundle, err := s.bob.CreateBundle(bobKey)
+		s.Require().NoError(err)
+		s.Require().Len(bobBundle.GetSignedPreKeys()[bobInstallationID].GetOneTimePreKeys(), maxOneTimePreKeys-i)
+
+		senderDBPath := fmt.Sprintf("/tmp/sender-%d.db", i)
+		os.Remove(senderDBPath)
+		senderPersistence, err := NewSQLLitePersistence(senderDBPath, "sender")
+		s.Require().NoError(err)
+		sender := NewEncryptionService(senderPersistence, DefaultEncryptionServiceConfig(fmt.Sprintf("sender-%d", i)))
+
+		senderKey, err := crypto.GenerateKey()
+		s.Require().NoError(err)
+		s.Require().NoError(sender.ProcessPublicBundle(senderKey, bobBundle))
+
+		encryptionResponse, err := sender.EncryptPayload(&bobKey.PublicKey, senderKey, cleartext)
+		s.Require().NoError(err)
+
+		_, err = s.bob.DecryptPayload(bobKey, &senderKey.PublicKey, encryptionResponse)
+		s.Require().NoError(err)
+	}
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Len(bobBundle.GetSignedPreKeys()[bobInstallationID].GetOneTimePreKeys(), maxOneTimePreKeys, "It generates new one-time prekeys")
+}
+
+// Alice has Bob's bundle without one-time prekeys, as sent by older clients
+// Bob is able to decrypt the message sent with X3DH without a one-time prekey
+func (s *EncryptionServiceTestSuite) TestBundleWithoutOneTimePreKeys() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	bobBundle.GetSignedPreKeys()[bobInstallationID].OneTimePreKeys = nil
+
+	err = s.alice.ProcessPublicBundle(aliceKey, bobBundle)
+	s.Require().NoError(err)
+
+	encryptionResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.Nil(encryptionResponse[bobInstallationID].GetX3DHHeader().GetOneTimePreKey(), "It does not use a one-time prekey")
+
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts the payload using X3DH without a one-time prekey")
+}
+
 // Alice sends Bob 2 encrypted messages with X3DH and DR using an ephemeral key
 // and Bob's bundle.
 // Alice sends another message. This message should be using a DR
@@ -595,3 +736,242 @@ func (s *EncryptionServiceTestSuite) TestRefreshedBundle() {
 	s.Equal(bobBundle2.GetBundle().GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), x3dhHeader2.GetId())
 
 }
+
+// Bob rotates his bundle after Alice sent him a message using the previous one.
+// Bob is still able to decrypt it and publishes the new bundle
+func (s *EncryptionServiceTestSuite) TestRotateBundle() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle1, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	err = s.alice.ProcessPublicBundle(aliceKey, bobBundle1)
+	s.Require().NoError(err)
+
+	encryptionResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+
+	bobBundle2, err := s.bob.RotateBundle(bobKey)
+	s.Require().NoError(err)
+	signedPreKey := bobBundle2.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey()
+	s.NotEqual(bobBundle1.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), signedPreKey, "It creates a new signed prekey")
+
+	bobBundle3, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Equal(signedPreKey, bobBundle3.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), "It publishes the new signed prekey")
+
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts messages sent with the expired bundle")
+}
+
+// Bob's bundle is rotated after the rotation period
+// and deleted with the session established with it after the grace period
+func (s *EncryptionServiceTestSuite) TestRotateBundles() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle1, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	bundleID := bobBundle1.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey()
+
+	err = s.alice.ProcessPublicBundle(aliceKey, bobBundle1)
+	s.Require().NoError(err)
+
+	encryptionResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	// Nothing is rotated before the rotation period
+	s.Require().NoError(s.bob.RotateBundles())
+	bobBundle2, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Equal(bundleID, bobBundle2.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), "It keeps the bundle")
+
+	s.bob.config.BundleRotationPeriod = 0
+	s.Require().NoError(s.bob.RotateBundles())
+	s.bob.config.BundleRotationPeriod = time.Hour
+
+	bobBundle3, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.NotEqual(bundleID, bobBundle3.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), "It rotates the bundle")
+
+	privateKey, err := s.bob.persistence.GetPrivateKeyBundle(bundleID)
+	s.Require().NoError(err)
+	s.NotNil(privateKey, "It keeps the expired bundle during the grace period")
+
+	s.bob.config.BundleGracePeriod = 0
+	s.Require().NoError(s.bob.RotateBundles())
+
+	privateKey, err = s.bob.persistence.GetPrivateKeyBundle(bundleID)
+	s.Require().NoError(err)
+	s.Nil(privateKey, "It deletes the expired bundle")
+
+	session, err := s.bob.persistence.GetSessionStorage().Load(append(bundleID, []byte(aliceInstallationID)...))
+	s.Require().NoError(err)
+	s.Nil(session, "It deletes the session established with the expired bundle")
+
+	bobBundle4, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Equal(bobBundle3.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), bobBundle4.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey(), "It keeps the current bundle")
+}
+
+// Bob receives Alice's messages twice, which must not be reported as a broken session
+// because the session would be reset for no reason
+func (s *EncryptionServiceTestSuite) TestDecryptPayloadTwice() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	aliceBundle, err := s.alice.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+	s.Require().NoError(s.bob.ProcessPublicBundle(bobKey, aliceBundle))
+
+	firstResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, firstResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+
+	// The same message in the current chain
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, firstResponse)
+	s.Equal(ErrMessageConsumed, err, "It does not report a broken session")
+
+	// Alice performs a ratchet step after Bob's reply
+	encryptionResponse, err := s.bob.EncryptPayload(&aliceKey.PublicKey, bobKey, cleartext)
+	s.Require().NoError(err)
+	_, err = s.alice.DecryptPayload(aliceKey, &bobKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.NotEqual(firstResponse[bobInstallationID].GetDRHeader().GetKey(), encryptionResponse[bobInstallationID].GetDRHeader().GetKey())
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	// The same messages in the current and the previous chain
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Equal(ErrMessageConsumed, err, "It does not report a broken session")
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, firstResponse)
+	s.Equal(ErrMessageConsumed, err, "It does not report a broken session")
+
+	// The session still works
+	encryptionResponse, err = s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	decryptedPayload, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload)
+}
+
+// Alice's session with Bob gets out of sync after a conversation, so that Bob can't decrypt her messages.
+// Alice discards the session when she receives Bob's session reset and establishes a new one
+func (s *EncryptionServiceTestSuite) TestResetDesynchronisedSession() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+
+	aliceBundle, err := s.alice.CreateBundle(aliceKey)
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+	s.Require().NoError(s.bob.ProcessPublicBundle(bobKey, aliceBundle))
+
+	encryptionResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.bob.EncryptPayload(&aliceKey.PublicKey, bobKey, cleartext)
+	s.Require().NoError(err)
+	_, err = s.alice.DecryptPayload(aliceKey, &bobKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	// Alice loses the state of her ratchet
+	_, err = s.alice.persistence.(*SQLLitePersistence).db.Exec("DELETE FROM sessions")
+	s.Require().NoError(err)
+
+	encryptionResponse, err = s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Equal(ErrDecryptionFailed, err)
+
+	// Bob asks Alice to reset the session
+	bobBundle, err = s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+	s.Require().NoError(s.alice.ResetSession(&bobKey.PublicKey, bobInstallationID))
+
+	encryptionResponse, err = s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	s.NotNil(encryptionResponse[bobInstallationID].GetX3DHHeader(), "It establishes a new session")
+
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts messages sent with the new session")
+
+	// Bob replies using the new session
+	encryptionResponse, err = s.bob.EncryptPayload(&aliceKey.PublicKey, bobKey, cleartext)
+	s.Require().NoError(err)
+	decryptedPayload, err = s.alice.DecryptPayload(aliceKey, &bobKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts the reply")
+}
+
+// Bob loses his database, so that he can't decrypt messages sent with the previous session.
+// Alice establishes a new session with his new bundle after he resets the session
+func (s *EncryptionServiceTestSuite) TestResetSessionNotFound() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+
+	encryptionResponse, err := s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+
+	os.Remove("/tmp/bob.db")
+	s.initDatabases()
+
+	encryptionResponse, err = s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Equal(ErrSessionNotFound, err)
+
+	bobBundle, err = s.bob.CreateBundle(bobKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+	s.Require().NoError(s.alice.ResetSession(&bobKey.PublicKey, bobInstallationID))
+
+	encryptionResponse, err = s.alice.EncryptPayload(&bobKey.PublicKey, aliceKey, cleartext)
+	s.Require().NoError(err)
+	decryptedPayload, err := s.bob.DecryptPayload(bobKey, &aliceKey.PublicKey, encryptionResponse)
+	s.Require().NoError(err)
+	s.Equal(cleartext, decryptedPayload, "It decrypts messages sent with the new session")
+}
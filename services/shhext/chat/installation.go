@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func buildInstallationSignatureMaterial(metadata *InstallationMetadata) []byte {
+	var signatureMaterial []byte
+
+	signatureMaterial = append(signatureMaterial, metadata.GetIdentity()...)
+
+	// Strings are prefixed with their length, so that the material is not ambiguous
+	for _, s := range []string{metadata.GetInstallationId(), metadata.GetName()} {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(s)))
+		signatureMaterial = append(signatureMaterial, length...)
+		signatureMaterial = append(signatureMaterial, []byte(s)...)
+	}
+
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(metadata.GetTimestamp()))
+	return append(signatureMaterial, timestamp...)
+}
+
+// SignInstallationMetadata signs the installation metadata with the identity key
+func SignInstallationMetadata(identity *ecdsa.PrivateKey, metadata *InstallationMetadata) error {
+	signature, err := crypto.Sign(crypto.Keccak256(buildInstallationSignatureMaterial(metadata)), identity)
+	if err != nil {
+		return err
+	}
+	metadata.Signature = signature
+	return nil
+}
+
+// VerifyInstallationMetadata checks that the installation metadata is signed by its identity key
+func VerifyInstallationMetadata(metadata *InstallationMetadata) error {
+	identityKey, err := crypto.DecompressPubkey(metadata.GetIdentity())
+	if err != nil {
+		return err
+	}
+
+	recoveredKey, err := crypto.SigToPub(
+		crypto.Keccak256(buildInstallationSignatureMaterial(metadata)),
+		metadata.GetSignature(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if crypto.PubkeyToAddress(*recoveredKey) != crypto.PubkeyToAddress(*identityKey) {
+		return errors.New("identity key and signature mismatch")
+	}
+
+	return nil
+}
@@ -2,6 +2,16 @@
 // sources:
 // 1536754952_initial_schema.down.sql
 // 1536754952_initial_schema.up.sql
+// 1539249977_create_installations.down.sql
+// 1539249977_create_installations.up.sql
+// 1539606224_create_one_time_pre_keys.down.sql
+// 1539606224_create_one_time_pre_keys.up.sql
+// 1539780617_add_bundles_expired_at.down.sql
+// 1539780617_add_bundles_expired_at.up.sql
+// 1540715431_add_installation_id_to_ratchet_info.down.sql
+// 1540715431_add_installation_id_to_ratchet_info.up.sql
+// 1540900000_create_received_ratchet_keys.down.sql
+// 1540900000_create_received_ratchet_keys.up.sql
 // static.go
 // DO NOT EDIT!
 
@@ -110,6 +120,206 @@ func _1536754952_initial_schemaUpSql() (*asset, error) {
 	return a, nil
 }
 
+var __1539249977_create_installationsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x1a\x00\xe5\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x69\x6e\x73\x74\x61\x6c\x6c\x61\x74\x69\x6f\x6e\x73\x3b\x0a\x03\x00\xd8\xbf\x14\x75\x1a\x00\x00\x00")
+
+func _1539249977_create_installationsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1539249977_create_installationsDownSql,
+		"1539249977_create_installations.down.sql",
+	)
+}
+
+func _1539249977_create_installationsDownSql() (*asset, error) {
+	bytes, err := _1539249977_create_installationsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1539249977_create_installations.down.sql", size: 26, mode: os.FileMode(420), modTime: time.Unix(1792202105, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1539249977_create_installationsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x6c\x8e\x31\x6b\x85\x30\x14\x46\xf7\xfc\x8a\x6f\x53\xc1\xa1\x7b\xa7\x44\xaf\x12\x9a\xde\x94\x34\x42\x9d\x4a\x8a\x19\x02\x9a\x16\xcc\xd2\x7f\xff\x70\x78\x43\x1e\x6f\xbd\xf7\x9c\x8f\x33\x38\x92\x9e\xe0\xa5\x32\x84\x94\xcf\x12\xf6\x3d\x94\xf4\x9b\x4f\xb4\x02\x48\x5b\xcc\x25\x95\x7f\x28\x63\x15\xd8\x7a\xf0\x62\x4c\x2f\x50\xb1\xdf\x69\x83\xa7\x2f\x5f\x01\x39\x1c\xb1\xbe\x62\xa4\x49\x2e\xc6\xa3\x69\xae\x85\x92\x8e\x78\x96\x70\xfc\x61\xe1\x4f\x3d\x33\x8d\x50\x7a\x86\xe6\x27\xc6\xcb\x25\xc4\x1c\x7e\xf6\xb8\x41\x59\x6b\x48\x72\xfd\xfc\x70\xfa\x5d\xba\x15\x6f\xb4\xa2\xbd\x67\xf7\x8f\x99\x1d\x2c\x63\xb0\x3c\x19\x3d\x78\xe8\x99\xad\x23\xd1\xbd\x8a\xdb\x00\xe0\x26\x8e\x58\x07\x01\x00\x00")
+
+func _1539249977_create_installationsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1539249977_create_installationsUpSql,
+		"1539249977_create_installations.up.sql",
+	)
+}
+
+func _1539249977_create_installationsUpSql() (*asset, error) {
+	bytes, err := _1539249977_create_installationsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1539249977_create_installations.up.sql", size: 263, mode: os.FileMode(420), modTime: time.Unix(1792202105, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1539606224_create_one_time_pre_keysDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x74\x51\xcd\x4e\xb4\x30\x14\xdd\xf7\x29\xee\x72\x48\x58\x7c\x7b\x56\x4c\xbf\x8b\x21\x76\xda\xb1\x53\x12\x5d\x35\x38\x5c\x9d\x46\x28\x13\xa8\x26\xbc\xbd\xc1\x8c\x60\x25\x6e\x7b\x4e\xef\xf9\xfb\xaf\xd5\x11\x4c\xbe\x17\x08\xbd\x27\x1b\x5c\x47\xf6\x3a\x90\x7d\xa3\x69\xcc\x18\xe3\x1a\x73\x83\x37\xc2\x50\x87\xf3\x85\x82\x75\xfe\xa5\xb7\x1f\xff\x60\xc7\x00\x9e\xdf\x7d\xd3\x92\x75\x0d\xec\x85\xda\x83\x54\x06\x64\x25\x44\xca\x00\xe8\x7a\xa1\x8e\x86\xba\x9d\x8f\x7d\xc1\xf3\xab\x6b\xc8\x07\x17\xa6\x2d\x7f\x9c\xba\x8e\xc2\xe0\xce\x0b\x3f\x82\x9d\x1f\x43\xdd\xb6\x75\x70\xbd\x9f\xf5\x0c\x3e\x9a\x88\x50\xc9\xf2\xa1\xc2\xdd\xe2\x28\x5d\xb4\x12\x50\x12\xb8\x92\x85\x28\xb9\x01\x8d\x47\x91\x73\x9c\x25\x0b\xa5\xb1\xbc\x93\x70\x8f\x4f\xb0\x7e\x4c\x40\x63\x81\x1a\x25\xc7\xd3\x2d\xe0\xb8\x1b\xdd\xab\xa7\xe6\xbb\x9b\x84\x25\x19\x63\xa5\x3c\xa1\x36\x50\x4a\xa3\x36\xe5\x9c\x50\x20\x37\x6b\x3f\x69\xdc\xc7\x6a\x2e\x8d\x83\xa7\x9b\xa0\x85\x56\x87\xe8\x7c\xc6\x7e\xac\x16\x03\xb9\x30\xa8\xff\x98\x4b\xa3\xcc\x0f\x08\xbf\xbc\x66\xec\x73\x00\x85\xe0\x24\x0e\x03\x02\x00\x00")
+
+func _1539606224_create_one_time_pre_keysDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1539606224_create_one_time_pre_keysDownSql,
+		"1539606224_create_one_time_pre_keys.down.sql",
+	)
+}
+
+func _1539606224_create_one_time_pre_keysDownSql() (*asset, error) {
+	bytes, err := _1539606224_create_one_time_pre_keysDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1539606224_create_one_time_pre_keys.down.sql", size: 515, mode: os.FileMode(420), modTime: time.Unix(1792206595, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1539606224_create_one_time_pre_keysUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x64\x90\x41\x4b\xc4\x30\x10\x85\xef\xfd\x15\xef\xa8\xe0\xc1\xfb\x9e\x92\x76\xb6\x04\x67\x27\x52\x53\x70\x4f\x25\x6e\x23\x06\xbb\x69\x69\xb3\xc2\xfe\x7b\x09\x88\xb2\x78\x9d\x37\xef\xe3\x9b\xa9\x3b\x52\x8e\xe0\x94\x66\xc2\x9c\xc2\x90\xe3\x39\x0c\xcb\x1a\x86\xcf\x70\xdd\x70\x57\x01\x71\x0c\x29\xc7\x7c\x85\x66\xab\x21\xd6\x41\x7a\xe6\x87\x92\xa4\x2d\xfb\x69\xf2\x39\xce\x69\x88\x23\x1c\xbd\xba\x9b\x85\xe5\xf2\x36\xc5\x53\x41\xdd\x96\xf1\xdc\x99\x83\xea\x8e\x78\xa2\x23\xac\xa0\xb6\xb2\x67\x53\x3b\x98\x56\x6c\x47\x85\xbd\xac\xf1\xcb\xe7\xf0\xdb\x2d\xb3\xcb\x16\x46\x68\x6b\x99\x94\xa0\xa1\xbd\xea\xd9\xe1\xb1\x24\xc5\x7a\xcb\xfe\xbc\xa0\x97\x17\xd3\x0a\x35\xd0\xa6\x85\x91\x3f\x9f\xea\x7e\x57\x55\x8a\x1d\x75\x3f\xd7\xae\x3e\x9f\x3e\x42\x1e\x62\x7a\x9f\xa1\x9a\x06\xb5\xe5\xfe\x20\xff\xbe\x00\xcd\x56\xef\xaa\xef\x01\x00\xb0\x4b\x42\x1e\x2a\x01\x00\x00")
+
+func _1539606224_create_one_time_pre_keysUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1539606224_create_one_time_pre_keysUpSql,
+		"1539606224_create_one_time_pre_keys.up.sql",
+	)
+}
+
+func _1539606224_create_one_time_pre_keysUpSql() (*asset, error) {
+	bytes, err := _1539606224_create_one_time_pre_keysUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1539606224_create_one_time_pre_keys.up.sql", size: 298, mode: os.FileMode(420), modTime: time.Unix(1792202353, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1539780617_add_bundles_expired_atDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x92\x4f\x6f\xa3\x30\x14\xc4\xef\xfe\x14\x73\xdc\x5d\x71\xd8\x9e\x39\x19\x78\x89\xac\x1a\x3b\x32\x8e\xd4\x9c\x10\x09\x6e\x6b\x25\x21\x08\x9c\xa8\xfd\xf6\x15\x69\xfe\x91\x46\xbd\xf2\xde\x30\xf3\x9b\xe7\xd4\x10\xb7\x04\x4b\xf9\x4c\x1b\x6e\x16\xb0\x3c\x91\x84\xae\x0a\xab\x77\x17\x4a\xdf\xbc\xee\xca\x65\xb5\x5a\xef\x5b\xf0\x02\x05\x49\x4a\x2d\xfe\x61\x62\x74\x3e\x5a\x8a\x59\x46\x92\x2c\x3d\x9a\xb0\xb3\xc9\xf1\xd7\xcb\x7d\x53\x6f\x5c\x5f\x1e\x9e\xf0\x87\x01\xbe\x76\x4d\xf0\xe1\x13\x89\xd4\x09\x94\xb6\x50\x73\x29\xa3\x61\xd2\xf4\xa1\xda\x6c\xaa\xe0\x77\x4d\xe9\x6b\x58\x7a\xb1\xa3\x85\xb6\xf3\x87\x2a\xb8\x72\xed\xbe\xd5\x83\xa8\xf7\x6f\x8d\xab\xcb\xb6\xbb\x7e\xbe\x68\x30\x33\x22\x1f\x18\x9f\x69\x01\xad\x90\x6a\x35\x91\x22\xb5\x10\x53\xa5\x0d\x0d\xf2\xe0\xb7\xae\x0f\xd5\xb6\xc5\x5c\x15\x62\xaa\x28\x43\x22\xa6\x10\x6a\xec\xec\x3e\x5a\xdf\xb9\x1a\x89\xd6\x92\xb8\x42\x46\x13\x3e\x97\x16\xff\xd9\xdf\x98\x31\xa1\x0a\x32\x76\x10\xe9\x5b\xda\x53\x79\x67\xe0\xe8\x1e\x30\xba\x05\x8a\xee\x48\xa2\x6b\xb4\xe8\x62\x7f\xec\xfa\xe4\x10\xb3\xcc\xe8\xd9\xb8\xe3\x98\x71\x69\xc9\xfc\x2c\xde\x90\xe2\x39\xe1\x9a\xef\x2e\xf5\xed\xfd\x7e\x39\xfa\xe9\x65\x8c\xac\x1f\xce\xbf\x06\x00\x55\x28\x0f\x96\x66\x02\x00\x00")
+
+func _1539780617_add_bundles_expired_atDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1539780617_add_bundles_expired_atDownSql,
+		"1539780617_add_bundles_expired_at.down.sql",
+	)
+}
+
+func _1539780617_add_bundles_expired_atDownSql() (*asset, error) {
+	bytes, err := _1539780617_add_bundles_expired_atDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1539780617_add_bundles_expired_at.down.sql", size: 614, mode: os.FileMode(420), modTime: time.Unix(1792205147, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1539780617_add_bundles_expired_atUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4f\x00\xb0\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x62\x75\x6e\x64\x6c\x65\x73\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x65\x78\x70\x69\x72\x65\x64\x5f\x61\x74\x20\x55\x4e\x53\x49\x47\x4e\x45\x44\x20\x42\x49\x47\x20\x49\x4e\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x30\x3b\x0a\x03\x00\x31\x1a\x8e\x55\x4f\x00\x00\x00")
+
+func _1539780617_add_bundles_expired_atUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1539780617_add_bundles_expired_atUpSql,
+		"1539780617_add_bundles_expired_at.up.sql",
+	)
+}
+
+func _1539780617_add_bundles_expired_atUpSql() (*asset, error) {
+	bytes, err := _1539780617_add_bundles_expired_atUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1539780617_add_bundles_expired_at.up.sql", size: 79, mode: os.FileMode(420), modTime: time.Unix(1792202643, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1540715431_add_installation_id_to_ratchet_infoDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x74\x91\xc1\x4e\xeb\x30\x10\x45\xf7\xfe\x8a\x59\x36\x92\x37\x6f\x9d\x55\xea\x37\x41\x11\xae\x5d\x5c\x57\x82\x95\x15\x9a\x81\x5a\x24\x6e\x95\x18\xa4\xfc\x3d\x0a\x82\x44\x6e\x60\xeb\xb9\xd6\x3d\x73\x46\x18\x2c\x2c\x82\x2d\xb6\x12\xa1\xaf\xe3\xe9\x4c\xd1\xf9\xf0\x72\x71\x1f\xff\x60\xc3\x00\x9e\xdf\x43\xd3\x92\xf3\x0d\x6c\xa5\xde\x82\xd2\x16\xd4\x51\x4a\xce\x00\xe8\x7a\xa6\x8e\xfa\xba\x75\x6f\x34\x7e\x8d\xa7\x57\xdf\x50\x88\x3e\x8e\xeb\xfc\x30\x76\x1d\xc5\xde\x9f\xe6\x7c\x32\xf6\x61\x88\x75\xdb\xd6\xd1\x5f\xc2\xd4\x67\xf1\xd1\x26\x81\x4b\x20\x17\x7d\x47\xee\xda\x53\x52\x79\x54\xd5\xc3\x11\x37\x33\x2a\x9f\x21\x32\xd0\x0a\x84\x56\xa5\xac\x84\x05\x83\x7b\x59\x08\x9c\x28\x4b\x6d\xb0\xba\x53\x70\x8f\x4f\xb0\x7c\xcc\xc0\x60\x89\x06\x95\xc0\xc3\xf7\xe6\xc3\x66\xf0\xaf\x81\x9a\x9f\xd2\x8c\x65\x39\x63\x95\x3a\xa0\xb1\x50\x29\xab\x57\xd6\x0e\x28\x51\xd8\x45\x1c\x4f\x45\x2d\x70\x3c\x35\xc2\x6f\x0d\xf0\xf5\xc6\xa5\xd1\xbb\xa4\x30\x67\xff\x8d\xde\xff\x72\xbf\x9c\x15\xd2\xa2\xf9\xe3\xb2\x06\x55\xb1\x43\xb8\xa1\xcf\xd9\xe7\x00\xff\xec\xc2\x8f\x0f\x02\x00\x00")
+
+func _1540715431_add_installation_id_to_ratchet_infoDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1540715431_add_installation_id_to_ratchet_infoDownSql,
+		"1540715431_add_installation_id_to_ratchet_info.down.sql",
+	)
+}
+
+func _1540715431_add_installation_id_to_ratchet_infoDownSql() (*asset, error) {
+	bytes, err := _1540715431_add_installation_id_to_ratchet_infoDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1540715431_add_installation_id_to_ratchet_info.down.sql", size: 527, mode: os.FileMode(420), modTime: time.Unix(1792204972, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1540715431_add_installation_id_to_ratchet_infoUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x74\x91\xc1\x4e\x84\x30\x10\x86\xef\x7d\x8a\x39\x2e\x09\x27\xaf\x9c\xd8\x3a\x18\x62\xb7\x5d\xbb\x25\xd1\x53\x83\xcb\xe8\x36\x42\xd9\x40\x35\xe1\xed\x0d\x46\x59\xbb\xe8\x75\xe6\xcf\xfc\x5f\xbe\xe1\x1a\x73\x83\x60\xf2\xad\x40\x18\xea\x70\x3c\x51\xb0\xce\xbf\xf4\xf6\xe3\x06\x36\x0c\xe0\xf9\xdd\x37\x2d\x59\xd7\xc0\x56\xa8\x2d\x48\x65\x40\x56\x42\xa4\x0c\x80\xce\x27\xea\x68\xa8\x5b\xfb\x46\xd3\xd7\x7a\x9e\xba\x86\x7c\x70\x61\x5a\xe7\xc7\xa9\xeb\x28\x0c\xee\xb8\xe4\xa3\xb5\xf3\x63\xa8\xdb\xb6\x0e\xae\xf7\x73\x9f\xc1\x47\x13\x05\x7a\x4f\x36\xb8\x8e\xec\x79\xa0\xa8\xb2\x92\xe5\x43\x85\x9b\x05\x35\x5d\x20\xd2\xeb\xab\x09\x28\x09\x5c\xc9\x42\x94\xdc\x80\xc6\xbd\xc8\x39\xce\xd8\x85\xd2\x58\xde\x49\xb8\xc7\x27\xb8\x5c\x4a\x40\x63\x81\x1a\x25\xc7\xc3\xb7\x8a\x71\x33\xba\x57\x4f\xcd\x0f\x45\xc2\x92\x8c\xb1\x52\x1e\x50\x1b\x28\xa5\x51\x2b\x8d\x07\x14\xc8\xcd\xc5\x64\x1a\x9b\xfb\x4d\x1b\x29\x5a\xc1\xa7\x6b\x05\x85\x56\xbb\xa8\x30\x63\xb7\x5a\xed\xff\x78\x68\xc6\x72\x61\x50\xff\xf3\x6a\x8d\x32\xdf\x21\x5c\xd1\x67\xec\x73\x00\xf7\x3e\x89\x5f\x20\x02\x00\x00")
+
+func _1540715431_add_installation_id_to_ratchet_infoUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1540715431_add_installation_id_to_ratchet_infoUpSql,
+		"1540715431_add_installation_id_to_ratchet_info.up.sql",
+	)
+}
+
+func _1540715431_add_installation_id_to_ratchet_infoUpSql() (*asset, error) {
+	bytes, err := _1540715431_add_installation_id_to_ratchet_infoUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1540715431_add_installation_id_to_ratchet_info.up.sql", size: 544, mode: os.FileMode(420), modTime: time.Unix(1792204972, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1540900000_create_received_ratchet_keysDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x22\x00\xdd\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x72\x65\x63\x65\x69\x76\x65\x64\x5f\x72\x61\x74\x63\x68\x65\x74\x5f\x6b\x65\x79\x73\x3b\x0a\x03\x00\x6a\x95\x56\x96\x22\x00\x00\x00")
+
+func _1540900000_create_received_ratchet_keysDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1540900000_create_received_ratchet_keysDownSql,
+		"1540900000_create_received_ratchet_keys.down.sql",
+	)
+}
+
+func _1540900000_create_received_ratchet_keysDownSql() (*asset, error) {
+	bytes, err := _1540900000_create_received_ratchet_keysDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1540900000_create_received_ratchet_keys.down.sql", size: 34, mode: os.FileMode(420), modTime: time.Unix(1792205233, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __1540900000_create_received_ratchet_keysUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x64\xcb\x41\x0a\xc2\x30\x10\x46\xe1\x7d\x4e\xf1\x2f\x5b\xe8\x0d\x5c\x35\x21\x4a\x60\x98\xc1\x92\xac\x83\xa6\x03\x06\x45\xa5\xa9\x82\xb7\x17\x57\x0a\xae\xdf\xf7\xdc\xe4\xc7\xe8\x11\x47\x4b\x1e\x8b\x16\xad\x4f\x9d\xf3\x72\x58\xcb\x49\xd7\x7c\xd6\x57\x43\x67\x80\xa6\xad\xd5\xdb\x35\xd7\x19\x96\xc4\x82\x25\x82\x13\xd1\x60\x80\xfb\xe3\x78\xa9\xe5\x63\xff\x5b\xe2\xb0\x4f\xbe\xfb\xee\xc3\x0f\xef\x21\x0c\x27\xbc\xa5\xe0\x22\xc2\x8e\x65\xf2\xa6\xdf\x98\xf7\x00\x6f\x06\x37\xdd\x94\x00\x00\x00")
+
+func _1540900000_create_received_ratchet_keysUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__1540900000_create_received_ratchet_keysUpSql,
+		"1540900000_create_received_ratchet_keys.up.sql",
+	)
+}
+
+func _1540900000_create_received_ratchet_keysUpSql() (*asset, error) {
+	bytes, err := _1540900000_create_received_ratchet_keysUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "1540900000_create_received_ratchet_keys.up.sql", size: 148, mode: os.FileMode(420), modTime: time.Unix(1792205233, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _staticGo = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x54\xcc\x41\x8a\x02\x31\x10\x46\xe1\x7d\x4e\xf1\x2f\x67\x60\x3a\xb5\x9f\x13\x0c\x83\x82\xa0\x17\xa8\x4e\x17\x95\xa2\xe9\xa4\x49\x95\xe2\xf1\xdd\x28\xe2\xf2\xc1\xe3\x23\xc2\x89\xcb\xca\x2a\xf0\xe0\xb0\x02\xd9\x66\x59\xfc\x55\x5f\xff\xe7\x1f\xfc\x5d\x8e\x87\x6f\x0c\xf1\x7e\x1d\x45\x1c\xc3\xb4\x06\xac\x45\x47\x54\xc1\x6c\x8d\x87\x89\xa7\xfd\x43\x4a\x89\x48\xfb\xaf\x4a\x93\xc1\x21\xd0\x3e\xcd\xd6\x16\x0e\xc6\xb4\xaf\x8a\xcd\x74\x70\x58\x6f\x8e\xa9\x23\x67\xca\x99\x5c\xc6\xcd\x8a\x38\x79\xad\x72\x0f\x2a\x95\x83\xde\x23\x3d\x81\xac\x1d\x39\x3d\x02\x00\x00\xff\xff\x7c\xfc\xfc\x0b\xbc\x00\x00\x00")
 
 func staticGoBytes() ([]byte, error) {
@@ -184,6 +394,16 @@ func AssetNames() []string {
 var _bindata = map[string]func() (*asset, error){
 	"1536754952_initial_schema.down.sql": _1536754952_initial_schemaDownSql,
 	"1536754952_initial_schema.up.sql": _1536754952_initial_schemaUpSql,
+	"1539249977_create_installations.down.sql": _1539249977_create_installationsDownSql,
+	"1539249977_create_installations.up.sql": _1539249977_create_installationsUpSql,
+	"1539606224_create_one_time_pre_keys.down.sql": _1539606224_create_one_time_pre_keysDownSql,
+	"1539606224_create_one_time_pre_keys.up.sql": _1539606224_create_one_time_pre_keysUpSql,
+	"1539780617_add_bundles_expired_at.down.sql": _1539780617_add_bundles_expired_atDownSql,
+	"1539780617_add_bundles_expired_at.up.sql": _1539780617_add_bundles_expired_atUpSql,
+	"1540715431_add_installation_id_to_ratchet_info.down.sql": _1540715431_add_installation_id_to_ratchet_infoDownSql,
+	"1540715431_add_installation_id_to_ratchet_info.up.sql": _1540715431_add_installation_id_to_ratchet_infoUpSql,
+	"1540900000_create_received_ratchet_keys.down.sql": _1540900000_create_received_ratchet_keysDownSql,
+	"1540900000_create_received_ratchet_keys.up.sql": _1540900000_create_received_ratchet_keysUpSql,
 	"static.go": staticGo,
 }
 
@@ -229,6 +449,16 @@ type bintree struct {
 var _bintree = &bintree{nil, map[string]*bintree{
 	"1536754952_initial_schema.down.sql": &bintree{_1536754952_initial_schemaDownSql, map[string]*bintree{}},
 	"1536754952_initial_schema.up.sql": &bintree{_1536754952_initial_schemaUpSql, map[string]*bintree{}},
+	"1539249977_create_installations.down.sql": &bintree{_1539249977_create_installationsDownSql, map[string]*bintree{}},
+	"1539249977_create_installations.up.sql": &bintree{_1539249977_create_installationsUpSql, map[string]*bintree{}},
+	"1539606224_create_one_time_pre_keys.down.sql": &bintree{_1539606224_create_one_time_pre_keysDownSql, map[string]*bintree{}},
+	"1539606224_create_one_time_pre_keys.up.sql": &bintree{_1539606224_create_one_time_pre_keysUpSql, map[string]*bintree{}},
+	"1539780617_add_bundles_expired_at.down.sql": &bintree{_1539780617_add_bundles_expired_atDownSql, map[string]*bintree{}},
+	"1539780617_add_bundles_expired_at.up.sql": &bintree{_1539780617_add_bundles_expired_atUpSql, map[string]*bintree{}},
+	"1540715431_add_installation_id_to_ratchet_info.down.sql": &bintree{_1540715431_add_installation_id_to_ratchet_infoDownSql, map[string]*bintree{}},
+	"1540715431_add_installation_id_to_ratchet_info.up.sql": &bintree{_1540715431_add_installation_id_to_ratchet_infoUpSql, map[string]*bintree{}},
+	"1540900000_create_received_ratchet_keys.down.sql": &bintree{_1540900000_create_received_ratchet_keysDownSql, map[string]*bintree{}},
+	"1540900000_create_received_ratchet_keys.up.sql": &bintree{_1540900000_create_received_ratchet_keysUpSql, map[string]*bintree{}},
 	"static.go": &bintree{staticGo, map[string]*bintree{}},
 }}
 
@@ -16,6 +16,20 @@ type RatchetInfo struct {
 	BundleID       []byte
 	EphemeralKey   []byte
 	InstallationID string
+	// OneTimePreKey is the one-time prekey used to establish the session, if any
+	OneTimePreKey []byte
+}
+
+// Installation holds the state of an installation of an identity
+type Installation struct {
+	// ID is the installation ID
+	ID string `json:"id"`
+	// Name is the device name sent while pairing
+	Name string `json:"name"`
+	// Timestamp is the creation time of the latest processed installation metadata
+	Timestamp int64 `json:"timestamp"`
+	// Enabled is set if messages are encrypted for the installation
+	Enabled bool `json:"enabled"`
 }
 
 // PersistenceService defines the interface for a storage service
@@ -36,16 +50,47 @@ type PersistenceService interface {
 	GetPrivateKeyBundle([]byte) ([]byte, error)
 	// AddPrivateBundle persists a BundleContainer
 	AddPrivateBundle(*BundleContainer) error
-	// MarkBundleExpired marks a bundle as expired, not to be used for encryption anymore
+	// MarkBundleExpired marks our bundle as expired, not to be used for encryption anymore
 	MarkBundleExpired([]byte) error
+	// MarkBundlesExpired marks our bundles created before the specified time as expired
+	MarkBundlesExpired(int64) error
+	// DeleteExpiredBundles deletes our bundles which expired before the specified time,
+	// together with sessions established with them
+	DeleteExpiredBundles(int64) error
 
 	// AddRatchetInfo persists the specified ratchet info
-	AddRatchetInfo([]byte, []byte, []byte, []byte, string) error
-	// GetRatchetInfo retrieves the existing RatchetInfo for a specified bundle ID and interlocutor public key
-	GetRatchetInfo([]byte, []byte) (*RatchetInfo, error)
+	AddRatchetInfo([]byte, []byte, []byte, []byte, []byte, string) error
+	// GetRatchetInfo retrieves the existing RatchetInfo for a specified bundle ID, interlocutor public key and installation
+	GetRatchetInfo([]byte, []byte, string) (*RatchetInfo, error)
 	// GetAnyRatchetInfo retrieves any existing RatchetInfo for a specified interlocutor public key
 	GetAnyRatchetInfo([]byte, string) (*RatchetInfo, error)
 	// RatchetInfoConfirmed clears the ephemeral key in the RatchetInfo
-	// associated with the specified bundle ID and interlocutor identity public key
-	RatchetInfoConfirmed([]byte, []byte) error
+	// associated with the specified bundle ID, interlocutor identity public key and installation
+	RatchetInfoConfirmed([]byte, []byte, string) error
+	// DeleteRatchetInfo deletes the ratchet info and sessions established with an installation of their identity
+	DeleteRatchetInfo([]byte, string) error
+	// IsRatchetKeyReceived returns true if the session with the specified ID received the ratchet public key
+	IsRatchetKeyReceived([]byte, []byte) (bool, error)
+
+	// AddInstallations persists the specified installations of an identity, keeping existing ones unchanged
+	AddInstallations([]byte, []string, bool) error
+	// SetInstallationMetadata sets the name of an installation if the timestamp is newer
+	SetInstallationMetadata([]byte, string, string, int64) error
+	// SetInstallationEnabled enables or disables an installation
+	SetInstallationEnabled([]byte, string, bool) error
+	// GetInstallations retrieves all installations of an identity
+	GetInstallations([]byte) ([]*Installation, error)
+
+	// AddPrivateOneTimePreKeys persists one-time prekeys generated for an installation of our identity
+	AddPrivateOneTimePreKeys([]byte, string, []*ecdsa.PrivateKey) error
+	// GetOneTimePreKeys retrieves public keys of our one-time prekeys generated for an installation
+	GetOneTimePreKeys([]byte, string) ([][]byte, error)
+	// GetAnyOneTimePreKey retrieves any public one-time prekey of their installation which was not used
+	GetAnyOneTimePreKey([]byte, string) ([]byte, error)
+	// GetPrivateOneTimePreKey retrieves the private key of our one-time prekey
+	GetPrivateOneTimePreKey([]byte) ([]byte, error)
+	// MarkOneTimePreKeyUsed marks their one-time prekey as used, so that it's not used again
+	MarkOneTimePreKeyUsed([]byte) error
+	// DeleteOneTimePreKey deletes our one-time prekey once it was used
+	DeleteOneTimePreKey([]byte) error
 }
@@ -3,22 +3,39 @@ package chat
 import (
 	"crypto/ecdsa"
 	"errors"
+	"sync"
+	"time"
 
+	ecrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/golang/protobuf/proto"
 )
 
+// sessionResetInterval is the min time between session reset messages sent to the same identity,
+// so that sessions which can't be established do not cause a loop of resets
+const sessionResetInterval = 10 * time.Minute
+
+// ErrSessionResetRateLimited is returned when a session reset was sent to the identity recently
+var ErrSessionResetRateLimited = errors.New("session reset sent recently")
+
 type ProtocolService struct {
 	log        log.Logger
 	encryption *EncryptionService
 	Enabled    bool
+
+	mu sync.Mutex
+	// sessionResets holds the time of the last session reset sent to each identity
+	sessionResets map[string]time.Time
+	now           func() time.Time
 }
 
 // NewProtocolService creates a new ProtocolService instance
 func NewProtocolService(encryption *EncryptionService) *ProtocolService {
 	return &ProtocolService{
-		log:        log.New("package", "status-go/services/sshext.chat"),
-		encryption: encryption,
+		log:           log.New("package", "status-go/services/sshext.chat"),
+		encryption:    encryption,
+		sessionResets: make(map[string]time.Time),
+		now:           time.Now,
 	}
 }
 
@@ -80,6 +97,120 @@ func (p *ProtocolService) BuildDirectMessage(myIdentityKey *ecdsa.PrivateKey, th
 	return response, nil
 }
 
+// BuildPairingMessage marshals a message with signed metadata of our installation, sent to our other installations,
+// given the user identity private key and the device name
+func (p *ProtocolService) BuildPairingMessage(myIdentityKey *ecdsa.PrivateKey, name string) ([]byte, error) {
+	metadata, err := p.encryption.CreateInstallationMetadata(myIdentityKey, name)
+	if err != nil {
+		p.log.Error("encryption-service", "error creating installation metadata", err)
+		return nil, err
+	}
+
+	protocolMessage := &ProtocolMessage{
+		InstallationMetadata: metadata,
+	}
+
+	return p.addBundleAndMarshal(myIdentityKey, protocolMessage)
+}
+
+// BuildSessionResetMessage marshals a message asking them to discard the session with our installation
+// and establish a new one with our bundle, given the user identity private key and their public key.
+// A session reset to them is reserved until the interval passes, ErrSessionResetRateLimited is returned
+// if one was reserved recently. Either SessionResetSent or SessionResetFailed must be called
+// once the message is sent or it can't be sent
+func (p *ProtocolService) BuildSessionResetMessage(myIdentityKey *ecdsa.PrivateKey, theirPublicKey *ecdsa.PublicKey) ([]byte, error) {
+	if err := p.reserveSessionReset(theirPublicKey); err != nil {
+		return nil, err
+	}
+
+	protocolMessage := &ProtocolMessage{
+		SessionReset: &SessionReset{
+			InstallationId: p.encryption.installationID,
+		},
+	}
+
+	msg, err := p.addBundleAndMarshal(myIdentityKey, protocolMessage)
+	if err != nil {
+		p.SessionResetFailed(theirPublicKey)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// reserveSessionReset records a session reset to them unless one was recorded recently,
+// so that concurrent failures do not send more than one
+func (p *ProtocolService) reserveSessionReset(theirPublicKey *ecdsa.PublicKey) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for key, sentAt := range p.sessionResets {
+		if now.Sub(sentAt) >= sessionResetInterval {
+			delete(p.sessionResets, key)
+		}
+	}
+
+	key := string(ecrypto.CompressPubkey(theirPublicKey))
+	if _, ok := p.sessionResets[key]; ok {
+		return ErrSessionResetRateLimited
+	}
+	p.sessionResets[key] = now
+	return nil
+}
+
+// SessionResetSent discards our session with the installation which sent the message
+// we could not decrypt, given their public key and the message. Otherwise the broken
+// session could still be used for messages sent to them
+func (p *ProtocolService) SessionResetSent(theirPublicKey *ecdsa.PublicKey, payload []byte) error {
+	if installationID := p.senderInstallationID(payload); installationID != "" {
+		return p.encryption.ResetSession(theirPublicKey, installationID)
+	}
+	return nil
+}
+
+// SessionResetFailed releases the session reset reserved by BuildSessionResetMessage
+// if it could not be sent, so that it can be sent again
+func (p *ProtocolService) SessionResetFailed(theirPublicKey *ecdsa.PublicKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sessionResets, string(ecrypto.CompressPubkey(theirPublicKey)))
+}
+
+// senderInstallationID returns the installation which sent the direct message to our installation,
+// or an empty string if it is not known
+func (p *ProtocolService) senderInstallationID(payload []byte) string {
+	protocolMessage := &ProtocolMessage{}
+	if err := proto.Unmarshal(payload, protocolMessage); err != nil {
+		return ""
+	}
+
+	msgs := protocolMessage.GetDirectMessage()
+	msg := msgs[p.encryption.installationID]
+	if msg == nil {
+		msg = msgs["none"]
+	}
+
+	if installationID := msg.GetDRHeader().GetInstallationId(); installationID != "" {
+		return installationID
+	}
+	return msg.GetX3DHHeader().GetInstallationId()
+}
+
+// GetOurInstallations returns other installations of the user identity
+func (p *ProtocolService) GetOurInstallations(myIdentityKey *ecdsa.PrivateKey) ([]*Installation, error) {
+	return p.encryption.GetOurInstallations(myIdentityKey)
+}
+
+// EnableInstallation enables sending messages to another installation of the user identity
+func (p *ProtocolService) EnableInstallation(myIdentityKey *ecdsa.PrivateKey, installationID string) error {
+	return p.encryption.EnableInstallation(myIdentityKey, installationID)
+}
+
+// DisableInstallation stops sending messages to another installation of the user identity
+func (p *ProtocolService) DisableInstallation(myIdentityKey *ecdsa.PrivateKey, installationID string) error {
+	return p.encryption.DisableInstallation(myIdentityKey, installationID)
+}
+
 // ProcessPublicBundle processes a received X3DH bundle
 func (p *ProtocolService) ProcessPublicBundle(myIdentityKey *ecdsa.PrivateKey, bundle *Bundle) error {
 	return p.encryption.ProcessPublicBundle(myIdentityKey, bundle)
@@ -90,7 +221,18 @@ func (p *ProtocolService) GetBundle(myIdentityKey *ecdsa.PrivateKey) (*Bundle, e
 	return p.encryption.CreateBundle(myIdentityKey)
 }
 
-// HandleMessage unmarshals a message and processes it, decrypting it if it is a 1:1 message
+// RotateBundle replaces the signed prekey of our bundle with a new one
+func (p *ProtocolService) RotateBundle(myIdentityKey *ecdsa.PrivateKey) (*Bundle, error) {
+	return p.encryption.RotateBundle(myIdentityKey)
+}
+
+// RotateBundles rotates expired bundles and deletes old ones
+func (p *ProtocolService) RotateBundles() error {
+	return p.encryption.RotateBundles()
+}
+
+// HandleMessage unmarshals a message and processes it, decrypting it if it is a 1:1 message.
+// Pairing and session reset messages are processed without returning any payload
 func (p *ProtocolService) HandleMessage(myIdentityKey *ecdsa.PrivateKey, theirPublicKey *ecdsa.PublicKey, payload []byte) ([]byte, error) {
 	if p.encryption == nil {
 		return nil, errors.New("encryption service not initialized")
@@ -112,6 +254,19 @@ func (p *ProtocolService) HandleMessage(myIdentityKey *ecdsa.PrivateKey, theirPu
 		}
 	}
 
+	// Check if it's a pairing message
+	if metadata := protocolMessage.GetInstallationMetadata(); metadata != nil {
+		return nil, p.encryption.ProcessInstallationMetadata(myIdentityKey, metadata)
+	}
+
+	// Check if it's a session reset, the bundle processed above is used for the new session
+	if reset := protocolMessage.GetSessionReset(); reset != nil {
+		if theirPublicKey == nil {
+			return nil, errors.New("session reset without a sender")
+		}
+		return nil, p.encryption.ResetSession(theirPublicKey, reset.GetInstallationId())
+	}
+
 	// Check if it's a public message
 	if publicMessage := protocolMessage.GetPublicMessage(); publicMessage != nil {
 		// Nothing to do, as already in cleartext
@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/golang/protobuf/proto"
@@ -39,8 +40,8 @@ func (s *ProtocolServiceTestSuite) SetupTest() {
 		panic(err)
 	}
 
-	s.alice = NewProtocolService(NewEncryptionService(alicePersistence, "1"))
-	s.bob = NewProtocolService(NewEncryptionService(bobPersistence, "2"))
+	s.alice = NewProtocolService(NewEncryptionService(alicePersistence, DefaultEncryptionServiceConfig("1")))
+	s.bob = NewProtocolService(NewEncryptionService(bobPersistence, DefaultEncryptionServiceConfig("2")))
 }
 
 func (s *ProtocolServiceTestSuite) TestBuildDirectMessage() {
@@ -117,3 +118,176 @@ func (s *ProtocolServiceTestSuite) TestBuildAndReadDirectMessage() {
 	s.NoError(err)
 	s.Equalf(proto.Equal(&payload, &recoveredPayload), true, "It successfully unmarshal the decrypted message")
 }
+
+func (s *ProtocolServiceTestSuite) TestBuildAndReadPairingMessage() {
+	aliceKey, err := crypto.GenerateKey()
+	s.NoError(err)
+
+	// Both services are installations of the same identity
+	marshaledMsg, err := s.bob.BuildPairingMessage(aliceKey, "desktop")
+	s.NoError(err)
+
+	unmarshaledMsg, err := s.alice.HandleMessage(aliceKey, &aliceKey.PublicKey, marshaledMsg)
+	s.NoError(err)
+	s.Nil(unmarshaledMsg, "It does not return a payload")
+
+	installations, err := s.alice.GetOurInstallations(aliceKey)
+	s.NoError(err)
+	s.Equal(1, len(installations))
+	s.Equal("2", installations[0].ID)
+	s.Equal("desktop", installations[0].Name)
+	s.True(installations[0].Enabled, "It enables the installation")
+}
+
+func (s *ProtocolServiceTestSuite) TestSessionReset() {
+	bobKey, err := crypto.GenerateKey()
+	s.NoError(err)
+	aliceKey, err := crypto.GenerateKey()
+	s.NoError(err)
+
+	bobBundle, err := s.bob.GetBundle(bobKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+
+	keys := []*ecdsa.PublicKey{&bobKey.PublicKey}
+	marshaledMsg, err := s.alice.BuildDirectMessage(aliceKey, keys, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.HandleMessage(bobKey, &aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey])
+	s.Require().NoError(err)
+
+	// Bob can't decrypt messages after he loses the session
+	for _, query := range []string{"DELETE FROM ratchet_info", "DELETE FROM sessions"} {
+		_, err = s.bob.encryption.persistence.(*SQLLitePersistence).db.Exec(query)
+		s.Require().NoError(err)
+	}
+	marshaledMsg, err = s.alice.BuildDirectMessage(aliceKey, keys, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.HandleMessage(bobKey, &aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey])
+	s.Require().Equal(ErrSessionNotFound, err)
+
+	resetMsg, err := s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.bob.SessionResetSent(&aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey]))
+
+	unmarshaledMsg, err := s.alice.HandleMessage(aliceKey, &bobKey.PublicKey, resetMsg)
+	s.Require().NoError(err)
+	s.Nil(unmarshaledMsg, "It does not return a payload")
+
+	marshaledMsg, err = s.alice.BuildDirectMessage(aliceKey, keys, cleartext)
+	s.Require().NoError(err)
+	unmarshaledMsg, err = s.bob.HandleMessage(bobKey, &aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey])
+	s.Require().NoError(err)
+	s.Equal(cleartext, unmarshaledMsg, "It decrypts messages sent with the new session")
+
+	// Session resets are not sent again too soon
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.Equal(ErrSessionResetRateLimited, err)
+
+	now := time.Now()
+	s.bob.now = func() time.Time { return now.Add(sessionResetInterval) }
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.NoError(err, "It sends a session reset after the interval")
+}
+
+func (s *ProtocolServiceTestSuite) TestSessionResetFailed() {
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	now := time.Now()
+	s.bob.now = func() time.Time { return now }
+
+	// A session reset is reserved when it is built
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.Require().NoError(err)
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.Equal(ErrSessionResetRateLimited, err)
+
+	// It is released if it could not be sent
+	s.bob.SessionResetFailed(&aliceKey.PublicKey)
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.Require().NoError(err, "It is not rate limited if it was not sent")
+
+	// Resets reserved before the interval are forgotten
+	otherKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	s.bob.now = func() time.Time { return now.Add(sessionResetInterval) }
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &otherKey.PublicKey)
+	s.Require().NoError(err)
+	s.Len(s.bob.sessionResets, 1, "It prunes expired session resets")
+}
+
+func (s *ProtocolServiceTestSuite) TestSessionResetConcurrent() {
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	// Only one of concurrent session resets is built
+	const resets = 5
+	errs := make(chan error, resets)
+	for i := 0; i < resets; i++ {
+		go func() {
+			_, err := s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+			errs <- err
+		}()
+	}
+	built := 0
+	for i := 0; i < resets; i++ {
+		if err := <-errs; err == nil {
+			built++
+		} else {
+			s.Equal(ErrSessionResetRateLimited, err)
+		}
+	}
+	s.Equal(1, built)
+}
+
+// Bob can't decrypt Alice's message, so he discards the session with her installation
+// which would be used for his messages to her otherwise
+func (s *ProtocolServiceTestSuite) TestSessionResetDiscardsOurSession() {
+	bobKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	aliceKey, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bobBundle, err := s.bob.GetBundle(bobKey)
+	s.Require().NoError(err)
+	s.Require().NoError(s.alice.ProcessPublicBundle(aliceKey, bobBundle))
+
+	keys := []*ecdsa.PublicKey{&bobKey.PublicKey}
+	marshaledMsg, err := s.alice.BuildDirectMessage(aliceKey, keys, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.HandleMessage(bobKey, &aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey])
+	s.Require().NoError(err)
+
+	// Alice loses the state of her ratchet
+	_, err = s.alice.encryption.persistence.(*SQLLitePersistence).db.Exec("DELETE FROM sessions")
+	s.Require().NoError(err)
+
+	marshaledMsg, err = s.alice.BuildDirectMessage(aliceKey, keys, cleartext)
+	s.Require().NoError(err)
+	_, err = s.bob.HandleMessage(bobKey, &aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey])
+	s.Require().Equal(ErrDecryptionFailed, err)
+
+	aliceKeyC := crypto.CompressPubkey(&aliceKey.PublicKey)
+	drInfo, err := s.bob.encryption.persistence.GetAnyRatchetInfo(aliceKeyC, "1")
+	s.Require().NoError(err)
+	s.Require().NotNil(drInfo)
+
+	_, err = s.bob.BuildSessionResetMessage(bobKey, &aliceKey.PublicKey)
+	s.Require().NoError(err)
+
+	// The session is kept until the session reset is sent
+	drInfo, err = s.bob.encryption.persistence.GetAnyRatchetInfo(aliceKeyC, "1")
+	s.Require().NoError(err)
+	s.Require().NotNil(drInfo)
+
+	err = s.bob.SessionResetSent(&aliceKey.PublicKey, marshaledMsg[&bobKey.PublicKey])
+	s.Require().NoError(err)
+
+	drInfo, err = s.bob.encryption.persistence.GetAnyRatchetInfo(aliceKeyC, "1")
+	s.Require().NoError(err)
+	s.Nil(drInfo, "It discards the session with the installation which sent the message")
+}
@@ -27,3 +27,9 @@ type SendGroupMessageRPC struct {
 	Payload hexutil.Bytes
 	PubKeys []hexutil.Bytes
 }
+
+// SendPairingMessageRPC represents the RPC payload for the SendPairingMessage RPC method
+type SendPairingMessageRPC struct {
+	Sig  string
+	Name string
+}
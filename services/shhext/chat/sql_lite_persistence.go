@@ -159,8 +159,8 @@ func (s *SQLLitePersistence) AddPublicBundle(b *Bundle) error {
 			_ = tx.Rollback()
 			return err
 		}
-		// Mark old bundles as expired
-		updateStmt, err := tx.Prepare("UPDATE bundles SET expired = 1 WHERE identity = ? AND installation_id = ? AND signed_pre_key != ?")
+		// Mark old bundles as expired, our bundles are expired only when we rotate them
+		updateStmt, err := tx.Prepare("UPDATE bundles SET expired = 1 WHERE identity = ? AND installation_id = ? AND signed_pre_key != ? AND private_key IS NULL")
 		if err != nil {
 			return err
 		}
@@ -176,14 +176,76 @@ func (s *SQLLitePersistence) AddPublicBundle(b *Bundle) error {
 			return err
 		}
 
+		if oneTimePreKeys := signedPreKeyContainer.GetOneTimePreKeys(); len(oneTimePreKeys) > 0 {
+			if err = replacePublicOneTimePreKeys(tx, b.GetIdentity(), installationID, oneTimePreKeys); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
 	}
 
 	return tx.Commit()
 }
 
+// replacePublicOneTimePreKeys replaces the stored one-time prekeys of their installation with the published ones.
+// Keys which are not published anymore were consumed, keys which are already known keep their state
+func replacePublicOneTimePreKeys(tx *sql.Tx, identity []byte, installationID string, oneTimePreKeys [][]byte) error {
+	published := make(map[string]bool)
+	for _, oneTimePreKey := range oneTimePreKeys {
+		published[string(oneTimePreKey)] = true
+	}
+
+	rows, err := tx.Query("SELECT public_key FROM one_time_pre_keys WHERE identity = ? AND installation_id = ? AND private_key IS NULL", identity, installationID)
+	if err != nil {
+		return err
+	}
+
+	var consumed [][]byte
+	for rows.Next() {
+		var publicKey []byte
+		if err = rows.Scan(&publicKey); err != nil {
+			rows.Close()
+			return err
+		}
+		if !published[string(publicKey)] {
+			consumed = append(consumed, publicKey)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	deleteStmt, err := tx.Prepare("DELETE FROM one_time_pre_keys WHERE public_key = ? AND private_key IS NULL")
+	if err != nil {
+		return err
+	}
+	defer deleteStmt.Close()
+
+	for _, publicKey := range consumed {
+		if _, err = deleteStmt.Exec(publicKey); err != nil {
+			return err
+		}
+	}
+
+	insertStmt, err := tx.Prepare("INSERT INTO one_time_pre_keys(identity, installation_id, public_key, timestamp) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertStmt.Close()
+
+	for _, oneTimePreKey := range oneTimePreKeys {
+		if _, err = insertStmt.Exec(identity, installationID, oneTimePreKey, time.Now().UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // GetAnyPrivateBundle retrieves any bundle from the database containing a private key
 func (s *SQLLitePersistence) GetAnyPrivateBundle(myIdentityKey []byte) (*BundleContainer, error) {
-	stmt, err := s.db.Prepare("SELECT identity, signed_pre_key, installation_id, timestamp FROM bundles WHERE identity = ? AND expired = 0")
+	stmt, err := s.db.Prepare("SELECT identity, signed_pre_key, installation_id, timestamp, private_key FROM bundles WHERE identity = ? AND expired = 0")
 	if err != nil {
 		return nil, err
 	}
@@ -208,17 +270,25 @@ func (s *SQLLitePersistence) GetAnyPrivateBundle(myIdentityKey []byte) (*BundleC
 	for rows.Next() {
 		var signedPreKey []byte
 		var installationID string
+		var rowTimestamp int64
+		var privateKey []byte
 		rowCount++
 		err = rows.Scan(
 			&identity,
 			&signedPreKey,
 			&installationID,
-			&timestamp,
+			&rowTimestamp,
+			&privateKey,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		// The bundle is as old as our signed prekey, signed prekeys of our other installations are rotated by them
+		if privateKey != nil {
+			timestamp = rowTimestamp
+		}
+
 		bundle.SignedPreKeys[installationID] = &SignedPreKey{SignedPreKey: signedPreKey}
 		bundle.Identity = identity
 	}
@@ -233,9 +303,10 @@ func (s *SQLLitePersistence) GetAnyPrivateBundle(myIdentityKey []byte) (*BundleC
 
 }
 
-// GetPrivateKeyBundle retrieves a private key for a bundle from the database
+// GetPrivateKeyBundle retrieves a private key for a bundle from the database.
+// Keys of expired bundles are returned until the bundles are deleted, so that delayed messages can be decrypted
 func (s *SQLLitePersistence) GetPrivateKeyBundle(bundleID []byte) ([]byte, error) {
-	stmt, err := s.db.Prepare("SELECT private_key FROM bundles WHERE expired = 0 AND signed_pre_key = ? LIMIT 1")
+	stmt, err := s.db.Prepare("SELECT private_key FROM bundles WHERE signed_pre_key = ? AND private_key IS NOT NULL LIMIT 1")
 	if err != nil {
 		return nil, err
 	}
@@ -254,20 +325,58 @@ func (s *SQLLitePersistence) GetPrivateKeyBundle(bundleID []byte) ([]byte, error
 	}
 }
 
-// RatchetInfoConfirmed clears the ephemeral key in the RatchetInfo
-// associated with the specified bundle ID and interlocutor identity public key
+// MarkBundleExpired marks our bundle of the identity as expired.
+// Bundles of our other installations are expired when they publish new ones
 func (s *SQLLitePersistence) MarkBundleExpired(identity []byte) error {
-	stmt, err := s.db.Prepare("UPDATE bundles SET expired = 1 WHERE identity = ?")
+	stmt, err := s.db.Prepare("UPDATE bundles SET expired = 1, expired_at = ? WHERE identity = ? AND private_key IS NOT NULL AND expired = 0")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(identity)
+	_, err = stmt.Exec(time.Now().UnixNano(), identity)
 
 	return err
 }
 
+// MarkBundlesExpired marks our bundles created before the specified time as expired
+func (s *SQLLitePersistence) MarkBundlesExpired(createdBefore int64) error {
+	stmt, err := s.db.Prepare("UPDATE bundles SET expired = 1, expired_at = ? WHERE timestamp < ? AND private_key IS NOT NULL AND expired = 0")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(time.Now().UnixNano(), createdBefore)
+
+	return err
+}
+
+// DeleteExpiredBundles deletes our bundles which expired before the specified time from the database,
+// together with ratchet info and double ratchet sessions established with them
+func (s *SQLLitePersistence) DeleteExpiredBundles(expiredBefore int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		// Session IDs are prefixed with the bundle ID
+		"DELETE FROM sessions WHERE EXISTS (SELECT 1 FROM bundles WHERE private_key IS NOT NULL AND expired = 1 AND expired_at < ? AND substr(sessions.id, 1, length(signed_pre_key)) = signed_pre_key)",
+		"DELETE FROM received_ratchet_keys WHERE EXISTS (SELECT 1 FROM bundles WHERE private_key IS NOT NULL AND expired = 1 AND expired_at < ? AND substr(received_ratchet_keys.session_id, 1, length(signed_pre_key)) = signed_pre_key)",
+		"DELETE FROM ratchet_info WHERE bundle_id IN (SELECT signed_pre_key FROM bundles WHERE private_key IS NOT NULL AND expired = 1 AND expired_at < ?)",
+		"DELETE FROM bundles WHERE private_key IS NOT NULL AND expired = 1 AND expired_at < ?",
+	}
+	for _, query := range queries {
+		if _, err = tx.Exec(query, expiredBefore); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // GetPublicBundle retrieves an existing Bundle for the specified public key from the database
 func (s *SQLLitePersistence) GetPublicBundle(publicKey *ecdsa.PublicKey) (*Bundle, error) {
 
@@ -317,8 +426,8 @@ func (s *SQLLitePersistence) GetPublicBundle(publicKey *ecdsa.PublicKey) (*Bundl
 }
 
 // AddRatchetInfo persists the specified ratchet info into the database
-func (s *SQLLitePersistence) AddRatchetInfo(key []byte, identity []byte, bundleID []byte, ephemeralKey []byte, installationID string) error {
-	stmt, err := s.db.Prepare("INSERT INTO ratchet_info(symmetric_key, identity, bundle_id, ephemeral_key, installation_id) VALUES(?, ?, ?, ?, ?)")
+func (s *SQLLitePersistence) AddRatchetInfo(key []byte, identity []byte, bundleID []byte, ephemeralKey []byte, oneTimePreKey []byte, installationID string) error {
+	stmt, err := s.db.Prepare("INSERT INTO ratchet_info(symmetric_key, identity, bundle_id, ephemeral_key, one_time_pre_key, installation_id) VALUES(?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
@@ -329,15 +438,17 @@ func (s *SQLLitePersistence) AddRatchetInfo(key []byte, identity []byte, bundleI
 		identity,
 		bundleID,
 		ephemeralKey,
+		oneTimePreKey,
 		installationID,
 	)
 
 	return err
 }
 
-// GetRatchetInfo retrieves the existing RatchetInfo for a specified bundle ID and interlocutor public key from the database
-func (s *SQLLitePersistence) GetRatchetInfo(bundleID []byte, theirIdentity []byte) (*RatchetInfo, error) {
-	stmt, err := s.db.Prepare("SELECT ratchet_info.identity, ratchet_info.symmetric_key, bundles.private_key, bundles.signed_pre_key, ratchet_info.ephemeral_key, ratchet_info.installation_id FROM ratchet_info JOIN bundles ON bundle_id = signed_pre_key WHERE ratchet_info.identity = ? AND bundle_id = ? LIMIT 1")
+// GetRatchetInfo retrieves the existing RatchetInfo for a specified bundle ID, interlocutor public key
+// and installation from the database. Any installation matches if the installation ID is empty
+func (s *SQLLitePersistence) GetRatchetInfo(bundleID []byte, theirIdentity []byte, installationID string) (*RatchetInfo, error) {
+	stmt, err := s.db.Prepare("SELECT ratchet_info.identity, ratchet_info.symmetric_key, bundles.private_key, bundles.signed_pre_key, ratchet_info.ephemeral_key, ratchet_info.installation_id, ratchet_info.one_time_pre_key FROM ratchet_info JOIN bundles ON bundle_id = signed_pre_key WHERE ratchet_info.identity = ? AND bundle_id = ? AND (? = '' OR ratchet_info.installation_id = ?) LIMIT 1")
 	if err != nil {
 		return nil, err
 	}
@@ -347,13 +458,14 @@ func (s *SQLLitePersistence) GetRatchetInfo(bundleID []byte, theirIdentity []byt
 		BundleID: bundleID,
 	}
 
-	err = stmt.QueryRow(theirIdentity, bundleID).Scan(
+	err = stmt.QueryRow(theirIdentity, bundleID, installationID, installationID).Scan(
 		&ratchetInfo.Identity,
 		&ratchetInfo.Sk,
 		&ratchetInfo.PrivateKey,
 		&ratchetInfo.PublicKey,
 		&ratchetInfo.EphemeralKey,
 		&ratchetInfo.InstallationID,
+		&ratchetInfo.OneTimePreKey,
 	)
 	switch err {
 	case sql.ErrNoRows:
@@ -368,7 +480,7 @@ func (s *SQLLitePersistence) GetRatchetInfo(bundleID []byte, theirIdentity []byt
 
 // GetAnyRatchetInfo retrieves any existing RatchetInfo for a specified interlocutor public key from the database
 func (s *SQLLitePersistence) GetAnyRatchetInfo(identity []byte, installationID string) (*RatchetInfo, error) {
-	stmt, err := s.db.Prepare("SELECT symmetric_key, bundles.private_key, signed_pre_key, bundle_id, ephemeral_key FROM ratchet_info JOIN bundles ON bundle_id = signed_pre_key WHERE expired = 0 AND ratchet_info.identity = ? AND ratchet_info.installation_id = ? LIMIT 1")
+	stmt, err := s.db.Prepare("SELECT symmetric_key, bundles.private_key, signed_pre_key, bundle_id, ephemeral_key, one_time_pre_key FROM ratchet_info JOIN bundles ON bundle_id = signed_pre_key WHERE expired = 0 AND ratchet_info.identity = ? AND ratchet_info.installation_id = ? LIMIT 1")
 	if err != nil {
 		return nil, err
 	}
@@ -385,6 +497,7 @@ func (s *SQLLitePersistence) GetAnyRatchetInfo(identity []byte, installationID s
 		&ratchetInfo.PublicKey,
 		&ratchetInfo.BundleID,
 		&ratchetInfo.EphemeralKey,
+		&ratchetInfo.OneTimePreKey,
 	)
 	switch err {
 	case sql.ErrNoRows:
@@ -398,9 +511,9 @@ func (s *SQLLitePersistence) GetAnyRatchetInfo(identity []byte, installationID s
 }
 
 // RatchetInfoConfirmed clears the ephemeral key in the RatchetInfo
-// associated with the specified bundle ID and interlocutor identity public key
-func (s *SQLLitePersistence) RatchetInfoConfirmed(bundleID []byte, theirIdentity []byte) error {
-	stmt, err := s.db.Prepare("UPDATE ratchet_info SET ephemeral_key = NULL WHERE identity = ? AND bundle_id = ?")
+// associated with the specified bundle ID, interlocutor identity public key and installation
+func (s *SQLLitePersistence) RatchetInfoConfirmed(bundleID []byte, theirIdentity []byte, installationID string) error {
+	stmt, err := s.db.Prepare("UPDATE ratchet_info SET ephemeral_key = NULL WHERE identity = ? AND bundle_id = ? AND installation_id = ?")
 	if err != nil {
 		return err
 	}
@@ -409,11 +522,304 @@ func (s *SQLLitePersistence) RatchetInfoConfirmed(bundleID []byte, theirIdentity
 	_, err = stmt.Exec(
 		theirIdentity,
 		bundleID,
+		installationID,
 	)
 
 	return err
 }
 
+// DeleteRatchetInfo deletes the ratchet info established with an installation of an identity
+// from the database, together with the double ratchet sessions
+func (s *SQLLitePersistence) DeleteRatchetInfo(identity []byte, installationID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = deleteRatchetInfo(tx, identity, installationID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func deleteRatchetInfo(tx *sql.Tx, identity []byte, installationID string) error {
+	rows, err := tx.Query("SELECT bundle_id FROM ratchet_info WHERE identity = ? AND installation_id = ?", identity, installationID)
+	if err != nil {
+		return err
+	}
+
+	// Session IDs are the bundle ID followed by the installation ID
+	var sessionIDs [][]byte
+	for rows.Next() {
+		var bundleID []byte
+		if err = rows.Scan(&bundleID); err != nil {
+			rows.Close()
+			return err
+		}
+		sessionIDs = append(sessionIDs, append(bundleID, []byte(installationID)...))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE id = ?",
+		"DELETE FROM received_ratchet_keys WHERE session_id = ?",
+	} {
+		if err = execForEach(tx, query, sessionIDs); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM ratchet_info WHERE identity = ? AND installation_id = ?", identity, installationID)
+	return err
+}
+
+func execForEach(tx *sql.Tx, query string, args [][]byte) error {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, arg := range args {
+		if _, err = stmt.Exec(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsRatchetKeyReceived returns true if the session with the specified ID received the ratchet public key
+func (s *SQLLitePersistence) IsRatchetKeyReceived(sessionID []byte, key []byte) (bool, error) {
+	var received bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM received_ratchet_keys WHERE session_id = ? AND public_key = ?)", sessionID, key).Scan(&received)
+	return received, err
+}
+
+// AddInstallations adds the specified installations of an identity to the database.
+// Installations which are already known are not changed
+func (s *SQLLitePersistence) AddInstallations(identity []byte, installationIDs []string, enabled bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO installations(identity, installation_id, enabled) VALUES(?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, installationID := range installationIDs {
+		if _, err = stmt.Exec(identity, installationID, enabled); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetInstallationMetadata sets the name of the installation, adding an enabled installation if it's not known.
+// The name is changed only if the timestamp is newer than the one of the stored metadata
+func (s *SQLLitePersistence) SetInstallationMetadata(identity []byte, installationID string, name string, timestamp int64) error {
+	if err := s.AddInstallations(identity, []string{installationID}, true); err != nil {
+		return err
+	}
+
+	stmt, err := s.db.Prepare("UPDATE installations SET name = ?, timestamp = ? WHERE identity = ? AND installation_id = ? AND timestamp < ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(name, timestamp, identity, installationID, timestamp)
+	return err
+}
+
+// SetInstallationEnabled enables or disables the installation
+func (s *SQLLitePersistence) SetInstallationEnabled(identity []byte, installationID string, enabled bool) error {
+	stmt, err := s.db.Prepare("UPDATE installations SET enabled = ? WHERE identity = ? AND installation_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(enabled, identity, installationID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInstallationNotFound
+	}
+
+	return nil
+}
+
+// GetInstallations retrieves all installations of the identity from the database
+func (s *SQLLitePersistence) GetInstallations(identity []byte) ([]*Installation, error) {
+	stmt, err := s.db.Prepare("SELECT installation_id, name, timestamp, enabled FROM installations WHERE identity = ? ORDER BY installation_id")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(identity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var installations []*Installation
+	for rows.Next() {
+		installation := &Installation{}
+		err = rows.Scan(
+			&installation.ID,
+			&installation.Name,
+			&installation.Timestamp,
+			&installation.Enabled,
+		)
+		if err != nil {
+			return nil, err
+		}
+		installations = append(installations, installation)
+	}
+
+	return installations, rows.Err()
+}
+
+// AddPrivateOneTimePreKeys adds one-time prekeys generated for our installation to the database
+func (s *SQLLitePersistence) AddPrivateOneTimePreKeys(identity []byte, installationID string, keys []*ecdsa.PrivateKey) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO one_time_pre_keys(identity, installation_id, public_key, private_key, timestamp) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, key := range keys {
+		_, err = stmt.Exec(
+			identity,
+			installationID,
+			crypto.CompressPubkey(&key.PublicKey),
+			crypto.FromECDSA(key),
+			time.Now().UnixNano(),
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetOneTimePreKeys retrieves public keys of our one-time prekeys generated for the installation from the database
+func (s *SQLLitePersistence) GetOneTimePreKeys(identity []byte, installationID string) ([][]byte, error) {
+	stmt, err := s.db.Prepare("SELECT public_key FROM one_time_pre_keys WHERE identity = ? AND installation_id = ? AND private_key IS NOT NULL ORDER BY timestamp")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(identity, installationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys [][]byte
+	for rows.Next() {
+		var key []byte
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetAnyOneTimePreKey retrieves a random public one-time prekey of their installation which was not used from the database,
+// so that senders sharing the same bundle are less likely to pick the same key
+func (s *SQLLitePersistence) GetAnyOneTimePreKey(identity []byte, installationID string) ([]byte, error) {
+	stmt, err := s.db.Prepare("SELECT public_key FROM one_time_pre_keys WHERE identity = ? AND installation_id = ? AND private_key IS NULL AND used = 0 ORDER BY RANDOM() LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var key []byte
+	err = stmt.QueryRow(identity, installationID).Scan(&key)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+		return key, nil
+	default:
+		return nil, err
+	}
+}
+
+// GetPrivateOneTimePreKey retrieves the private key of our one-time prekey from the database
+func (s *SQLLitePersistence) GetPrivateOneTimePreKey(publicKey []byte) ([]byte, error) {
+	stmt, err := s.db.Prepare("SELECT private_key FROM one_time_pre_keys WHERE public_key = ? AND private_key IS NOT NULL LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var privateKey []byte
+	err = stmt.QueryRow(publicKey).Scan(&privateKey)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+		return privateKey, nil
+	default:
+		return nil, err
+	}
+}
+
+// MarkOneTimePreKeyUsed marks their one-time prekey as used
+func (s *SQLLitePersistence) MarkOneTimePreKeyUsed(publicKey []byte) error {
+	stmt, err := s.db.Prepare("UPDATE one_time_pre_keys SET used = 1 WHERE public_key = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(publicKey)
+	return err
+}
+
+// DeleteOneTimePreKey deletes our one-time prekey from the database
+func (s *SQLLitePersistence) DeleteOneTimePreKey(publicKey []byte) error {
+	stmt, err := s.db.Prepare("DELETE FROM one_time_pre_keys WHERE public_key = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(publicKey)
+	return err
+}
+
 // Get retrieves the message key for a specified public key and message number
 func (s *SQLLiteKeysStorage) Get(pubKey dr.Key, msgNum uint) (dr.Key, bool, error) {
 	var keyBytes []byte
@@ -524,13 +930,13 @@ func (s *SQLLiteSessionStorage) Save(id []byte, state *dr.State) error {
 	recvChainKey := state.RecvCh.CK[:]
 	recvChainN := state.RecvCh.N
 
-	stmt, err := s.db.Prepare("insert into sessions(id, dhr, dhs_public, dhs_private, root_chain_key, send_chain_key, send_chain_n, recv_chain_key, recv_chain_n, pn, step) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(
+	_, err = tx.Exec(
+		"insert into sessions(id, dhr, dhs_public, dhs_private, root_chain_key, send_chain_key, send_chain_n, recv_chain_key, recv_chain_n, pn, step) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		id,
 		dhr,
 		dhsPublic[:],
@@ -543,8 +949,20 @@ func (s *SQLLiteSessionStorage) Save(id []byte, state *dr.State) error {
 		pn,
 		step,
 	)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
 
-	return err
+	// Ratchet keys they used are remembered to recognize messages which were already received
+	if state.DHr != (dr.Key{}) {
+		if _, err = tx.Exec("INSERT INTO received_ratchet_keys(session_id, public_key) VALUES (?, ?)", id, dhr); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Load retrieves the double ratchet state for a given ID
@@ -4,9 +4,11 @@ import (
 	"database/sql"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/golang/protobuf/proto"
+	dr "github.com/status-im/doubleratchet"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -150,11 +152,12 @@ func (s *SQLLitePersistenceTestSuite) TestRatchetInfoPrivateBundle() {
 		[]byte("their-public-key"),
 		bundle.GetBundle().GetSignedPreKeys()["2"].GetSignedPreKey(),
 		[]byte("ephemeral-public-key"),
+		nil,
 		"1",
 	)
 	s.Require().NoError(err)
 
-	ratchetInfo, err := s.service.GetRatchetInfo(bundle.GetBundle().GetSignedPreKeys()["2"].GetSignedPreKey(), []byte("their-public-key"))
+	ratchetInfo, err := s.service.GetRatchetInfo(bundle.GetBundle().GetSignedPreKeys()["2"].GetSignedPreKey(), []byte("their-public-key"), "1")
 
 	s.Require().NoError(err)
 	s.NotNil(ratchetInfo.ID, "It adds an id")
@@ -187,11 +190,12 @@ func (s *SQLLitePersistenceTestSuite) TestRatchetInfoPublicBundle() {
 		theirPublicKey,
 		signedPreKey,
 		[]byte("public-ephemeral-key"),
+		[]byte("one-time-pre-key"),
 		installationID,
 	)
 	s.Require().NoError(err)
 
-	ratchetInfo, err := s.service.GetRatchetInfo(signedPreKey, theirPublicKey)
+	ratchetInfo, err := s.service.GetRatchetInfo(signedPreKey, theirPublicKey, installationID)
 
 	s.Require().NoError(err)
 	s.Require().NotNil(ratchetInfo, "It returns the ratchet info")
@@ -202,6 +206,7 @@ func (s *SQLLitePersistenceTestSuite) TestRatchetInfoPublicBundle() {
 	s.Equal(ratchetInfo.Identity, theirPublicKey, "It returns the identity of the contact")
 	s.Equal(ratchetInfo.PublicKey, signedPreKey, "It  returns the public key of the bundle")
 	s.Equal(installationID, ratchetInfo.InstallationID, "It returns the right installationID")
+	s.Equal([]byte("one-time-pre-key"), ratchetInfo.OneTimePreKey, "It returns the one-time prekey")
 	s.Nilf(ratchetInfo.PrivateKey, "It does not return the private key")
 
 	ratchetInfo, err = s.service.GetAnyRatchetInfo(theirPublicKey, installationID)
@@ -214,6 +219,44 @@ func (s *SQLLitePersistenceTestSuite) TestRatchetInfoPublicBundle() {
 	s.Equal(ratchetInfo.PublicKey, signedPreKey, "It  returns the public key of the bundle")
 	s.Equal(signedPreKey, ratchetInfo.BundleID, "It returns the bundle id")
 	s.Equal(installationID, ratchetInfo.InstallationID, "It saves the right installation ID")
+	s.Equal([]byte("one-time-pre-key"), ratchetInfo.OneTimePreKey, "It returns the one-time prekey")
+}
+
+func (s *SQLLitePersistenceTestSuite) TestRatchetInfoInstallations() {
+	theirPublicKey := []byte("their-public-key")
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bundle, err := NewBundleContainer(key, "1")
+	s.Require().NoError(err)
+	s.Require().NoError(s.service.AddPrivateBundle(bundle))
+	bundleID := bundle.GetBundle().GetSignedPreKeys()["1"].GetSignedPreKey()
+
+	// Two installations of the same identity established a session with our bundle
+	err = s.service.AddRatchetInfo([]byte("symmetric-key-2"), theirPublicKey, bundleID, nil, nil, "2")
+	s.Require().NoError(err)
+	err = s.service.AddRatchetInfo([]byte("symmetric-key-3"), theirPublicKey, bundleID, []byte("ephemeral-key"), nil, "3")
+	s.Require().NoError(err)
+
+	ratchetInfo, err := s.service.GetRatchetInfo(bundleID, theirPublicKey, "2")
+	s.Require().NoError(err)
+	s.Require().NotNil(ratchetInfo)
+	s.Equal([]byte("symmetric-key-2"), ratchetInfo.Sk, "It keeps the session of the first installation")
+	s.Equal(append(bundleID, []byte("2")...), ratchetInfo.ID, "It returns the session of the installation")
+
+	ratchetInfo, err = s.service.GetRatchetInfo(bundleID, theirPublicKey, "3")
+	s.Require().NoError(err)
+	s.Require().NotNil(ratchetInfo)
+	s.Equal([]byte("symmetric-key-3"), ratchetInfo.Sk, "It keeps the session of the second installation")
+
+	s.Require().NoError(s.service.RatchetInfoConfirmed(bundleID, theirPublicKey, "2"))
+	ratchetInfo, err = s.service.GetRatchetInfo(bundleID, theirPublicKey, "3")
+	s.Require().NoError(err)
+	s.Equal([]byte("ephemeral-key"), ratchetInfo.EphemeralKey, "It confirms the session of the installation only")
+
+	ratchetInfo, err = s.service.GetRatchetInfo(bundleID, theirPublicKey, "")
+	s.Require().NoError(err)
+	s.NotNil(ratchetInfo, "It returns any session if the installation is not known")
 }
 
 func (s *SQLLitePersistenceTestSuite) TestRatchetInfoNoBundle() {
@@ -222,12 +265,13 @@ func (s *SQLLitePersistenceTestSuite) TestRatchetInfoNoBundle() {
 		[]byte("their-public-key"),
 		[]byte("non-existing-bundle"),
 		[]byte("non-existing-ephemeral-key"),
+		nil,
 		"none",
 	)
 
 	s.Error(err, "It returns an error")
 
-	_, err = s.service.GetRatchetInfo([]byte("non-existing-bundle"), []byte("their-public-key"))
+	_, err = s.service.GetRatchetInfo([]byte("non-existing-bundle"), []byte("their-public-key"), "none")
 	s.Require().NoError(err)
 
 	ratchetInfo, err := s.service.GetAnyRatchetInfo([]byte("their-public-key"), "4")
@@ -235,5 +279,207 @@ func (s *SQLLitePersistenceTestSuite) TestRatchetInfoNoBundle() {
 	s.Nil(ratchetInfo, "It returns nil when no bundle is there")
 }
 
-// TODO: Add test for MarkBundleExpired
+func (s *SQLLitePersistenceTestSuite) TestInstallations() {
+	identity := []byte("identity")
+
+	installations, err := s.service.GetInstallations(identity)
+	s.Require().NoError(err)
+	s.Empty(installations, "It returns no installations")
+
+	err = s.service.AddInstallations(identity, []string{"1", "2"}, false)
+	s.Require().NoError(err)
+
+	err = s.service.SetInstallationEnabled(identity, "2", true)
+	s.Require().NoError(err)
+
+	// Known installations are not changed
+	err = s.service.AddInstallations(identity, []string{"2"}, false)
+	s.Require().NoError(err)
+
+	err = s.service.SetInstallationMetadata(identity, "3", "desktop", 2)
+	s.Require().NoError(err)
+
+	// Older metadata is ignored
+	err = s.service.SetInstallationMetadata(identity, "3", "old name", 1)
+	s.Require().NoError(err)
+
+	installations, err = s.service.GetInstallations(identity)
+	s.Require().NoError(err)
+	s.Equal([]*Installation{
+		{ID: "1"},
+		{ID: "2", Enabled: true},
+		{ID: "3", Name: "desktop", Timestamp: 2, Enabled: true},
+	}, installations)
+
+	installations, err = s.service.GetInstallations([]byte("other-identity"))
+	s.Require().NoError(err)
+	s.Empty(installations, "It returns installations of the identity only")
+
+	err = s.service.SetInstallationEnabled(identity, "4", true)
+	s.Equal(ErrInstallationNotFound, err, "It returns an error for unknown installations")
+}
+
+func (s *SQLLitePersistenceTestSuite) TestBundleExpiry() {
+	installationID := "1"
+	theirPublicKey := []byte("their-public-key")
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	identity := crypto.CompressPubkey(&key.PublicKey)
+
+	bundle, err := NewBundleContainer(key, installationID)
+	s.Require().NoError(err)
+	s.Require().NoError(s.service.AddPrivateBundle(bundle))
+	bundleID := bundle.GetBundle().GetSignedPreKeys()[installationID].GetSignedPreKey()
+
+	// A bundle of our other installation
+	otherBundle, err := NewBundleContainer(key, "2")
+	s.Require().NoError(err)
+	s.Require().NoError(s.service.AddPublicBundle(otherBundle.GetBundle()))
+
+	err = s.service.AddRatchetInfo([]byte("symmetric-key"), theirPublicKey, bundleID, nil, nil, "3")
+	s.Require().NoError(err)
+
+	s.Require().NoError(s.service.MarkBundlesExpired(time.Now().Add(-time.Hour).UnixNano()))
+	anyPrivateBundle, err := s.service.GetAnyPrivateBundle(identity)
+	s.Require().NoError(err)
+	s.Len(anyPrivateBundle.GetBundle().GetSignedPreKeys(), 2, "It does not expire new bundles")
+
+	s.Require().NoError(s.service.MarkBundleExpired(identity))
+	anyPrivateBundle, err = s.service.GetAnyPrivateBundle(identity)
+	s.Require().NoError(err)
+	s.Require().NotNil(anyPrivateBundle)
+	s.Len(anyPrivateBundle.GetBundle().GetSignedPreKeys(), 1, "It expires our bundle only")
+	s.Equal(int64(0), anyPrivateBundle.Timestamp, "It does not return the timestamp of other bundles")
+
+	privateKey, err := s.service.GetPrivateKeyBundle(bundleID)
+	s.Require().NoError(err)
+	s.Equal(bundle.GetPrivateSignedPreKey(), privateKey, "It returns the private key of the expired bundle")
+
+	s.Require().NoError(s.service.DeleteExpiredBundles(time.Now().Add(-time.Hour).UnixNano()))
+	privateKey, err = s.service.GetPrivateKeyBundle(bundleID)
+	s.Require().NoError(err)
+	s.NotNil(privateKey, "It keeps bundles during the grace period")
+
+	s.Require().NoError(s.service.DeleteExpiredBundles(time.Now().UnixNano()))
+	privateKey, err = s.service.GetPrivateKeyBundle(bundleID)
+	s.Require().NoError(err)
+	s.Nil(privateKey, "It deletes the expired bundle")
+
+	ratchetInfo, err := s.service.GetRatchetInfo(bundleID, theirPublicKey, "3")
+	s.Require().NoError(err)
+	s.Nil(ratchetInfo, "It deletes the ratchet info")
+
+	publicBundle, err := s.service.GetPublicBundle(&key.PublicKey)
+	s.Require().NoError(err)
+	s.Require().NotNil(publicBundle)
+	s.Len(publicBundle.GetSignedPreKeys(), 1, "It keeps bundles of other installations")
+}
+
+func (s *SQLLitePersistenceTestSuite) TestDeleteRatchetInfo() {
+	installationID := "1"
+	theirPublicKey := []byte("their-public-key")
+	otherPublicKey := []byte("other-public-key")
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+
+	bundle, err := NewBundleContainer(key, installationID)
+	s.Require().NoError(err)
+	s.Require().NoError(s.service.AddPrivateBundle(bundle))
+	bundleID := bundle.GetBundle().GetSignedPreKeys()[installationID].GetSignedPreKey()
+
+	sessionStorage := s.service.GetSessionStorage()
+	for _, identity := range [][]byte{theirPublicKey, otherPublicKey} {
+		err = s.service.AddRatchetInfo([]byte("symmetric-key"), identity, bundleID, nil, nil, "2")
+		s.Require().NoError(err)
+	}
+	state := dr.DefaultState(dr.Key{0x01})
+	state.DHr = dr.Key{0x02}
+	s.Require().NoError(sessionStorage.Save(append(bundleID, []byte("2")...), &state))
+
+	received, err := s.service.IsRatchetKeyReceived(append(bundleID, []byte("2")...), state.DHr[:])
+	s.Require().NoError(err)
+	s.True(received, "It remembers their ratchet key")
+
+	s.Require().NoError(s.service.DeleteRatchetInfo(theirPublicKey, "2"))
+
+	received, err = s.service.IsRatchetKeyReceived(append(bundleID, []byte("2")...), state.DHr[:])
+	s.Require().NoError(err)
+	s.False(received, "It deletes their ratchet keys")
+
+	ratchetInfo, err := s.service.GetRatchetInfo(bundleID, theirPublicKey, "2")
+	s.Require().NoError(err)
+	s.Nil(ratchetInfo, "It deletes the ratchet info")
+
+	session, err := sessionStorage.Load(append(bundleID, []byte("2")...))
+	s.Require().NoError(err)
+	s.Nil(session, "It deletes the session")
+
+	ratchetInfo, err = s.service.GetRatchetInfo(bundleID, otherPublicKey, "2")
+	s.Require().NoError(err)
+	s.NotNil(ratchetInfo, "It keeps the ratchet info of other identities")
+}
+
 // TODO: Add test for AddPublicBundle checking that it expires previous bundles
+
+func (s *SQLLitePersistenceTestSuite) TestOneTimePreKeys() {
+	installationID := "1"
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	identity := crypto.CompressPubkey(&key.PublicKey)
+
+	// Our one-time prekeys
+	keys, err := GenerateOneTimePreKeys(2)
+	s.Require().NoError(err)
+	s.Require().NoError(s.service.AddPrivateOneTimePreKeys(identity, installationID, keys))
+
+	publicKeys, err := s.service.GetOneTimePreKeys(identity, installationID)
+	s.Require().NoError(err)
+	s.Len(publicKeys, 2, "It returns our one-time prekeys")
+
+	privateKey, err := s.service.GetPrivateOneTimePreKey(crypto.CompressPubkey(&keys[0].PublicKey))
+	s.Require().NoError(err)
+	s.Equal(crypto.FromECDSA(keys[0]), privateKey, "It returns the private key")
+
+	s.Require().NoError(s.service.DeleteOneTimePreKey(crypto.CompressPubkey(&keys[0].PublicKey)))
+	privateKey, err = s.service.GetPrivateOneTimePreKey(crypto.CompressPubkey(&keys[0].PublicKey))
+	s.Require().NoError(err)
+	s.Nil(privateKey, "It deletes the private key")
+
+	publicKeys, err = s.service.GetOneTimePreKeys(identity, installationID)
+	s.Require().NoError(err)
+	s.Equal([][]byte{crypto.CompressPubkey(&keys[1].PublicKey)}, publicKeys, "It returns the remaining one-time prekeys")
+
+	// Their one-time prekeys published in a bundle
+	bundle, err := NewBundleContainer(key, installationID)
+	s.Require().NoError(err)
+	bundle.GetBundle().GetSignedPreKeys()[installationID].OneTimePreKeys = [][]byte{[]byte("key-1")}
+	s.Require().NoError(s.service.AddPublicBundle(bundle.GetBundle()))
+
+	theirKey, err := s.service.GetAnyOneTimePreKey(identity, installationID)
+	s.Require().NoError(err)
+	s.Equal([]byte("key-1"), theirKey, "It returns their one-time prekey")
+
+	s.Require().NoError(s.service.MarkOneTimePreKeyUsed([]byte("key-1")))
+	theirKey, err = s.service.GetAnyOneTimePreKey(identity, installationID)
+	s.Require().NoError(err)
+	s.Nil(theirKey, "It does not return used one-time prekeys")
+
+	// A used key published again is not used twice
+	bundle.GetBundle().GetSignedPreKeys()[installationID].OneTimePreKeys = [][]byte{[]byte("key-1"), []byte("key-2")}
+	s.Require().NoError(s.service.AddPublicBundle(bundle.GetBundle()))
+	theirKey, err = s.service.GetAnyOneTimePreKey(identity, installationID)
+	s.Require().NoError(err)
+	s.Equal([]byte("key-2"), theirKey, "It returns the new one-time prekey")
+
+	// Keys which are not published anymore are removed
+	bundle.GetBundle().GetSignedPreKeys()[installationID].OneTimePreKeys = [][]byte{[]byte("key-3")}
+	s.Require().NoError(s.service.AddPublicBundle(bundle.GetBundle()))
+	theirKey, err = s.service.GetAnyOneTimePreKey(identity, installationID)
+	s.Require().NoError(err)
+	s.Equal([]byte("key-3"), theirKey, "It returns the published one-time prekey")
+
+	// Our keys are not changed by bundles
+	publicKeys, err = s.service.GetOneTimePreKeys(identity, installationID)
+	s.Require().NoError(err)
+	s.Equal([][]byte{crypto.CompressPubkey(&keys[1].PublicKey)}, publicKeys, "It keeps our one-time prekeys")
+}
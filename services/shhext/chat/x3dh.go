@@ -138,20 +138,25 @@ func PerformDH(privateKey *ecies.PrivateKey, publicKey *ecies.PublicKey) ([]byte
 	)
 }
 
-func getSharedSecret(dh1 []byte, dh2 []byte, dh3 []byte) []byte {
-	secretInput := append(append(dh1, dh2...), dh3...)
+func getSharedSecret(dhs ...[]byte) []byte {
+	var secretInput []byte
+	for _, dh := range dhs {
+		secretInput = append(secretInput, dh...)
+	}
 
 	return crypto.Keccak256(secretInput)
 }
 
-// x3dhActive handles initiating an X3DH session
+// x3dhActive handles initiating an X3DH session.
+// theirOneTimePreKey is optional, the fourth DH is performed only if it is set
 func x3dhActive(
 	myIdentityKey *ecies.PrivateKey,
 	theirSignedPreKey *ecies.PublicKey,
 	myEphemeralKey *ecies.PrivateKey,
 	theirIdentityKey *ecies.PublicKey,
+	theirOneTimePreKey *ecies.PublicKey,
 ) ([]byte, error) {
-	var dh1, dh2, dh3 []byte
+	var dh1, dh2, dh3, dh4 []byte
 	var err error
 
 	if dh1, err = PerformDH(myIdentityKey, theirSignedPreKey); err != nil {
@@ -166,17 +171,25 @@ func x3dhActive(
 		return nil, err
 	}
 
-	return getSharedSecret(dh1, dh2, dh3), nil
+	if theirOneTimePreKey != nil {
+		if dh4, err = PerformDH(myEphemeralKey, theirOneTimePreKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return getSharedSecret(dh1, dh2, dh3, dh4), nil
 }
 
-// x3dhPassive handles the response to an initiated X3DH session
+// x3dhPassive handles the response to an initiated X3DH session.
+// myOneTimePreKey is optional, the fourth DH is performed only if it is set
 func x3dhPassive(
 	theirIdentityKey *ecies.PublicKey,
 	mySignedPreKey *ecies.PrivateKey,
 	theirEphemeralKey *ecies.PublicKey,
 	myIdentityKey *ecies.PrivateKey,
+	myOneTimePreKey *ecies.PrivateKey,
 ) ([]byte, error) {
-	var dh1, dh2, dh3 []byte
+	var dh1, dh2, dh3, dh4 []byte
 	var err error
 
 	if dh1, err = PerformDH(mySignedPreKey, theirIdentityKey); err != nil {
@@ -191,7 +204,13 @@ func x3dhPassive(
 		return nil, err
 	}
 
-	return getSharedSecret(dh1, dh2, dh3), nil
+	if myOneTimePreKey != nil {
+		if dh4, err = PerformDH(myOneTimePreKey, theirEphemeralKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return getSharedSecret(dh1, dh2, dh3, dh4), nil
 }
 
 // PerformActiveDH performs a Diffie-Hellman exchange using a public key and a generated ephemeral key.
@@ -214,8 +233,9 @@ func PerformActiveDH(publicKey *ecdsa.PublicKey) ([]byte, *ecdsa.PublicKey, erro
 }
 
 // PerformActiveX3DH takes someone else's bundle and calculates shared secret.
+// The one-time prekey is optional and can be nil.
 // Returns the shared secret and the ephemeral key used.
-func PerformActiveX3DH(identity []byte, signedPreKey []byte, prv *ecdsa.PrivateKey) ([]byte, *ecdsa.PublicKey, error) {
+func PerformActiveX3DH(identity []byte, signedPreKey []byte, oneTimePreKey []byte, prv *ecdsa.PrivateKey) ([]byte, *ecdsa.PublicKey, error) {
 	bundleIdentityKey, err := crypto.DecompressPubkey(identity)
 	if err != nil {
 		return nil, nil, err
@@ -226,6 +246,15 @@ func PerformActiveX3DH(identity []byte, signedPreKey []byte, prv *ecdsa.PrivateK
 		return nil, nil, err
 	}
 
+	var bundleOneTimePreKey *ecies.PublicKey
+	if oneTimePreKey != nil {
+		key, err := crypto.DecompressPubkey(oneTimePreKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		bundleOneTimePreKey = ecies.ImportECDSAPublic(key)
+	}
+
 	ephemeralKey, err := crypto.GenerateKey()
 	if err != nil {
 		return nil, nil, err
@@ -236,6 +265,7 @@ func PerformActiveX3DH(identity []byte, signedPreKey []byte, prv *ecdsa.PrivateK
 		ecies.ImportECDSAPublic(bundleSignedPreKey),
 		ecies.ImportECDSA(ephemeralKey),
 		ecies.ImportECDSAPublic(bundleIdentityKey),
+		bundleOneTimePreKey,
 	)
 	if err != nil {
 		return nil, nil, err
@@ -246,13 +276,20 @@ func PerformActiveX3DH(identity []byte, signedPreKey []byte, prv *ecdsa.PrivateK
 
 // PerformPassiveX3DH handles the part of the protocol where
 // our interlocutor used our bundle, with ID of the signedPreKey,
-// we loaded our identity key and the correct signedPreKey and we perform X3DH
-func PerformPassiveX3DH(theirIdentityKey *ecdsa.PublicKey, mySignedPreKey *ecdsa.PrivateKey, theirEphemeralKey *ecdsa.PublicKey, myPrivateKey *ecdsa.PrivateKey) ([]byte, error) {
+// we loaded our identity key and the correct signedPreKey and we perform X3DH.
+// myOneTimePreKey is nil if our interlocutor did not use any of our one-time prekeys
+func PerformPassiveX3DH(theirIdentityKey *ecdsa.PublicKey, mySignedPreKey *ecdsa.PrivateKey, theirEphemeralKey *ecdsa.PublicKey, myPrivateKey *ecdsa.PrivateKey, myOneTimePreKey *ecdsa.PrivateKey) ([]byte, error) {
+	var oneTimePreKey *ecies.PrivateKey
+	if myOneTimePreKey != nil {
+		oneTimePreKey = ecies.ImportECDSA(myOneTimePreKey)
+	}
+
 	sharedSecret, err := x3dhPassive(
 		ecies.ImportECDSAPublic(theirIdentityKey),
 		ecies.ImportECDSA(mySignedPreKey),
 		ecies.ImportECDSAPublic(theirEphemeralKey),
 		ecies.ImportECDSA(myPrivateKey),
+		oneTimePreKey,
 	)
 	if err != nil {
 		return nil, err
@@ -260,3 +297,16 @@ func PerformPassiveX3DH(theirIdentityKey *ecdsa.PublicKey, mySignedPreKey *ecdsa
 
 	return sharedSecret, nil
 }
+
+// GenerateOneTimePreKeys generates the specified number of one-time prekeys
+func GenerateOneTimePreKeys(count int) ([]*ecdsa.PrivateKey, error) {
+	keys := make([]*ecdsa.PrivateKey, 0, count)
+	for i := 0; i < count; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -187,6 +187,7 @@ func TestX3dhActive(t *testing.T) {
 		ecies.ImportECDSAPublic(&bobSignedPreKey.PublicKey),
 		ecies.ImportECDSA(aliceEphemeralKey),
 		ecies.ImportECDSAPublic(&bobIdentityKey.PublicKey),
+		nil,
 	)
 	require.NoError(t, err, "Shared key should be generated without errors")
 	require.Equal(t, sharedKey, x3dh, "Should generate the correct key")
@@ -211,6 +212,7 @@ func TestPerformPassiveX3DH(t *testing.T) {
 		bobSignedPreKey,
 		&aliceEphemeralKey.PublicKey,
 		bobPrivateKey,
+		nil,
 	)
 	require.NoError(t, err, "Shared key should be generated without errors")
 	require.Equal(t, sharedKey, x3dh, "Should generate the correct key")
@@ -225,8 +227,53 @@ func TestPerformActiveX3DH(t *testing.T) {
 
 	signedPreKey := bundle.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey()
 
-	actualSharedSecret, actualEphemeralKey, err := PerformActiveX3DH(bundle.GetIdentity(), signedPreKey, privateKey)
+	actualSharedSecret, actualEphemeralKey, err := PerformActiveX3DH(bundle.GetIdentity(), signedPreKey, nil, privateKey)
 	require.NoError(t, err, "No error should be reported")
 	require.NotNil(t, actualEphemeralKey, "An ephemeral key-pair should be generated")
 	require.NotNil(t, actualSharedSecret, "A shared key should be generated")
 }
+
+func TestX3DHOneTimePreKey(t *testing.T) {
+	bundle, err := bobBundle()
+	require.NoError(t, err, "Test bundle should be generated without errors")
+
+	bobPrivateKey, err := crypto.ToECDSA([]byte(bobPrivateKey))
+	require.NoError(t, err, "Private key should be imported without errors")
+
+	bobSignedPreKey, err := crypto.ToECDSA([]byte(bobSignedPreKey))
+	require.NoError(t, err, "Private key should be imported without errors")
+
+	alicePrivateKey, err := crypto.ToECDSA([]byte(alicePrivateKey))
+	require.NoError(t, err, "Private key should be imported without errors")
+
+	oneTimePreKeys, err := GenerateOneTimePreKeys(1)
+	require.NoError(t, err, "One-time prekeys should be generated without errors")
+	require.Len(t, oneTimePreKeys, 1)
+	oneTimePreKey := crypto.CompressPubkey(&oneTimePreKeys[0].PublicKey)
+
+	signedPreKey := bundle.GetSignedPreKeys()[bobInstallationID].GetSignedPreKey()
+
+	aliceSharedSecret, aliceEphemeralKey, err := PerformActiveX3DH(bundle.GetIdentity(), signedPreKey, oneTimePreKey, alicePrivateKey)
+	require.NoError(t, err, "No error should be reported")
+
+	bobSharedSecret, err := PerformPassiveX3DH(
+		&alicePrivateKey.PublicKey,
+		bobSignedPreKey,
+		aliceEphemeralKey,
+		bobPrivateKey,
+		oneTimePreKeys[0],
+	)
+	require.NoError(t, err, "No error should be reported")
+	require.Equal(t, aliceSharedSecret, bobSharedSecret, "The same secret should be calculated")
+
+	// The one-time prekey is part of the secret
+	bobSharedSecret, err = PerformPassiveX3DH(
+		&alicePrivateKey.PublicKey,
+		bobSignedPreKey,
+		aliceEphemeralKey,
+		bobPrivateKey,
+		nil,
+	)
+	require.NoError(t, err, "No error should be reported")
+	require.NotEqual(t, aliceSharedSecret, bobSharedSecret, "A different secret should be calculated without the one-time prekey")
+}
@@ -50,6 +50,12 @@ func (api *DebugAPI) PostSync(ctx context.Context, req whisper.NewMessage) (hash
 	return
 }
 
+// RequestQueues returns the state of queues of requests for historic messages,
+// one for each MailServer processing or waiting for requests.
+func (api *DebugAPI) RequestQueues(_ context.Context) ([]RequestQueue, error) {
+	return api.s.scheduler.Queues(), nil
+}
+
 // waitForHash waits for a specific hash to be sent
 func (api *DebugAPI) waitForHash(ctx context.Context, hash hexutil.Bytes) error {
 	h := common.BytesToHash(hash)
@@ -0,0 +1,330 @@
+package shhext
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/status-im/status-go/params"
+	"github.com/status-im/status-go/peers"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// maxConnectedMailServers is the number of the best ranked MailServers
+	// connected when there is no connected MailServer to select.
+	maxConnectedMailServers = 2
+	// maxDiscoveredMailServers is the max number of MailServers read from the peer pool cache.
+	maxDiscoveredMailServers = 10
+	// mailServerFailureBackoff is the time a MailServer is ranked last after a failed request.
+	mailServerFailureBackoff = 5 * time.Minute
+	// mailServerVerificationTTL is the time a result of a discovered MailServer verification is kept.
+	mailServerVerificationTTL = time.Hour
+	// mailServerVerificationTimeout is the max time of a single MailServer verification.
+	mailServerVerificationTimeout = 5 * time.Second
+)
+
+// errMailServersPending is returned by Select when there is no MailServer to select
+// but discovered MailServers are still verified. Requests are queued until they are verified.
+var errMailServersPending = errors.New("mailservers verification pending")
+
+// MailServerVerifier verifies that a discovered node is a trusted MailServer,
+// e.g. it is listed in the on-chain registry.
+type MailServerVerifier interface {
+	VerifyNode(ctx context.Context, nodeID discover.NodeID) bool
+}
+
+// verification is a result of a MailServer verification.
+type verification struct {
+	trusted bool
+	at      time.Time
+}
+
+// peerServer is a part of p2p.Server used to connect to MailServers.
+type peerServer interface {
+	AddPeer(node *discover.Node)
+	Peers() []*p2p.Peer
+}
+
+// mailServerStats describes requests sent to a MailServer.
+type mailServerStats struct {
+	node *discover.Node
+	// discovered is set for MailServers found by the peer pool.
+	discovered bool
+	// requests is the number of finished requests, successful or not.
+	requests  int
+	successes int
+	// latency is a moving average of time between sending a request and receiving the response.
+	latency  time.Duration
+	failedAt time.Time
+}
+
+// successRate returns the ratio of successful requests.
+// MailServers without requests are ranked in the middle.
+func (s *mailServerStats) successRate() float64 {
+	return float64(s.successes+1) / float64(s.requests+2)
+}
+
+// sentRequest is a request waiting for a MailServer response.
+type sentRequest struct {
+	id   discover.NodeID
+	sent time.Time
+}
+
+// mailServerPool ranks known MailServers by their success rate and latency
+// measured from sent requests, so that the best one can be selected automatically.
+// MailServers are trusted nodes from the cluster config and MailServers found
+// by the peer pool, which are verified with the on-chain registry if it is configured.
+type mailServerPool struct {
+	mu      sync.Mutex
+	servers map[discover.NodeID]*mailServerStats
+	pending map[common.Hash]sentRequest
+	server  peerServer
+	// discovered returns MailServers found by the peer pool.
+	discovered func() []*discover.Node
+	// verifier checks discovered MailServers, it is optional.
+	// Verifications run in the background without holding the lock.
+	verifier      MailServerVerifier
+	verifications map[discover.NodeID]verification
+	verifying     map[discover.NodeID]struct{}
+	// verifierVersion is changed with the verifier so that results of its
+	// running verifications are discarded.
+	verifierVersion int
+	now             func() time.Time
+}
+
+// discoveredMailServers returns a function reading MailServers found by the peer pool
+// from its cache. Only trusted MailServers are cached by the peer pool.
+func discoveredMailServers(db *leveldb.DB) func() []*discover.Node {
+	cache := peers.NewCache(db)
+	return func() []*discover.Node {
+		var nodes []*discover.Node
+		for _, n := range cache.GetPeersRange(params.MailServerDiscv5Topic, maxDiscoveredMailServers) {
+			nodes = append(nodes, discover.NewNode(discover.NodeID(n.ID), n.IP, n.UDP, n.TCP))
+		}
+		return nodes
+	}
+}
+
+func newMailServerPool(nodes []*discover.Node, discovered func() []*discover.Node) *mailServerPool {
+	p := &mailServerPool{
+		servers:    make(map[discover.NodeID]*mailServerStats),
+		pending:    make(map[common.Hash]sentRequest),
+		discovered: discovered,
+		now:        time.Now,
+
+		verifications: make(map[discover.NodeID]verification),
+		verifying:     make(map[discover.NodeID]struct{}),
+	}
+	p.add(nodes...)
+	return p
+}
+
+// SetVerifier sets the verifier of discovered MailServers.
+func (p *mailServerPool) SetVerifier(verifier MailServerVerifier) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.verifier = verifier
+	p.verifierVersion++
+	p.verifications = make(map[discover.NodeID]verification)
+	p.verifying = make(map[discover.NodeID]struct{})
+}
+
+// Start sets the server used to check and establish connections with MailServers.
+func (p *mailServerPool) Start(server peerServer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.server = server
+}
+
+// add adds MailServers to the pool. Already known MailServers are kept.
+func (p *mailServerPool) add(nodes ...*discover.Node) {
+	for _, n := range nodes {
+		if _, ok := p.servers[n.ID]; !ok {
+			p.servers[n.ID] = &mailServerStats{node: n}
+		}
+	}
+}
+
+// addDiscovered adds MailServers found by the peer pool. Already known MailServers are kept.
+func (p *mailServerPool) addDiscovered(nodes ...*discover.Node) {
+	for _, n := range nodes {
+		if _, ok := p.servers[n.ID]; !ok {
+			p.servers[n.ID] = &mailServerStats{node: n, discovered: true}
+		}
+	}
+}
+
+// verified returns true if the MailServer can be used and false if it is not trusted
+// or not verified yet, which is reported as pending. Only discovered MailServers are
+// checked with the verifier. The last result is used until a verification started
+// after mailServerVerificationTTL replaces it.
+func (p *mailServerPool) verified(s *mailServerStats) (trusted, pending bool) {
+	if !s.discovered || p.verifier == nil {
+		return true, false
+	}
+
+	v, ok := p.verifications[s.node.ID]
+	if !ok || p.now().Sub(v.at) >= mailServerVerificationTTL {
+		p.startVerification(s.node)
+	}
+	if !ok {
+		return false, true
+	}
+	return v.trusted, false
+}
+
+// startVerification verifies the MailServer in the background unless
+// its verification is already running. It must be called with the lock held.
+func (p *mailServerPool) startVerification(node *discover.Node) {
+	if _, ok := p.verifying[node.ID]; ok {
+		return
+	}
+	p.verifying[node.ID] = struct{}{}
+	go p.verify(p.verifier, p.verifierVersion, node)
+}
+
+// verify calls the verifier, which can block for mailServerVerificationTimeout,
+// and stores the result.
+func (p *mailServerPool) verify(verifier MailServerVerifier, version int, node *discover.Node) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailServerVerificationTimeout)
+	trusted := verifier.VerifyNode(ctx, node.ID)
+	cancel()
+	if !trusted {
+		log.Debug("discovered mailserver is not verified", "enode", node)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if version != p.verifierVersion {
+		return
+	}
+	delete(p.verifying, node.ID)
+	p.verifications[node.ID] = verification{trusted: trusted, at: p.now()}
+}
+
+// ranked returns verified MailServers from the best to the worst and whether
+// some MailServers are not verified yet. Recently failed MailServers are always ranked last.
+func (p *mailServerPool) ranked() (result []*mailServerStats, pending bool) {
+	if p.discovered != nil {
+		p.addDiscovered(p.discovered()...)
+	}
+
+	now := p.now()
+	result = make([]*mailServerStats, 0, len(p.servers))
+	for _, s := range p.servers {
+		trusted, unverified := p.verified(s)
+		if trusted {
+			result = append(result, s)
+		}
+		pending = pending || unverified
+	}
+	sort.Slice(result, func(i, j int) bool {
+		failedI := now.Sub(result[i].failedAt) < mailServerFailureBackoff
+		failedJ := now.Sub(result[j].failedAt) < mailServerFailureBackoff
+		if failedI != failedJ {
+			return failedJ
+		}
+		if rateI, rateJ := result[i].successRate(), result[j].successRate(); rateI != rateJ {
+			return rateI > rateJ
+		}
+		if result[i].latency != result[j].latency {
+			return result[i].latency < result[j].latency
+		}
+		// keep the order stable
+		return result[i].node.ID.String() < result[j].node.ID.String()
+	})
+	return result, pending
+}
+
+// Select returns the best ranked connected MailServer.
+// If none of MailServers is connected, connections with the best ranked ones
+// are initiated and ErrNoMailServerConnected is returned, so that the request
+// can be retried later. If there are no MailServers to select yet because
+// discovered ones are still verified, errMailServersPending is returned.
+func (p *mailServerPool) Select() (*discover.Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ranked, pending := p.ranked()
+	if len(ranked) == 0 {
+		if pending {
+			return nil, errMailServersPending
+		}
+		return nil, ErrNoMailServers
+	}
+	if p.server == nil {
+		return nil, ErrNoMailServerConnected
+	}
+
+	connected := make(map[discover.NodeID]struct{})
+	for _, peer := range p.server.Peers() {
+		connected[peer.ID()] = struct{}{}
+	}
+	for _, s := range ranked {
+		if _, ok := connected[s.node.ID]; ok {
+			return s.node, nil
+		}
+	}
+
+	p.connect(ranked)
+	return nil, ErrNoMailServerConnected
+}
+
+// connect initiates connections with the best ranked MailServers.
+func (p *mailServerPool) connect(ranked []*mailServerStats) {
+	if p.server == nil {
+		return
+	}
+	for i := 0; i < len(ranked) && i < maxConnectedMailServers; i++ {
+		log.Debug("connecting to mailserver", "enode", ranked[i].node)
+		p.server.AddPeer(ranked[i].node)
+	}
+}
+
+// RequestSent starts measuring a request sent to the MailServer.
+func (p *mailServerPool) RequestSent(hash common.Hash, id discover.NodeID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending[hash] = sentRequest{id: id, sent: p.now()}
+}
+
+// RequestFinished updates statistics of the MailServer which received the request
+// and returns its ID. A request is not successful if MailServer responded with an error
+// or did not respond at all. Unknown requests are ignored.
+func (p *mailServerPool) RequestFinished(hash common.Hash, success bool) (discover.NodeID, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req, ok := p.pending[hash]
+	if !ok {
+		return discover.NodeID{}, false
+	}
+	delete(p.pending, hash)
+
+	s, ok := p.servers[req.id]
+	if !ok {
+		// a MailServer passed explicitly in the request
+		return req.id, true
+	}
+	s.requests++
+	if !success {
+		s.failedAt = p.now()
+		log.Debug("mailserver request failed", "enode", s.node, "requests", s.requests, "successes", s.successes)
+		return req.id, true
+	}
+	s.successes++
+	latency := p.now().Sub(req.sent)
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = (3*s.latency + latency) / 4
+	}
+	return req.id, true
+}
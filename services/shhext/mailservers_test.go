@@ -0,0 +1,251 @@
+package shhext
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePeerServer keeps a set of connected peers.
+type fakePeerServer struct {
+	mu    sync.Mutex
+	peers map[discover.NodeID]bool
+	added []discover.NodeID
+}
+
+func newFakePeerServer() *fakePeerServer {
+	return &fakePeerServer{peers: make(map[discover.NodeID]bool)}
+}
+
+func (s *fakePeerServer) AddPeer(node *discover.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added = append(s.added, node.ID)
+}
+
+func (s *fakePeerServer) Peers() []*p2p.Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var peers []*p2p.Peer
+	for id := range s.peers {
+		peers = append(peers, p2p.NewPeer(id, "", nil))
+	}
+	return peers
+}
+
+func (s *fakePeerServer) connect(nodes ...*discover.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range nodes {
+		s.peers[n.ID] = true
+	}
+}
+
+func newTestMailServerNode(b byte) *discover.Node {
+	return discover.NewNode(discover.NodeID{b}, net.IPv4(127, 0, 0, 1), 30303, 30303)
+}
+
+func newTestMailServerPool(nodes ...*discover.Node) (*mailServerPool, *fakePeerServer, *time.Time) {
+	now := time.Unix(1000, 0)
+	server := newFakePeerServer()
+	pool := newMailServerPool(nodes, nil)
+	pool.now = func() time.Time { return now }
+	pool.Start(server)
+	return pool, server, &now
+}
+
+func TestMailServerPoolSelectConnected(t *testing.T) {
+	nodes := []*discover.Node{newTestMailServerNode(1), newTestMailServerNode(2), newTestMailServerNode(3)}
+	pool, server, _ := newTestMailServerPool(nodes...)
+
+	_, err := pool.Select()
+	require.Equal(t, ErrNoMailServerConnected, err)
+	require.Len(t, server.added, maxConnectedMailServers)
+
+	server.connect(nodes[2])
+	selected, err := pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, nodes[2], selected)
+}
+
+func TestMailServerPoolNoMailServers(t *testing.T) {
+	pool, _, _ := newTestMailServerPool()
+	_, err := pool.Select()
+	require.Equal(t, ErrNoMailServers, err)
+}
+
+func TestMailServerPoolRanking(t *testing.T) {
+	nodes := []*discover.Node{newTestMailServerNode(1), newTestMailServerNode(2), newTestMailServerNode(3)}
+	pool, server, now := newTestMailServerPool(nodes...)
+	server.connect(nodes...)
+
+	request := func(hash common.Hash, n *discover.Node, latency time.Duration, success bool) {
+		pool.RequestSent(hash, n.ID)
+		*now = now.Add(latency)
+		pool.RequestFinished(hash, success)
+	}
+
+	// the fastest MailServer is preferred
+	request(common.Hash{0x01}, nodes[0], 2*time.Second, true)
+	request(common.Hash{0x02}, nodes[1], time.Second, true)
+	request(common.Hash{0x03}, nodes[2], time.Second, true)
+	request(common.Hash{0x04}, nodes[2], time.Second, true)
+	selected, err := pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, nodes[2], selected)
+
+	// failed MailServer is ranked last until the backoff passes
+	request(common.Hash{0x05}, nodes[2], time.Second, false)
+	selected, err = pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, nodes[1], selected)
+
+	*now = now.Add(mailServerFailureBackoff)
+	selected, err = pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, nodes[1], selected, "success rate is lower")
+
+	// unknown requests are ignored
+	pool.RequestFinished(common.Hash{0x06}, false)
+	require.Empty(t, pool.pending)
+}
+
+func TestMailServerPoolDiscovered(t *testing.T) {
+	discovered := newTestMailServerNode(1)
+	server := newFakePeerServer()
+	server.connect(discovered)
+	pool := newMailServerPool(nil, func() []*discover.Node {
+		return []*discover.Node{discovered}
+	})
+	pool.Start(server)
+
+	selected, err := pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, discovered, selected)
+}
+
+// fakeVerifier trusts a set of nodes and counts verifications.
+// If block is set, verifications wait until it is closed.
+type fakeVerifier struct {
+	mu      sync.Mutex
+	trusted map[discover.NodeID]bool
+	calls   int
+	block   chan struct{}
+}
+
+func (v *fakeVerifier) VerifyNode(_ context.Context, id discover.NodeID) bool {
+	if v.block != nil {
+		<-v.block
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.calls++
+	return v.trusted[id]
+}
+
+func (v *fakeVerifier) setTrusted(id discover.NodeID, trusted bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.trusted[id] = trusted
+}
+
+func (v *fakeVerifier) callsCount() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.calls
+}
+
+// waitForVerifications waits until background verifications of the pool are finished.
+func waitForVerifications(t *testing.T, pool *mailServerPool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pool.mu.Lock()
+		running := len(pool.verifying)
+		pool.mu.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			require.FailNow(t, "timed out while waiting for verifications")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMailServerPoolVerifier(t *testing.T) {
+	trusted := newTestMailServerNode(1)
+	verified := newTestMailServerNode(2)
+	unverified := newTestMailServerNode(3)
+	pool, server, now := newTestMailServerPool(trusted)
+	pool.discovered = func() []*discover.Node {
+		return []*discover.Node{verified, unverified}
+	}
+	verifier := &fakeVerifier{trusted: map[discover.NodeID]bool{verified.ID: true}}
+	pool.SetVerifier(verifier)
+
+	// discovered MailServers are verified in the background
+	server.connect(unverified)
+	_, err := pool.Select()
+	require.Equal(t, ErrNoMailServerConnected, err)
+	waitForVerifications(t, pool)
+	// MailServers from the cluster config are not verified
+	require.Equal(t, 2, verifier.callsCount())
+
+	// the unverified MailServer is never selected
+	_, err = pool.Select()
+	require.Equal(t, ErrNoMailServerConnected, err)
+
+	server.connect(verified)
+	selected, err := pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, verified, selected)
+	// results are cached
+	require.Equal(t, 2, verifier.callsCount())
+
+	// the MailServer removed from the registry is not selected after verification expires
+	verifier.setTrusted(verified.ID, false)
+	*now = now.Add(mailServerVerificationTTL)
+	_, err = pool.Select()
+	require.NoError(t, err)
+	waitForVerifications(t, pool)
+	require.Equal(t, 4, verifier.callsCount())
+	_, err = pool.Select()
+	require.Equal(t, ErrNoMailServerConnected, err)
+}
+
+func TestMailServerPoolVerificationPending(t *testing.T) {
+	discovered := newTestMailServerNode(1)
+	pool, server, _ := newTestMailServerPool()
+	server.connect(discovered)
+	pool.discovered = func() []*discover.Node {
+		return []*discover.Node{discovered}
+	}
+	verifier := &fakeVerifier{
+		trusted: map[discover.NodeID]bool{discovered.ID: true},
+		block:   make(chan struct{}),
+	}
+	pool.SetVerifier(verifier)
+
+	// a slow verification does not block the pool
+	_, err := pool.Select()
+	require.Equal(t, errMailServersPending, err)
+	pool.RequestSent(common.Hash{0x01}, discovered.ID)
+	_, known := pool.RequestFinished(common.Hash{0x01}, true)
+	require.True(t, known)
+	_, err = pool.Select()
+	require.Equal(t, errMailServersPending, err)
+
+	close(verifier.block)
+	waitForVerifications(t, pool)
+	require.Equal(t, 1, verifier.callsCount())
+	selected, err := pool.Select()
+	require.NoError(t, err)
+	require.Equal(t, discovered, selected)
+}
@@ -0,0 +1,263 @@
+package shhext
+
+import (
+	"crypto/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// maxActiveRequests is the max number of requests sent to a single MailServer at once.
+	// Other requests wait in the queue until one of the active requests is finished.
+	maxActiveRequests = 1
+	// maxRequestRetries is the max number of times an expired request is sent again.
+	maxRequestRetries = 3
+	// requestRetryBackoff is the delay before the first retry of an expired request.
+	// It is doubled with each retry.
+	requestRetryBackoff = time.Second
+	// pendingRequestRetryInterval is the delay before a request is sent again
+	// when discovered MailServers are still verified.
+	pendingRequestRetryInterval = time.Second
+)
+
+// queuedRequest is a request waiting until it can be sent.
+type queuedRequest struct {
+	request  *pagedRequest
+	priority int
+	queuedAt time.Time
+	// seq keeps requests with the same priority in order.
+	seq uint64
+}
+
+// requestQueue holds requests to a single MailServer.
+type requestQueue struct {
+	active []*pagedRequest
+	queued []*queuedRequest
+}
+
+// requestScheduler sends requests for historic messages so that each MailServer
+// processes a limited number of requests at once. Waiting requests are sent
+// in order of their priority. A request is finished when all its pages are delivered,
+// it fails or it expires and can't be retried.
+type requestScheduler struct {
+	tracker *tracker
+
+	mu     sync.Mutex
+	queues map[string]*requestQueue
+	seq    uint64
+	now    func() time.Time
+	// retryPending is the delay before a request waiting for verified MailServers is sent again.
+	retryPending time.Duration
+}
+
+func newRequestScheduler(t *tracker) *requestScheduler {
+	return &requestScheduler{
+		tracker:      t,
+		queues:       make(map[string]*requestQueue),
+		now:          time.Now,
+		retryPending: pendingRequestRetryInterval,
+	}
+}
+
+// Schedule sends the request to the MailServer identified by the key immediately
+// if it does not process too many requests already, otherwise the request is queued.
+// Requests to automatically selected MailServers share a queue with an empty key.
+// They are also queued if discovered MailServers are not verified yet.
+// It returns the ID used in all signals related to the request.
+func (s *requestScheduler) Schedule(key string, p *pagedRequest, priority int) (common.Hash, error) {
+	p.finished = func() { s.finish(key, p) }
+
+	s.mu.Lock()
+	q, ok := s.queues[key]
+	if !ok {
+		q = &requestQueue{}
+		s.queues[key] = q
+	}
+	s.seq++
+	r := &queuedRequest{
+		request:  p,
+		priority: priority,
+		queuedAt: s.now(),
+		seq:      s.seq,
+	}
+	if len(q.active) < maxActiveRequests && len(q.queued) == 0 {
+		q.active = append(q.active, p)
+		s.mu.Unlock()
+
+		// errors are returned to the caller as the request is sent immediately
+		err := s.send(p)
+		if err == errMailServersPending {
+			err = s.requeue(key, r)
+		}
+		if err != nil {
+			s.finish(key, p)
+			return common.Hash{}, err
+		}
+		return p.requestID, nil
+	}
+	defer s.mu.Unlock()
+
+	if _, err := rand.Read(p.requestID[:]); err != nil {
+		return common.Hash{}, err
+	}
+	q.push(r)
+	log.Debug("mailserver request queued", "requestID", p.requestID, "priority", priority, "queued", len(q.queued))
+	return p.requestID, nil
+}
+
+// push adds the request to the queue in order of priority.
+func (q *requestQueue) push(r *queuedRequest) {
+	q.queued = append(q.queued, r)
+	sort.Slice(q.queued, func(i, j int) bool {
+		if q.queued[i].priority != q.queued[j].priority {
+			return q.queued[i].priority > q.queued[j].priority
+		}
+		return q.queued[i].seq < q.queued[j].seq
+	})
+}
+
+// send sends the first page of the request and tracks it.
+func (s *requestScheduler) send(p *pagedRequest) error {
+	hash, err := p.next(nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if p.requestID == (common.Hash{}) {
+		p.requestID = hash
+	}
+	s.mu.Unlock()
+	s.tracker.AddPagedRequest(hash, time.After(p.timeout), p)
+	return nil
+}
+
+// requeue moves the active request back to the queue because there is no verified
+// MailServer to send it to yet. Queued requests are sent again after retryPending.
+func (s *requestScheduler) requeue(key string, r *queuedRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.request.requestID == (common.Hash{}) {
+		if _, err := rand.Read(r.request.requestID[:]); err != nil {
+			return err
+		}
+	}
+	q := s.queues[key]
+	for i, active := range q.active {
+		if active == r.request {
+			q.active = append(q.active[:i], q.active[i+1:]...)
+			break
+		}
+	}
+	q.push(r)
+	log.Debug("mailserver request waits for verified mailservers", "requestID", r.request.requestID, "queued", len(q.queued))
+	time.AfterFunc(s.retryPending, func() { s.resume(key) })
+	return nil
+}
+
+// finish removes the request from active requests and sends the next queued request.
+func (s *requestScheduler) finish(key string, p *pagedRequest) {
+	s.mu.Lock()
+	q, ok := s.queues[key]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	for i, active := range q.active {
+		if active == p {
+			q.active = append(q.active[:i], q.active[i+1:]...)
+			break
+		}
+	}
+	if len(q.active) == 0 && len(q.queued) == 0 {
+		delete(s.queues, key)
+	}
+	s.mu.Unlock()
+
+	s.resume(key)
+}
+
+// resume sends the next queued request if the MailServer does not process
+// too many requests already.
+func (s *requestScheduler) resume(key string) {
+	s.mu.Lock()
+	q, ok := s.queues[key]
+	if !ok || len(q.queued) == 0 || len(q.active) >= maxActiveRequests {
+		s.mu.Unlock()
+		return
+	}
+	next := q.queued[0]
+	q.queued = q.queued[1:]
+	q.active = append(q.active, next.request)
+	s.mu.Unlock()
+
+	// the request can be finished by the tracker which must not be blocked
+	go s.sendQueued(key, next)
+}
+
+// sendQueued sends a request taken from the queue.
+// If it can't be sent, it fails and the next request is sent.
+func (s *requestScheduler) sendQueued(key string, r *queuedRequest) {
+	err := s.send(r.request)
+	if err == errMailServersPending {
+		err = s.requeue(key, r)
+	}
+	if err != nil {
+		log.Error("failed to send queued mailserver request", "requestID", r.request.requestID, "err", err)
+		s.tracker.requestFailed(r.request.requestID, err)
+		s.finish(key, r.request)
+	}
+}
+
+// RequestQueue describes requests to a single MailServer.
+type RequestQueue struct {
+	// MailServer is the ID of the MailServer.
+	// It is empty for requests sent to automatically selected MailServers.
+	MailServer string `json:"mailServer"`
+	// Active is a list of IDs of requests sent to the MailServer.
+	Active []common.Hash `json:"active"`
+	// Queued is a list of waiting requests in order in which they will be sent.
+	Queued []QueuedRequest `json:"queued"`
+}
+
+// QueuedRequest describes a request waiting in the queue.
+type QueuedRequest struct {
+	RequestID common.Hash `json:"requestID"`
+	Priority  int         `json:"priority"`
+	// QueuedAt is the unix timestamp when the request was queued.
+	QueuedAt int64 `json:"queuedAt"`
+}
+
+// Queues returns the state of all request queues.
+func (s *requestScheduler) Queues() []RequestQueue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]RequestQueue, 0, len(s.queues))
+	for key, q := range s.queues {
+		info := RequestQueue{
+			MailServer: key,
+			Active:     make([]common.Hash, 0, len(q.active)),
+			Queued:     make([]QueuedRequest, 0, len(q.queued)),
+		}
+		for _, p := range q.active {
+			info.Active = append(info.Active, p.requestID)
+		}
+		for _, r := range q.queued {
+			info.Queued = append(info.Queued, QueuedRequest{
+				RequestID: r.request.requestID,
+				Priority:  r.priority,
+				QueuedAt:  r.queuedAt.Unix(),
+			})
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].MailServer < result[j].MailServer
+	})
+	return result
+}
@@ -0,0 +1,170 @@
+package shhext
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestScheduler(handler EnvelopeEventsHandler) *requestScheduler {
+	return newRequestScheduler(&tracker{
+		handler: handler,
+		cache:   map[common.Hash]EnvelopeState{},
+		pages:   map[common.Hash]*pagedRequest{},
+	})
+}
+
+// newTestRequest returns a request which reports its hash to the sent channel when it is sent.
+func newTestRequest(hash common.Hash, sent chan<- common.Hash) *pagedRequest {
+	return &pagedRequest{
+		timeout: time.Hour,
+		next: func([]byte) (common.Hash, error) {
+			sent <- hash
+			return hash, nil
+		},
+	}
+}
+
+func waitForSent(t *testing.T, sent <-chan common.Hash) common.Hash {
+	select {
+	case hash := <-sent:
+		return hash
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out while waiting for a request to be sent")
+	}
+	return common.Hash{}
+}
+
+func TestRequestSchedulerPriority(t *testing.T) {
+	s := newTestScheduler(nil)
+	sent := make(chan common.Hash, 3)
+
+	first := newTestRequest(common.Hash{0x01}, sent)
+	requestID, err := s.Schedule("a", first, 0)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{0x01}, requestID)
+	require.Equal(t, common.Hash{0x01}, waitForSent(t, sent))
+
+	background := newTestRequest(common.Hash{0x02}, sent)
+	backgroundID, err := s.Schedule("a", background, 0)
+	require.NoError(t, err)
+	active := newTestRequest(common.Hash{0x03}, sent)
+	activeID, err := s.Schedule("a", active, 1)
+	require.NoError(t, err)
+	require.Empty(t, sent)
+
+	// requests to other MailServers are not queued
+	_, err = s.Schedule("b", newTestRequest(common.Hash{0x04}, sent), 0)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{0x04}, waitForSent(t, sent))
+
+	queues := s.Queues()
+	require.Len(t, queues, 2)
+	require.Equal(t, "a", queues[0].MailServer)
+	require.Equal(t, []common.Hash{{0x01}}, queues[0].Active)
+	require.Len(t, queues[0].Queued, 2)
+	require.Equal(t, activeID, queues[0].Queued[0].RequestID)
+	require.Equal(t, backgroundID, queues[0].Queued[1].RequestID)
+
+	// a request with higher priority is sent first
+	first.finish()
+	require.Equal(t, common.Hash{0x03}, waitForSent(t, sent))
+	active.finish()
+	require.Equal(t, common.Hash{0x02}, waitForSent(t, sent))
+	// the ID returned when the request was queued is kept
+	require.Equal(t, backgroundID, background.requestID)
+
+	background.finish()
+	queues = s.Queues()
+	require.Len(t, queues, 1)
+	require.Equal(t, "b", queues[0].MailServer)
+}
+
+func TestRequestSchedulerSendError(t *testing.T) {
+	s := newTestScheduler(nil)
+	sendErr := errors.New("send failed")
+	failing := &pagedRequest{
+		next: func([]byte) (common.Hash, error) {
+			return common.Hash{}, sendErr
+		},
+	}
+
+	// the error is returned if the request is sent immediately
+	_, err := s.Schedule("a", failing, 0)
+	require.Equal(t, sendErr, err)
+	require.Empty(t, s.Queues())
+}
+
+func TestRequestSchedulerQueuedSendError(t *testing.T) {
+	mock := newHandlerMock(1)
+	s := newTestScheduler(mock)
+	sent := make(chan common.Hash, 2)
+
+	first := newTestRequest(common.Hash{0x01}, sent)
+	_, err := s.Schedule("a", first, 0)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{0x01}, waitForSent(t, sent))
+
+	requestID, err := s.Schedule("a", &pagedRequest{
+		next: func([]byte) (common.Hash, error) {
+			return common.Hash{}, errors.New("send failed")
+		},
+	}, 0)
+	require.NoError(t, err)
+	_, err = s.Schedule("a", newTestRequest(common.Hash{0x02}, sent), 0)
+	require.NoError(t, err)
+
+	// the failed request is reported and the next one is sent
+	first.finish()
+	select {
+	case failed := <-mock.requestsFailed:
+		require.Equal(t, requestID, failed)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out while waiting for a request to fail")
+	}
+	require.Equal(t, common.Hash{0x02}, waitForSent(t, sent))
+}
+
+func TestRequestSchedulerPendingMailServers(t *testing.T) {
+	s := newTestScheduler(nil)
+	s.retryPending = 10 * time.Millisecond
+	sent := make(chan common.Hash, 1)
+
+	var (
+		mu      sync.Mutex
+		pending = true
+	)
+	request := &pagedRequest{
+		timeout: time.Hour,
+		next: func([]byte) (common.Hash, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if pending {
+				return common.Hash{}, errMailServersPending
+			}
+			sent <- common.Hash{0x01}
+			return common.Hash{0x01}, nil
+		},
+	}
+
+	// the request is queued until MailServers are verified
+	requestID, err := s.Schedule("", request, 0)
+	require.NoError(t, err)
+	require.NotEqual(t, common.Hash{}, requestID)
+	queues := s.Queues()
+	require.Len(t, queues, 1)
+	require.Empty(t, queues[0].Active)
+	require.Len(t, queues[0].Queued, 1)
+	require.Equal(t, requestID, queues[0].Queued[0].RequestID)
+
+	mu.Lock()
+	pending = false
+	mu.Unlock()
+	require.Equal(t, common.Hash{0x01}, waitForSent(t, sent))
+	// the ID returned when the request was queued is kept
+	require.Equal(t, requestID, request.requestID)
+}
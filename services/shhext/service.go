@@ -13,6 +13,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/rpc"
 	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
 	"github.com/status-im/status-go/services/shhext/chat"
@@ -22,6 +23,9 @@ import (
 
 var errProtocolNotInitialized = errors.New("procotol is not initialized")
 
+// bundleRotationInterval is the time between checks for expired bundles.
+const bundleRotationInterval = time.Hour
+
 // EnvelopeState in local tracker
 type EnvelopeState int
 
@@ -38,7 +42,9 @@ const (
 type EnvelopeEventsHandler interface {
 	EnvelopeSent(common.Hash)
 	EnvelopeExpired(common.Hash)
-	MailServerRequestCompleted(common.Hash, common.Hash, []byte)
+	MailServerRequestCompleted(common.Hash, common.Hash, []byte, bool)
+	MailServerRequestProgress(common.Hash, common.Hash, []byte)
+	MailServerRequestFailed(common.Hash, error)
 	MailServerRequestExpired(common.Hash)
 }
 
@@ -46,6 +52,9 @@ type EnvelopeEventsHandler interface {
 type Service struct {
 	w              *whisper.Whisper
 	tracker        *tracker
+	scheduler      *requestScheduler
+	mailServers    *mailServerPool
+	syncState      *syncState
 	nodeID         *ecdsa.PrivateKey
 	deduplicator   *dedup.Deduplicator
 	protocol       *chat.ProtocolService
@@ -53,6 +62,13 @@ type Service struct {
 	dataDir        string
 	installationID string
 	pfsEnabled     bool
+	// bundleRotationPeriod and bundleGracePeriod override the defaults of the encryption service if set.
+	bundleRotationPeriod time.Duration
+	bundleGracePeriod    time.Duration
+
+	rotationInterval time.Duration
+	rotationWG       sync.WaitGroup
+	rotationQuit     chan struct{}
 }
 
 type ServiceConfig struct {
@@ -60,6 +76,15 @@ type ServiceConfig struct {
 	InstallationID string
 	Debug          bool
 	PFSEnabled     bool
+	// BundleRotationPeriod is the time after which the signed prekey of our bundle is rotated.
+	// The default of the encryption service is used if it is zero.
+	BundleRotationPeriod time.Duration
+	// BundleGracePeriod is the time expired bundles and sessions established with them are kept.
+	// The default of the encryption service is used if it is zero.
+	BundleGracePeriod time.Duration
+	// MailServers is a list of trusted MailServers selected
+	// when a request for historic messages does not specify one.
+	MailServers []*discover.Node
 }
 
 // Make sure that Service implements node.Service interface.
@@ -67,19 +92,39 @@ var _ node.Service = (*Service)(nil)
 
 // New returns a new Service. dataDir is a folder path to a network-independent location
 func New(w *whisper.Whisper, handler EnvelopeEventsHandler, db *leveldb.DB, config *ServiceConfig) *Service {
+	var (
+		discovered func() []*discover.Node
+		state      *syncState
+	)
+	if db != nil {
+		discovered = discoveredMailServers(db)
+		state = newSyncState(db)
+	}
+	mailServers := newMailServerPool(config.MailServers, discovered)
 	track := &tracker{
-		w:       w,
-		handler: handler,
-		cache:   map[common.Hash]EnvelopeState{},
+		w:            w,
+		handler:      handler,
+		mailServers:  mailServers,
+		syncState:    state,
+		retryBackoff: requestRetryBackoff,
+		cache:        map[common.Hash]EnvelopeState{},
+		pages:        map[common.Hash]*pagedRequest{},
 	}
 	return &Service{
 		w:              w,
 		tracker:        track,
+		scheduler:      newRequestScheduler(track),
+		mailServers:    mailServers,
+		syncState:      state,
 		deduplicator:   dedup.NewDeduplicator(w, db),
 		debug:          config.Debug,
 		dataDir:        config.DataDir,
 		installationID: config.InstallationID,
 		pfsEnabled:     config.PFSEnabled,
+
+		bundleRotationPeriod: config.BundleRotationPeriod,
+		bundleGracePeriod:    config.BundleGracePeriod,
+		rotationInterval:     bundleRotationInterval,
 	}
 }
 
@@ -101,11 +146,54 @@ func (s *Service) InitProtocol(address string, password string) error {
 	if err != nil {
 		return err
 	}
-	s.protocol = chat.NewProtocolService(chat.NewEncryptionService(persistence, s.installationID))
+
+	encryptionConfig := chat.DefaultEncryptionServiceConfig(s.installationID)
+	if s.bundleRotationPeriod > 0 {
+		encryptionConfig.BundleRotationPeriod = s.bundleRotationPeriod
+	}
+	if s.bundleGracePeriod > 0 {
+		encryptionConfig.BundleGracePeriod = s.bundleGracePeriod
+	}
+	s.protocol = chat.NewProtocolService(chat.NewEncryptionService(persistence, encryptionConfig))
+
+	s.stopBundleRotation()
+	s.startBundleRotation(s.protocol)
 
 	return nil
 }
 
+// startBundleRotation periodically rotates expired bundles of the protocol until it is stopped.
+func (s *Service) startBundleRotation(protocol *chat.ProtocolService) {
+	quit := make(chan struct{})
+	s.rotationQuit = quit
+	s.rotationWG.Add(1)
+	go func() {
+		defer s.rotationWG.Done()
+		ticker := time.NewTicker(s.rotationInterval)
+		defer ticker.Stop()
+		for {
+			if err := protocol.RotateBundles(); err != nil {
+				log.Error("failed to rotate bundles", "err", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// stopBundleRotation stops rotation of bundles of the previously initialized protocol.
+func (s *Service) stopBundleRotation() {
+	if s.rotationQuit == nil {
+		return
+	}
+	close(s.rotationQuit)
+	s.rotationWG.Wait()
+	s.rotationQuit = nil
+}
+
 func (s *Service) ProcessPublicBundle(myIdentityKey *ecdsa.PrivateKey, bundle *chat.Bundle) error {
 	if s.protocol == nil {
 		return errProtocolNotInitialized
@@ -122,6 +210,12 @@ func (s *Service) GetBundle(myIdentityKey *ecdsa.PrivateKey) (*chat.Bundle, erro
 	return s.protocol.GetBundle(myIdentityKey)
 }
 
+// SetMailServerVerifier sets the verifier of MailServers found by the peer pool
+// which are selected automatically, e.g. a verifier using the on-chain registry.
+func (s *Service) SetMailServerVerifier(verifier MailServerVerifier) {
+	s.mailServers.SetVerifier(verifier)
+}
+
 // APIs returns a list of new APIs.
 func (s *Service) APIs() []rpc.API {
 	apis := []rpc.API{
@@ -149,6 +243,7 @@ func (s *Service) APIs() []rpc.API {
 // It does nothing in this case but is required by `node.Service` interface.
 func (s *Service) Start(server *p2p.Server) error {
 	s.tracker.Start()
+	s.mailServers.Start(server)
 	s.nodeID = server.PrivateKey
 	return nil
 }
@@ -156,18 +251,66 @@ func (s *Service) Start(server *p2p.Server) error {
 // Stop is run when a service is stopped.
 // It does nothing in this case but is required by `node.Service` interface.
 func (s *Service) Stop() error {
+	s.stopBundleRotation()
 	s.tracker.Stop()
 	return nil
 }
 
+// pagedRequest is a request for historic messages delivered by MailServer
+// in many pages. Each page is requested with the cursor returned for the previous one.
+type pagedRequest struct {
+	// requestID is the hash of the first request. It is used in all signals.
+	requestID common.Hash
+	timeout   time.Duration
+	// next sends a request for the page pointed by the cursor and returns its hash.
+	next func(cursor []byte) (common.Hash, error)
+	// incomplete is set if envelopes of any previous page were not received.
+	incomplete bool
+	// retries is the number of times the request was sent again after it expired.
+	// Requests without MailServerPeer are sent again to the best MailServer at the moment.
+	retries int
+	// finished is called when the request is finished. It is set by the scheduler.
+	finished func()
+	// cursor points to the currently requested page.
+	cursor []byte
+	// sync is the requested time range used to update the sync state of topics
+	// when the request is completed. It is nil if the sync state can't be updated.
+	sync *syncRange
+	// mailServer is the MailServer which responded to the previous pages.
+	mailServer discover.NodeID
+	pages      int
+}
+
+// finish notifies the scheduler that the request is finished.
+func (p *pagedRequest) finish() {
+	if p.finished != nil {
+		p.finished()
+	}
+}
+
+// responded records the MailServer which responded to a page.
+// The sync state is not updated if pages were delivered by different MailServers.
+func (p *pagedRequest) responded(mailServer discover.NodeID, known bool) {
+	if !known || (p.pages > 0 && p.mailServer != mailServer) {
+		p.sync = nil
+	}
+	p.mailServer = mailServer
+	p.pages++
+}
+
 // tracker responsible for processing events for envelopes that we are interested in
 // and calling specified handler.
 type tracker struct {
-	w       *whisper.Whisper
-	handler EnvelopeEventsHandler
+	w           *whisper.Whisper
+	handler     EnvelopeEventsHandler
+	mailServers *mailServerPool
+	syncState   *syncState
+	// retryBackoff is the delay before the first retry of an expired request.
+	retryBackoff time.Duration
 
 	mu    sync.Mutex
 	cache map[common.Hash]EnvelopeState
+	pages map[common.Hash]*pagedRequest
 
 	wg   sync.WaitGroup
 	quit chan struct{}
@@ -196,14 +339,47 @@ func (t *tracker) Add(hash common.Hash) {
 	t.cache[hash] = EnvelopePosted
 }
 
-// Add request hash to a tracker.
-func (t *tracker) AddRequest(hash common.Hash, timerC <-chan time.Time) {
+// AddPagedRequest adds request hash to a tracker. If MailServer returns
+// a cursor, the next page is requested automatically until all pages are delivered.
+func (t *tracker) AddPagedRequest(hash common.Hash, timerC <-chan time.Time, p *pagedRequest) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.cache[hash] = MailServerRequestSent
+	t.pages[hash] = p
 	go t.expireRequest(hash, timerC)
 }
 
+func (t *tracker) requestNextPage(p *pagedRequest, cursor []byte) {
+	hash, err := p.next(cursor)
+	if err != nil {
+		log.Error("failed to request next page", "requestID", p.requestID, "err", err)
+		t.requestFailed(p.requestID, err)
+		p.finish()
+		return
+	}
+	t.AddPagedRequest(hash, time.After(p.timeout), p)
+}
+
+// retryRequest sends the expired page again after a delay
+// which is doubled with each retry.
+func (t *tracker) retryRequest(p *pagedRequest) {
+	select {
+	case <-t.quit:
+		return
+	case <-time.After(t.retryBackoff << uint(p.retries-1)):
+	}
+	t.requestNextPage(p, p.cursor)
+}
+
+// requestFailed notifies that the request could not be sent.
+func (t *tracker) requestFailed(requestID common.Hash, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.handler != nil {
+		t.handler.MailServerRequestFailed(requestID, err)
+	}
+}
+
 func (t *tracker) expireRequest(hash common.Hash, timerC <-chan time.Time) {
 	select {
 	case <-t.quit:
@@ -289,13 +465,73 @@ func (t *tracker) handleEventMailServerRequestCompleted(event whisper.EnvelopeEv
 	}
 	log.Debug("mailserver response received", "hash", event.Hash)
 	delete(t.cache, event.Hash)
-	if t.handler != nil {
-		if resp, ok := event.Data.(*whisper.MailServerResponse); ok {
-			t.handler.MailServerRequestCompleted(event.Hash, resp.LastEnvelopeHash, resp.Cursor)
+
+	resp, ok := event.Data.(*whisper.MailServerResponse)
+	var (
+		mailServer discover.NodeID
+		known      bool
+	)
+	if t.mailServers != nil {
+		mailServer, known = t.mailServers.RequestFinished(event.Hash, ok && resp.Error == nil)
+	}
+	if !ok {
+		if p, ok := t.pages[event.Hash]; ok {
+			delete(t.pages, event.Hash)
+			p.finish()
+		}
+		return
+	}
+
+	requestID := event.Hash
+	incomplete := isIncompleteResponse(resp)
+	if incomplete {
+		log.Warn("envelopes from mailserver were not received", "hash", event.Hash,
+			"sent", resp.Checksum.Envelopes, "received", resp.Received.Envelopes)
+	}
+	p, paged := t.pages[event.Hash]
+	if paged {
+		delete(t.pages, event.Hash)
+		requestID = p.requestID
+		p.responded(mailServer, known)
+		p.incomplete = p.incomplete || incomplete
+		incomplete = p.incomplete
+		if resp.Error == nil && len(resp.Cursor) > 0 {
+			if t.handler != nil {
+				t.handler.MailServerRequestProgress(requestID, resp.LastEnvelopeHash, resp.Cursor)
+			}
+			p.cursor = resp.Cursor
+			go t.requestNextPage(p, resp.Cursor)
+			return
+		}
+		defer p.finish()
+	}
+
+	if resp.Error != nil {
+		log.Debug("mailserver request failed", "hash", event.Hash, "err", resp.Error)
+		if t.handler != nil {
+			t.handler.MailServerRequestFailed(requestID, resp.Error)
 		}
+		return
+	}
+
+	if paged && p.sync != nil && !incomplete && t.syncState != nil {
+		if err := t.syncState.Update(p.mailServer, *p.sync); err != nil {
+			log.Error("failed to update sync state", "requestID", requestID, "err", err)
+		}
+	}
+
+	if t.handler != nil {
+		t.handler.MailServerRequestCompleted(requestID, resp.LastEnvelopeHash, resp.Cursor, incomplete)
 	}
 }
 
+// isIncompleteResponse returns true if the mailserver sent a checksum of the envelopes
+// and it does not match the envelopes received from the mailserver.
+// Responses of mailservers which do not send checksums are never incomplete.
+func isIncompleteResponse(resp *whisper.MailServerResponse) bool {
+	return resp.Error == nil && resp.Checksum != nil && *resp.Checksum != resp.Received
+}
+
 func (t *tracker) handleEventMailServerRequestExpired(event whisper.EnvelopeEvent) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -306,7 +542,24 @@ func (t *tracker) handleEventMailServerRequestExpired(event whisper.EnvelopeEven
 	}
 	log.Debug("mailserver response expired", "hash", event.Hash)
 	delete(t.cache, event.Hash)
+	if t.mailServers != nil {
+		t.mailServers.RequestFinished(event.Hash, false)
+	}
+
+	requestID := event.Hash
+	if p, ok := t.pages[event.Hash]; ok {
+		delete(t.pages, event.Hash)
+		requestID = p.requestID
+		if p.retries < maxRequestRetries {
+			p.retries++
+			log.Debug("retrying expired mailserver request", "requestID", requestID, "retries", p.retries)
+			go t.retryRequest(p)
+			return
+		}
+		defer p.finish()
+	}
+
 	if t.handler != nil {
-		t.handler.MailServerRequestExpired(event.Hash)
+		t.handler.MailServerRequestExpired(requestID)
 	}
 }
@@ -4,25 +4,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/mailserver"
+	"github.com/status-im/status-go/params"
+	"github.com/status-im/status-go/services/shhext/chat"
 	"github.com/status-im/status-go/t/helpers"
 	"github.com/stretchr/testify/suite"
 )
 
 func newHandlerMock(buf int) handlerMock {
 	return handlerMock{
-		confirmations:     make(chan common.Hash, buf),
-		expirations:       make(chan common.Hash, buf),
-		requestsCompleted: make(chan common.Hash, buf),
-		requestsExpired:   make(chan common.Hash, buf),
+		confirmations:      make(chan common.Hash, buf),
+		expirations:        make(chan common.Hash, buf),
+		requestsCompleted:  make(chan common.Hash, buf),
+		requestsIncomplete: make(chan common.Hash, buf),
+		requestsProgress:   make(chan common.Hash, buf),
+		requestsFailed:     make(chan common.Hash, buf),
+		requestsExpired:    make(chan common.Hash, buf),
 	}
 }
 
@@ -30,7 +40,11 @@ type handlerMock struct {
 	confirmations     chan common.Hash
 	expirations       chan common.Hash
 	requestsCompleted chan common.Hash
-	requestsExpired   chan common.Hash
+	// requestsIncomplete receives completed requests with missing envelopes
+	requestsIncomplete chan common.Hash
+	requestsProgress   chan common.Hash
+	requestsFailed     chan common.Hash
+	requestsExpired    chan common.Hash
 }
 
 func (t handlerMock) EnvelopeSent(hash common.Hash) {
@@ -41,10 +55,21 @@ func (t handlerMock) EnvelopeExpired(hash common.Hash) {
 	t.expirations <- hash
 }
 
-func (t handlerMock) MailServerRequestCompleted(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte) {
+func (t handlerMock) MailServerRequestCompleted(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte, incomplete bool) {
+	if incomplete {
+		t.requestsIncomplete <- requestID
+	}
 	t.requestsCompleted <- requestID
 }
 
+func (t handlerMock) MailServerRequestProgress(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte) {
+	t.requestsProgress <- requestID
+}
+
+func (t handlerMock) MailServerRequestFailed(requestID common.Hash, err error) {
+	t.requestsFailed <- requestID
+}
+
 func (t handlerMock) MailServerRequestExpired(hash common.Hash) {
 	t.requestsExpired <- hash
 }
@@ -215,6 +240,15 @@ func (s *ShhExtSuite) TestRequestMessagesErrors() {
 	})
 	s.Nil(hash)
 	s.Contains(err.Error(), "Query range is invalid: from > to (10 > 5)")
+
+	// MailServer is not set and there are no known MailServers
+	hash, err = api.RequestMessages(context.TODO(), MessagesRequest{})
+	s.Nil(hash)
+	s.Equal(ErrNoMailServers, err)
+
+	// sync state is not persisted without a database
+	_, err = api.SyncMessages(context.TODO(), SyncMessagesRequest{Topics: []whisper.TopicType{testTopicA}})
+	s.Equal(ErrSyncStateNotAvailable, err)
 }
 
 func (s *ShhExtSuite) TestRequestMessagesSuccess() {
@@ -281,6 +315,14 @@ func (s *ShhExtSuite) TestRequestMessagesSuccess() {
 	s.NotNil(hash)
 	s.Contains(api.service.tracker.cache, common.BytesToHash(hash))
 
+	// the next request to the same MailServer is sent when the previous one is finished
+	service.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  common.BytesToHash(hash),
+		Data:  &whisper.MailServerResponse{},
+	})
+	s.Equal(common.BytesToHash(hash), <-mock.requestsCompleted)
+
 	// Send a request without a symmetric key. In this case,
 	// a public key extracted from MailServerPeer will be used.
 	hash, err = api.RequestMessages(context.TODO(), MessagesRequest{
@@ -289,6 +331,106 @@ func (s *ShhExtSuite) TestRequestMessagesSuccess() {
 	s.NoError(err)
 	s.NotNil(hash)
 	s.Contains(api.service.tracker.cache, common.BytesToHash(hash))
+
+	// sync topics which were never synced
+	// the request is queued as the previous request is not finished
+	service.syncState = newTestSyncState(s.T())
+	hashes, err := api.SyncMessages(context.TODO(), SyncMessagesRequest{
+		MailServerPeer: mailNode.Server().Self().String(),
+		Topics:         []whisper.TopicType{testTopicA, testTopicB},
+		Priority:       1,
+	})
+	s.NoError(err)
+	s.Len(hashes, 1)
+	s.NotContains(api.service.tracker.cache, common.BytesToHash(hashes[0]))
+
+	queues, err := NewDebugAPI(service).RequestQueues(context.TODO())
+	s.NoError(err)
+	s.Require().Len(queues, 1)
+	s.Equal(mailNode.Server().Self().ID.String(), queues[0].MailServer)
+	s.Equal([]common.Hash{common.BytesToHash(hash)}, queues[0].Active)
+	s.Require().Len(queues[0].Queued, 1)
+	s.Equal(common.BytesToHash(hashes[0]), queues[0].Queued[0].RequestID)
+	s.Equal(1, queues[0].Queued[0].Priority)
+}
+
+func (s *ShhExtSuite) TestRequestMessagesReplayProtection() {
+	dir, err := ioutil.TempDir("", "test-shhext-mailserver")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	shh := whisper.New(nil)
+	aNode, err := node.New(&node.Config{
+		P2P: p2p.Config{
+			MaxPeers:    math.MaxInt32,
+			NoDiscovery: true,
+		},
+	}) // in-memory node as no data dir
+	s.Require().NoError(err)
+	s.Require().NoError(aNode.Register(func(*node.ServiceContext) (node.Service, error) { return shh, nil }))
+	s.Require().NoError(aNode.Start())
+	defer func() { s.NoError(aNode.Stop()) }()
+
+	service := New(shh, newHandlerMock(1), nil, &ServiceConfig{InstallationID: "1", DataDir: os.TempDir()})
+	// requests are signed with the node key
+	service.nodeID = aNode.Server().PrivateKey
+	api := NewPublicAPI(service)
+
+	// a mailserver which rejects requests without a timestamp
+	mailShh := whisper.New(nil)
+	var mailServer mailserver.WMailServer
+	s.Require().NoError(mailServer.Init(mailShh, &params.WhisperConfig{
+		DataDir:                  dir,
+		MailServerPassword:       "some-pass",
+		MailServerRequestMaxSkew: 60,
+	}))
+	defer mailServer.Close()
+	mailShh.RegisterServer(&mailServer)
+
+	mailNode, err := node.New(&node.Config{
+		P2P: p2p.Config{
+			MaxPeers:    math.MaxInt32,
+			NoDiscovery: true,
+			ListenAddr:  ":0",
+		},
+	}) // in-memory node as no data dir
+	s.Require().NoError(err)
+	s.Require().NoError(mailNode.Register(func(*node.ServiceContext) (node.Service, error) { return mailShh, nil }))
+	s.Require().NoError(mailNode.Start())
+	defer func() { s.NoError(mailNode.Stop()) }()
+
+	waitErr := helpers.WaitForPeerAsync(aNode.Server(), mailNode.Server().Self().String(), p2p.PeerEventTypeAdd, time.Second)
+	aNode.Server().AddPeer(mailNode.Server().Self())
+	s.Require().NoError(<-waitErr)
+
+	events := make(chan whisper.EnvelopeEvent, 10)
+	sub := shh.SubscribeEnvelopeEvents(events)
+	defer sub.Unsubscribe()
+
+	// the default request with a single topic
+	symKeyID, err := shh.AddSymKeyFromPassword("some-pass")
+	s.Require().NoError(err)
+	hash, err := api.RequestMessages(context.TODO(), MessagesRequest{
+		MailServerPeer: mailNode.Server().Self().String(),
+		SymKeyID:       symKeyID,
+	})
+	s.Require().NoError(err)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Event != whisper.EventMailServerRequestCompleted || ev.Hash != common.BytesToHash(hash) {
+				continue
+			}
+			resp, ok := ev.Data.(*whisper.MailServerResponse)
+			s.Require().True(ok)
+			s.Nil(resp.Error, "It is accepted by the mailserver")
+			return
+		case <-timeout:
+			s.FailNow("mailserver response was not received")
+		}
+	}
 }
 
 func (s *ShhExtSuite) TestDebugPostSync() {
@@ -383,6 +525,74 @@ func (s *ShhExtSuite) TestEnvelopeExpiredOnDebugPostSync() {
 	s.Equal(errEnvelopeExpired.Error(), err.Error())
 }
 
+func (s *ShhExtSuite) TestInitProtocolBundleRotation() {
+	dir, err := ioutil.TempDir("", "test-shhext-protocol")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	service := New(whisper.New(nil), nil, nil, &ServiceConfig{
+		InstallationID:       "1",
+		DataDir:              dir,
+		PFSEnabled:           true,
+		BundleRotationPeriod: time.Minute,
+	})
+	s.Require().NoError(service.InitProtocol("0x01", "password"))
+	rotationQuit := service.rotationQuit
+	s.Require().NotNil(rotationQuit)
+
+	// the rotation of bundles of the previous protocol is stopped
+	s.Require().NoError(service.InitProtocol("0x01", "password"))
+	select {
+	case <-rotationQuit:
+	default:
+		s.Fail("rotation of the previous protocol was not stopped")
+	}
+
+	service.stopBundleRotation()
+	s.Nil(service.rotationQuit)
+}
+
+func (s *ShhExtSuite) TestBundleRotationDeletesExpiredBundles() {
+	dir, err := ioutil.TempDir("", "test-shhext-protocol")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	service := New(whisper.New(nil), nil, nil, &ServiceConfig{
+		InstallationID:       "1",
+		DataDir:              dir,
+		PFSEnabled:           true,
+		BundleRotationPeriod: time.Millisecond,
+		BundleGracePeriod:    time.Millisecond,
+	})
+	service.rotationInterval = 10 * time.Millisecond
+	s.Require().NoError(service.InitProtocol("0x01", "password"))
+	defer service.stopBundleRotation()
+
+	key, err := crypto.GenerateKey()
+	s.Require().NoError(err)
+	bundle, err := service.GetBundle(key)
+	s.Require().NoError(err)
+	bundleID := bundle.GetSignedPreKeys()["1"].GetSignedPreKey()
+
+	persistence, err := chat.NewSQLLitePersistence(filepath.Join(dir, fmt.Sprintf("%x.db", "0x01")), "password")
+	s.Require().NoError(err)
+
+	// the rotation job expires the bundle and deletes it after the grace period
+	deadline := time.After(5 * time.Second)
+	for {
+		privateKey, err := persistence.GetPrivateKeyBundle(bundleID)
+		s.Require().NoError(err)
+		if privateKey == nil {
+			break
+		}
+		select {
+		case <-deadline:
+			s.FailNow("expired bundle was not deleted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
 func (s *ShhExtSuite) TearDown() {
 	for _, n := range s.nodes {
 		s.NoError(n.Stop())
@@ -406,6 +616,7 @@ type TrackerSuite struct {
 func (s *TrackerSuite) SetupTest() {
 	s.tracker = &tracker{
 		cache: map[common.Hash]EnvelopeState{},
+		pages: map[common.Hash]*pagedRequest{},
 	}
 }
 
@@ -439,10 +650,20 @@ func (s *TrackerSuite) TestRemoved() {
 	s.NotContains(s.tracker.cache, testHash)
 }
 
+// newSinglePageRequest returns a request which is finished with the first response
+// and is not retried when it expires.
+func newSinglePageRequest(hash common.Hash) *pagedRequest {
+	return &pagedRequest{
+		requestID: hash,
+		timeout:   defaultRequestTimeout * time.Second,
+		retries:   maxRequestRetries,
+	}
+}
+
 func (s *TrackerSuite) TestRequestCompleted() {
 	mock := newHandlerMock(1)
 	s.tracker.handler = mock
-	s.tracker.AddRequest(testHash, time.After(defaultRequestTimeout*time.Second))
+	s.tracker.AddPagedRequest(testHash, time.After(defaultRequestTimeout*time.Second), newSinglePageRequest(testHash))
 	s.Contains(s.tracker.cache, testHash)
 	s.Equal(MailServerRequestSent, s.tracker.cache[testHash])
 	s.tracker.handleEvent(whisper.EnvelopeEvent{
@@ -457,13 +678,54 @@ func (s *TrackerSuite) TestRequestCompleted() {
 	case <-time.After(10 * time.Second):
 		s.Fail("timed out while waiting for a request to be completed")
 	}
+	s.Empty(mock.requestsIncomplete)
+}
+
+func (s *TrackerSuite) TestRequestIncomplete() {
+	mock := newHandlerMock(1)
+	s.tracker.handler = mock
+	s.tracker.AddPagedRequest(testHash, time.After(defaultRequestTimeout*time.Second), newSinglePageRequest(testHash))
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  testHash,
+		Data: &whisper.MailServerResponse{
+			Checksum: &whisper.MailServerChecksum{Envelopes: 2, Hash: common.Hash{0x01}},
+			Received: whisper.MailServerChecksum{Envelopes: 1, Hash: common.Hash{0x02}},
+		},
+	})
+	select {
+	case requestID := <-mock.requestsCompleted:
+		s.Equal(testHash, requestID)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for a request to be completed")
+	}
+	s.Equal(testHash, <-mock.requestsIncomplete)
+}
+
+func (s *TrackerSuite) TestRequestFailed() {
+	mock := newHandlerMock(1)
+	s.tracker.handler = mock
+	s.tracker.AddPagedRequest(testHash, time.After(defaultRequestTimeout*time.Second), newSinglePageRequest(testHash))
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  testHash,
+		Data:  &whisper.MailServerResponse{Error: &whisper.MailServerError{Code: 5, Message: "peer requests limit exceeded"}},
+	})
+	select {
+	case requestID := <-mock.requestsFailed:
+		s.Equal(testHash, requestID)
+		s.NotContains(s.tracker.cache, testHash)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for a request to fail")
+	}
+	s.Empty(mock.requestsCompleted)
 }
 
 func (s *TrackerSuite) TestRequestExpiration() {
 	mock := newHandlerMock(1)
 	s.tracker.handler = mock
 	c := make(chan time.Time)
-	s.tracker.AddRequest(testHash, c)
+	s.tracker.AddPagedRequest(testHash, c, newSinglePageRequest(testHash))
 	s.Contains(s.tracker.cache, testHash)
 	s.Equal(MailServerRequestSent, s.tracker.cache[testHash])
 	s.tracker.handleEvent(whisper.EnvelopeEvent{
@@ -478,3 +740,179 @@ func (s *TrackerSuite) TestRequestExpiration() {
 		s.Fail("timed out while waiting for request expiration")
 	}
 }
+
+func (s *TrackerSuite) TestPagedRequestCompleted() {
+	mock := newHandlerMock(1)
+	s.tracker.handler = mock
+
+	nextHash := common.Hash{0x02}
+	cursors := make(chan []byte, 1)
+	s.tracker.AddPagedRequest(testHash, time.After(defaultRequestTimeout*time.Second), &pagedRequest{
+		requestID: testHash,
+		timeout:   defaultRequestTimeout * time.Second,
+		next: func(cursor []byte) (common.Hash, error) {
+			cursors <- cursor
+			return nextHash, nil
+		},
+	})
+
+	// envelopes missing from the first page are reported when the request is completed
+	cursor := []byte{0x01}
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  testHash,
+		Data: &whisper.MailServerResponse{
+			Cursor:   cursor,
+			Checksum: &whisper.MailServerChecksum{Envelopes: 1},
+		},
+	})
+	select {
+	case requestID := <-mock.requestsProgress:
+		s.Equal(testHash, requestID)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for a request progress")
+	}
+	select {
+	case c := <-cursors:
+		s.Equal(cursor, c)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for the next page request")
+	}
+
+	// the next page is tracked asynchronously
+	tracked := func() bool {
+		s.tracker.mu.Lock()
+		defer s.tracker.mu.Unlock()
+		_, ok := s.tracker.pages[nextHash]
+		return ok
+	}
+	for start := time.Now(); !tracked(); time.Sleep(10 * time.Millisecond) {
+		s.Require().True(time.Since(start) < 10*time.Second, "timed out while waiting for the next page to be tracked")
+	}
+
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  nextHash,
+		Data:  &whisper.MailServerResponse{},
+	})
+	select {
+	case requestID := <-mock.requestsCompleted:
+		s.Equal(testHash, requestID)
+		s.NotContains(s.tracker.cache, nextHash)
+		s.NotContains(s.tracker.pages, nextHash)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for a request to be completed")
+	}
+	s.Equal(testHash, <-mock.requestsIncomplete)
+}
+
+func (s *TrackerSuite) TestPagedRequestSendFailed() {
+	mock := newHandlerMock(1)
+	s.tracker.handler = mock
+
+	s.tracker.AddPagedRequest(testHash, time.After(defaultRequestTimeout*time.Second), &pagedRequest{
+		requestID: testHash,
+		timeout:   defaultRequestTimeout * time.Second,
+		next: func(cursor []byte) (common.Hash, error) {
+			return common.Hash{}, errors.New("send failed")
+		},
+	})
+
+	// a failure to send the next page is not reported as an expiration
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  testHash,
+		Data:  &whisper.MailServerResponse{Cursor: []byte{0x01}},
+	})
+	select {
+	case requestID := <-mock.requestsFailed:
+		s.Equal(testHash, requestID)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for a request to fail")
+	}
+	s.Empty(mock.requestsExpired)
+}
+
+func (s *TrackerSuite) TestPagedRequestRetry() {
+	mock := newHandlerMock(1)
+	s.tracker.handler = mock
+
+	nextHash := common.Hash{0x02}
+	cursors := make(chan []byte, 1)
+	s.tracker.AddPagedRequest(testHash, make(chan time.Time), &pagedRequest{
+		requestID: testHash,
+		timeout:   defaultRequestTimeout * time.Second,
+		next: func(cursor []byte) (common.Hash, error) {
+			cursors <- cursor
+			return nextHash, nil
+		},
+		retries: maxRequestRetries - 1,
+		cursor:  []byte{0x01},
+	})
+
+	// the expired page is requested again
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestExpired,
+		Hash:  testHash,
+	})
+	select {
+	case c := <-cursors:
+		s.Equal([]byte{0x01}, c)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for the request to be sent again")
+	}
+	s.Empty(mock.requestsExpired)
+
+	tracked := func() bool {
+		s.tracker.mu.Lock()
+		defer s.tracker.mu.Unlock()
+		_, ok := s.tracker.pages[nextHash]
+		return ok
+	}
+	for start := time.Now(); !tracked(); time.Sleep(10 * time.Millisecond) {
+		s.Require().True(time.Since(start) < 10*time.Second, "timed out while waiting for the request to be tracked")
+	}
+
+	// the request expires when retries are exhausted
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestExpired,
+		Hash:  nextHash,
+	})
+	select {
+	case requestID := <-mock.requestsExpired:
+		s.Equal(testHash, requestID)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for request expiration")
+	}
+	s.Empty(cursors)
+}
+
+func (s *TrackerSuite) TestPagedRequestUpdatesSyncState() {
+	mock := newHandlerMock(1)
+	s.tracker.handler = mock
+	s.tracker.mailServers = newMailServerPool(nil, nil)
+	s.tracker.syncState = newTestSyncState(s.T())
+
+	mailServer := discover.NodeID{0x01}
+	s.tracker.mailServers.RequestSent(testHash, mailServer)
+	s.tracker.AddPagedRequest(testHash, make(chan time.Time), &pagedRequest{
+		requestID: testHash,
+		timeout:   defaultRequestTimeout * time.Second,
+		sync:      &syncRange{topics: []whisper.TopicType{testTopicA}, from: 100, to: 200},
+	})
+	s.tracker.handleEvent(whisper.EnvelopeEvent{
+		Event: whisper.EventMailServerRequestCompleted,
+		Hash:  testHash,
+		Data:  &whisper.MailServerResponse{},
+	})
+	select {
+	case requestID := <-mock.requestsCompleted:
+		s.Equal(testHash, requestID)
+	case <-time.After(10 * time.Second):
+		s.Fail("timed out while waiting for a request to be completed")
+	}
+
+	last, err := s.tracker.syncState.Get(mailServer, testTopicA)
+	s.Require().NoError(err)
+	s.Equal(uint32(200), last)
+}
@@ -2,6 +2,7 @@ package shhext
 
 import (
 	"github.com/ethereum/go-ethereum/common"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
 	"github.com/status-im/status-go/signal"
 )
 
@@ -19,8 +20,22 @@ func (h EnvelopeSignalHandler) EnvelopeExpired(hash common.Hash) {
 }
 
 // MailServerRequestCompleted triggered when the mailserver sends a message to notify that the request has been completed
-func (h EnvelopeSignalHandler) MailServerRequestCompleted(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte) {
-	signal.SendMailServerRequestCompleted(requestID, lastEnvelopeHash, cursor)
+func (h EnvelopeSignalHandler) MailServerRequestCompleted(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte, incomplete bool) {
+	signal.SendMailServerRequestCompleted(requestID, lastEnvelopeHash, cursor, incomplete)
+}
+
+// MailServerRequestProgress triggered when the mailserver delivered a page of a request and the next page is requested
+func (h EnvelopeSignalHandler) MailServerRequestProgress(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte) {
+	signal.SendMailServerRequestProgress(requestID, lastEnvelopeHash, cursor)
+}
+
+// MailServerRequestFailed triggered when the mailserver responds with an error
+func (h EnvelopeSignalHandler) MailServerRequestFailed(requestID common.Hash, err error) {
+	if msErr, ok := err.(*whisper.MailServerError); ok {
+		signal.SendMailServerRequestFailed(requestID, int(msErr.Code), msErr.Message)
+		return
+	}
+	signal.SendMailServerRequestFailed(requestID, 0, err.Error())
 }
 
 // MailServerRequestExpired triggered when the mailserver request expires
@@ -0,0 +1,149 @@
+package shhext
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/status-im/status-go/db"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// maxSyncRange is the max time range of a single request sent when topics are synced.
+	// It matches the default max query range of MailServer. Topics which were never synced
+	// are requested for this range.
+	maxSyncRange = 24 * time.Hour
+	// maxSyncGap is the max time range requested for topics which were last synced long ago.
+	// It is split into many requests. Older envelopes are not requested anymore.
+	maxSyncGap = 7 * 24 * time.Hour
+	// syncOverlap is the time requested again before the last synced time,
+	// so that envelopes archived by MailServer with a delay are not missed.
+	syncOverlap = time.Minute
+)
+
+// syncRange is a time range of envelopes with the topics.
+type syncRange struct {
+	topics []whisper.TopicType
+	from   uint32
+	to     uint32
+	// resync is set if the range starts after the last synced time of the topics
+	// because envelopes older than maxSyncGap are not requested.
+	// Such a range updates the last synced time despite the gap.
+	resync bool
+}
+
+// syncState keeps the last time up to which envelopes with a topic were
+// received from a MailServer, so that only envelopes sent since then are requested.
+type syncState struct {
+	db *leveldb.DB
+}
+
+func newSyncState(db *leveldb.DB) *syncState {
+	return &syncState{db: db}
+}
+
+func syncStateKey(mailServer discover.NodeID, topic whisper.TopicType) []byte {
+	return db.Key(db.MailServerSyncState, mailServer[:], topic[:])
+}
+
+// Get returns the last synced time of the topic or 0 if it was never synced.
+func (s *syncState) Get(mailServer discover.NodeID, topic whisper.TopicType) (uint32, error) {
+	data, err := s.db.Get(syncStateKey(mailServer, topic), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// Update sets the last synced time of the topics to the end of the received range.
+// Topics are updated only if there is no gap between the range and their last synced time,
+// unless the range is requested by a resync.
+func (s *syncState) Update(mailServer discover.NodeID, r syncRange) error {
+	batch := leveldb.Batch{}
+	for _, topic := range r.topics {
+		last, err := s.Get(mailServer, topic)
+		if err != nil {
+			return err
+		}
+		if last != 0 && ((r.from > last && !r.resync) || r.to <= last) {
+			continue
+		}
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, r.to)
+		batch.Put(syncStateKey(mailServer, topic), value)
+	}
+	return s.db.Write(&batch, nil)
+}
+
+// Ranges returns time ranges to request in order to sync the topics until now.
+// Topics with the same last synced time are grouped. Ranges longer than maxSyncRange
+// are split, so that each of them is accepted by MailServer. They are returned
+// from the oldest, so that the last synced time can be updated when each of them is completed.
+// Topics synced up to now are skipped.
+func (s *syncState) Ranges(mailServer discover.NodeID, topics []whisper.TopicType, now uint32) ([]syncRange, error) {
+	oldest := subtractDuration(now, maxSyncRange)
+	oldestGap := subtractDuration(now, maxSyncGap)
+
+	type rangeKey struct {
+		from   uint32
+		resync bool
+	}
+	groups := make(map[rangeKey][]whisper.TopicType)
+	for _, topic := range topics {
+		last, err := s.Get(mailServer, topic)
+		if err != nil {
+			return nil, err
+		}
+		if last >= now {
+			continue
+		}
+
+		key := rangeKey{from: oldest}
+		if last > 0 {
+			key.from = subtractDuration(last, syncOverlap)
+			if key.from < oldestGap {
+				key = rangeKey{from: oldestGap, resync: true}
+			}
+		}
+		groups[key] = append(groups[key], topic)
+	}
+
+	var result []syncRange
+	for key, topics := range groups {
+		step := uint32(maxSyncRange / time.Second)
+		for from := key.from; from < now; from += step {
+			to := now
+			if now-from > step {
+				to = from + step
+			}
+			result = append(result, syncRange{
+				topics: topics,
+				from:   from,
+				to:     to,
+				// only the first range can start after the last synced time
+				resync: key.resync && from == key.from,
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].from != result[j].from {
+			return result[i].from < result[j].from
+		}
+		return result[i].to < result[j].to
+	})
+	return result, nil
+}
+
+// subtractDuration returns the timestamp d before t or 0 if t is earlier.
+func subtractDuration(t uint32, d time.Duration) uint32 {
+	seconds := uint32(d / time.Second)
+	if t < seconds {
+		return 0
+	}
+	return t - seconds
+}
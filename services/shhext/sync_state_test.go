@@ -0,0 +1,146 @@
+package shhext
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+var (
+	testTopicA = whisper.TopicType{0x01, 0x02, 0x03, 0x04}
+	testTopicB = whisper.TopicType{0x05, 0x06, 0x07, 0x08}
+)
+
+func newTestSyncState(t *testing.T) *syncState {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	return newSyncState(db)
+}
+
+func TestSyncStateUpdate(t *testing.T) {
+	state := newTestSyncState(t)
+	mailServerA := discover.NodeID{0x01}
+	mailServerB := discover.NodeID{0x02}
+
+	last, err := state.Get(mailServerA, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), last)
+
+	require.NoError(t, state.Update(mailServerA, syncRange{topics: []whisper.TopicType{testTopicA}, from: 100, to: 200}))
+	last, err = state.Get(mailServerA, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, uint32(200), last)
+
+	// the state is kept per MailServer
+	last, err = state.Get(mailServerB, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), last)
+
+	// ranges with a gap or older ranges are ignored
+	require.NoError(t, state.Update(mailServerA, syncRange{topics: []whisper.TopicType{testTopicA}, from: 250, to: 300}))
+	require.NoError(t, state.Update(mailServerA, syncRange{topics: []whisper.TopicType{testTopicA}, from: 50, to: 150}))
+	last, err = state.Get(mailServerA, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, uint32(200), last)
+
+	require.NoError(t, state.Update(mailServerA, syncRange{topics: []whisper.TopicType{testTopicA}, from: 150, to: 300}))
+	last, err = state.Get(mailServerA, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, uint32(300), last)
+}
+
+func TestSyncStateRanges(t *testing.T) {
+	state := newTestSyncState(t)
+	mailServer := discover.NodeID{0x01}
+	now := uint32(1000000)
+	oldest := now - uint32(maxSyncRange.Seconds())
+	overlap := uint32(syncOverlap.Seconds())
+
+	// topics never synced are requested for the max range
+	ranges, err := state.Ranges(mailServer, []whisper.TopicType{testTopicA, testTopicB}, now)
+	require.NoError(t, err)
+	require.Equal(t, []syncRange{{topics: []whisper.TopicType{testTopicA, testTopicB}, from: oldest, to: now}}, ranges)
+
+	synced := now - 600
+	require.NoError(t, state.Update(mailServer, syncRange{topics: []whisper.TopicType{testTopicA}, from: oldest, to: synced}))
+	ranges, err = state.Ranges(mailServer, []whisper.TopicType{testTopicA, testTopicB}, now)
+	require.NoError(t, err)
+	require.Equal(t, []syncRange{
+		{topics: []whisper.TopicType{testTopicB}, from: oldest, to: now},
+		{topics: []whisper.TopicType{testTopicA}, from: synced - overlap, to: now},
+	}, ranges)
+
+	// topics synced up to now are skipped
+	require.NoError(t, state.Update(mailServer, syncRange{topics: []whisper.TopicType{testTopicA}, from: synced, to: now}))
+	ranges, err = state.Ranges(mailServer, []whisper.TopicType{testTopicA}, now)
+	require.NoError(t, err)
+	require.Empty(t, ranges)
+}
+
+func TestSyncStateStaleWatermark(t *testing.T) {
+	state := newTestSyncState(t)
+	mailServer := discover.NodeID{0x01}
+	now := uint32(10000000)
+	day := uint32(maxSyncRange.Seconds())
+	overlap := uint32(syncOverlap.Seconds())
+	topics := []whisper.TopicType{testTopicA}
+
+	// the watermark is older than the max range of a single request
+	synced := now - 2*day - 600
+	require.NoError(t, state.Update(mailServer, syncRange{topics: topics, from: synced - day, to: synced}))
+
+	ranges, err := state.Ranges(mailServer, topics, now)
+	require.NoError(t, err)
+	from := synced - overlap
+	require.Equal(t, []syncRange{
+		{topics: topics, from: from, to: from + day},
+		{topics: topics, from: from + day, to: from + 2*day},
+		{topics: topics, from: from + 2*day, to: now},
+	}, ranges)
+
+	// completed ranges advance the watermark up to now
+	for _, r := range ranges {
+		require.NoError(t, state.Update(mailServer, r))
+	}
+	last, err := state.Get(mailServer, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, now, last)
+}
+
+func TestSyncStateResync(t *testing.T) {
+	state := newTestSyncState(t)
+	mailServer := discover.NodeID{0x01}
+	now := uint32(10000000)
+	day := uint32(maxSyncRange.Seconds())
+	oldestGap := now - uint32(maxSyncGap.Seconds())
+	topics := []whisper.TopicType{testTopicA}
+
+	// envelopes older than the max gap are not requested
+	synced := oldestGap - day
+	require.NoError(t, state.Update(mailServer, syncRange{topics: topics, from: synced - day, to: synced}))
+
+	ranges, err := state.Ranges(mailServer, topics, now)
+	require.NoError(t, err)
+	require.Len(t, ranges, int(maxSyncGap/maxSyncRange))
+	require.Equal(t, syncRange{topics: topics, from: oldestGap, to: oldestGap + day, resync: true}, ranges[0])
+	for _, r := range ranges[1:] {
+		require.False(t, r.resync)
+	}
+	require.Equal(t, now, ranges[len(ranges)-1].to)
+
+	// the first range is accepted despite the gap
+	require.NoError(t, state.Update(mailServer, ranges[0]))
+	last, err := state.Get(mailServer, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, oldestGap+day, last)
+
+	// a range with a gap is still ignored if it is not a resync
+	require.NoError(t, state.Update(mailServer, syncRange{topics: topics, from: now - 10, to: now}))
+	last, err = state.Get(mailServer, testTopicA)
+	require.NoError(t, err)
+	require.Equal(t, oldestGap+day, last)
+}
@@ -15,6 +15,13 @@ const (
 	// EventMailServerRequestCompleted is triggered when whisper receives a message ack from the mailserver
 	EventMailServerRequestCompleted = "mailserver.request.completed"
 
+	// EventMailServerRequestProgress is triggered when a page of a paginated request is received
+	// from the mailserver and the next page is requested
+	EventMailServerRequestProgress = "mailserver.request.progress"
+
+	// EventMailServerRequestFailed is triggered when the mailserver responds that it failed to process the request
+	EventMailServerRequestFailed = "mailserver.request.failed"
+
 	// EventMailServerRequestExpired is triggered when request TTL ends
 	EventMailServerRequestExpired = "mailserver.request.expired"
 
@@ -22,6 +29,7 @@ const (
 	EventEnodeDiscovered = "enode.discovered"
 
 	// EventDecryptMessageFailed is triggered when we receive a message from a bundle we don't have
+	// or which can't be decrypted with the session
 	EventDecryptMessageFailed = "messages.decrypt.failed"
 )
 
@@ -35,6 +43,16 @@ type MailServerResponseSignal struct {
 	RequestID        common.Hash `json:"requestID"`
 	LastEnvelopeHash common.Hash `json:"lastEnvelopeHash"`
 	Cursor           string      `json:"cursor"`
+	// Incomplete is set if some envelopes sent by the mailserver were not received.
+	// It is always false if the mailserver does not send checksums of envelopes.
+	Incomplete bool `json:"incomplete"`
+}
+
+// MailServerRequestFailedSignal holds the error received in the response from the mailserver.
+type MailServerRequestFailedSignal struct {
+	RequestID common.Hash `json:"requestID"`
+	ErrorCode int         `json:"errorCode"`
+	Error     string      `json:"error"`
 }
 
 // DecryptMessageFailedSignal holds the sender of the message that could not be decrypted
@@ -53,15 +71,36 @@ func SendEnvelopeExpired(hash common.Hash) {
 }
 
 // SendMailServerRequestCompleted triggered when mail server response has been received
-func SendMailServerRequestCompleted(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte) {
+func SendMailServerRequestCompleted(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte, incomplete bool) {
 	sig := MailServerResponseSignal{
 		RequestID:        requestID,
 		LastEnvelopeHash: lastEnvelopeHash,
 		Cursor:           string(cursor),
+		Incomplete:       incomplete,
 	}
 	send(EventMailServerRequestCompleted, sig)
 }
 
+// SendMailServerRequestProgress triggered when a page of a paginated request has been received
+func SendMailServerRequestProgress(requestID common.Hash, lastEnvelopeHash common.Hash, cursor []byte) {
+	sig := MailServerResponseSignal{
+		RequestID:        requestID,
+		LastEnvelopeHash: lastEnvelopeHash,
+		Cursor:           string(cursor),
+	}
+	send(EventMailServerRequestProgress, sig)
+}
+
+// SendMailServerRequestFailed triggered when mail server failed to process the request
+func SendMailServerRequestFailed(requestID common.Hash, errorCode int, errorMessage string) {
+	sig := MailServerRequestFailedSignal{
+		RequestID: requestID,
+		ErrorCode: errorCode,
+		Error:     errorMessage,
+	}
+	send(EventMailServerRequestFailed, sig)
+}
+
 // SendMailServerRequestExpired triggered when mail server request expires
 func SendMailServerRequestExpired(hash common.Hash) {
 	send(EventMailServerRequestExpired, EnvelopeSignal{hash})
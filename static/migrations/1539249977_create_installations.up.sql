@@ -0,0 +1,8 @@
+CREATE TABLE installations (
+  identity BLOB NOT NULL,
+  installation_id TEXT NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  timestamp UNSIGNED BIG INT NOT NULL DEFAULT 0,
+  enabled BOOLEAN DEFAULT 0,
+  PRIMARY KEY (identity, installation_id) ON CONFLICT IGNORE
+);
@@ -0,0 +1,15 @@
+DROP TABLE one_time_pre_keys;
+
+CREATE TABLE ratchet_info_v0 (
+  bundle_id BLOB NOT NULL,
+  ephemeral_key BLOB,
+  identity BLOB NOT NULL,
+  symmetric_key BLOB NOT NULL,
+  installation_id TEXT NOT NULL,
+  UNIQUE(bundle_id, identity) ON CONFLICT REPLACE,
+  FOREIGN KEY (bundle_id) REFERENCES bundles(signed_pre_key)
+);
+
+INSERT INTO ratchet_info_v0 SELECT bundle_id, ephemeral_key, identity, symmetric_key, installation_id FROM ratchet_info;
+DROP TABLE ratchet_info;
+ALTER TABLE ratchet_info_v0 RENAME TO ratchet_info;
@@ -0,0 +1,10 @@
+CREATE TABLE one_time_pre_keys (
+  identity BLOB NOT NULL,
+  installation_id TEXT NOT NULL,
+  public_key BLOB NOT NULL PRIMARY KEY ON CONFLICT IGNORE,
+  private_key BLOB,
+  used BOOLEAN DEFAULT 0,
+  timestamp UNSIGNED BIG INT NOT NULL
+);
+
+ALTER TABLE ratchet_info ADD COLUMN one_time_pre_key BLOB;
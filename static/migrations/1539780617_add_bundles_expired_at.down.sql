@@ -0,0 +1,18 @@
+CREATE TEMPORARY TABLE ratchet_info_backup AS SELECT * FROM ratchet_info;
+DELETE FROM ratchet_info;
+
+CREATE TABLE bundles_v1 (
+  identity BLOB NOT NULL,
+  installation_id TEXT NOT NULL,
+  private_key BLOB,
+  signed_pre_key BLOB NOT NULL PRIMARY KEY ON CONFLICT IGNORE,
+  timestamp UNSIGNED BIG INT NOT NULL,
+  expired BOOLEAN DEFAULT 0
+);
+
+INSERT INTO bundles_v1 SELECT identity, installation_id, private_key, signed_pre_key, timestamp, expired FROM bundles;
+DROP TABLE bundles;
+ALTER TABLE bundles_v1 RENAME TO bundles;
+
+INSERT INTO ratchet_info SELECT * FROM ratchet_info_backup;
+DROP TABLE ratchet_info_backup;
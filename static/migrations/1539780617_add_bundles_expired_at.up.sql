@@ -0,0 +1 @@
+ALTER TABLE bundles ADD COLUMN expired_at UNSIGNED BIG INT NOT NULL DEFAULT 0;
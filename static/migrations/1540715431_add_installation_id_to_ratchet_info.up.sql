@@ -0,0 +1,14 @@
+CREATE TABLE ratchet_info_v2 (
+  bundle_id BLOB NOT NULL,
+  ephemeral_key BLOB,
+  identity BLOB NOT NULL,
+  symmetric_key BLOB NOT NULL,
+  installation_id TEXT NOT NULL,
+  one_time_pre_key BLOB,
+  UNIQUE(bundle_id, identity, installation_id) ON CONFLICT REPLACE,
+  FOREIGN KEY (bundle_id) REFERENCES bundles(signed_pre_key)
+);
+
+INSERT INTO ratchet_info_v2 SELECT bundle_id, ephemeral_key, identity, symmetric_key, installation_id, one_time_pre_key FROM ratchet_info;
+DROP TABLE ratchet_info;
+ALTER TABLE ratchet_info_v2 RENAME TO ratchet_info;
@@ -0,0 +1 @@
+DROP TABLE received_ratchet_keys;
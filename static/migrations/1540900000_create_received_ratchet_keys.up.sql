@@ -0,0 +1,5 @@
+CREATE TABLE received_ratchet_keys (
+  session_id BLOB NOT NULL,
+  public_key BLOB NOT NULL,
+  UNIQUE(session_id, public_key) ON CONFLICT IGNORE
+);
@@ -47,6 +47,16 @@
 		on which it was called. It's recommended running mail server
 		on a different machine and running the third command
 		from some beefy server.
+
+		4. Benchmark concurrent requests for the same messages:
+			go test -v -run XXX -bench BenchmarkConcurrentMailserverRequests \
+				./t/benchmarks \
+				-peerurl=$ENODE_ADDR \
+				-ccypeers=20
+
+		Compare the result with the result of a mail server started with
+		"MailServerDisableQueryCache": true in WhisperConfig in order to
+		see how much the query cache helps.
 */
 
 package benchmarks
@@ -1,12 +1,15 @@
 package benchmarks
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/node"
 	whisper "github.com/ethereum/go-ethereum/whisper/whisperv6"
 	"github.com/status-im/status-go/services/shhext"
@@ -34,6 +37,60 @@ func TestConcurrentMailserverPeers(t *testing.T) {
 func testMailserverPeer(t *testing.T) {
 	t.Parallel()
 
+	p := startMailserverPeer(t)
+	defer p.stop(t)
+
+	messages, err := p.shhAPI.GetFilterMessages(p.filterID)
+	require.NoError(t, err)
+	require.Len(t, messages, 0)
+
+	// request messages from mail server
+	requestID, err := p.requestMessages()
+	require.NoError(t, err)
+	require.NotNil(t, requestID)
+	// wait for all messages
+	require.NoError(t, waitForMessages(t, *msgCount, p.shhAPI, p.filterID))
+}
+
+// BenchmarkConcurrentMailserverRequests measures how long it takes `ccyPeers`
+// peers to retrieve the same messages from a MailServer at once.
+//
+// Run it against a MailServer with and without `MailServerDisableQueryCache`
+// in order to see how much the query cache helps.
+func BenchmarkConcurrentMailserverRequests(b *testing.B) {
+	peers := make([]*mailserverPeer, *ccyPeers)
+	for i := range peers {
+		peers[i] = startMailserverPeer(b)
+		defer peers[i].stop(b)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var wg sync.WaitGroup
+		for _, p := range peers {
+			wg.Add(1)
+			go func(p *mailserverPeer) {
+				defer wg.Done()
+				if err := p.requestMessagesAndWait(); err != nil {
+					b.Error(err)
+				}
+			}(p)
+		}
+		wg.Wait()
+	}
+}
+
+// mailserverPeer is a node connected to the MailServer.
+type mailserverPeer struct {
+	node      *node.Node
+	shh       *whisper.Whisper
+	shhAPI    *whisper.PublicWhisperAPI
+	shhextAPI *shhext.PublicAPI
+	symKeyID  string
+	filterID  string
+}
+
+func startMailserverPeer(t testing.TB) *mailserverPeer {
 	shhService := createWhisperService()
 	shhAPI := whisper.NewPublicWhisperAPI(shhService)
 	config := &shhext.ServiceConfig{
@@ -60,7 +117,6 @@ func testMailserverPeer(t *testing.T) {
 
 	// start node
 	require.NoError(t, n.Start())
-	defer func() { require.NoError(t, n.Stop()) }()
 
 	// add mail server as a peer
 	require.NoError(t, addPeerWithConfirmation(n.Server(), peerEnode))
@@ -76,25 +132,59 @@ func testMailserverPeer(t *testing.T) {
 		AllowP2P: true,
 	})
 	require.NoError(t, err)
-	messages, err := shhAPI.GetFilterMessages(filterID)
-	require.NoError(t, err)
-	require.Len(t, messages, 0)
 
-	// request messages from mail server
+	// sym key to authenticate requests
 	symKeyID, err := shhService.AddSymKeyFromPassword(mailServerPass)
 	require.NoError(t, err)
 	ok, err := shhAPI.MarkTrustedPeer(context.TODO(), *peerURL)
 	require.NoError(t, err)
 	require.True(t, ok)
-	requestID, err := shhextAPI.RequestMessages(context.TODO(), shhext.MessagesRequest{
+
+	return &mailserverPeer{
+		node:      n,
+		shh:       shhService,
+		shhAPI:    shhAPI,
+		shhextAPI: shhextAPI,
+		symKeyID:  symKeyID,
+		filterID:  filterID,
+	}
+}
+
+func (p *mailserverPeer) stop(t testing.TB) {
+	require.NoError(t, p.node.Stop())
+}
+
+func (p *mailserverPeer) requestMessages() (hexutil.Bytes, error) {
+	return p.shhextAPI.RequestMessages(context.TODO(), shhext.MessagesRequest{
 		MailServerPeer: *peerURL,
-		SymKeyID:       symKeyID,
+		SymKeyID:       p.symKeyID,
 		Topic:          topic,
 	})
-	require.NoError(t, err)
-	require.NotNil(t, requestID)
-	// wait for all messages
-	require.NoError(t, waitForMessages(t, *msgCount, shhAPI, filterID))
+}
+
+// requestMessagesAndWait requests messages and waits until the MailServer
+// completes the request.
+func (p *mailserverPeer) requestMessagesAndWait() error {
+	events := make(chan whisper.EnvelopeEvent, 10)
+	sub := p.shh.SubscribeEnvelopeEvents(events)
+	defer sub.Unsubscribe()
+
+	requestID, err := p.requestMessages()
+	if err != nil {
+		return err
+	}
+
+	timeout := time.After(time.Minute)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Event == whisper.EventMailServerRequestCompleted && bytes.Equal(ev.Hash[:], requestID) {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("request %s timed out", requestID)
+		}
+	}
 }
 
 func waitForMessages(t *testing.T, messagesCount int64, shhAPI *whisper.PublicWhisperAPI, filterID string) error {